@@ -1,6 +1,7 @@
 // Modules to control application life and create native browser window
-const { app, BrowserWindow, screen } = require("electron");
+const { app, BrowserWindow, screen, session } = require("electron");
 const path = require("path");
+const fs = require("fs");
 const express = require("express");
 // const cors = require("cors");
 const localServerApp = express();
@@ -15,6 +16,20 @@ const startLocalServer = (done) => {
   });
 };
 
+// The Go server only answers requests carrying the token it writes to disk on startup.
+const API_URLS = ["http://localhost:3001/*", "https://localhost:3001/*"];
+const API_TOKEN_FILE = process.env.BLUBBER_API_TOKEN_FILE || path.join(__dirname, "..", "server", "api_token");
+const attachApiToken = () => {
+  session.defaultSession.webRequest.onBeforeSendHeaders({ urls: API_URLS }, (details, callback) => {
+    try {
+      details.requestHeaders["X-API-Token"] = fs.readFileSync(API_TOKEN_FILE, "utf8").trim();
+    } catch (err) {
+      console.log("Could not read API token:", err.message);
+    }
+    callback({ requestHeaders: details.requestHeaders });
+  });
+};
+
 function createWindow() {
   // Create the browser window.
   const { width, height } = screen.getPrimaryDisplay().workAreaSize;
@@ -38,6 +53,7 @@ function createWindow() {
 // initialization and is ready to create browser windows.
 // Some APIs can only be used after this event occurs.
 app.whenReady().then(() => {
+  attachApiToken();
   startLocalServer(createWindow);
 
   app.on("activate", function () {
@@ -328,15 +328,21 @@ export default function DownloadPopup({addFile, currentHash=null, basicTrigger=f
                                     <th className="teeh">
                                         Peer ID                                         
                                     </th>
+                                    <th className="teeh">
+                                        Score
+                                    </th>
                                 </tr>
                                 {actualPeerData.map((peer, index) => {
                                     if (index >= currEntries * numRows && index < (currEntries + 1) * numRows)
                                         return (
                                         <tr key={index} 
-                                            className={`body-row ${peerData[0] === peer.peerid ? 'selected' : ''}`}
-                                            onClick={() => handleRowClick(peer)}>
+                                            className={`body-row ${peerData[0] === peer.id ? 'selected' : ''}`}
+                                            onClick={() => handleRowClick(peer.id)}>
+                                            <td className="teedee">
+                                                    {peer.id}
+                                            </td>
                                             <td className="teedee">
-                                                    {peer}
+                                                    {peer.score.toFixed(2)}
                                             </td>
                                         </tr>
                                         );
@@ -359,7 +365,7 @@ export default function DownloadPopup({addFile, currentHash=null, basicTrigger=f
                             </tbody>
                         </table>
                         {peerError !== '' && <div className="errors peer-error">{peerError}</div>}
-                        {actualPeerData.map(peer => (<Tooltip key={peer} id={peer}/>))}
+                        {actualPeerData.map(peer => (<Tooltip key={peer.id} id={peer.id}/>))}
                         </>)}
                         {(!onPeerTable && fileData !== "") && (<div className="file-metadata">
                             <div className="file-info">
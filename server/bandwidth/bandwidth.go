@@ -0,0 +1,326 @@
+// Package bandwidth limits what the node sends to other peers: a token bucket for all uploads and
+// one per peer, rates that change by time of day, and a number of upload slots with a queue. File
+// uploads and proxy traffic share the same limiter.
+package bandwidth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"server/database/models"
+	"sync"
+	"time"
+)
+
+const (
+	chunkSize   = 32 * 1024       // Largest write waited for at once
+	meterWindow = 5               // Seconds the current rate is averaged over
+	peerIdle    = 2 * time.Minute // Buckets of peers idle for longer are dropped
+)
+
+var ErrQueueFull = errors.New("upload queue is full")
+
+// Default is the limiter of the node
+var Default = New(models.UploadLimits{MaxUploads: 4, MaxQueue: 16})
+
+// Limiter enforces upload limits
+type Limiter struct {
+	mutex   sync.Mutex
+	limits  models.UploadLimits
+	windows []window
+	global  bucket
+	peers   map[string]*bucket
+	active  int
+	queue   []chan struct{}
+	total   int64
+	meter   [meterWindow]int64 // Bytes sent in each of the last seconds
+	second  int64              // Unix second of meter[second % meterWindow]
+}
+
+// window is a parsed UploadWindow, times are minutes since midnight
+type window struct {
+	start, end int
+	globalRate int64
+	peerRate   int64
+}
+
+// bucket is a token bucket holding up to a second of tokens, it goes negative when a write is
+// larger than what is available and the writer waits for the debt to be paid back
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter, the limits must be valid.
+func New(limits models.UploadLimits) *Limiter {
+	l := &Limiter{peers: map[string]*bucket{}}
+	l.limits = limits
+	l.windows, _ = parseSchedule(limits.Schedule)
+	return l
+}
+
+// Validate checks limits before they are saved.
+func Validate(limits models.UploadLimits) error {
+	if limits.GlobalRate < 0 || limits.PeerRate < 0 {
+		return fmt.Errorf("rates can't be negative")
+	}
+	if limits.MaxUploads < 0 || limits.MaxQueue < 0 {
+		return fmt.Errorf("maxUploads and maxQueue can't be negative")
+	}
+	_, err := parseSchedule(limits.Schedule)
+	return err
+}
+
+// SetLimits replaces the limits, uploads in progress are throttled with the new ones right away.
+func (l *Limiter) SetLimits(limits models.UploadLimits) error {
+	windows, err := parseSchedule(limits.Schedule)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.limits = limits
+	l.windows = windows
+	l.wakeWaiters()
+	return nil
+}
+
+// Limits returns the configured limits.
+func (l *Limiter) Limits() models.UploadLimits {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.limits
+}
+
+// Acquire waits for an upload slot. The returned function gives the slot back and must be called
+// once the upload is over.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	l.mutex.Lock()
+	if l.limits.MaxUploads == 0 || (l.active < l.limits.MaxUploads && len(l.queue) == 0) {
+		l.active++
+		l.mutex.Unlock()
+		return l.release, nil
+	}
+	if len(l.queue) >= l.limits.MaxQueue {
+		l.mutex.Unlock()
+		return nil, ErrQueueFull
+	}
+
+	ready := make(chan struct{})
+	l.queue = append(l.queue, ready)
+	l.mutex.Unlock()
+
+	select {
+	case <-ready:
+		return l.release, nil
+	case <-ctx.Done():
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		for i, waiter := range l.queue {
+			if waiter == ready {
+				l.queue = append(l.queue[:i], l.queue[i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+		// The slot was handed over while giving up, pass it on
+		l.active--
+		l.wakeWaiters()
+		return nil, ctx.Err()
+	}
+}
+
+func (l *Limiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.active--
+	l.wakeWaiters()
+}
+
+// wakeWaiters hands the free slots to the oldest waiters, the mutex must be held.
+func (l *Limiter) wakeWaiters() {
+	for len(l.queue) > 0 && (l.limits.MaxUploads == 0 || l.active < l.limits.MaxUploads) {
+		l.active++
+		close(l.queue[0])
+		l.queue = l.queue[1:]
+	}
+}
+
+// FreeSlots returns the number of uploads that would start right away, -1 when there is no limit.
+func (l *Limiter) FreeSlots() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.limits.MaxUploads == 0 {
+		return -1
+	}
+	return max(l.limits.MaxUploads-l.active-len(l.queue), 0)
+}
+
+// Wait blocks until n bytes may be sent to peer, and counts them as sent.
+func (l *Limiter) Wait(peer string, n int) {
+	l.mutex.Lock()
+	now := time.Now()
+	globalRate, peerRate := l.rates(now)
+
+	wait := l.global.take(now, globalRate, n)
+	if peerRate > 0 {
+		b, ok := l.peers[peer]
+		if !ok {
+			l.prunePeers(now)
+			b = &bucket{tokens: float64(peerRate), last: now}
+			l.peers[peer] = b
+		}
+		wait = max(wait, b.take(now, peerRate, n))
+	}
+	l.count(now, n)
+	l.mutex.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Writer returns a writer to peer that is throttled by the limiter.
+func (l *Limiter) Writer(w io.Writer, peer string) io.Writer {
+	return &limitedWriter{w: w, peer: peer, limiter: l}
+}
+
+// Usage returns the current rate and the limits in effect.
+func (l *Limiter) Usage() models.UploadUsage {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.count(now, 0)
+	var sent int64
+	for _, bytes := range l.meter {
+		sent += bytes
+	}
+	globalRate, peerRate := l.rates(now)
+
+	return models.UploadUsage{
+		Rate:       sent / meterWindow,
+		TotalBytes: l.total,
+		GlobalRate: globalRate,
+		PeerRate:   peerRate,
+		Active:     l.active,
+		Queued:     len(l.queue),
+		MaxUploads: l.limits.MaxUploads,
+	}
+}
+
+// rates returns the rates in effect at the given time, the mutex must be held.
+func (l *Limiter) rates(now time.Time) (int64, int64) {
+	minute := now.Hour()*60 + now.Minute()
+	for _, w := range l.windows {
+		if w.contains(minute) {
+			return w.globalRate, w.peerRate
+		}
+	}
+	return l.limits.GlobalRate, l.limits.PeerRate
+}
+
+// count adds n bytes to the meter, the mutex must be held.
+func (l *Limiter) count(now time.Time, n int) {
+	second := now.Unix()
+	if second-l.second >= meterWindow {
+		l.meter = [meterWindow]int64{}
+	} else {
+		for s := l.second + 1; s <= second; s++ {
+			l.meter[s%meterWindow] = 0
+		}
+	}
+	l.second = second
+	l.meter[second%meterWindow] += int64(n)
+	l.total += int64(n)
+}
+
+func (l *Limiter) prunePeers(now time.Time) {
+	for peer, b := range l.peers {
+		if now.Sub(b.last) > peerIdle {
+			delete(l.peers, peer)
+		}
+	}
+}
+
+// take removes n tokens and returns how long to wait until the bucket is out of debt.
+func (b *bucket) take(now time.Time, rate int64, n int) time.Duration {
+	if rate <= 0 {
+		b.last = now
+		return 0
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(rate)
+	} else {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(rate), float64(rate))
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
+func (w window) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end // Spans midnight
+}
+
+func parseSchedule(schedule []models.UploadWindow) ([]window, error) {
+	windows := []window{}
+	for _, w := range schedule {
+		start, err := parseTimeOfDay(w.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(w.End)
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("window %s-%s is empty", w.Start, w.End)
+		}
+		if w.GlobalRate < 0 || w.PeerRate < 0 {
+			return nil, fmt.Errorf("rates can't be negative")
+		}
+		windows = append(windows, window{start: start, end: end, globalRate: w.GlobalRate, peerRate: w.PeerRate})
+	}
+	return windows, nil
+}
+
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+type limitedWriter struct {
+	w       io.Writer
+	peer    string
+	limiter *Limiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), chunkSize)]
+		lw.limiter.Wait(lw.peer, len(chunk))
+
+		n, err := lw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
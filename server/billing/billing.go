@@ -0,0 +1,260 @@
+// Package billing invoices the clients of the proxies this node offers. Every cycle it adds up the
+// ProxyLogs no bill covers yet per client node and offering, bills the bytes at the rate of the
+// offering and sends the bill over libp2p. Bills the client doesn't pay before they are due become overdue, and
+// clients with overdue or disputed bills are refused by the proxy until they pay.
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"server/database/models"
+	"server/database/operations"
+	"server/events"
+	"server/p2p"
+	"server/proxy"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// Status of a bill
+const (
+	BillIssued   = "issued"
+	BillPaid     = "paid"
+	BillOverdue  = "overdue"
+	BillDisputed = "disputed"
+)
+
+var (
+	ErrBillNotFound = errors.New("bill not found")
+	ErrBillPaid     = errors.New("the bill is already paid")
+)
+
+// Config holds the billing settings
+type Config struct {
+	Cycle time.Duration // How often bills are issued and unpaid ones sent again
+	Grace time.Duration // Time a client has to pay a bill before it is overdue
+}
+
+// DefaultConfig returns the settings used when no flags are given.
+func DefaultConfig() Config {
+	return Config{
+		Cycle: time.Hour,
+		Grace: 24 * time.Hour,
+	}
+}
+
+// Engine issues the bills and collects them
+type Engine struct {
+	node   host.Host
+	db     *sql.DB
+	config Config
+
+	mutex sync.Mutex // Only one cycle or resend runs at a time
+}
+
+func NewEngine(node host.Host, db *sql.DB, config Config) *Engine {
+	return &Engine{node: node, db: db, config: config}
+}
+
+// Run runs a billing cycle every config.Cycle until ctx is done.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.config.Cycle)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.cycle()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Bills returns the bills issued so far, newest first.
+func (e *Engine) Bills() ([]models.IssuedBill, error) {
+	return operations.GetAllIssuedBills(e.db)
+}
+
+// Resend sends an unpaid bill again now, a disputed bill the client accepts lifts its suspension.
+func (e *Engine) Resend(id int64) (*models.IssuedBill, error) {
+	bill, err := operations.FindIssuedBill(e.db, id)
+	if err != nil {
+		return nil, err
+	} else if bill == nil {
+		return nil, ErrBillNotFound
+	} else if bill.Status == BillPaid {
+		return nil, ErrBillPaid
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.collect(bill, time.Now())
+	return bill, nil
+}
+
+// cycle bills the traffic since the previous cycle and sends the bills that are not paid yet.
+func (e *Engine) cycle() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	offerings, err := operations.GetProxyOfferings(e.db)
+	if err != nil {
+		log.Printf("Failed to read the proxy offerings: %v", err)
+		return
+	}
+	// Traffic stays unbilled while we don't offer a proxy, it is billed once we do again
+	if len(offerings) == 0 {
+		return
+	}
+
+	now := time.Now()
+	err = e.issue(offerings, now)
+	if err != nil {
+		log.Printf("Failed to issue proxy bills: %v", err)
+	}
+
+	unpaid, err := operations.GetUnpaidIssuedBills(e.db)
+	if err != nil {
+		log.Printf("Failed to read the unpaid proxy bills: %v", err)
+		return
+	}
+	for i := range unpaid {
+		e.collect(&unpaid[i], now)
+	}
+}
+
+// pendingKey is the client node and offering a bill is added up for
+type pendingKey struct {
+	peer     string
+	offering int64
+}
+
+// pendingBill is a bill being added up with the ProxyLogs it covers
+type pendingBill struct {
+	offer models.Proxy
+	bill  models.IssuedBill
+	logs  []string
+}
+
+// issue adds up the unbilled ProxyLogs per client node and offering and writes a bill for each.
+// Traffic of clients that never registered their node can't be billed, it is kept until they do.
+// Traffic of deleted offerings, or from before offerings, is billed at the first offering.
+func (e *Engine) issue(offerings []models.Proxy, now time.Time) error {
+	logs, err := operations.GetUnbilledProxyLogs(e.db, now.Unix())
+	if err != nil {
+		return err
+	}
+
+	offers := map[int64]models.Proxy{}
+	for _, offering := range offerings {
+		offers[offering.ID] = offering
+	}
+
+	pending := map[pendingKey]*pendingBill{}
+	keys := []pendingKey{}
+	for _, record := range logs {
+		peer := record.Peer
+		if peer == "" {
+			peer, err = operations.FindIPtoNode(e.db, record.IP)
+			if err != nil {
+				return err
+			}
+		}
+		if peer == "" {
+			continue
+		}
+
+		offer, ok := offers[record.Offering]
+		if !ok {
+			offer = offerings[0]
+		}
+		key := pendingKey{peer, offer.ID}
+		p, ok := pending[key]
+		if !ok {
+			p = &pendingBill{offer: offer, bill: models.IssuedBill{Peer: peer, Offering: offer.ID, From: record.Time, To: record.Time}}
+			pending[key] = p
+			keys = append(keys, key)
+		}
+		p.bill.IP = record.IP
+		p.bill.Bytes += record.Bytes
+		p.bill.From = min(p.bill.From, record.Time)
+		p.bill.To = max(p.bill.To, record.Time)
+		p.logs = append(p.logs, record.Id)
+	}
+
+	for _, key := range keys {
+		offer := pending[key].offer
+		bill := pending[key].bill
+		bill.Rate = offer.Rate
+		bill.Amount = offer.Rate * float64(bill.Bytes) / p2p.ProxyRateUnit
+		bill.Wallet = offer.Wallet
+		bill.Issued = now.Unix()
+		bill.Due = now.Add(e.config.Grace).Unix()
+		bill.Status = BillIssued
+		// Traffic through a free proxy is only recorded
+		if bill.Amount <= 0 {
+			bill.Status = BillPaid
+			bill.Paid = now.Unix()
+		}
+
+		bill.ID, err = operations.AddIssuedBill(e.db, bill, pending[key].logs)
+		if err != nil {
+			return err
+		}
+		log.Printf("Issued proxy bill %d to %s for offering %d: %d bytes, %.8f", bill.ID, key.peer, bill.Offering, bill.Bytes, bill.Amount)
+		events.Publish(events.IssuedBill, bill)
+	}
+	return nil
+}
+
+// collect sends a bill to the client and saves what came of it. A bill that isn't paid by its due
+// time is overdue, the client is suspended from the proxy when its bill becomes overdue or disputed.
+func (e *Engine) collect(bill *models.IssuedBill, now time.Time) {
+	previous := bill.Status
+	bill.Attempts++
+	txid, err := p2p.SendProxyBillWithConfirmation(e.node, e.db, bill.Peer, models.ProxyBill{
+		IP:       bill.IP,
+		Rate:     bill.Rate,
+		Bytes:    bill.Bytes,
+		Amount:   bill.Amount,
+		Wallet:   bill.Wallet,
+		ID:       bill.ID,
+		Offering: bill.Offering,
+		From:     bill.From,
+		To:       bill.To,
+	})
+	switch {
+	case err == nil:
+		bill.Status = BillPaid
+		bill.Paid = now.Unix()
+		bill.TxID = txid
+		bill.LastError = ""
+	case errors.Is(err, p2p.ErrBillDisputed):
+		bill.Status = BillDisputed
+		bill.LastError = err.Error()
+	default:
+		bill.LastError = err.Error()
+		if bill.Status == BillIssued && now.Unix() >= bill.Due {
+			bill.Status = BillOverdue
+		}
+	}
+
+	err = operations.UpdateIssuedBill(e.db, *bill)
+	if err != nil {
+		log.Printf("Failed to save proxy bill %d: %v", bill.ID, err)
+	}
+	if bill.Status == previous {
+		return
+	}
+
+	log.Printf("Proxy bill %d to %s is %s", bill.ID, bill.Peer, bill.Status)
+	events.Publish(events.IssuedBill, *bill)
+	if bill.Status == BillOverdue || bill.Status == BillDisputed {
+		closed := proxy.CloseSessions(bill.Peer)
+		log.Printf("Suspended proxy client %s, closed %d connections", bill.Peer, closed)
+	}
+}
@@ -7,21 +7,13 @@ import (
 	"path/filepath"
 	"time"
 
-	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcwallet/wallet"
 	_ "github.com/btcsuite/btcwallet/walletdb/bdb"
 )
 
 func createWallet(walletDir string, net string, pubPassphraseString, privPassphraseString string, db *sql.DB) error {
 	//Choose which network parameters to use based on net
-	netParams := &chaincfg.MainNetParams
-	if net == "simnet" {
-		netParams = &chaincfg.SimNetParams
-	} else if net == "testnet" {
-		netParams = &chaincfg.TestNet3Params
-	}
-
-	loader := wallet.NewLoader(netParams, filepath.Join(walletDir, net), true, 10*time.Second, 250)
+	loader := wallet.NewLoader(NetParams(net), filepath.Join(walletDir, net), true, 10*time.Second, 250)
 
 	pubPassphrase := []byte(pubPassphraseString)
 
@@ -17,7 +17,7 @@ var sysProcAttr = &unix.SysProcAttr{
 
 // Interrupt a command/process.
 func InterruptCmd(cmd *exec.Cmd) {
-	err := cmd.Process.Signal(os.Interrupt)
+	err := interrupt(cmd)
 	if err != nil {
 		log.Println(err)
 	}
@@ -27,3 +27,8 @@ func InterruptCmd(cmd *exec.Cmd) {
 		log.Println(err)
 	}
 }
+
+// Send an interrupt to a process without waiting for it to exit.
+func interrupt(cmd *exec.Cmd) error {
+	return cmd.Process.Signal(os.Interrupt)
+}
@@ -13,7 +13,7 @@ var sysProcAttr = &windows.SysProcAttr{
 
 // Interrupt a command/process.
 func InterruptCmd(cmd *exec.Cmd) {
-	err := sendCtrlBreak(cmd.Process.Pid)
+	err := interrupt(cmd)
 	if err != nil {
 		log.Println(err)
 	}
@@ -24,6 +24,11 @@ func InterruptCmd(cmd *exec.Cmd) {
 	}
 }
 
+// Send an interrupt to a process without waiting for it to exit.
+func interrupt(cmd *exec.Cmd) error {
+	return sendCtrlBreak(cmd.Process.Pid)
+}
+
 // Send Ctrl+Break to a process.
 func sendCtrlBreak(pid int) error {
 	d, err := windows.LoadDLL("kernel32.dll")
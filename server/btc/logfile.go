@@ -0,0 +1,95 @@
+package btc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// logFile keeps the output of a btc process. Once it reaches maxSize it is rotated: name.log becomes
+// name.log.1, name.log.1 becomes name.log.2 and so on, keeping maxFiles rotated files.
+type logFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+func openLogFile(path string, maxSize int64, maxFiles int) (*logFile, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating log directory: %v", err)
+	}
+
+	l := &logFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	err = l.open()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		err := l.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file to name.log.1, the mutex must be held.
+func (l *logFile) rotate() error {
+	l.file.Close()
+	l.file = nil
+
+	if l.maxFiles < 1 {
+		os.Remove(l.path)
+	} else {
+		for i := l.maxFiles - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		}
+		os.Rename(l.path, l.path+".1")
+	}
+	return l.open()
+}
+
+func (l *logFile) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,294 @@
+package btc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btclog"
+	"github.com/btcsuite/btcwallet/chain"
+	"github.com/btcsuite/btcwallet/rpc/legacyrpc"
+	"github.com/btcsuite/btcwallet/wallet"
+	"github.com/btcsuite/btcwallet/walletdb"
+	"github.com/btcsuite/btcwallet/wtxmgr"
+	"github.com/lightninglabs/neutrino"
+)
+
+// Chain backends of the wallet
+const (
+	BackendBtcd     = "btcd"     // btcd and btcwallet run as child processes
+	BackendNeutrino = "neutrino" // btcwallet runs in process on a neutrino light client
+)
+
+const walletRPCAddr = "127.0.0.1:8332" // Same port as the btcwallet process, createBtcwalletClient connects to it
+
+// embeddedWallet runs btcwallet in process. Its chain backend is a neutrino light client that
+// downloads block headers and compact filters (BIP157/158) from peers instead of the whole chain,
+// and it serves the same JSON-RPC as the btcwallet process on walletRPCAddr.
+type embeddedWallet struct {
+	loader       *wallet.Loader
+	spvdb        walletdb.DB
+	chainService *neutrino.ChainService
+	chainClient  *chain.NeutrinoClient
+	rpcServer    *legacyrpc.Server
+}
+
+// NetParams returns the chain parameters of a network name, mainnet for unknown names.
+func NetParams(net string) *chaincfg.Params {
+	switch net {
+	case "simnet":
+		return &chaincfg.SimNetParams
+	case "testnet":
+		return &chaincfg.TestNet3Params
+	case "regtest":
+		return &chaincfg.RegressionNetParams
+	}
+	return &chaincfg.MainNetParams
+}
+
+// useWalletLogger sends the logs of the wallet, neutrino and the RPC server to the log file of
+// btcwallet, the same file the btcwallet process writes to.
+func useWalletLogger(output *logFile, debug bool) {
+	var backend *btclog.Backend
+	if debug {
+		backend = btclog.NewBackend(io.MultiWriter(output, os.Stdout))
+	} else {
+		backend = btclog.NewBackend(output)
+	}
+	loggers := map[string]func(btclog.Logger){
+		"BTWL": wallet.UseLogger,
+		"TMGR": wtxmgr.UseLogger,
+		"CHNS": chain.UseLogger,
+		"BTCN": neutrino.UseLogger,
+		"RPCS": legacyrpc.UseLogger,
+	}
+	for subsystem, use := range loggers {
+		logger := backend.Logger(subsystem)
+		logger.SetLevel(btclog.LevelInfo)
+		use(logger)
+	}
+}
+
+// startEmbeddedWallet opens the wallet in walletDir, connects it to the network through neutrino
+// and serves its JSON-RPC. Peers are the only peers neutrino connects to, the DNS seeds of the
+// network are used when there are none.
+func startEmbeddedWallet(walletDir string, network string, pubPassphrase string, peers []string) (*embeddedWallet, error) {
+	params := NetParams(network)
+	e := &embeddedWallet{
+		loader: wallet.NewLoader(params, walletDir, true, 10*time.Second, 250),
+	}
+
+	// Headers and filters are kept next to the wallet
+	var err error
+	e.spvdb, err = walletdb.Create("bdb", filepath.Join(walletDir, "neutrino.db"), true, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("error opening neutrino database: %v", err)
+	}
+
+	e.chainService, err = neutrino.NewChainService(neutrino.Config{
+		DataDir:      walletDir,
+		Database:     e.spvdb,
+		ChainParams:  *params,
+		ConnectPeers: peers,
+	})
+	if err != nil {
+		e.spvdb.Close()
+		return nil, fmt.Errorf("error creating neutrino chain service: %v", err)
+	}
+	err = e.chainService.Start()
+	if err != nil {
+		e.spvdb.Close()
+		return nil, fmt.Errorf("error starting neutrino: %v", err)
+	}
+
+	e.chainClient = chain.NewNeutrinoClient(params, e.chainService)
+	err = e.chainClient.Start()
+	if err != nil {
+		e.stop()
+		return nil, fmt.Errorf("error starting neutrino client: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", walletRPCAddr)
+	if err != nil {
+		e.stop()
+		return nil, fmt.Errorf("error listening for wallet RPC: %v", err)
+	}
+	e.rpcServer = legacyrpc.NewServer(&legacyrpc.Options{
+		Username:            "user",
+		Password:            "password",
+		MaxPOSTClients:      10,
+		MaxWebsocketClients: 25,
+	}, e.loader, []net.Listener{listener})
+
+	// The wallet syncs through neutrino and answers RPC once it is open
+	e.loader.RunAfterLoad(func(w *wallet.Wallet) {
+		w.SynchronizeRPC(e.chainClient)
+		e.rpcServer.RegisterWallet(w)
+		e.rpcServer.SetChainServer(e.chainClient)
+	})
+	_, err = e.loader.OpenExistingWallet([]byte(pubPassphrase), false)
+	if err != nil {
+		e.stop()
+		return nil, fmt.Errorf("error opening wallet: %v", err)
+	}
+
+	return e, nil
+}
+
+// stop stops what startEmbeddedWallet started, in reverse order. The RPC server goes first so that
+// no request reaches a closed wallet.
+func (e *embeddedWallet) stop() error {
+	errs := []error{}
+	if e.rpcServer != nil {
+		e.rpcServer.Stop()
+	}
+	err := e.loader.UnloadWallet()
+	if err != nil && !errors.Is(err, wallet.ErrNotLoaded) {
+		errs = append(errs, fmt.Errorf("error closing wallet: %v", err))
+	}
+	if e.chainClient != nil {
+		e.chainClient.Stop()
+		e.chainClient.WaitForShutdown()
+	}
+	err = e.chainService.Stop()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error stopping neutrino: %v", err))
+	}
+	err = e.spvdb.Close()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("error closing neutrino database: %v", err))
+	}
+	return errors.Join(errs...)
+}
+
+// check reads the sync progress of neutrino and whether the wallet is locked. Neutrino only knows
+// the chain height its peers announced, the best of them counts as the headers to sync.
+func (e *embeddedWallet) check() (SyncStatus, error) {
+	status := SyncStatus{}
+	w, ok := e.loader.LoadedWallet()
+	if !ok {
+		return status, errors.New("wallet is not loaded")
+	}
+	status.WalletLocked = w.Locked()
+
+	best, err := e.chainService.BestBlock()
+	if err != nil {
+		return status, fmt.Errorf("error reading the best block: %v", err)
+	}
+	status.Blocks = best.Height
+	status.Headers = best.Height
+	for _, peer := range e.chainService.Peers() {
+		status.Headers = max(status.Headers, peer.LastBlock())
+	}
+	status.Progress = 1
+	if status.Headers > 0 {
+		status.Progress = float64(status.Blocks) / float64(status.Headers)
+	}
+	status.Syncing = !e.chainService.IsCurrent() || !w.ChainSynced()
+
+	if e.chainService.ConnectedCount() == 0 {
+		return status, errors.New("not connected to any peer")
+	}
+	return status, nil
+}
+
+// startEmbedded starts the wallet in process and connects the RPC client to it.
+func (s *Supervisor) startEmbedded(walletDir string, pubPassphrase string) error {
+	useWalletLogger(s.wallet.log, s.config.Debug)
+
+	var err error
+	s.embedded, err = startEmbeddedWallet(walletDir, s.config.Net, pubPassphrase, s.config.Peers)
+	if err != nil {
+		s.closeLogs()
+		return err
+	}
+
+	s.btcwallet, err = createBtcwalletClient(s.config.Net)
+	if err != nil {
+		s.embedded.stop()
+		s.closeLogs()
+		return err
+	}
+
+	s.mutex.Lock()
+	s.wallet.status = ProcessStatus{
+		State:   Running,
+		PID:     os.Getpid(), // The wallet is part of this process
+		Started: time.Now().Unix(),
+		Log:     s.wallet.status.Log,
+	}
+	s.mutex.Unlock()
+	s.publish()
+	return nil
+}
+
+// runEmbedded checks the wallet every HealthInterval until ctx is done. A wallet in process can't
+// be restarted on its own, neutrino reconnects to its peers by itself.
+func (s *Supervisor) runEmbedded(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkEmbedded()
+		}
+	}
+}
+
+func (s *Supervisor) checkEmbedded() {
+	sync, err := s.embedded.check()
+
+	s.mutex.Lock()
+	p := s.wallet
+	s.sync = sync
+	p.status.LastCheck = time.Now().Unix()
+	state := Running
+	if err != nil {
+		state = Unhealthy
+		p.status.Failures++
+		p.status.Error = err.Error()
+	} else {
+		p.status.Failures = 0
+	}
+	changed := p.status.State != state
+	p.status.State = state
+	s.mutex.Unlock()
+
+	if changed {
+		s.publish()
+	}
+	if err != nil {
+		log.Printf("Health check of the wallet failed: %v", err)
+	}
+}
+
+// stopEmbedded stops the wallet in process, it keeps stopping in the background when ctx is done
+// first.
+func (s *Supervisor) stopEmbedded(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.embedded.stop()
+	}()
+
+	select {
+	case err := <-done:
+		s.mutex.Lock()
+		s.wallet.status.State = Stopped
+		s.wallet.status.PID = 0
+		s.mutex.Unlock()
+		return err
+	case <-ctx.Done():
+		return errors.New("the wallet did not stop in time")
+	}
+}
@@ -0,0 +1,154 @@
+package btc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"server/database/models"
+	"server/events"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/integration/rpctest"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet"
+)
+
+// newSimnetWallet creates a wallet on simnet in a new directory and returns the directory.
+func newSimnetWallet(t *testing.T, pubPassphrase string) string {
+	t.Helper()
+	walletDir := t.TempDir()
+	loader := wallet.NewLoader(NetParams("simnet"), walletDir, true, 10*time.Second, 250)
+	_, err := loader.CreateNewWallet([]byte(pubPassphrase), []byte("private"), nil, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loader.UnloadWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return walletDir
+}
+
+// TestEmbeddedWalletOnSimnet runs the neutrino backend against a simnet btcd that serves the
+// compact filters, the only peer it is given.
+func TestEmbeddedWalletOnSimnet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs btcd")
+	}
+
+	harness, err := rpctest.New(&chaincfg.SimNetParams, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100 blocks for the coinbase to mature, and one to spend
+	err = harness.SetUp(true, 1)
+	if err != nil {
+		harness.TearDown()
+		t.Fatal(err)
+	}
+	defer harness.TearDown()
+	_, height, err := harness.Client.GetBestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walletDir := newSimnetWallet(t, "public")
+	e, err := startEmbeddedWallet(walletDir, "simnet", "public", []string{harness.P2PAddress()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := e.stop()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Synced once neutrino has the headers of btcd and the wallet caught up with them
+	deadline := time.Now().Add(time.Minute)
+	for {
+		status, err := e.check()
+		if err == nil && status.Blocks == height && !status.Syncing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("not synced to height %d: %+v, %v", height, status, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	client, err := createBtcwalletClient("simnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ShutdownClient(client)
+
+	address, err := client.GetNewAddress("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !address.IsForNet(&chaincfg.SimNetParams) {
+		t.Errorf("address %s is not a simnet address", address)
+	}
+	validation, err := client.ValidateAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validation.IsMine {
+		t.Errorf("address %s is not one of the wallet", address)
+	}
+
+	// A payment reaches the wallet through the filters once btcd mines it, and is published
+	published, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	watching := make(chan struct{})
+	go func() {
+		defer close(watching)
+		e.watchTransactions(ctx, client)
+	}()
+	defer func() {
+		cancel()
+		<-watching
+	}()
+
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txid, err := harness.SendOutputs([]*wire.TxOut{wire.NewTxOut(btcutil.SatoshiPerBitcoin, pkScript)}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = harness.Client.Generate(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var transaction models.Transactions
+	timeout := time.After(time.Minute)
+	for transaction.Id != txid.String() {
+		select {
+		case event := <-published:
+			if event.Type == events.WalletTransaction {
+				transaction, _ = event.Data.(models.Transactions)
+			}
+		case <-timeout:
+			t.Fatalf("transaction %s was not published", txid)
+		}
+	}
+	if transaction.Category != "receive" || transaction.Wallet != address.String() || transaction.Amount != 1 || transaction.Confirmations != 1 {
+		t.Errorf("published %+v", transaction)
+	}
+
+	balance, err := client.GetBalance("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != btcutil.SatoshiPerBitcoin {
+		t.Errorf("balance is %v", balance)
+	}
+}
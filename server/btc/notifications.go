@@ -0,0 +1,114 @@
+package btc
+
+import (
+	"context"
+	"log"
+	"server/database/operations"
+	"server/events"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcwallet/wallet"
+)
+
+// WatchTransactions publishes a wallet transaction event for every new transaction and whenever a
+// pending one gets its first confirmation, until ctx is done. The wallet in process notifies its
+// transactions, btcwallet the process is polled every interval.
+func (s *Supervisor) WatchTransactions(ctx context.Context, interval time.Duration) {
+	if s.embedded != nil {
+		s.embedded.watchTransactions(ctx, s.btcwallet)
+		return
+	}
+	pollTransactions(ctx, s.btcwallet, interval)
+}
+
+// pollTransactions lists the transactions of btcwallet since the block of the previous poll. Those
+// are the transactions mined since then and the pending ones, only the pending ones are kept to
+// tell their first confirmation from a new transaction.
+func pollTransactions(ctx context.Context, btcwallet *rpcclient.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var since *chainhash.Hash
+	// Pending transactions already reported, per transaction and category (a transfer to self is both send and receive)
+	pending := map[string]bool{}
+	first := true
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		result, err := btcwallet.ListSinceBlock(since)
+		if err != nil {
+			log.Printf("Error polling wallet transactions: %v\n", err)
+			continue
+		}
+		last, err := chainhash.NewHashFromStr(result.LastBlock)
+		if err != nil {
+			log.Printf("Error reading the last block of wallet transactions: %v\n", err)
+			continue
+		}
+
+		stillPending := map[string]bool{}
+		for _, transaction := range operations.TransactionRecords(result.Transactions) {
+			key := transaction.Id + ":" + transaction.Category
+			reported := pending[key]
+			if transaction.Confirmations == 0 {
+				stillPending[key] = true
+			}
+
+			// Transactions already in the wallet at startup are history, not news
+			if first || (reported && transaction.Confirmations == 0) {
+				continue
+			}
+			events.Publish(events.WalletTransaction, transaction)
+		}
+		// Confirmed transactions are in a block before the next poll starts, dropped ones are gone
+		pending = stillPending
+		since = last
+		first = false
+	}
+}
+
+// watchTransactions publishes the transactions the wallet notifies: pending ones when they are
+// accepted and mined ones with the block that confirms them. The records come from btcwallet so
+// they match those of the RPC.
+func (e *embeddedWallet) watchTransactions(ctx context.Context, btcwallet *rpcclient.Client) {
+	w, ok := e.loader.LoadedWallet()
+	if !ok {
+		log.Println("Not watching wallet transactions, the wallet is not loaded")
+		return
+	}
+	client := w.NtfnServer.TransactionNotifications()
+	defer client.Done()
+
+	for {
+		select {
+		case notification := <-client.C:
+			transactions := notification.UnminedTransactions
+			for _, block := range notification.AttachedBlocks {
+				transactions = append(transactions, block.Transactions...)
+			}
+			for _, transaction := range transactions {
+				publishTransaction(btcwallet, transaction)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func publishTransaction(btcwallet *rpcclient.Client, transaction wallet.TransactionSummary) {
+	result, err := btcwallet.GetTransaction(transaction.Hash)
+	if err != nil {
+		log.Printf("Error reading wallet transaction %s: %v\n", transaction.Hash, err)
+		return
+	}
+	for _, record := range operations.TransactionDetailRecords(result) {
+		events.Publish(events.WalletTransaction, record)
+	}
+}
@@ -4,12 +4,13 @@ import (
 	"bufio"    // For reading output line-by-line
 	"errors"   // For returning error values
 	"fmt"      // For printing debug output
+	"io"       // For the log file the output is written to
 	"os/exec"  // For starting external processes
 	"strings"  // For working with string operations
 )
 
 // Start the btcd process.
-func startBtcd(net string, miningaddr string, debug bool) (*exec.Cmd, error) {
+func startBtcd(net string, miningaddr string, debug bool, output io.Writer) (*exec.Cmd, error) {
 	netCmd := "" // Initialize an empty network argument
 	if net != "mainnet" {
 		netCmd = "--" + net // If not mainnet, add a flag like "--testnet" or "--simnet"
@@ -36,6 +37,7 @@ func startBtcd(net string, miningaddr string, debug bool) (*exec.Cmd, error) {
 	)
 
 	cmd.SysProcAttr = sysProcAttr // Platform-specific process attributes (e.g., set process group)
+	cmd.Stderr = output           // Errors go to the log file with the output
 
 	// Get a pipe to read btcd's standard output
 	cmdStdout, err := cmd.StdoutPipe()
@@ -49,6 +51,7 @@ func startBtcd(net string, miningaddr string, debug bool) (*exec.Cmd, error) {
 		go func() {
 			// Asynchronously scan and optionally print btcd's output
 			for scanner.Scan() {
+				fmt.Fprintln(output, scanner.Text()) // Keep the output in the log file
 				if debug {
 					fmt.Println(scanner.Text())
 				}
@@ -64,6 +67,7 @@ func startBtcd(net string, miningaddr string, debug bool) (*exec.Cmd, error) {
 
 	// Read lines from btcd output to detect successful start
 	for scanner.Scan() {
+		fmt.Fprintln(output, scanner.Text()) // Keep the output in the log file
 		if debug {
 			fmt.Println(scanner.Text())
 		}
@@ -82,11 +86,12 @@ func startBtcd(net string, miningaddr string, debug bool) (*exec.Cmd, error) {
 	}
 
 	// If we reach here, btcd did not start correctly
+	cmd.Wait() // Its output ended, reap the process
 	return nil, errors.New("failed to start btcd")
 }
 
 // Start the btcwallet process.
-func startBtcwallet(net string, debug bool) (*exec.Cmd, error) {
+func startBtcwallet(net string, debug bool, output io.Writer) (*exec.Cmd, error) {
 	netCmd := "" // Initialize network argument
 	if net != "mainnet" {
 		netCmd = "--" + net // Add network flag if not mainnet
@@ -100,6 +105,7 @@ func startBtcwallet(net string, debug bool) (*exec.Cmd, error) {
 	)
 
 	cmd.SysProcAttr = sysProcAttr // Set system-specific attributes
+	cmd.Stderr = output           // Errors go to the log file with the output
 
 	// Get a pipe to read btcwallet's standard output
 	cmdStdout, err := cmd.StdoutPipe()
@@ -113,6 +119,7 @@ func startBtcwallet(net string, debug bool) (*exec.Cmd, error) {
 		go func() {
 			// Asynchronously scan and optionally print btcwallet's output
 			for scanner.Scan() {
+				fmt.Fprintln(output, scanner.Text()) // Keep the output in the log file
 				if debug {
 					fmt.Println(scanner.Text())
 				}
@@ -132,6 +139,7 @@ func startBtcwallet(net string, debug bool) (*exec.Cmd, error) {
 
 	// Read btcwallet output and look for key startup messages
 	for scanner.Scan() {
+		fmt.Fprintln(output, scanner.Text()) // Keep the output in the log file
 		if debug {
 			fmt.Println(scanner.Text())
 		}
@@ -149,5 +157,6 @@ func startBtcwallet(net string, debug bool) (*exec.Cmd, error) {
 	}
 
 	// If we reach here, btcwallet did not start correctly
+	cmd.Wait() // Its output ended, reap the process
 	return nil, errors.New("failed to start btcwallet")
 }
@@ -1,30 +1,30 @@
 package btc // Package name: btc (this file belongs to the btc package)
 
 import (
+	"context"      // Stopping the processes without a deadline
 	"database/sql" // SQL database package
 	"errors"       // Standard error handling package
-	"fmt"          // For printing and scanning from stdin
+	"fmt"          // Formatting errors
 	"os"           // OS-level functions (file system, env, etc.)
-	"os/exec"      // For starting and controlling external processes
 	"path/filepath" // For building filesystem paths in a portable way
 
 	"server/database/operations" // Your custom package for database operations
 
 	"github.com/btcsuite/btcd/btcutil" // Bitcoin utility functions (path helpers, etc.)
-	"github.com/btcsuite/btcd/rpcclient" // Bitcoin RPC client (talk to btcd/btcwallet)
 )
 
-// Start starts Bitcoin-related services: btcd and btcwallet,
-// ensures the wallet exists, gets the mining address, and returns everything ready.
-func Start(net string, db *sql.DB, debug bool) (*exec.Cmd, *exec.Cmd, *rpcclient.Client, *rpcclient.Client, error) {
+// Start starts Bitcoin-related services: btcd and btcwallet, or the wallet in process on neutrino,
+// ensures the wallet exists, gets the mining address, and returns them ready and supervised.
+func Start(db *sql.DB, privPassphrase string, config Config) (*Supervisor, error) {
+	net := config.Net         // Network the processes run on
 	pubPassphrase := "public" // Hardcoded public passphrase (for wallet encryption)
-	var privPassphrase string // Private passphrase (user input)
 
-	// Prompt user for private passphrase
-	fmt.Print("Enter your private passphrase: ")
-	_, err := fmt.Scanln(&privPassphrase) // Read input from console
-	if err != nil {
-		return nil, nil, nil, nil, err // Fail if unable to read
+	// The private passphrase comes from the daemon's flags or environment, there is no prompt
+	if privPassphrase == "" {
+		return nil, errors.New("a private passphrase is required")
+	}
+	if config.Backend != BackendBtcd && config.Backend != BackendNeutrino {
+		return nil, fmt.Errorf("unknown chain backend %q", config.Backend)
 	}
 
 	// Get wallet directory path (based on system, eg. ~/.btcwallet/)
@@ -37,89 +37,112 @@ func Start(net string, db *sql.DB, debug bool) (*exec.Cmd, *exec.Cmd, *rpcclient
 		// If wallet.db does not exist, create a new wallet
 		err := createWallet(walletDir, net, pubPassphrase, privPassphrase, db)
 		if err != nil {
-			return nil, nil, nil, nil, err // Fail if wallet creation fails
+			return nil, err // Fail if wallet creation fails
 		}
 	}
 
 	// Store wallet passphrases into the database
-	err = operations.UpdateWalletPassphrases(db, pubPassphrase, privPassphrase)
+	err := operations.UpdateWalletPassphrases(db, pubPassphrase, privPassphrase)
 	if err != nil {
-		return nil, nil, nil, nil, err
+		return nil, err
 	}
 
 	// Retrieve wallet info (address, etc.) from database
 	walletInfo, err := operations.GetWalletInfo(db)
 	if err != nil {
-		return nil, nil, nil, nil, err
+		return nil, err
+	}
+
+	// Open the log files the output of the processes goes to
+	s, err := newSupervisor(config)
+	if err != nil {
+		return nil, err
+	}
+	s.miningaddr = walletInfo.Address // Get the mining/receiving address
+
+	// With neutrino the wallet runs in this process and nothing mines, the address is only stored
+	if config.Backend == BackendNeutrino {
+		err = s.startEmbedded(filepath.Join(walletDir, net), pubPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		if s.miningaddr == "" {
+			s.miningaddr, err = operations.StoreAddress(s.btcwallet, db)
+			if err != nil {
+				s.Stop(context.Background())
+				return nil, err
+			}
+		}
+		return s, nil
 	}
-	address := walletInfo.Address // Get the mining/receiving address
 
 	// If no address stored yet, create a new one
-	if address == "" {
+	if s.miningaddr == "" {
 		// Start temporary btcd and btcwallet instances without mining address
-		btcdCmd, btcwalletCmd, btcd, btcwallet, err := startBtc(net, "", debug)
+		err = s.startAll()
 		if err != nil {
-			return nil, nil, nil, nil, err
+			return nil, err
 		}
 
 		// Generate a new address and store it in database
-		address, err = operations.StoreAddress(btcwallet, db)
+		s.miningaddr, err = operations.StoreAddress(s.btcwallet, db)
+
+		// Gracefully shutdown the temporary clients and stop the processes
+		ShutdownClient(s.btcd)
+		ShutdownClient(s.btcwallet)
+		s.stopProcess(context.Background(), s.wallet)
+		s.stopProcess(context.Background(), s.daemon)
 		if err != nil {
-			return nil, nil, nil, nil, err
+			s.closeLogs()
+			return nil, err
 		}
-
-		// Gracefully shutdown the temporary clients
-		ShutdownClient(btcd)
-		ShutdownClient(btcwallet)
-
-		// Stop the btcd and btcwallet processes
-		InterruptCmd(btcwalletCmd)
-		InterruptCmd(btcdCmd)
 	}
 
 	// Start final btcd and btcwallet instances, now with a mining address
-	btcdCmd, btcwalletCmd, btcd, btcwallet, err := startBtc(net, address, debug)
+	err = s.startAll()
 	if err != nil {
-		return nil, nil, nil, nil, err
+		return nil, err
 	}
 
-	// Return the process handles and client connections
-	return btcdCmd, btcwalletCmd, btcd, btcwallet, nil
+	// Return the supervisor holding the processes and client connections
+	return s, nil
 }
 
-// startBtc starts btcd (full node) and btcwallet processes and RPC clients.
+// startAll starts btcd (full node) and btcwallet processes and RPC clients.
 // If an error happens at any step, it shuts everything down cleanly.
-func startBtc(net string, miningaddr string, debug bool) (*exec.Cmd, *exec.Cmd, *rpcclient.Client, *rpcclient.Client, error) {
+func (s *Supervisor) startAll() error {
+	// Clean up the processes already started and the log files if a step fails
+	fail := func(err error) error {
+		s.stopProcess(context.Background(), s.wallet)
+		s.stopProcess(context.Background(), s.daemon)
+		s.closeLogs()
+		return err
+	}
+
 	// Start btcd process (optionally providing mining address)
-	btcdCmd, err := startBtcd(net, miningaddr, debug)
+	err := s.startProcess(s.daemon)
 	if err != nil {
-		return nil, nil, nil, nil, err
+		return fail(err)
 	}
 
 	// Start btcwallet process
-	btcwalletCmd, err := startBtcwallet(net, debug)
+	err = s.startProcess(s.wallet)
 	if err != nil {
-		InterruptCmd(btcdCmd) // Clean up btcd if btcwallet failed
-		return nil, nil, nil, nil, err
+		return fail(err)
 	}
 
 	// Create RPC client to communicate with btcd node
-	btcd, err := createBtcdClient(net)
+	s.btcd, err = createBtcdClient(s.config.Net)
 	if err != nil {
-		InterruptCmd(btcwalletCmd) // Clean up btcwallet
-		InterruptCmd(btcdCmd)
-		return nil, nil, nil, nil, err
+		return fail(err)
 	}
 
 	// Create RPC client to communicate with btcwallet
-	btcwallet, err := createBtcwalletClient(net)
+	s.btcwallet, err = createBtcwalletClient(s.config.Net)
 	if err != nil {
-		ShutdownClient(btcd) // Shut down btcd client
-		InterruptCmd(btcwalletCmd)
-		InterruptCmd(btcdCmd)
-		return nil, nil, nil, nil, err
+		ShutdownClient(s.btcd) // Shut down btcd client
+		return fail(err)
 	}
 
-	// Return process handles and RPC clients
-	return btcdCmd, btcwalletCmd, btcd, btcwallet, nil
+	return nil
 }
@@ -0,0 +1,530 @@
+package btc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"server/events"
+
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+// Process states
+const (
+	Starting   = "starting"
+	Running    = "running"
+	Unhealthy  = "unhealthy"  // Health checks fail, restarted after MaxFailures of them in a row
+	Restarting = "restarting" // Waiting for its backoff before starting again
+	Stopped    = "stopped"
+	Disabled   = "disabled" // Not used by the backend, btcd with neutrino
+)
+
+const (
+	initialBackoff = time.Second
+	stableAfter    = time.Minute      // A process healthy for this long gets its backoff reset
+	stopTimeout    = 30 * time.Second // Time an unhealthy process has to exit before it is restarted
+)
+
+// Config holds the settings of btcd and btcwallet
+type Config struct {
+	Net            string        // mainnet, testnet, simnet or regtest
+	Backend        string        // BackendBtcd or BackendNeutrino
+	Peers          []string      // Only peers neutrino connects to, the DNS seeds are used when empty
+	Debug          bool          // Also print the output of the processes
+	LogDir         string        // Output of the processes, in btcd.log and btcwallet.log
+	LogMaxSize     int64         // Bytes after which a log file is rotated
+	LogMaxFiles    int           // Rotated log files kept per process
+	HealthInterval time.Duration // How often the processes are checked over RPC
+	CheckTimeout   time.Duration // Time a health check has to answer
+	MaxFailures    int           // Failed health checks in a row before a process is restarted
+	MaxBackoff     time.Duration // Longest wait before a restart, the wait doubles from a second
+}
+
+// DefaultConfig returns the settings used when no flags are given.
+func DefaultConfig() Config {
+	return Config{
+		Net:            "testnet",
+		Backend:        BackendBtcd,
+		LogDir:         "./logs",
+		LogMaxSize:     10 << 20,
+		LogMaxFiles:    3,
+		HealthInterval: 30 * time.Second,
+		CheckTimeout:   10 * time.Second,
+		MaxFailures:    3,
+		MaxBackoff:     5 * time.Minute,
+	}
+}
+
+// ProcessStatus is the state of btcd or btcwallet
+type ProcessStatus struct {
+	State     string `json:"state"`
+	PID       int    `json:"pid,omitempty"`
+	Started   int64  `json:"started,omitempty"` // When the running process started
+	Restarts  int    `json:"restarts"`
+	Failures  int    `json:"failures"` // Failed health checks in a row
+	LastCheck int64  `json:"lastCheck,omitempty"`
+	Error     string `json:"error,omitempty"` // Why it last failed
+	Log       string `json:"log"`
+}
+
+// SyncStatus is how far btcd got with the chain, as of the last health check
+type SyncStatus struct {
+	Blocks       int32   `json:"blocks"`
+	Headers      int32   `json:"headers"`
+	Progress     float64 `json:"progress"` // Between 0 and 1
+	Syncing      bool    `json:"syncing"`
+	WalletLocked bool    `json:"walletLocked"`
+}
+
+// Status of the btc processes
+type Status struct {
+	Net       string        `json:"net"`
+	Backend   string        `json:"backend"`
+	Btcd      ProcessStatus `json:"btcd"`
+	Btcwallet ProcessStatus `json:"btcwallet"`
+	Sync      SyncStatus    `json:"sync"`
+}
+
+// process is a supervised child process
+type process struct {
+	name    string
+	start   func(output io.Writer) (*exec.Cmd, error)
+	check   func() error
+	log     *logFile
+	cmd     *exec.Cmd
+	exited  chan error       // Receives what Wait returned once the process exits
+	retry   <-chan time.Time // Fires when the backoff before a restart is over
+	backoff time.Duration
+	status  ProcessStatus
+}
+
+// Supervisor runs btcd and btcwallet. It checks them over RPC and restarts them when they exit or
+// stop answering. The RPC clients are kept across restarts, they reconnect on their own. With the
+// neutrino backend there are no processes, the wallet runs in process and is only checked.
+type Supervisor struct {
+	config     Config
+	miningaddr string
+	btcd       *rpcclient.Client
+	btcwallet  *rpcclient.Client
+
+	mutex    sync.Mutex
+	daemon   *process
+	wallet   *process
+	sync     SyncStatus
+	stopping bool
+	embedded *embeddedWallet // Set with the neutrino backend
+
+	cancel context.CancelFunc // Stops the health checks
+	done   chan struct{}      // Closed once the health checks stopped
+}
+
+func newSupervisor(config Config) (*Supervisor, error) {
+	if config.MaxFailures < 1 {
+		config.MaxFailures = 1
+	}
+	s := &Supervisor{config: config}
+
+	// The wallet logs to btcwallet.log as the process would, there is no btcd
+	if config.Backend == BackendNeutrino {
+		btcwalletLog, err := openLogFile(filepath.Join(config.LogDir, "btcwallet.log"), config.LogMaxSize, config.LogMaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		s.daemon = &process{name: "btcd", status: ProcessStatus{State: Disabled}}
+		s.wallet = &process{name: "btcwallet", log: btcwalletLog, status: ProcessStatus{State: Stopped, Log: btcwalletLog.path}}
+		return s, nil
+	}
+
+	btcdLog, err := openLogFile(filepath.Join(config.LogDir, "btcd.log"), config.LogMaxSize, config.LogMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	btcwalletLog, err := openLogFile(filepath.Join(config.LogDir, "btcwallet.log"), config.LogMaxSize, config.LogMaxFiles)
+	if err != nil {
+		btcdLog.Close()
+		return nil, err
+	}
+
+	s.daemon = &process{
+		name: "btcd",
+		start: func(output io.Writer) (*exec.Cmd, error) {
+			return startBtcd(config.Net, s.miningaddr, config.Debug, output)
+		},
+		check:   s.checkBtcd,
+		log:     btcdLog,
+		backoff: initialBackoff,
+		status:  ProcessStatus{State: Stopped, Log: btcdLog.path},
+	}
+	s.wallet = &process{
+		name: "btcwallet",
+		start: func(output io.Writer) (*exec.Cmd, error) {
+			return startBtcwallet(config.Net, config.Debug, output)
+		},
+		check:   s.checkBtcwallet,
+		log:     btcwalletLog,
+		backoff: initialBackoff,
+		status:  ProcessStatus{State: Stopped, Log: btcwalletLog.path},
+	}
+	return s, nil
+}
+
+// Btcd returns the RPC client of btcd, nil with the neutrino backend.
+func (s *Supervisor) Btcd() *rpcclient.Client {
+	return s.btcd
+}
+
+// Btcwallet returns the RPC client of btcwallet.
+func (s *Supervisor) Btcwallet() *rpcclient.Client {
+	return s.btcwallet
+}
+
+// Status returns the state of the processes and the sync progress of the last health check.
+func (s *Supervisor) Status() Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return Status{
+		Net:       s.config.Net,
+		Backend:   s.config.Backend,
+		Btcd:      s.daemon.status,
+		Btcwallet: s.wallet.status,
+		Sync:      s.sync,
+	}
+}
+
+// Start starts the health checks, the processes already run.
+func (s *Supervisor) Start(_ context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	if s.embedded != nil {
+		go s.runEmbedded(ctx)
+	} else {
+		go s.run(ctx)
+	}
+	return nil
+}
+
+// Stop stops the health checks and the RPC clients, then btcwallet and finally btcd, so that the
+// wallet is never left without its chain backend. A process that hasn't exited when ctx is done
+// is killed, btcd is then interrupted and killed right away.
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.mutex.Lock()
+	s.stopping = true
+	s.mutex.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+		}
+	}
+
+	ShutdownClient(s.btcwallet)
+	if s.btcd != nil {
+		ShutdownClient(s.btcd)
+	}
+
+	var err error
+	if s.embedded != nil {
+		err = s.stopEmbedded(ctx)
+	} else {
+		err = errors.Join(s.stopProcess(ctx, s.wallet), s.stopProcess(ctx, s.daemon))
+	}
+	s.closeLogs()
+	return err
+}
+
+func (s *Supervisor) closeLogs() {
+	for _, p := range []*process{s.daemon, s.wallet} {
+		if p.log != nil {
+			p.log.Close()
+		}
+	}
+}
+
+// run checks the processes every HealthInterval and restarts the ones that exited or keep failing
+// their checks, until ctx is done.
+func (s *Supervisor) run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		s.mutex.Lock()
+		btcdExited, btcwalletExited := s.daemon.exited, s.wallet.exited
+		s.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, p := range []*process{s.daemon, s.wallet} {
+				err := s.check(p)
+				if err != nil {
+					s.fail(ctx, p, err)
+				}
+			}
+		case err := <-btcdExited:
+			s.exited(s.daemon)
+			s.fail(ctx, s.daemon, exitError(err))
+		case err := <-btcwalletExited:
+			s.exited(s.wallet)
+			s.fail(ctx, s.wallet, exitError(err))
+		case <-s.daemon.retry:
+			s.restart(ctx, s.daemon)
+		case <-s.wallet.retry:
+			s.restart(ctx, s.wallet)
+		}
+	}
+}
+
+// check runs the health check of a process, it returns an error once the process failed
+// MaxFailures checks in a row.
+func (s *Supervisor) check(p *process) error {
+	s.mutex.Lock()
+	running := p.cmd != nil
+	s.mutex.Unlock()
+	if !running {
+		return nil
+	}
+
+	err := p.check()
+
+	s.mutex.Lock()
+	p.status.LastCheck = time.Now().Unix()
+	changed := false
+	if err != nil {
+		p.status.Failures++
+		p.status.Error = err.Error()
+		changed = p.status.State != Unhealthy
+		p.status.State = Unhealthy
+	} else {
+		changed = p.status.State != Running
+		p.status.Failures = 0
+		p.status.State = Running
+		if time.Since(time.Unix(p.status.Started, 0)) >= stableAfter {
+			p.backoff = initialBackoff
+		}
+	}
+	failures := p.status.Failures
+	s.mutex.Unlock()
+
+	if changed {
+		s.publish()
+	}
+	if err != nil {
+		log.Printf("Health check of %s failed (%d in a row): %v", p.name, failures, err)
+		if failures >= s.config.MaxFailures {
+			return fmt.Errorf("%d health checks failed, last: %v", failures, err)
+		}
+	}
+	return nil
+}
+
+func (s *Supervisor) checkBtcd() error {
+	if s.btcd.Disconnected() {
+		return errors.New("RPC client is disconnected")
+	}
+	_, err := call(s.config.CheckTimeout, s.btcd.GetBlockCount)
+	if err != nil {
+		return err
+	}
+
+	// The sync progress is informative, a failure doesn't make btcd unhealthy
+	info, err := call(s.config.CheckTimeout, s.btcd.GetBlockChainInfo)
+	if err != nil {
+		log.Printf("Error reading the sync progress of btcd: %v", err)
+		return nil
+	}
+	s.mutex.Lock()
+	s.sync.Blocks = info.Blocks
+	s.sync.Headers = info.Headers
+	s.sync.Progress = info.VerificationProgress
+	s.sync.Syncing = info.InitialBlockDownload || info.Blocks < info.Headers
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *Supervisor) checkBtcwallet() error {
+	if s.btcwallet.Disconnected() {
+		return errors.New("RPC client is disconnected")
+	}
+	locked, err := call(s.config.CheckTimeout, func() (bool, error) {
+		result, err := s.btcwallet.RawRequest("walletislocked", nil)
+		if err != nil {
+			return false, err
+		}
+		var locked bool
+		err = json.Unmarshal(result, &locked)
+		return locked, err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.sync.WalletLocked = locked
+	s.mutex.Unlock()
+	return nil
+}
+
+// call runs an RPC and gives up after timeout, the client would otherwise hold requests sent while
+// it reconnects until the connection is back.
+func call[T any](timeout time.Duration, rpc func() (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := rpc()
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-time.After(timeout):
+		var zero T
+		return zero, fmt.Errorf("no answer within %v", timeout)
+	}
+}
+
+// fail stops a process that exited or keeps failing its checks and schedules its restart. The
+// backoff doubles every time until the process stays healthy for stableAfter.
+func (s *Supervisor) fail(ctx context.Context, p *process, cause error) {
+	// An unhealthy process still runs, it makes way for the new one
+	stopCtx, cancel := context.WithTimeout(ctx, stopTimeout)
+	err := s.stopProcess(stopCtx, p)
+	cancel()
+	if err != nil {
+		log.Println(err)
+	}
+
+	s.mutex.Lock()
+	backoff := p.backoff
+	p.backoff = min(p.backoff*2, s.config.MaxBackoff)
+	p.retry = time.After(backoff)
+	p.status.State = Restarting
+	p.status.Error = cause.Error()
+	s.mutex.Unlock()
+	s.publish()
+
+	log.Printf("%s failed, restarting it in %v: %v", p.name, backoff, cause)
+}
+
+// restart starts a process again once its backoff is over.
+func (s *Supervisor) restart(ctx context.Context, p *process) {
+	p.retry = nil
+	err := s.startProcess(p)
+	if err != nil {
+		s.fail(ctx, p, err)
+		return
+	}
+
+	s.mutex.Lock()
+	p.status.Restarts++
+	s.mutex.Unlock()
+	s.publish()
+	log.Printf("Restarted %s", p.name)
+}
+
+// startProcess starts a process and waits for it in the background.
+func (s *Supervisor) startProcess(p *process) error {
+	s.mutex.Lock()
+	p.status.State = Starting
+	s.mutex.Unlock()
+	s.publish()
+
+	cmd, err := p.start(p.log)
+	if err != nil {
+		s.mutex.Lock()
+		p.status.State = Stopped
+		p.status.Error = err.Error()
+		s.mutex.Unlock()
+		s.publish()
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	s.mutex.Lock()
+	if s.stopping {
+		// Stop gave up waiting for the restart, the process is not left behind
+		s.mutex.Unlock()
+		interrupt(cmd)
+		<-exited
+		return errors.New("the node is shutting down")
+	}
+	p.cmd = cmd
+	p.exited = exited
+	p.status = ProcessStatus{
+		State:    Running,
+		PID:      cmd.Process.Pid,
+		Started:  time.Now().Unix(),
+		Restarts: p.status.Restarts,
+		Log:      p.status.Log,
+	}
+	s.mutex.Unlock()
+	s.publish()
+	return nil
+}
+
+// exited forgets a process that exited on its own.
+func (s *Supervisor) exited(p *process) {
+	s.mutex.Lock()
+	p.cmd = nil
+	p.exited = nil
+	p.status.PID = 0
+	s.mutex.Unlock()
+}
+
+// stopProcess interrupts a process and waits for it to exit, it is killed when ctx is done first.
+func (s *Supervisor) stopProcess(ctx context.Context, p *process) error {
+	s.mutex.Lock()
+	cmd, exited := p.cmd, p.exited
+	p.cmd = nil
+	p.exited = nil
+	p.status.State = Stopped
+	p.status.PID = 0
+	s.mutex.Unlock()
+	if cmd == nil {
+		return nil
+	}
+
+	err := interrupt(cmd)
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		log.Printf("Error interrupting %s: %v", p.name, err)
+	}
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-exited
+		return fmt.Errorf("%s did not exit in time and was killed", p.name)
+	}
+}
+
+func (s *Supervisor) publish() {
+	events.Publish(events.BtcStatus, s.Status())
+}
+
+func exitError(err error) error {
+	if err == nil {
+		return errors.New("exited")
+	}
+	return fmt.Errorf("exited: %v", err)
+}
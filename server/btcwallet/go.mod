@@ -56,5 +56,3 @@ require (
 go 1.22
 
 replace github.com/btcsuite/btcd => ../btcd
-
-replace github.com/btcsuite/btcd/btcec/v2 => github.com/btcsuite/btcd/btcec/v2 v2.2.2
@@ -1575,11 +1575,7 @@ func signMessage(icmd interface{}, w *wallet.Wallet) (interface{}, error) {
 	_ = wire.WriteVarString(&buf, 0, "Bitcoin Signed Message:\n")
 	_ = wire.WriteVarString(&buf, 0, cmd.Message)
 	messageHash := chainhash.DoubleHashB(buf.Bytes())
-	sigbytes, err := ecdsa.SignCompact(privKey, messageHash, true)
-	if err != nil {
-		return nil, err
-	}
-
+	sigbytes := ecdsa.SignCompact(privKey, messageHash, true)
 	return base64.StdEncoding.EncodeToString(sigbytes), nil
 }
 
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiPrefix is where the daemon serves its versioned API
+const apiPrefix = "/api/v1"
+
+// client calls the daemon's REST API
+type client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// newClient returns a client for the API at addr, or on the Unix socket when socketPath is set.
+func newClient(addr, token, socketPath string) *client {
+	c := &client{
+		base:  strings.TrimSuffix(addr, "/"),
+		token: token,
+		http:  &http.Client{Timeout: 2 * time.Minute},
+	}
+
+	if socketPath != "" {
+		c.base = "http://unix"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		}
+	}
+	return c
+}
+
+// apiError is the error envelope returned by the daemon
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// do sends body as JSON and returns the raw response body of a successful call.
+func (c *client) do(method, path string, body any) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, c.base+apiPrefix+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		request.Header.Set("X-API-Token", c.token)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("is the daemon running? %v", err)
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 300 {
+		var envelope apiError
+		if json.Unmarshal(data, &envelope) == nil && envelope.Error.Message != "" {
+			return nil, fmt.Errorf("%s (%s)", envelope.Error.Message, envelope.Error.Code)
+		}
+		if response.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("the daemon rejected the API token, check -token-file")
+		}
+		return nil, fmt.Errorf("%s: %s", response.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// call sends body and decodes the JSON response into result, which may be nil.
+func (c *client) call(method, path string, body, result any) ([]byte, error) {
+	data, err := c.do(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if result != nil && len(data) > 0 {
+		err = json.Unmarshal(data, result)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected response from the daemon: %v", err)
+		}
+	}
+	return data, nil
+}
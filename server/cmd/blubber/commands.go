@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"server/database/models"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// output prints either the daemon's JSON or a human readable summary
+type output struct {
+	json bool
+	w    io.Writer
+}
+
+// raw prints a JSON response indented, an empty response is printed as null.
+func (o *output) raw(data []byte) {
+	var indented bytes.Buffer
+	if len(bytes.TrimSpace(data)) == 0 || json.Indent(&indented, data, "", "  ") != nil {
+		fmt.Fprintln(o.w, "null")
+		return
+	}
+	fmt.Fprintln(o.w, strings.TrimSpace(indented.String()))
+}
+
+// table prints rows under headers in aligned columns.
+func (o *output) table(headers []string, rows [][]string) {
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	tw.Flush()
+}
+
+// done prints data with -json or the message otherwise.
+func (o *output) done(data []byte, format string, args ...any) {
+	if o.json {
+		o.raw(data)
+		return
+	}
+	fmt.Fprintf(o.w, format+"\n", args...)
+}
+
+// parse parses the flags of a subcommand and checks its number of arguments.
+func parse(flags *flag.FlagSet, args []string, want int, usage string) ([]string, error) {
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: blubber %s\n", usage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if flags.NArg() != want {
+		return nil, fmt.Errorf("usage: blubber %s", usage)
+	}
+	return flags.Args(), nil
+}
+
+func runAdd(c *client, out *output, usage string, args []string) error {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	name := flags.String("name", "", "name to store the file under, defaults to the file name")
+	args, err := parse(flags, args, 1, usage)
+	if err != nil {
+		return err
+	}
+
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if *name == "" {
+		*name = info.Name()
+	}
+
+	request := map[string]any{
+		"path":      path,
+		"name":      *name,
+		"extension": filepath.Ext(path),
+		"size":      info.Size(),
+		"date":      time.Now().Format("2006-01-02"),
+	}
+	var record models.Storing
+	data, err := c.call("POST", "/storing", request, &record)
+	if err != nil {
+		return err
+	}
+	out.done(data, "%s", record.Hash)
+	return nil
+}
+
+func runHost(c *client, out *output, usage string, args []string) error {
+	flags := flag.NewFlagSet("host", flag.ExitOnError)
+	args, err := parse(flags, args, 2, usage)
+	if err != nil {
+		return err
+	}
+
+	price, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid price %q", args[1])
+	}
+
+	var record models.JoinedHosting
+	data, err := c.call("POST", "/hosting", map[string]any{"hash": args[0], "price": price}, &record)
+	if err != nil {
+		return err
+	}
+	out.done(data, "Hosting %s for %v", record.Name, record.Price)
+	return nil
+}
+
+func runUnhost(c *client, out *output, usage string, args []string) error {
+	flags := flag.NewFlagSet("unhost", flag.ExitOnError)
+	args, err := parse(flags, args, 1, usage)
+	if err != nil {
+		return err
+	}
+
+	data, err := c.call("DELETE", "/hosting/"+args[0], nil, nil)
+	if err != nil {
+		return err
+	}
+	out.done(data, "Stopped hosting %s", args[0])
+	return nil
+}
+
+func runShare(c *client, out *output, usage string, args []string) error {
+	flags := flag.NewFlagSet("share", flag.ExitOnError)
+	encrypted := flags.Bool("encrypted", false, "share an encrypted copy, the key is only in the link")
+	price := flags.Float64("price", 0, "price of the encrypted copy for other peers")
+	args, err := parse(flags, args, 1, usage)
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Hash string `json:"hash"`
+		Link string `json:"link"`
+	}
+	path, body := "/sharing", map[string]any{"hash": args[0]}
+	if *encrypted {
+		path, body["price"] = "/encrypted-shares", *price
+	}
+	data, err := c.call("POST", path, body, &response)
+	if err != nil {
+		return err
+	}
+	if *encrypted {
+		out.done(data, "Encrypted copy %s\n%s", response.Hash, response.Link)
+		return nil
+	}
+	out.done(data, "%s", response.Link)
+	return nil
+}
+
+func runUnshare(c *client, out *output, usage string, args []string) error {
+	flags := flag.NewFlagSet("unshare", flag.ExitOnError)
+	args, err := parse(flags, args, 1, usage)
+	if err != nil {
+		return err
+	}
+
+	data, err := c.call("DELETE", "/sharing/"+args[0], nil, nil)
+	if err != nil {
+		return err
+	}
+	out.done(data, "Stopped sharing %s", args[0])
+	return nil
+}
+
+func runFiles(c *client, out *output, usage string, args []string) error {
+	flags := flag.NewFlagSet("files", flag.ExitOnError)
+	_, err := parse(flags, args, 0, usage)
+	if err != nil {
+		return err
+	}
+
+	var storing []models.Storing
+	storingData, err := c.call("GET", "/storing", nil, &storing)
+	if err != nil {
+		return err
+	}
+	var hosting []models.JoinedHosting
+	hostingData, err := c.call("GET", "/hosting", nil, &hosting)
+	if err != nil {
+		return err
+	}
+	var sharing []models.JoinedSharing
+	sharingData, err := c.call("GET", "/sharing", nil, &sharing)
+	if err != nil {
+		return err
+	}
+
+	if out.json {
+		out.raw([]byte(fmt.Sprintf(`{"storing":%s,"hosting":%s,"sharing":%s}`, storingData, hostingData, sharingData)))
+		return nil
+	}
+
+	prices := map[string]float64{}
+	for _, record := range hosting {
+		prices[record.Hash] = record.Price
+	}
+	shared := map[string]bool{}
+	for _, record := range sharing {
+		shared[record.Hash] = true
+	}
+
+	rows := [][]string{}
+	for _, record := range storing {
+		price, hosted := prices[record.Hash]
+		hostedColumn := "-"
+		if hosted {
+			hostedColumn = strconv.FormatFloat(price, 'f', -1, 64)
+		}
+		sharedColumn := "-"
+		if shared[record.Hash] {
+			sharedColumn = "yes"
+		}
+		rows = append(rows, []string{record.Hash, record.Name, strconv.FormatInt(record.Size, 10), hostedColumn, sharedColumn})
+	}
+	out.table([]string{"HASH", "NAME", "SIZE", "PRICE", "SHARED"}, rows)
+	return nil
+}
+
+func runSearch(c *client, out *output, usage string, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	info := flags.Bool("info", false, "ask every provider for a quote: name, size, price and free slots")
+	order := flags.String("sort", "price", "order of the quotes: price, latency or score")
+	args, err := parse(flags, args, 1, usage)
+	if err != nil {
+		return err
+	}
+
+	if *info {
+		return searchQuotes(c, out, args[0], *order)
+	}
+
+	var response struct {
+		Providers []struct {
+			ID    string  `json:"id"`
+			Score float64 `json:"score"`
+		} `json:"providers"`
+	}
+	data, err := c.call("GET", "/providers/"+args[0], nil, &response)
+	if err != nil {
+		return err
+	}
+
+	if out.json {
+		out.raw(data)
+		return nil
+	}
+	if len(response.Providers) == 0 {
+		fmt.Fprintln(out.w, "No providers found")
+		return nil
+	}
+	rows := [][]string{}
+	for _, provider := range response.Providers {
+		rows = append(rows, []string{provider.ID, strconv.FormatFloat(provider.Score, 'f', 3, 64)})
+	}
+	out.table([]string{"PEER", "SCORE"}, rows)
+	return nil
+}
+
+// searchQuotes collects the quotes of all providers in one call, best first.
+func searchQuotes(c *client, out *output, hash, order string) error {
+	var quotes []struct {
+		Peer      string  `json:"peer"`
+		Available bool    `json:"available"`
+		Name      string  `json:"name"`
+		Size      int64   `json:"size"`
+		Price     float64 `json:"price"`
+		FreeSlots int     `json:"freeSlots"`
+		LatencyMs int64   `json:"latencyMs"`
+		Score     float64 `json:"score"`
+		Error     string  `json:"error"`
+	}
+	data, err := c.call("GET", "/providers/"+hash+"/quotes?sort="+url.QueryEscape(order), nil, &quotes)
+	if err != nil {
+		return err
+	}
+
+	if out.json {
+		out.raw(data)
+		return nil
+	}
+	if len(quotes) == 0 {
+		fmt.Fprintln(out.w, "No providers found")
+		return nil
+	}
+
+	rows := [][]string{}
+	for _, quote := range quotes {
+		score := strconv.FormatFloat(quote.Score, 'f', 3, 64)
+		switch {
+		case quote.Error != "":
+			rows = append(rows, []string{quote.Peer, score, "-", "-", "-", "-", "-", quote.Error})
+		case !quote.Available:
+			rows = append(rows, []string{quote.Peer, score, "-", "-", "-", "-", "-", "no longer hosted"})
+		default:
+			rows = append(rows, []string{quote.Peer, score, quote.Name, strconv.FormatInt(quote.Size, 10),
+				strconv.FormatFloat(quote.Price, 'f', -1, 64), strconv.Itoa(quote.FreeSlots), fmt.Sprintf("%dms", quote.LatencyMs), ""})
+		}
+	}
+	out.table([]string{"PEER", "SCORE", "NAME", "SIZE", "PRICE", "SLOTS", "LATENCY", "ERROR"}, rows)
+	return nil
+}
+
+func runDownload(c *client, out *output, usage string, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return listDownloads(c, out)
+		case "cancel", "pause", "resume":
+			if len(args) != 2 {
+				return fmt.Errorf("usage: blubber download %s <id>", args[0])
+			}
+			return changeDownload(c, out, args[0], args[1])
+		}
+	}
+
+	flags := flag.NewFlagSet("download", flag.ExitOnError)
+	peer := flags.String("peer", "", "provider to try first")
+	maxPrice := flags.Float64("max-price", 0, "skip providers asking more, 0 for no limit")
+	priority := flags.Int64("priority", 0, "higher priorities download first")
+	destination := flags.String("dest", "", "directory to save the file to, defaults to the daemon's download directory")
+	store := flags.Bool("store", false, "also add the file to the stored files")
+	args, err := parse(flags, args, 1, usage)
+	if err != nil {
+		return err
+	}
+
+	if *destination != "" {
+		*destination, err = filepath.Abs(*destination)
+		if err != nil {
+			return err
+		}
+	}
+
+	request := map[string]any{
+		"hash":        args[0],
+		"peer":        *peer,
+		"maxPrice":    *maxPrice,
+		"priority":    *priority,
+		"destination": *destination,
+		"store":       *store,
+	}
+	var job models.DownloadJob
+	data, err := c.call("POST", "/downloads/jobs", request, &job)
+	if err != nil {
+		return err
+	}
+	out.done(data, "Queued download %d for %s", job.Id, job.Hash)
+	return nil
+}
+
+func listDownloads(c *client, out *output) error {
+	var jobs []models.DownloadJob
+	data, err := c.call("GET", "/downloads/jobs", nil, &jobs)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		out.raw(data)
+		return nil
+	}
+
+	rows := [][]string{}
+	for _, job := range jobs {
+		rows = append(rows, []string{
+			strconv.FormatInt(job.Id, 10),
+			job.State,
+			strconv.FormatInt(job.Priority, 10),
+			strconv.FormatInt(job.Attempts, 10),
+			job.Hash,
+			job.Path,
+			job.Error,
+		})
+	}
+	out.table([]string{"ID", "STATE", "PRIORITY", "ATTEMPTS", "HASH", "PATH", "ERROR"}, rows)
+	return nil
+}
+
+func changeDownload(c *client, out *output, action, id string) error {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return fmt.Errorf("invalid download id %q", id)
+	}
+
+	if action == "cancel" {
+		data, err := c.call("DELETE", "/downloads/jobs/"+id, nil, nil)
+		if err != nil {
+			return err
+		}
+		out.done(data, "Canceled download %s", id)
+		return nil
+	}
+
+	var job models.DownloadJob
+	data, err := c.call("POST", "/downloads/jobs/"+id+"/"+action, nil, &job)
+	if err != nil {
+		return err
+	}
+	out.done(data, "Download %d is %s", job.Id, job.State)
+	return nil
+}
+
+func runPeers(c *client, out *output, usage string, args []string) error {
+	flags := flag.NewFlagSet("peers", flag.ExitOnError)
+	_, err := parse(flags, args, 0, usage)
+	if err != nil {
+		return err
+	}
+
+	var peers []struct {
+		ID        string   `json:"id"`
+		Addresses []string `json:"addresses"`
+	}
+	data, err := c.call("GET", "/peers", nil, &peers)
+	if err != nil {
+		return err
+	}
+	if out.json {
+		out.raw(data)
+		return nil
+	}
+
+	rows := [][]string{}
+	for _, peer := range peers {
+		rows = append(rows, []string{peer.ID, strings.Join(peer.Addresses, " ")})
+	}
+	out.table([]string{"PEER", "ADDRESSES"}, rows)
+	return nil
+}
+
+func runWallet(c *client, out *output, usage string, args []string) error {
+	if len(args) == 1 && args[0] == "transactions" {
+		var transactions []models.Transactions
+		data, err := c.call("GET", "/transactions", nil, &transactions)
+		if err != nil {
+			return err
+		}
+		if out.json {
+			out.raw(data)
+			return nil
+		}
+
+		rows := [][]string{}
+		for _, transaction := range transactions {
+			rows = append(rows, []string{
+				transaction.Date,
+				transaction.Category,
+				strconv.FormatFloat(transaction.Amount, 'f', -1, 64),
+				strconv.FormatInt(transaction.Confirmations, 10),
+				transaction.Id,
+			})
+		}
+		out.table([]string{"DATE", "CATEGORY", "AMOUNT", "CONFIRMATIONS", "ID"}, rows)
+		return nil
+	}
+	if len(args) == 1 && args[0] == "status" {
+		type process struct {
+			State    string `json:"state"`
+			PID      int    `json:"pid"`
+			Restarts int    `json:"restarts"`
+			Failures int    `json:"failures"`
+			Error    string `json:"error"`
+			Log      string `json:"log"`
+		}
+		var status struct {
+			Btcd      process `json:"btcd"`
+			Btcwallet process `json:"btcwallet"`
+			Sync      struct {
+				Blocks       int32   `json:"blocks"`
+				Headers      int32   `json:"headers"`
+				Progress     float64 `json:"progress"`
+				WalletLocked bool    `json:"walletLocked"`
+			} `json:"sync"`
+		}
+		data, err := c.call("GET", "/btc/status", nil, &status)
+		if err != nil {
+			return err
+		}
+		if out.json {
+			out.raw(data)
+			return nil
+		}
+
+		rows := [][]string{}
+		for _, p := range []struct {
+			name string
+			process
+		}{{"btcd", status.Btcd}, {"btcwallet", status.Btcwallet}} {
+			rows = append(rows, []string{p.name, p.State, strconv.Itoa(p.PID), strconv.Itoa(p.Restarts),
+				strconv.Itoa(p.Failures), p.Log, p.Error})
+		}
+		out.table([]string{"PROCESS", "STATE", "PID", "RESTARTS", "FAILED CHECKS", "LOG", "ERROR"}, rows)
+		out.done(data, "Blocks: %d of %d (%.1f%%)\nWallet locked: %v", status.Sync.Blocks, status.Sync.Headers,
+			status.Sync.Progress*100, status.Sync.WalletLocked)
+		return nil
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("usage: blubber %s", usage)
+	}
+
+	var wallet models.Wallet
+	data, err := c.call("GET", "/wallet", nil, &wallet)
+	if err != nil {
+		return err
+	}
+	out.done(data, "Address: %s\nBalance: %v\nPending: %v", wallet.Address, wallet.CurrentBalance, wallet.PendingBalance)
+	return nil
+}
+
+func runProxy(c *client, out *output, usage string, args []string) error {
+	if len(args) == 0 {
+		var proxy models.Proxy
+		data, err := c.call("GET", "/proxy", nil, &proxy)
+		if err != nil {
+			return err
+		}
+		out.done(data, "Offering %s at %v", proxy.IP, proxy.Rate)
+		return nil
+	}
+
+	switch args[0] {
+	case "list":
+		var proxies []models.Proxy
+		data, err := c.call("GET", "/proxies", nil, &proxies)
+		if err != nil {
+			return err
+		}
+		if out.json {
+			out.raw(data)
+			return nil
+		}
+
+		rows := [][]string{}
+		for _, proxy := range proxies {
+			protocol := proxy.Protocol
+			if protocol == "" {
+				protocol = "socks5"
+			}
+			rows = append(rows, []string{proxy.IP, protocol, proxy.Region, strconv.FormatFloat(proxy.Rate, 'f', -1, 64), proxy.Node})
+		}
+		out.table([]string{"ADDRESS", "PROTOCOL", "REGION", "RATE", "PEER"}, rows)
+		return nil
+
+	case "probe":
+		var results []struct {
+			Proxy      models.Proxy `json:"proxy"`
+			Latency    int64        `json:"latency"`
+			Throughput int64        `json:"throughput"`
+			Score      float64      `json:"score"`
+			Error      string       `json:"error"`
+		}
+		data, err := c.call("GET", "/proxies/probe?refresh=true", nil, &results)
+		if err != nil {
+			return err
+		}
+		if out.json {
+			out.raw(data)
+			return nil
+		}
+
+		rows := [][]string{}
+		for _, result := range results {
+			rows = append(rows, []string{result.Proxy.IP, result.Proxy.Protocol, strconv.FormatFloat(result.Proxy.Rate, 'f', -1, 64),
+				strconv.FormatInt(result.Latency, 10), strconv.FormatInt(result.Throughput, 10),
+				strconv.FormatFloat(result.Score, 'f', 3, 64), result.Error})
+		}
+		out.table([]string{"ADDRESS", "PROTOCOL", "RATE", "LATENCY (MS)", "THROUGHPUT (B/S)", "SCORE", "ERROR"}, rows)
+		return nil
+
+	case "offerings":
+		var offerings []models.Proxy
+		data, err := c.call("GET", "/proxy/offerings", nil, &offerings)
+		if err != nil {
+			return err
+		}
+		if out.json {
+			out.raw(data)
+			return nil
+		}
+
+		rows := [][]string{}
+		for _, offering := range offerings {
+			rows = append(rows, []string{strconv.FormatInt(offering.ID, 10), offering.IP, offering.Listen, offering.Protocol,
+				offering.Region, strconv.FormatFloat(offering.Rate, 'f', -1, 64), strconv.FormatInt(offering.Bandwidth, 10)})
+		}
+		out.table([]string{"ID", "ADDRESS", "LISTEN", "PROTOCOL", "REGION", "RATE", "BANDWIDTH"}, rows)
+		return nil
+
+	case "offer":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: blubber proxy offer <ip> <rate>")
+		}
+		rate, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return fmt.Errorf("invalid rate %q", args[2])
+		}
+
+		var proxy models.Proxy
+		data, err := c.call("PUT", "/proxy", map[string]any{"ip": args[1], "rate": rate}, &proxy)
+		if err != nil {
+			return err
+		}
+		out.done(data, "Offering %s at %v", proxy.IP, proxy.Rate)
+		return nil
+
+	case "logs":
+		var logs []models.ProxyLogs
+		data, err := c.call("GET", "/proxy/logs", nil, &logs)
+		if err != nil {
+			return err
+		}
+		if out.json {
+			out.raw(data)
+			return nil
+		}
+
+		rows := [][]string{}
+		for _, log := range logs {
+			rows = append(rows, []string{time.Unix(log.Time, 0).Format(time.DateTime), log.Session, log.IP, log.Peer,
+				strconv.FormatInt(log.BytesIn, 10), strconv.FormatInt(log.BytesOut, 10)})
+		}
+		out.table([]string{"TIME", "SESSION", "CLIENT", "PEER", "IN", "OUT"}, rows)
+		return nil
+
+	case "bills":
+		var bills []models.IssuedBill
+		data, err := c.call("GET", "/proxy/bills", nil, &bills)
+		if err != nil {
+			return err
+		}
+		if out.json {
+			out.raw(data)
+			return nil
+		}
+
+		rows := [][]string{}
+		for _, bill := range bills {
+			rows = append(rows, []string{strconv.FormatInt(bill.ID, 10), time.Unix(bill.Issued, 0).Format(time.DateTime),
+				bill.Peer, strconv.FormatInt(bill.Bytes, 10), strconv.FormatFloat(bill.Amount, 'f', -1, 64), bill.Status})
+		}
+		out.table([]string{"ID", "ISSUED", "PEER", "BYTES", "AMOUNT", "STATUS"}, rows)
+		return nil
+
+	default:
+		return fmt.Errorf("usage: blubber %s", usage)
+	}
+}
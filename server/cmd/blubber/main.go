@@ -0,0 +1,114 @@
+// Command blubber controls a running BlubberBytes daemon through its REST API.
+//
+//	blubber [-api http://localhost:3001] [-token-file ./api_token] [-json] <command> [arguments]
+//
+// Every command prints a short human readable summary, or the daemon's JSON response with -json
+// so that it can be piped into other tools.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is one blubber subcommand
+type command struct {
+	usage string
+	help  string
+	run   func(c *client, out *output, usage string, args []string) error
+}
+
+var commands = map[string]command{
+	"add":      {"add <path>", "store a file from the local disk", runAdd},
+	"host":     {"host <hash> <price>", "host a stored file for a price", runHost},
+	"unhost":   {"unhost <hash>", "stop hosting a file", runUnhost},
+	"share":    {"share [-encrypted [-price <price>]] <hash>", "share a stored file and print its link", runShare},
+	"unshare":  {"unshare <hash>", "stop sharing a file", runUnshare},
+	"files":    {"files", "list stored, hosted and shared files", runFiles},
+	"search":   {"search [-info] [-sort price|latency|score] <hash>", "find the peers providing a file", runSearch},
+	"download": {"download [flags] <hash> | list | cancel <id> | pause <id> | resume <id>", "queue and manage downloads", runDownload},
+	"peers":    {"peers", "list connected peers", runPeers},
+	"wallet":   {"wallet [transactions | status]", "show the wallet balance, its transactions or the state of btcd and btcwallet", runWallet},
+	"proxy":    {"proxy [list | probe | offerings | offer <ip> <rate> | logs | bills]", "show, find or offer proxies", runProxy},
+}
+
+func main() {
+	flags := flag.NewFlagSet("blubber", flag.ExitOnError)
+	addr := flags.String("api", envOr("BLUBBER_API", "http://localhost:3001"), "address of the daemon's REST API (or BLUBBER_API)")
+	tokenFile := flags.String("token-file", envOr("BLUBBER_API_TOKEN_FILE", "./api_token"), "file holding the API token (or BLUBBER_API_TOKEN_FILE)")
+	socket := flags.String("socket", os.Getenv("BLUBBER_API_SOCKET"), "Unix socket of the API, used instead of -api (or BLUBBER_API_SOCKET)")
+	asJSON := flags.Bool("json", false, "print the daemon's JSON responses")
+	flags.Usage = func() { usage(flags) }
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		usage(flags)
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "blubber: unknown command %q\n\n", flags.Arg(0))
+		usage(flags)
+		os.Exit(2)
+	}
+
+	token, err := readToken(*tokenFile, *socket)
+	if err != nil {
+		fatal(err)
+	}
+
+	out := &output{json: *asJSON, w: os.Stdout}
+	err = cmd.run(newClient(*addr, token, *socket), out, cmd.usage, flags.Args()[1:])
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// readToken reads the API token, BLUBBER_API_TOKEN takes precedence over the file.
+// The token is optional over the Unix socket.
+func readToken(path, socket string) (string, error) {
+	if token := os.Getenv("BLUBBER_API_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if socket != "" {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading API token: %v (run blubber from the daemon's directory or pass -token-file)", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "Usage: blubber [flags] <command> [arguments]\n\nCommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n             %s\n", name, commands[name].help, commands[name].usage)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flags.PrintDefaults()
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "blubber: %v\n", err)
+	os.Exit(1)
+}
@@ -51,10 +51,10 @@ func CreateNewTables(db *sql.DB) error {
 		return fmt.Errorf("failed to set up WalletInfo table: %v", err)
 	}
 
-	// Create Proxy table
-	err = SetupProxyTable(db)
+	// Create ProxyOfferings table
+	err = SetupProxyOfferingsTable(db)
 	if err != nil {
-		return fmt.Errorf("failed to set up Proxy table: %v", err)
+		return fmt.Errorf("failed to set up ProxyOfferings table: %v", err)
 	}
 
 	// Create ProxyLogs table
@@ -69,6 +69,102 @@ func CreateNewTables(db *sql.DB) error {
 		return fmt.Errorf("failed to set up IPtoNode table: %v", err)
 	}
 
+	// Create DownloadJobs table
+	err = SetupDownloadJobsTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up DownloadJobs table: %v", err)
+	}
+
+	// Create Peers table
+	err = SetupPeersTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up Peers table: %v", err)
+	}
+
+	// Create Reputation table
+	err = SetupReputationTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up Reputation table: %v", err)
+	}
+
+	// Create UploadLimits table
+	err = SetupUploadLimitsTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up UploadLimits table: %v", err)
+	}
+
+	// Create BlockedPeers table
+	err = SetupBlockedPeersTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up BlockedPeers table: %v", err)
+	}
+
+	// Create AbuseLog table
+	err = SetupAbuseLogTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up AbuseLog table: %v", err)
+	}
+
+	// Create EncryptedShares table
+	err = SetupEncryptedSharesTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up EncryptedShares table: %v", err)
+	}
+
+	// Create StorageContracts table
+	err = SetupStorageContractsTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up StorageContracts table: %v", err)
+	}
+
+	// Create StorageChallenges table
+	err = SetupStorageChallengesTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up StorageChallenges table: %v", err)
+	}
+
+	// Create Pins table
+	err = SetupPinsTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up Pins table: %v", err)
+	}
+
+	// Create StorageSettings table
+	err = SetupStorageSettingsTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up StorageSettings table: %v", err)
+	}
+
+	// Create ProxyUsage table
+	err = SetupProxyUsageTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up ProxyUsage table: %v", err)
+	}
+
+	// Create IssuedBills table
+	err = SetupIssuedBillsTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up IssuedBills table: %v", err)
+	}
+
+	// Create PaidBills table
+	err = SetupPaidBillsTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up PaidBills table: %v", err)
+	}
+
+	// Create ProxyRules table
+	err = SetupProxyRulesTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up ProxyRules table: %v", err)
+	}
+
+	// Create ProxyDenials table
+	err = SetupProxyDenialsTable(db)
+	if err != nil {
+		return fmt.Errorf("failed to set up ProxyDenials table: %v", err)
+	}
+
 	fmt.Println("All new tables created successfully.")
 	return nil
 }
@@ -5,7 +5,7 @@ import (
 	"fmt"
 )
 
-// SetupFilesTables initializes tables related to file management (storing, hosting, sharing, saved).
+// SetupFilesTables initializes tables related to file management (storing, hosting, sharing, saved, collections).
 func SetupFilesTables(db *sql.DB) error {
 	tables := map[string]string{
 		"Storing": `
@@ -36,6 +36,14 @@ func SetupFilesTables(db *sql.DB) error {
 				extension TEXT NOT NULL,
 				size INTEGER NOT NULL
 			);`,
+		"Collections": `
+			CREATE TABLE IF NOT EXISTS Collections (
+				hash TEXT PRIMARY KEY NOT NULL,
+				name TEXT NOT NULL,
+				owner TEXT NOT NULL,
+				manifest TEXT NOT NULL,
+				FOREIGN KEY(hash) REFERENCES Storing(hash)
+			);`,
 	}
 
 	// Execute each table creation statement
@@ -115,7 +123,9 @@ func SetupWalletInfoTable(db *sql.DB) error {
 	}
 	fmt.Printf("WalletInfo table created successfully.\n")
 
-	query := `INSERT INTO WalletInfo (address, pubPassphrase, privPassphrase) VALUES (?, ?, ?)`
+	// Only the first start inserts the placeholder, a kept database already has its row
+	query := `INSERT INTO WalletInfo (address, pubPassphrase, privPassphrase)
+	          SELECT ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM WalletInfo)`
 	_, err = db.Exec(query, "", "", "")
 	if err != nil {
 		return fmt.Errorf("error initializing WalletInfo table: %v", err)
@@ -125,12 +135,18 @@ func SetupWalletInfoTable(db *sql.DB) error {
 	return nil
 }
 
-// SetupProxyTable initializes the Proxy table with a placeholder row.
-func SetupProxyTable(db *sql.DB) error {
+// SetupProxyOfferingsTable creates the ProxyOfferings table, the proxies this node offers. The
+// single offer of the Proxy table it replaces becomes the first SOCKS5 offering.
+func SetupProxyOfferingsTable(db *sql.DB) error {
 	createTable :=
-		`CREATE TABLE IF NOT EXISTS Proxy (
-			ip TEXT PRIMARY KEY NOT NULL,
+		`CREATE TABLE IF NOT EXISTS ProxyOfferings (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ip TEXT NOT NULL,
+			listen TEXT NOT NULL DEFAULT '',
+			protocol TEXT NOT NULL,
 			rate REAL NOT NULL,
+			bandwidth INTEGER NOT NULL DEFAULT 0,
+			region TEXT NOT NULL DEFAULT '',
 			node TEXT NOT NULL,
 			wallet TEXT NOT NULL
 		);`
@@ -138,27 +154,51 @@ func SetupProxyTable(db *sql.DB) error {
 	// Execute the table creation statement
 	_, err := db.Exec(createTable)
 	if err != nil {
-		return fmt.Errorf("error creating Proxy table: %v", err)
+		return fmt.Errorf("error creating ProxyOfferings table: %v", err)
+	}
+	fmt.Printf("ProxyOfferings table created successfully.\n")
+
+	var legacy int
+	err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'Proxy'`).Scan(&legacy)
+	if err != nil {
+		return fmt.Errorf("error looking for the Proxy table: %v", err)
+	}
+	if legacy == 0 {
+		return nil
 	}
-	fmt.Printf("Proxy table created successfully.\n")
 
-	query := `INSERT INTO Proxy (ip, rate, node, wallet) VALUES (?, ?, ?, ?)`
-	_, err = db.Exec(query, "", 0, "", "")
+	// The placeholder row of the Proxy table has no IP
+	query := `INSERT INTO ProxyOfferings (ip, protocol, rate, node, wallet)
+	          SELECT ip, 'socks5', rate, node, wallet FROM Proxy WHERE ip != ''`
+	_, err = db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("error moving the Proxy table to ProxyOfferings: %v", err)
+	}
+	_, err = db.Exec(`DROP TABLE Proxy`)
 	if err != nil {
-		return fmt.Errorf("error initializing Proxy table: %v", err)
+		return fmt.Errorf("error dropping the Proxy table: %v", err)
 	}
-	fmt.Printf("Proxy table initialized successfully.\n")
+	fmt.Printf("Proxy table moved to ProxyOfferings.\n")
 
 	return nil
 }
 
+// SetupProxyLogsTable creates the ProxyLogs table, every row is a checkpoint of a proxy session
+// with the bytes since the previous one. bytes_in is what the client sent, bytes_out what it got,
+// bill is the IssuedBills row the bytes were billed in and offering the proxy the client used.
 func SetupProxyLogsTable(db *sql.DB) error {
 	createTable :=
 		`CREATE TABLE IF NOT EXISTS ProxyLogs (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session TEXT NOT NULL DEFAULT '',
 			ip TEXT NOT NULL,
+			peer TEXT NOT NULL DEFAULT '',
+			bytes_in INTEGER NOT NULL DEFAULT 0,
+			bytes_out INTEGER NOT NULL DEFAULT 0,
 			bytes INTEGER NOT NULL,
-			time INTEGER NOT NULL
+			time INTEGER NOT NULL,
+			bill INTEGER NOT NULL DEFAULT 0,
+			offering INTEGER NOT NULL DEFAULT 0
 		);`
 
 	// Execute the table creation statement
@@ -166,11 +206,58 @@ func SetupProxyLogsTable(db *sql.DB) error {
 	if err != nil {
 		return fmt.Errorf("error creating ProxyLogs table: %v", err)
 	}
+
+	// Databases from before the sessions only have the total bytes
+	err = addMissingColumns(db, "ProxyLogs", [][2]string{
+		{"session", "TEXT NOT NULL DEFAULT ''"},
+		{"peer", "TEXT NOT NULL DEFAULT ''"},
+		{"bytes_in", "INTEGER NOT NULL DEFAULT 0"},
+		{"bytes_out", "INTEGER NOT NULL DEFAULT 0"},
+		{"bill", "INTEGER NOT NULL DEFAULT 0"},
+		{"offering", "INTEGER NOT NULL DEFAULT 0"},
+	})
+	if err != nil {
+		return err
+	}
 	fmt.Printf("ProxyLogs table created successfully.\n")
 
 	return nil
 }
 
+// addMissingColumns adds the columns, given as name and definition, that a table doesn't have yet.
+func addMissingColumns(db *sql.DB, table string, columns [][2]string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("error reading %s columns: %v", table, err)
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, kind       string
+			defaultValue     sql.NullString
+		)
+		err = rows.Scan(&cid, &name, &kind, &notNull, &defaultValue, &pk)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning %s columns: %v", table, err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+
+	for _, column := range columns {
+		if existing[column[0]] {
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column[0], column[1]))
+		if err != nil {
+			return fmt.Errorf("error adding column %s to %s: %v", column[0], table, err)
+		}
+	}
+	return nil
+}
+
 func SetupIPtoNodeTable(db *sql.DB) error {
 	createTable :=
 		`CREATE TABLE IF NOT EXISTS IPtoNode (
@@ -187,3 +274,429 @@ func SetupIPtoNodeTable(db *sql.DB) error {
 
 	return nil
 }
+
+// SetupDownloadJobsTable initializes the DownloadJobs table holding the download manager's queue.
+func SetupDownloadJobsTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS DownloadJobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hash TEXT NOT NULL,
+			name TEXT NOT NULL,
+			extension TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			price REAL NOT NULL,
+			maxPrice REAL NOT NULL,
+			peer TEXT NOT NULL,
+			priority INTEGER NOT NULL,
+			state TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			destination TEXT NOT NULL,
+			path TEXT NOT NULL,
+			store INTEGER NOT NULL,
+			paid INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL,
+			nextAttempt INTEGER NOT NULL,
+			created INTEGER NOT NULL,
+			updated INTEGER NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating DownloadJobs table: %v", err)
+	}
+
+	err = addMissingColumns(db, "DownloadJobs", [][2]string{{"paid", "INTEGER NOT NULL DEFAULT 0"}})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("DownloadJobs table created successfully.\n")
+
+	return nil
+}
+
+// SetupPeersTable initializes the Peers table, the peerstore kept across restarts.
+func SetupPeersTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS Peers (
+			id TEXT PRIMARY KEY NOT NULL,
+			addresses TEXT NOT NULL,
+			record BLOB NOT NULL,
+			lastSeen INTEGER NOT NULL,
+			successes INTEGER NOT NULL,
+			failures INTEGER NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating Peers table: %v", err)
+	}
+	fmt.Printf("Peers table created successfully.\n")
+
+	return nil
+}
+
+// SetupReputationTable initializes the Reputation table, the outcomes of our dealings with each peer.
+func SetupReputationTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS Reputation (
+			peer TEXT PRIMARY KEY NOT NULL,
+			transfers INTEGER NOT NULL,
+			bytes INTEGER NOT NULL,
+			seconds REAL NOT NULL,
+			timeouts INTEGER NOT NULL,
+			failures INTEGER NOT NULL,
+			hashMismatches INTEGER NOT NULL,
+			disputes INTEGER NOT NULL,
+			bills INTEGER NOT NULL,
+			inaccurateBills INTEGER NOT NULL,
+			updated INTEGER NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating Reputation table: %v", err)
+	}
+	fmt.Printf("Reputation table created successfully.\n")
+
+	return nil
+}
+
+// SetupUploadLimitsTable initializes the UploadLimits table with the default limits.
+func SetupUploadLimitsTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS UploadLimits (
+			globalRate INTEGER NOT NULL,
+			peerRate INTEGER NOT NULL,
+			maxUploads INTEGER NOT NULL,
+			maxQueue INTEGER NOT NULL,
+			schedule TEXT NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating UploadLimits table: %v", err)
+	}
+	fmt.Printf("UploadLimits table created successfully.\n")
+
+	// No rate limit, 4 uploads at a time and 16 waiting
+	query := `INSERT INTO UploadLimits (globalRate, peerRate, maxUploads, maxQueue, schedule)
+	          SELECT ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM UploadLimits)`
+	_, err = db.Exec(query, 0, 0, 4, 16, "[]")
+	if err != nil {
+		return fmt.Errorf("error initializing UploadLimits table: %v", err)
+	}
+	fmt.Printf("UploadLimits table initialized successfully.\n")
+
+	return nil
+}
+
+func SetupBlockedPeersTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS BlockedPeers (
+			peer TEXT PRIMARY KEY NOT NULL,
+			reason TEXT NOT NULL,
+			created INTEGER NOT NULL,
+			expires INTEGER NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating BlockedPeers table: %v", err)
+	}
+	fmt.Printf("BlockedPeers table created successfully.\n")
+
+	return nil
+}
+
+func SetupAbuseLogTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS AbuseLog (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			peer TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			time INTEGER NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating AbuseLog table: %v", err)
+	}
+	fmt.Printf("AbuseLog table created successfully.\n")
+
+	return nil
+}
+
+func SetupEncryptedSharesTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS EncryptedShares (
+			hash TEXT PRIMARY KEY NOT NULL,
+			source TEXT NOT NULL,
+			key TEXT NOT NULL,
+			date TEXT NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating EncryptedShares table: %v", err)
+	}
+	fmt.Printf("EncryptedShares table created successfully.\n")
+
+	return nil
+}
+
+func SetupStorageContractsTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS StorageContracts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hash TEXT NOT NULL,
+			peer TEXT NOT NULL,
+			wallet TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			price REAL NOT NULL,
+			interval INTEGER NOT NULL,
+			start INTEGER NOT NULL,
+			end INTEGER NOT NULL,
+			rounds INTEGER NOT NULL,
+			round INTEGER NOT NULL,
+			nextProof INTEGER NOT NULL,
+			proofs INTEGER NOT NULL,
+			failures INTEGER NOT NULL,
+			paid REAL NOT NULL,
+			owed REAL NOT NULL DEFAULT 0,
+			status TEXT NOT NULL,
+			lastError TEXT NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating StorageContracts table: %v", err)
+	}
+
+	err = addMissingColumns(db, "StorageContracts", [][2]string{{"owed", "REAL NOT NULL DEFAULT 0"}})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("StorageContracts table created successfully.\n")
+
+	return nil
+}
+
+func SetupStorageChallengesTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS StorageChallenges (
+			contract INTEGER NOT NULL,
+			round INTEGER NOT NULL,
+			nonce TEXT NOT NULL,
+			chunks TEXT NOT NULL,
+			expected TEXT NOT NULL,
+			PRIMARY KEY (contract, round)
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating StorageChallenges table: %v", err)
+	}
+	fmt.Printf("StorageChallenges table created successfully.\n")
+
+	return nil
+}
+
+func SetupPinsTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS Pins (
+			hash TEXT NOT NULL,
+			peer TEXT NOT NULL,
+			name TEXT NOT NULL,
+			path TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			price REAL NOT NULL,
+			interval INTEGER NOT NULL,
+			expires INTEGER NOT NULL,
+			proofs INTEGER NOT NULL,
+			lastProof INTEGER NOT NULL,
+			PRIMARY KEY (hash, peer)
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating Pins table: %v", err)
+	}
+	fmt.Printf("Pins table created successfully.\n")
+
+	return nil
+}
+
+// SetupStorageSettingsTable initializes the StorageSettings table with the default settings.
+func SetupStorageSettingsTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS StorageSettings (
+			enabled INTEGER NOT NULL,
+			maxBytes INTEGER NOT NULL,
+			minPrice REAL NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating StorageSettings table: %v", err)
+	}
+	fmt.Printf("StorageSettings table created successfully.\n")
+
+	// Store up to 1 GiB for other peers, at any price
+	query := `INSERT INTO StorageSettings (enabled, maxBytes, minPrice)
+	          SELECT ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM StorageSettings)`
+	_, err = db.Exec(query, true, 1<<30, 0)
+	if err != nil {
+		return fmt.Errorf("error initializing StorageSettings table: %v", err)
+	}
+	fmt.Printf("StorageSettings table initialized successfully.\n")
+
+	return nil
+}
+
+func SetupProxyUsageTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS ProxyUsage (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			node TEXT NOT NULL,
+			ip TEXT NOT NULL,
+			bytes INTEGER NOT NULL,
+			time INTEGER NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating ProxyUsage table: %v", err)
+	}
+	fmt.Printf("ProxyUsage table created successfully.\n")
+
+	return nil
+}
+
+// SetupIssuedBillsTable creates the IssuedBills table, the bills sent to the clients of our proxy.
+func SetupIssuedBillsTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS IssuedBills (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			peer TEXT NOT NULL,
+			offering INTEGER NOT NULL DEFAULT 0,
+			ip TEXT NOT NULL,
+			bytes INTEGER NOT NULL,
+			rate REAL NOT NULL,
+			amount REAL NOT NULL,
+			wallet TEXT NOT NULL,
+			periodStart INTEGER NOT NULL,
+			periodEnd INTEGER NOT NULL,
+			issued INTEGER NOT NULL,
+			due INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			paid INTEGER NOT NULL DEFAULT 0,
+			txid TEXT NOT NULL DEFAULT '',
+			lastError TEXT NOT NULL DEFAULT ''
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating IssuedBills table: %v", err)
+	}
+
+	// Bills from before the offerings were all for the same proxy
+	err = addMissingColumns(db, "IssuedBills", [][2]string{
+		{"offering", "INTEGER NOT NULL DEFAULT 0"},
+		{"txid", "TEXT NOT NULL DEFAULT ''"},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("IssuedBills table created successfully.\n")
+
+	return nil
+}
+
+// SetupPaidBillsTable creates the PaidBills table, the bills of other peers' proxies we paid.
+func SetupPaidBillsTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS PaidBills (
+			peer TEXT NOT NULL,
+			bill INTEGER NOT NULL,
+			amount REAL NOT NULL,
+			wallet TEXT NOT NULL,
+			txid TEXT NOT NULL DEFAULT '',
+			time INTEGER NOT NULL,
+			PRIMARY KEY (peer, bill)
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating PaidBills table: %v", err)
+	}
+	fmt.Printf("PaidBills table created successfully.\n")
+
+	return nil
+}
+
+// SetupProxyRulesTable initializes the ProxyRules table with the default rules.
+func SetupProxyRulesTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS ProxyRules (
+			allowCidrs TEXT NOT NULL,
+			denyCidrs TEXT NOT NULL,
+			allowDomains TEXT NOT NULL,
+			denyDomains TEXT NOT NULL,
+			allowPorts TEXT NOT NULL,
+			maxConnections INTEGER NOT NULL,
+			blockPrivate BOOLEAN NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating ProxyRules table: %v", err)
+	}
+	fmt.Printf("ProxyRules table created successfully.\n")
+
+	// Web traffic only, 64 connections per client and nothing on the local network
+	query := `INSERT INTO ProxyRules (allowCidrs, denyCidrs, allowDomains, denyDomains, allowPorts, maxConnections,
+	          blockPrivate) SELECT ?, ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM ProxyRules)`
+	_, err = db.Exec(query, "[]", "[]", "[]", "[]", `["80","443"]`, 64, true)
+	if err != nil {
+		return fmt.Errorf("error initializing ProxyRules table: %v", err)
+	}
+	fmt.Printf("ProxyRules table initialized successfully.\n")
+
+	return nil
+}
+
+func SetupProxyDenialsTable(db *sql.DB) error {
+	createTable :=
+		`CREATE TABLE IF NOT EXISTS ProxyDenials (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ip TEXT NOT NULL,
+			destination TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			time INTEGER NOT NULL
+		);`
+
+	// Execute the table creation statement
+	_, err := db.Exec(createTable)
+	if err != nil {
+		return fmt.Errorf("error creating ProxyDenials table: %v", err)
+	}
+	fmt.Printf("ProxyDenials table created successfully.\n")
+
+	return nil
+}
@@ -0,0 +1,17 @@
+package models
+
+// Table for BlockedPeers
+type BlockedPeer struct {
+	Peer    string `json:"peer"`
+	Reason  string `json:"reason"`
+	Created int64  `json:"created"`
+	Expires int64  `json:"expires"` // 0 blocks the peer until it is unblocked
+}
+
+// Table for AbuseLog
+type AbuseLog struct {
+	ID     int64  `json:"id"`
+	Peer   string `json:"peer"`
+	Reason string `json:"reason"`
+	Time   int64  `json:"time"`
+}
@@ -0,0 +1,25 @@
+package models
+
+// Table for Collections
+type Collection struct {
+	Hash     string `json:"hash"`
+	Name     string `json:"name"`
+	Owner    string `json:"owner"`
+	Manifest string `json:"manifest"`
+}
+
+// Struct (not a table) for a single child of a collection manifest
+type CollectionEntry struct {
+	Hash string `json:"hash"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// Struct (not a table) for the signed manifest that describes a collection
+type CollectionManifest struct {
+	Name      string            `json:"name"`
+	Owner     string            `json:"owner"`
+	Date      string            `json:"date"`
+	Entries   []CollectionEntry `json:"entries"`
+	Signature string            `json:"signature"`
+}
@@ -0,0 +1,24 @@
+package models
+
+// Table for DownloadJobs
+type DownloadJob struct {
+	Id          int64   `json:"id"`
+	Hash        string  `json:"hash"`
+	Name        string  `json:"name"`
+	Extension   string  `json:"extension"`
+	Size        int64   `json:"size"`
+	Price       float64 `json:"price"`
+	MaxPrice    float64 `json:"maxPrice"`
+	Peer        string  `json:"peer"`
+	Priority    int64   `json:"priority"`
+	State       string  `json:"state"`
+	Attempts    int64   `json:"attempts"`
+	Destination string  `json:"destination"`
+	Path        string  `json:"path"`
+	Store       bool    `json:"store"`
+	Paid        bool    `json:"paid"` // The file was bought, later attempts only save it
+	Error       string  `json:"error"`
+	NextAttempt int64   `json:"nextAttempt"`
+	Created     int64   `json:"created"`
+	Updated     int64   `json:"updated"`
+}
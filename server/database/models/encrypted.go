@@ -0,0 +1,28 @@
+package models
+
+// Table for EncryptedShares, the encrypted copies of stored files that are shared by the hash of
+// their ciphertext
+type EncryptedShare struct {
+	Hash   string `json:"hash"`   // Hash of the encrypted blob
+	Source string `json:"source"` // Hash of the plaintext file
+	Key    string `json:"-"`      // Content key, handed out only in the fragment of the share link
+	Date   string `json:"date"`
+}
+
+// Struct (not a table) for EncryptedShare joined with the Storing record of its source
+type JoinedEncryptedShare struct {
+	Hash      string `json:"hash"`
+	Source    string `json:"source"`
+	Name      string `json:"name"`
+	Extension string `json:"extension"`
+	Size      int64  `json:"size"`
+	Date      string `json:"date"`
+}
+
+// Struct (not a table) for the metadata sealed at the start of an encrypted blob, so that only
+// the holders of the key learn the file name
+type EncryptedMetadata struct {
+	Name      string `json:"name"`
+	Extension string `json:"extension"`
+	Size      int64  `json:"size"`
+}
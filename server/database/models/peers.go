@@ -0,0 +1,11 @@
+package models
+
+// Table for Peers
+type Peer struct {
+	ID        string   `json:"id"`
+	Addresses []string `json:"addresses"`
+	Record    []byte   `json:"-"` // Signed peer record, forwarded to other peers as is
+	LastSeen  int64    `json:"lastSeen"`
+	Successes int64    `json:"successes"`
+	Failures  int64    `json:"failures"`
+}
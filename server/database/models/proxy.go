@@ -1,16 +1,36 @@
 package models
 
-// Table for Proxy
+// Table for ProxyOfferings, the proxies a node offers. Peers receive them as the proxies to choose from.
 type Proxy struct {
-	IP     string  `json:"ip"`
-	Rate   float64 `json:"rate"`
-	Node   string  `json:"node"`
-	Wallet string  `json:"wallet"`
+	ID        int64   `json:"id,omitempty"`
+	IP        string  `json:"ip"` // Address clients connect to, as host:port
+	Rate      float64 `json:"rate"`
+	Node      string  `json:"node"`
+	Wallet    string  `json:"wallet"`
+	Listen    string  `json:"listen,omitempty"`    // Local address, the default address of its protocol when empty
+	Protocol  string  `json:"protocol,omitempty"`  // socks5 or http, peers from before offerings only have socks5
+	Bandwidth int64   `json:"bandwidth,omitempty"` // Bytes per second shared by its clients, 0 for no cap
+	Region    string  `json:"region,omitempty"`
 }
 
-// Table for ProxyLogs
+// Table for ProxyLogs, a checkpoint of a proxy session with the bytes since the previous one
 type ProxyLogs struct {
-	Id    string `json:"id"`
+	Id       string `json:"id"`
+	Session  string `json:"session"`
+	IP       string `json:"ip"`
+	Peer     string `json:"peer"`     // Node of the client IP, when it registered
+	BytesIn  int64  `json:"bytesIn"`  // Sent by the client
+	BytesOut int64  `json:"bytesOut"` // Sent to the client
+	Bytes    int64  `json:"bytes"`    // BytesIn + BytesOut
+	Time     int64  `json:"time"`
+	Bill     int64  `json:"bill"`     // IssuedBills ID the bytes were billed in, 0 until they are
+	Offering int64  `json:"offering"` // ProxyOfferings ID the client connected to
+}
+
+// Table for ProxyUsage, the traffic we sent through other peers' proxies as counted on our side
+type ProxyUsage struct {
+	Id    int64  `json:"id"`
+	Node  string `json:"node"` // Peer ID of the proxy
 	IP    string `json:"ip"`
 	Bytes int64  `json:"bytes"`
 	Time  int64  `json:"time"`
@@ -24,9 +44,66 @@ type IPtoNode struct {
 
 // Struct (not a table) for ProxyBill
 type ProxyBill struct {
-	IP     string  `json:"ip"`
-	Rate   float64 `json:"rate"`
-	Bytes  int64   `json:"bytes"`
+	IP       string  `json:"ip"` // Client IP of a bill, a registration counts the IP it comes from instead
+	Rate     float64 `json:"rate"`
+	Bytes    int64   `json:"bytes"`
+	Amount   float64 `json:"amount"`
+	Wallet   string  `json:"wallet"`
+	ID       int64   `json:"id,omitempty"`       // IssuedBills ID on the proxy, empty for registrations
+	Offering int64   `json:"offering,omitempty"` // ProxyOfferings ID of the proxy the traffic went through
+	From     int64   `json:"from,omitempty"`     // Period of the traffic billed
+	To       int64   `json:"to,omitempty"`
+}
+
+// Table for IssuedBills, the bills this node sent to the clients of its proxy
+type IssuedBill struct {
+	ID        int64   `json:"id"`
+	Peer      string  `json:"peer"`
+	Offering  int64   `json:"offering"`
+	IP        string  `json:"ip"` // Last IP the client used in the period
+	Bytes     int64   `json:"bytes"`
+	Rate      float64 `json:"rate"`
+	Amount    float64 `json:"amount"`
+	Wallet    string  `json:"wallet"` // Our address the client pays to
+	From      int64   `json:"from"`
+	To        int64   `json:"to"`
+	Issued    int64   `json:"issued"`
+	Due       int64   `json:"due"`
+	Status    string  `json:"status"` // issued, paid, overdue or disputed
+	Attempts  int64   `json:"attempts"`
+	Paid      int64   `json:"paid,omitempty"` // When the client confirmed the payment
+	TxID      string  `json:"txid,omitempty"` // Transaction the client paid with
+	LastError string  `json:"lastError"`
+}
+
+// Table for PaidBills, the bills of other peers' proxies this node paid. A bill sent again is
+// answered from here instead of being paid twice.
+type PaidBill struct {
+	Peer   string  `json:"peer"` // Peer ID of the proxy
+	Bill   int64   `json:"bill"` // IssuedBills ID on the proxy
 	Amount float64 `json:"amount"`
 	Wallet string  `json:"wallet"`
+	TxID   string  `json:"txid"` // Empty while the payment is being sent
+	Time   int64   `json:"time"`
+}
+
+// Table for ProxyRules, where the clients of our proxy may connect to. Deny entries win over allow
+// entries, and when there are allow entries a destination has to match one of them.
+type ProxyRules struct {
+	AllowCIDRs     []string `json:"allowCidrs"`
+	DenyCIDRs      []string `json:"denyCidrs"`
+	AllowDomains   []string `json:"allowDomains"` // A domain matches its subdomains too
+	DenyDomains    []string `json:"denyDomains"`
+	AllowPorts     []string `json:"allowPorts"`     // Ports or ranges like 8000-8100, empty for every port
+	MaxConnections int      `json:"maxConnections"` // Open connections per client IP, 0 for no limit
+	BlockPrivate   bool     `json:"blockPrivate"`   // Refuse loopback, private and link-local addresses
+}
+
+// Table for ProxyDenials, the connections the proxy refused
+type ProxyDenials struct {
+	Id          int64  `json:"id"`
+	IP          string `json:"ip"`          // Client IP
+	Destination string `json:"destination"` // Host and port asked for, empty when the client was refused
+	Reason      string `json:"reason"`
+	Time        int64  `json:"time"`
 }
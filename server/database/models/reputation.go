@@ -0,0 +1,17 @@
+package models
+
+// Table for Reputation
+type Reputation struct {
+	Peer            string  `json:"peer"`
+	Transfers       int64   `json:"transfers"` // Files received in full with the right hash
+	Bytes           int64   `json:"bytes"`
+	Seconds         float64 `json:"seconds"` // Time spent on the transfers above, for the throughput
+	Timeouts        int64   `json:"timeouts"`
+	Failures        int64   `json:"failures"`
+	HashMismatches  int64   `json:"hashMismatches"`
+	Disputes        int64   `json:"disputes"` // Bills the peer refused to pay
+	Bills           int64   `json:"bills"`
+	InaccurateBills int64   `json:"inaccurateBills"`
+	Updated         int64   `json:"updated"`
+	Score           float64 `json:"score"` // Computed, not stored
+}
@@ -10,4 +10,6 @@ type Statistics struct {
 	SharingSize int64 `json:"sharingSize"`
 	SavedNum    int64 `json:"savedNum"`
 	SavedSize   int64 `json:"savedSize"`
+
+	Upload UploadUsage `json:"upload"` // Filled from the upload limiter, not the database
 }
@@ -0,0 +1,54 @@
+package models
+
+// Table for StorageContracts, the peers we pay to keep a copy of our files
+type StorageContract struct {
+	ID        int64   `json:"id"`
+	Hash      string  `json:"hash"`
+	Peer      string  `json:"peer"`
+	Wallet    string  `json:"wallet"` // Address of the storing peer, where the payments go
+	Size      int64   `json:"size"`
+	Price     float64 `json:"price"`    // Paid for every passed proof
+	Interval  int64   `json:"interval"` // Seconds between two proofs
+	Start     int64   `json:"start"`
+	End       int64   `json:"end"`
+	Rounds    int64   `json:"rounds"`    // Proof intervals in the contract
+	Round     int64   `json:"round"`     // Proof intervals done
+	NextProof int64   `json:"nextProof"` // When the next proof is due
+	Proofs    int64   `json:"proofs"`    // Passed proofs
+	Failures  int64   `json:"failures"`  // Failed proofs in a row
+	Paid      float64 `json:"paid"`
+	Owed      float64 `json:"owed"`   // Passed proofs whose payment failed, paid at the next check
+	Status    string  `json:"status"` // active, completed, failed or cancelled
+	LastError string  `json:"lastError"`
+}
+
+// Table for StorageChallenges, computed while we still have the file so that proofs can be
+// checked after the local copy is gone
+type StorageChallenge struct {
+	Contract int64   `json:"contract"`
+	Round    int64   `json:"round"`
+	Nonce    string  `json:"nonce"`
+	Chunks   []int64 `json:"chunks"`
+	Expected string  `json:"-"`
+}
+
+// Table for Pins, the files we keep for other peers
+type Pin struct {
+	Hash      string  `json:"hash"`
+	Peer      string  `json:"peer"` // The peer paying for the pin
+	Name      string  `json:"name"`
+	Path      string  `json:"-"`
+	Size      int64   `json:"size"`
+	Price     float64 `json:"price"`
+	Interval  int64   `json:"interval"`
+	Expires   int64   `json:"expires"`
+	Proofs    int64   `json:"proofs"`
+	LastProof int64   `json:"lastProof"`
+}
+
+// Table for StorageSettings, what this node accepts to store for other peers
+type StorageSettings struct {
+	Enabled  bool    `json:"enabled"`
+	MaxBytes int64   `json:"maxBytes"` // Total size of the pins
+	MinPrice float64 `json:"minPrice"` // Lowest price per proof interval
+}
@@ -0,0 +1,29 @@
+package models
+
+// Table for UploadLimits, rates are in bytes per second and 0 means unlimited
+type UploadLimits struct {
+	GlobalRate int64          `json:"globalRate"`
+	PeerRate   int64          `json:"peerRate"`
+	MaxUploads int            `json:"maxUploads"` // Uploads served at the same time, 0 for no limit
+	MaxQueue   int            `json:"maxQueue"`   // Requests waiting for a free upload slot
+	Schedule   []UploadWindow `json:"schedule"`
+}
+
+// Struct (not a table) for UploadWindow, the rates used between two times of day
+type UploadWindow struct {
+	Start      string `json:"start"` // Local time as HH:MM
+	End        string `json:"end"`   // Before Start for a window spanning midnight
+	GlobalRate int64  `json:"globalRate"`
+	PeerRate   int64  `json:"peerRate"`
+}
+
+// Struct (not a table) for UploadUsage
+type UploadUsage struct {
+	Rate       int64 `json:"rate"` // Bytes per second over the last seconds
+	TotalBytes int64 `json:"totalBytes"`
+	GlobalRate int64 `json:"globalRate"` // Limits in effect right now
+	PeerRate   int64 `json:"peerRate"`
+	Active     int   `json:"active"`
+	Queued     int   `json:"queued"`
+	MaxUploads int   `json:"maxUploads"`
+}
@@ -0,0 +1,172 @@
+package operations
+
+import (
+	"database/sql"
+	"fmt"
+	"server/database/models"
+)
+
+const issuedBillColumns = `id, peer, offering, ip, bytes, rate, amount, wallet, periodStart, periodEnd, issued, due, status,
+	attempts, paid, txid, lastError`
+
+// AddIssuedBill inserts a bill into the IssuedBills table and marks the ProxyLogs records it
+// covers, then returns the bill's ID.
+func AddIssuedBill(db *sql.DB, bill models.IssuedBill, logs []string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO IssuedBills (peer, offering, ip, bytes, rate, amount, wallet, periodStart, periodEnd, issued,
+	          due, status, attempts, paid, lastError) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, bill.Peer, bill.Offering, bill.IP, bill.Bytes, bill.Rate, bill.Amount, bill.Wallet, bill.From,
+		bill.To, bill.Issued, bill.Due, bill.Status, bill.Attempts, bill.Paid, bill.LastError)
+	if err != nil {
+		return 0, fmt.Errorf("error adding record to IssuedBills: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error reading IssuedBills ID: %v", err)
+	}
+
+	// Only logs no other bill took in the meantime are billed
+	query = `UPDATE ProxyLogs SET bill = ? WHERE id = ? AND bill = 0`
+	for _, logID := range logs {
+		_, err = tx.Exec(query, id, logID)
+		if err != nil {
+			return 0, fmt.Errorf("error updating record from ProxyLogs with ID %s: %v", logID, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("error committing IssuedBills: %v", err)
+	}
+
+	fmt.Printf("Record added to IssuedBills with ID: %d\n", id)
+	return id, nil
+}
+
+// UpdateIssuedBill saves the state of a bill.
+func UpdateIssuedBill(db *sql.DB, bill models.IssuedBill) error {
+	query := `UPDATE IssuedBills SET status = ?, attempts = ?, paid = ?, txid = ?, lastError = ? WHERE id = ?`
+	_, err := db.Exec(query, bill.Status, bill.Attempts, bill.Paid, bill.TxID, bill.LastError, bill.ID)
+	if err != nil {
+		return fmt.Errorf("error updating record from IssuedBills with ID %d: %v", bill.ID, err)
+	}
+	return nil
+}
+
+// FindIssuedBill retrieves a record from the IssuedBills table by its ID.
+func FindIssuedBill(db *sql.DB, id int64) (*models.IssuedBill, error) {
+	query := `SELECT ` + issuedBillColumns + ` FROM IssuedBills WHERE id = ?`
+	bill, err := scanIssuedBill(db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in IssuedBills with ID %d: %v", id, err)
+	}
+	return bill, nil
+}
+
+// GetAllIssuedBills retrieves all records from the IssuedBills table, newest first.
+func GetAllIssuedBills(db *sql.DB) ([]models.IssuedBill, error) {
+	return queryIssuedBills(db, `SELECT `+issuedBillColumns+` FROM IssuedBills ORDER BY id DESC`)
+}
+
+// GetUnpaidIssuedBills retrieves the bills that are issued or overdue, oldest first.
+func GetUnpaidIssuedBills(db *sql.DB) ([]models.IssuedBill, error) {
+	query := `SELECT ` + issuedBillColumns + ` FROM IssuedBills WHERE status IN ('issued', 'overdue') ORDER BY id`
+	return queryIssuedBills(db, query)
+}
+
+// CountOverdueBills counts the bills of a peer that are overdue or disputed.
+func CountOverdueBills(db *sql.DB, peer string) (int64, error) {
+	var count int64
+	query := `SELECT COUNT(*) FROM IssuedBills WHERE peer = ? AND status IN ('overdue', 'disputed')`
+	err := db.QueryRow(query, peer).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting records in IssuedBills: %v", err)
+	}
+	return count, nil
+}
+
+func queryIssuedBills(db *sql.DB, query string, args ...any) ([]models.IssuedBill, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying IssuedBills table: %v", err)
+	}
+	defer rows.Close()
+
+	bills := []models.IssuedBill{}
+	for rows.Next() {
+		bill, err := scanIssuedBill(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning IssuedBills record: %v", err)
+		}
+		bills = append(bills, *bill)
+	}
+
+	return bills, nil
+}
+
+func scanIssuedBill(row interface{ Scan(...any) error }) (*models.IssuedBill, error) {
+	var b models.IssuedBill
+	err := row.Scan(&b.ID, &b.Peer, &b.Offering, &b.IP, &b.Bytes, &b.Rate, &b.Amount, &b.Wallet, &b.From, &b.To, &b.Issued, &b.Due,
+		&b.Status, &b.Attempts, &b.Paid, &b.TxID, &b.LastError)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ReservePaidBill adds a bill we are about to pay to the PaidBills table, without its transaction.
+// It returns false when the bill is already there, paid or being paid.
+func ReservePaidBill(db *sql.DB, bill models.PaidBill) (bool, error) {
+	query := `INSERT OR IGNORE INTO PaidBills (peer, bill, amount, wallet, txid, time) VALUES (?, ?, ?, ?, '', ?)`
+	result, err := db.Exec(query, bill.Peer, bill.Bill, bill.Amount, bill.Wallet, bill.Time)
+	if err != nil {
+		return false, fmt.Errorf("error adding record to PaidBills: %v", err)
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error adding record to PaidBills: %v", err)
+	}
+	return added == 1, nil
+}
+
+// SetPaidBillTx records the transaction a reserved bill was paid with.
+func SetPaidBillTx(db *sql.DB, peer string, bill int64, txid string) error {
+	query := `UPDATE PaidBills SET txid = ? WHERE peer = ? AND bill = ?`
+	_, err := db.Exec(query, txid, peer, bill)
+	if err != nil {
+		return fmt.Errorf("error updating record from PaidBills for bill %d of %s: %v", bill, peer, err)
+	}
+	return nil
+}
+
+// DeletePaidBill removes the reservation of a bill whose payment failed.
+func DeletePaidBill(db *sql.DB, peer string, bill int64) error {
+	query := `DELETE FROM PaidBills WHERE peer = ? AND bill = ?`
+	_, err := db.Exec(query, peer, bill)
+	if err != nil {
+		return fmt.Errorf("error deleting record from PaidBills for bill %d of %s: %v", bill, peer, err)
+	}
+	return nil
+}
+
+// FindPaidBill retrieves a bill of a proxy from the PaidBills table.
+func FindPaidBill(db *sql.DB, peer string, bill int64) (*models.PaidBill, error) {
+	var b models.PaidBill
+	query := `SELECT peer, bill, amount, wallet, txid, time FROM PaidBills WHERE peer = ? AND bill = ?`
+	err := db.QueryRow(query, peer, bill).Scan(&b.Peer, &b.Bill, &b.Amount, &b.Wallet, &b.TxID, &b.Time)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in PaidBills for bill %d of %s: %v", bill, peer, err)
+	}
+	return &b, nil
+}
@@ -0,0 +1,106 @@
+package operations
+
+import (
+	"database/sql"
+	"fmt"
+	"server/database/models"
+)
+
+// AddBlockedPeer inserts a record into the BlockedPeers table, replacing any earlier block of the peer.
+func AddBlockedPeer(db *sql.DB, blocked models.BlockedPeer) error {
+	query := `INSERT INTO BlockedPeers (peer, reason, created, expires) VALUES (?, ?, ?, ?)
+	          ON CONFLICT(peer) DO UPDATE SET reason = excluded.reason, created = excluded.created, expires = excluded.expires`
+	_, err := db.Exec(query, blocked.Peer, blocked.Reason, blocked.Created, blocked.Expires)
+	if err != nil {
+		return fmt.Errorf("error saving record to BlockedPeers: %v", err)
+	}
+	return nil
+}
+
+// DeleteBlockedPeer removes a record from the BlockedPeers table.
+func DeleteBlockedPeer(db *sql.DB, peer string) error {
+	query := `DELETE FROM BlockedPeers WHERE peer = ?`
+	_, err := db.Exec(query, peer)
+	if err != nil {
+		return fmt.Errorf("error deleting record from BlockedPeers with peer %s: %v", peer, err)
+	}
+	return nil
+}
+
+// FindBlockedPeer retrieves the block of a peer, expired or not.
+func FindBlockedPeer(db *sql.DB, peer string) (*models.BlockedPeer, error) {
+	var blocked models.BlockedPeer
+	query := `SELECT peer, reason, created, expires FROM BlockedPeers WHERE peer = ?`
+	err := db.QueryRow(query, peer).Scan(&blocked.Peer, &blocked.Reason, &blocked.Created, &blocked.Expires)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in BlockedPeers with peer %s: %v", peer, err)
+	}
+	return &blocked, nil
+}
+
+// GetBlockedPeers retrieves the blocks that are still in effect at now, newest first.
+func GetBlockedPeers(db *sql.DB, now int64) ([]models.BlockedPeer, error) {
+	query := `SELECT peer, reason, created, expires FROM BlockedPeers WHERE expires = 0 OR expires > ? ORDER BY created DESC`
+	rows, err := db.Query(query, now)
+	if err != nil {
+		return nil, fmt.Errorf("error querying BlockedPeers table: %v", err)
+	}
+	defer rows.Close()
+
+	blocked := []models.BlockedPeer{}
+	for rows.Next() {
+		var record models.BlockedPeer
+		err := rows.Scan(&record.Peer, &record.Reason, &record.Created, &record.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning BlockedPeers record: %v", err)
+		}
+		blocked = append(blocked, record)
+	}
+
+	return blocked, nil
+}
+
+// AddAbuseLog records a refused or suspicious request of a peer.
+func AddAbuseLog(db *sql.DB, peer, reason string, time int64) error {
+	query := `INSERT INTO AbuseLog (peer, reason, time) VALUES (?, ?, ?)`
+	_, err := db.Exec(query, peer, reason, time)
+	if err != nil {
+		return fmt.Errorf("error adding record to AbuseLog: %v", err)
+	}
+	return nil
+}
+
+// GetAbuseLogs retrieves up to limit records of the AbuseLog table, newest first.
+func GetAbuseLogs(db *sql.DB, limit int) ([]models.AbuseLog, error) {
+	query := `SELECT id, peer, reason, time FROM AbuseLog ORDER BY id DESC LIMIT ?`
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying AbuseLog table: %v", err)
+	}
+	defer rows.Close()
+
+	logs := []models.AbuseLog{}
+	for rows.Next() {
+		var log models.AbuseLog
+		err := rows.Scan(&log.ID, &log.Peer, &log.Reason, &log.Time)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning AbuseLog record: %v", err)
+		}
+		logs = append(logs, log)
+	}
+
+	return logs, nil
+}
+
+// DeleteAbuseLogs removes the AbuseLog records older than before.
+func DeleteAbuseLogs(db *sql.DB, before int64) error {
+	query := `DELETE FROM AbuseLog WHERE time < ?`
+	_, err := db.Exec(query, before)
+	if err != nil {
+		return fmt.Errorf("error deleting records from AbuseLog: %v", err)
+	}
+	return nil
+}
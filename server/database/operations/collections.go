@@ -0,0 +1,68 @@
+package operations
+
+import (
+	"database/sql"
+	"fmt"
+	"server/database/models"
+)
+
+// AddCollection inserts a new record into the Collections table.
+func AddCollection(db *sql.DB, hash, name, owner, manifest string) error {
+	query := `INSERT INTO Collections (hash, name, owner, manifest) VALUES (?, ?, ?, ?)`
+	_, err := db.Exec(query, hash, name, owner, manifest)
+	if err != nil {
+		return fmt.Errorf("error adding record to Collections: %v", err)
+	}
+
+	fmt.Printf("Record added to Collections with hash: %s\n", hash)
+	return nil
+}
+
+// DeleteCollection removes a record from the Collections table by its hash.
+func DeleteCollection(db *sql.DB, hash string) error {
+	query := `DELETE FROM Collections WHERE hash = ?`
+	_, err := db.Exec(query, hash)
+	if err != nil {
+		return fmt.Errorf("error deleting record from Collections with hash %s: %v", hash, err)
+	}
+
+	fmt.Printf("Record with hash %s deleted successfully from Collections.\n", hash)
+	return nil
+}
+
+// FindCollection retrieves a record from the Collections table by its hash.
+func FindCollection(db *sql.DB, hash string) (*models.Collection, error) {
+	var collection models.Collection
+	query := `SELECT hash, name, owner, manifest FROM Collections WHERE hash = ?`
+	err := db.QueryRow(query, hash).Scan(&collection.Hash, &collection.Name, &collection.Owner, &collection.Manifest)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in Collections with hash %s: %v", hash, err)
+	}
+
+	return &collection, nil
+}
+
+// GetAllCollections retrieves all records from the Collections table.
+func GetAllCollections(db *sql.DB) ([]models.Collection, error) {
+	query := `SELECT hash, name, owner, manifest FROM Collections`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying Collections table: %v", err)
+	}
+	defer rows.Close()
+
+	collectionRecords := []models.Collection{}
+	for rows.Next() {
+		var record models.Collection
+		err := rows.Scan(&record.Hash, &record.Name, &record.Owner, &record.Manifest)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning Collections record: %v", err)
+		}
+		collectionRecords = append(collectionRecords, record)
+	}
+
+	return collectionRecords, nil
+}
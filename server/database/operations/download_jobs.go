@@ -0,0 +1,127 @@
+package operations
+
+import (
+	"database/sql"
+	"fmt"
+	"server/database/models"
+)
+
+const downloadJobsColumns = `id, hash, name, extension, size, price, maxPrice, peer, priority, state, attempts,
+	destination, path, store, paid, error, nextAttempt, created, updated`
+
+// AddDownloadJob inserts a new record into the DownloadJobs table and returns its id.
+func AddDownloadJob(db *sql.DB, job models.DownloadJob) (int64, error) {
+	query := `INSERT INTO DownloadJobs (hash, name, extension, size, price, maxPrice, peer, priority, state, attempts,
+	          destination, path, store, paid, error, nextAttempt, created, updated)
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.Exec(query, job.Hash, job.Name, job.Extension, job.Size, job.Price, job.MaxPrice, job.Peer, job.Priority,
+		job.State, job.Attempts, job.Destination, job.Path, job.Store, job.Paid, job.Error, job.NextAttempt, job.Created, job.Updated)
+	if err != nil {
+		return 0, fmt.Errorf("error adding record to DownloadJobs: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error adding record to DownloadJobs: %v", err)
+	}
+
+	fmt.Printf("Record added to DownloadJobs with hash: %s\n", job.Hash)
+	return id, nil
+}
+
+// UpdateDownloadJob overwrites the record with the job's id.
+func UpdateDownloadJob(db *sql.DB, job models.DownloadJob) error {
+	query := `UPDATE DownloadJobs SET hash = ?, name = ?, extension = ?, size = ?, price = ?, maxPrice = ?, peer = ?,
+	          priority = ?, state = ?, attempts = ?, destination = ?, path = ?, store = ?, paid = ?, error = ?,
+	          nextAttempt = ?, created = ?, updated = ? WHERE id = ?`
+	_, err := db.Exec(query, job.Hash, job.Name, job.Extension, job.Size, job.Price, job.MaxPrice, job.Peer, job.Priority,
+		job.State, job.Attempts, job.Destination, job.Path, job.Store, job.Paid, job.Error, job.NextAttempt, job.Created, job.Updated, job.Id)
+	if err != nil {
+		return fmt.Errorf("error updating record from DownloadJobs: %v", err)
+	}
+	return nil
+}
+
+// DeleteDownloadJob removes the record with the given id.
+func DeleteDownloadJob(db *sql.DB, id int64) error {
+	query := `DELETE FROM DownloadJobs WHERE id = ?`
+	_, err := db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("error deleting record from DownloadJobs: %v", err)
+	}
+
+	fmt.Printf("Record deleted from DownloadJobs with id: %d\n", id)
+	return nil
+}
+
+// FindDownloadJob retrieves the record with the given id.
+func FindDownloadJob(db *sql.DB, id int64) (*models.DownloadJob, error) {
+	query := `SELECT ` + downloadJobsColumns + ` FROM DownloadJobs WHERE id = ?`
+	job, err := scanDownloadJob(db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in DownloadJobs: %v", err)
+	}
+
+	return job, nil
+}
+
+// NextDownloadJob retrieves the queued job to run next: highest priority first, then oldest first.
+func NextDownloadJob(db *sql.DB, now int64) (*models.DownloadJob, error) {
+	query := `SELECT ` + downloadJobsColumns + ` FROM DownloadJobs
+	          WHERE state = 'queued' AND nextAttempt <= ?
+	          ORDER BY priority DESC, id ASC LIMIT 1`
+	job, err := scanDownloadJob(db.QueryRow(query, now))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in DownloadJobs: %v", err)
+	}
+
+	return job, nil
+}
+
+// GetAllDownloadJobs retrieves every record, in the order they would run.
+func GetAllDownloadJobs(db *sql.DB) ([]models.DownloadJob, error) {
+	query := `SELECT ` + downloadJobsColumns + ` FROM DownloadJobs ORDER BY priority DESC, id ASC`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DownloadJobs table: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := []models.DownloadJob{}
+	for rows.Next() {
+		job, err := scanDownloadJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning DownloadJobs record: %v", err)
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, nil
+}
+
+// RequeueRunningDownloadJobs puts the jobs that were running when the node stopped back in the queue.
+func RequeueRunningDownloadJobs(db *sql.DB) error {
+	query := `UPDATE DownloadJobs SET state = 'queued', nextAttempt = 0 WHERE state = 'running'`
+	_, err := db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("error updating records from DownloadJobs: %v", err)
+	}
+	return nil
+}
+
+func scanDownloadJob(row interface{ Scan(...any) error }) (*models.DownloadJob, error) {
+	var job models.DownloadJob
+	err := row.Scan(&job.Id, &job.Hash, &job.Name, &job.Extension, &job.Size, &job.Price, &job.MaxPrice, &job.Peer,
+		&job.Priority, &job.State, &job.Attempts, &job.Destination, &job.Path, &job.Store, &job.Paid, &job.Error, &job.NextAttempt,
+		&job.Created, &job.Updated)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
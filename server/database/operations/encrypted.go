@@ -0,0 +1,70 @@
+package operations
+
+import (
+	"database/sql"
+	"fmt"
+	"server/database/models"
+)
+
+// AddEncryptedShare inserts a new record into the EncryptedShares table.
+func AddEncryptedShare(db *sql.DB, share models.EncryptedShare) error {
+	query := `INSERT INTO EncryptedShares (hash, source, key, date) VALUES (?, ?, ?, ?)`
+	_, err := db.Exec(query, share.Hash, share.Source, share.Key, share.Date)
+	if err != nil {
+		return fmt.Errorf("error adding record to EncryptedShares: %v", err)
+	}
+
+	fmt.Printf("Record added to EncryptedShares with hash: %s\n", share.Hash)
+	return nil
+}
+
+// DeleteEncryptedShare removes a record from the EncryptedShares table by its hash.
+func DeleteEncryptedShare(db *sql.DB, hash string) error {
+	query := `DELETE FROM EncryptedShares WHERE hash = ?`
+	_, err := db.Exec(query, hash)
+	if err != nil {
+		return fmt.Errorf("error deleting record from EncryptedShares with hash %s: %v", hash, err)
+	}
+
+	fmt.Printf("Record with hash %s deleted successfully from EncryptedShares.\n", hash)
+	return nil
+}
+
+// FindEncryptedShare retrieves a record from the EncryptedShares table by its hash.
+func FindEncryptedShare(db *sql.DB, hash string) (*models.EncryptedShare, error) {
+	var share models.EncryptedShare
+	query := `SELECT hash, source, key, date FROM EncryptedShares WHERE hash = ?`
+	err := db.QueryRow(query, hash).Scan(&share.Hash, &share.Source, &share.Key, &share.Date)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in EncryptedShares with hash %s: %v", hash, err)
+	}
+
+	return &share, nil
+}
+
+// GetAllEncryptedShares retrieves all records from the EncryptedShares table with the name,
+// extension and size of their source file.
+func GetAllEncryptedShares(db *sql.DB) ([]models.JoinedEncryptedShare, error) {
+	query := `SELECT e.hash, e.source, COALESCE(s.name, ''), COALESCE(s.extension, ''), COALESCE(s.size, 0), e.date
+	          FROM EncryptedShares e LEFT JOIN Storing s ON e.source = s.hash`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying EncryptedShares table: %v", err)
+	}
+	defer rows.Close()
+
+	shareRecords := []models.JoinedEncryptedShare{}
+	for rows.Next() {
+		var record models.JoinedEncryptedShare
+		err := rows.Scan(&record.Hash, &record.Source, &record.Name, &record.Extension, &record.Size, &record.Date)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning EncryptedShares record: %v", err)
+		}
+		shareRecords = append(shareRecords, record)
+	}
+
+	return shareRecords, nil
+}
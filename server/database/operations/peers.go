@@ -0,0 +1,113 @@
+package operations
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"server/database/models"
+)
+
+// SavePeer inserts or updates a record in the Peers table. An empty record keeps the stored one
+// and lastSeen never moves backwards.
+func SavePeer(db *sql.DB, id string, addresses []string, record []byte, lastSeen int64) error {
+	addressesJSON, err := json.Marshal(addresses)
+	if err != nil {
+		return fmt.Errorf("error encoding peer addresses: %v", err)
+	}
+	if record == nil {
+		record = []byte{}
+	}
+
+	query := `INSERT INTO Peers (id, addresses, record, lastSeen, successes, failures) VALUES (?, ?, ?, ?, 0, 0)
+	          ON CONFLICT(id) DO UPDATE SET
+	              addresses = excluded.addresses,
+	              record = CASE WHEN length(excluded.record) > 0 THEN excluded.record ELSE Peers.record END,
+	              lastSeen = max(Peers.lastSeen, excluded.lastSeen)`
+	_, err = db.Exec(query, id, string(addressesJSON), record, lastSeen)
+	if err != nil {
+		return fmt.Errorf("error saving record to Peers: %v", err)
+	}
+	return nil
+}
+
+// AddPeerAttempt counts a successful or failed connection attempt to a peer.
+func AddPeerAttempt(db *sql.DB, id string, success bool, now int64) error {
+	query := `UPDATE Peers SET failures = failures + 1 WHERE id = ?`
+	args := []any{id}
+	if success {
+		query = `UPDATE Peers SET successes = successes + 1, lastSeen = max(lastSeen, ?) WHERE id = ?`
+		args = []any{now, id}
+	}
+
+	_, err := db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("error updating record from Peers: %v", err)
+	}
+	return nil
+}
+
+// FindPeer retrieves the record of a peer.
+func FindPeer(db *sql.DB, id string) (*models.Peer, error) {
+	query := `SELECT id, addresses, record, lastSeen, successes, failures FROM Peers WHERE id = ?`
+	peer, err := scanPeer(db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in Peers: %v", err)
+	}
+
+	return peer, nil
+}
+
+// GetKnownPeers retrieves up to limit peers, the most reliable and most recently seen first.
+func GetKnownPeers(db *sql.DB, limit int) ([]models.Peer, error) {
+	// The success rate is smoothed so that a peer seen once doesn't outrank a long-lived one
+	query := `SELECT id, addresses, record, lastSeen, successes, failures FROM Peers
+	          ORDER BY (successes + 1.0) / (successes + failures + 2.0) DESC, lastSeen DESC LIMIT ?`
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying Peers table: %v", err)
+	}
+	defer rows.Close()
+
+	peers := []models.Peer{}
+	for rows.Next() {
+		peer, err := scanPeer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning Peers record: %v", err)
+		}
+		peers = append(peers, *peer)
+	}
+
+	return peers, nil
+}
+
+// DeleteStalePeers removes the peers not seen since before that fail more often than not.
+func DeleteStalePeers(db *sql.DB, before int64) error {
+	query := `DELETE FROM Peers WHERE lastSeen < ? AND failures > successes`
+	result, err := db.Exec(query, before)
+	if err != nil {
+		return fmt.Errorf("error deleting records from Peers: %v", err)
+	}
+
+	if deleted, err := result.RowsAffected(); err == nil && deleted > 0 {
+		fmt.Printf("Deleted %d stale records from Peers\n", deleted)
+	}
+	return nil
+}
+
+func scanPeer(row interface{ Scan(...any) error }) (*models.Peer, error) {
+	var peer models.Peer
+	var addresses string
+	err := row.Scan(&peer.ID, &addresses, &peer.Record, &peer.LastSeen, &peer.Successes, &peer.Failures)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(addresses), &peer.Addresses)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding peer addresses: %v", err)
+	}
+	return &peer, nil
+}
@@ -6,48 +6,109 @@ import (
 	"server/database/models"
 )
 
-// UpdateProxy updates the only record in the Proxy table.
-func UpdateProxy(db *sql.DB, ip string, rate float64, node string, wallet string) error {
-	query := `UPDATE Proxy SET ip = ?, rate = ?, node = ?, wallet = ?`
-	_, err := db.Exec(query, ip, rate, node, wallet)
+const proxyOfferingColumns = `id, ip, listen, protocol, rate, bandwidth, region, node, wallet`
+
+// AddProxyOffering inserts a new record into the ProxyOfferings table and returns its ID.
+func AddProxyOffering(db *sql.DB, offering models.Proxy) (int64, error) {
+	query := `INSERT INTO ProxyOfferings (ip, listen, protocol, rate, bandwidth, region, node, wallet)
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.Exec(query, offering.IP, offering.Listen, offering.Protocol, offering.Rate,
+		offering.Bandwidth, offering.Region, offering.Node, offering.Wallet)
+	if err != nil {
+		return 0, fmt.Errorf("error adding record to ProxyOfferings: %v", err)
+	}
+
+	fmt.Printf("Record added to ProxyOfferings\n")
+	return result.LastInsertId()
+}
+
+// UpdateProxyOffering updates a record in the ProxyOfferings table.
+func UpdateProxyOffering(db *sql.DB, offering models.Proxy) error {
+	query := `UPDATE ProxyOfferings SET ip = ?, listen = ?, protocol = ?, rate = ?, bandwidth = ?,
+	          region = ?, node = ?, wallet = ? WHERE id = ?`
+	_, err := db.Exec(query, offering.IP, offering.Listen, offering.Protocol, offering.Rate,
+		offering.Bandwidth, offering.Region, offering.Node, offering.Wallet, offering.ID)
+	if err != nil {
+		return fmt.Errorf("error updating record from ProxyOfferings: %v", err)
+	}
+
+	fmt.Printf("Record updated successfully in ProxyOfferings.\n")
+	return nil
+}
+
+// DeleteProxyOffering deletes a record from the ProxyOfferings table.
+func DeleteProxyOffering(db *sql.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM ProxyOfferings WHERE id = ?`, id)
 	if err != nil {
-		return fmt.Errorf("error updating record from Proxy: %v", err)
+		return fmt.Errorf("error deleting record from ProxyOfferings: %v", err)
 	}
 
-	fmt.Printf("Record updated successfully in Proxy.\n")
+	fmt.Printf("Record deleted successfully from ProxyOfferings.\n")
 	return nil
 }
 
-// GetProxy retrieves the only record from the Proxy table.
-func GetProxy(db *sql.DB) (*models.Proxy, error) {
-	var proxy models.Proxy
-	query := `SELECT ip, rate, node, wallet FROM Proxy`
-	err := db.QueryRow(query).Scan(&proxy.IP, &proxy.Rate, &proxy.Node, &proxy.Wallet)
+// FindProxyOffering retrieves a record from the ProxyOfferings table.
+func FindProxyOffering(db *sql.DB, id int64) (*models.Proxy, error) {
+	offerings, err := queryProxyOfferings(db, `SELECT `+proxyOfferingColumns+` FROM ProxyOfferings WHERE id = ?`, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // No record found
+		return nil, err
+	}
+	if len(offerings) == 0 {
+		return nil, nil // No record found
+	}
+	return &offerings[0], nil
+}
+
+// GetProxyOfferings retrieves the records from the ProxyOfferings table, oldest first.
+func GetProxyOfferings(db *sql.DB) ([]models.Proxy, error) {
+	return queryProxyOfferings(db, `SELECT `+proxyOfferingColumns+` FROM ProxyOfferings ORDER BY id`)
+}
+
+func queryProxyOfferings(db *sql.DB, query string, args ...any) ([]models.Proxy, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying ProxyOfferings table: %v", err)
+	}
+	defer rows.Close()
+
+	offerings := []models.Proxy{}
+	for rows.Next() {
+		var offering models.Proxy
+		err := rows.Scan(&offering.ID, &offering.IP, &offering.Listen, &offering.Protocol, &offering.Rate,
+			&offering.Bandwidth, &offering.Region, &offering.Node, &offering.Wallet)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning ProxyOfferings record: %v", err)
 		}
-		return nil, fmt.Errorf("error finding record in Proxy: %v", err)
+		offerings = append(offerings, offering)
 	}
 
-	return &proxy, nil
+	return offerings, nil
 }
 
 // AddProxyLogs inserts a new record into the ProxyLogs table.
-func AddProxyLogs(db *sql.DB, ip string, bytes, time int64) error {
-	query := `INSERT INTO ProxyLogs (ip, bytes, time) VALUES (?, ?, ?)`
-	_, err := db.Exec(query, ip, bytes, time)
+func AddProxyLogs(db *sql.DB, record models.ProxyLogs) error {
+	query := `INSERT INTO ProxyLogs (session, ip, peer, bytes_in, bytes_out, bytes, time, offering) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.Exec(query, record.Session, record.IP, record.Peer, record.BytesIn, record.BytesOut, record.BytesIn+record.BytesOut, record.Time, record.Offering)
 	if err != nil {
 		return fmt.Errorf("error adding record to ProxyLogs: %v", err)
 	}
 
-	fmt.Printf("Record added to ProxyLogs\n")
 	return nil
 }
 
 func GetProxyLogs(db *sql.DB) ([]models.ProxyLogs, error) {
-	query := `SELECT id, ip, bytes, time FROM ProxyLogs`
-	rows, err := db.Query(query)
+	return queryProxyLogs(db, `SELECT id, session, ip, peer, bytes_in, bytes_out, bytes, time, bill, offering FROM ProxyLogs`)
+}
+
+// GetUnbilledProxyLogs retrieves the ProxyLogs records written before a time that no bill covers yet.
+func GetUnbilledProxyLogs(db *sql.DB, before int64) ([]models.ProxyLogs, error) {
+	query := `SELECT id, session, ip, peer, bytes_in, bytes_out, bytes, time, bill, offering FROM ProxyLogs
+	          WHERE bill = 0 AND time < ? ORDER BY id`
+	return queryProxyLogs(db, query, before)
+}
+
+func queryProxyLogs(db *sql.DB, query string, args ...any) ([]models.ProxyLogs, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error querying ProxyLogs table: %v", err)
 	}
@@ -56,7 +117,7 @@ func GetProxyLogs(db *sql.DB) ([]models.ProxyLogs, error) {
 	proxyLogsRecords := []models.ProxyLogs{}
 	for rows.Next() {
 		var record models.ProxyLogs
-		err := rows.Scan(&record.Id, &record.IP, &record.Bytes, &record.Time)
+		err := rows.Scan(&record.Id, &record.Session, &record.IP, &record.Peer, &record.BytesIn, &record.BytesOut, &record.Bytes, &record.Time, &record.Bill, &record.Offering)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning ProxyLogs record: %v", err)
 		}
@@ -66,8 +127,22 @@ func GetProxyLogs(db *sql.DB) ([]models.ProxyLogs, error) {
 	return proxyLogsRecords, nil
 }
 
+// FindIPtoNode returns the node a client IP registered as, or an empty string.
+func FindIPtoNode(db *sql.DB, ip string) (string, error) {
+	var node string
+	err := db.QueryRow(`SELECT node FROM IPtoNode WHERE ip = ?`, ip).Scan(&node)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("error finding record in IPtoNode: %v", err)
+	}
+	return node, nil
+}
+
+// AddIPtoNode maps a client IP to its node, a client registering again from the same IP replaces it.
 func AddIPtoNode(db *sql.DB, ip, node string) error {
-	query := `INSERT INTO IPtoNode (ip, node) VALUES (?, ?)`
+	query := `INSERT INTO IPtoNode (ip, node) VALUES (?, ?)
+		ON CONFLICT(ip) DO UPDATE SET node = excluded.node`
 	_, err := db.Exec(query, ip, node)
 	if err != nil {
 		return fmt.Errorf("error adding record to IPtoNode: %v", err)
@@ -77,6 +152,46 @@ func AddIPtoNode(db *sql.DB, ip, node string) error {
 	return nil
 }
 
-// func CalcProxyBill(db *sql.DB) error {
-// 	query := `SELECT SUM(bytes) FROM `
-// }
+// AddProxyUsage inserts a new record into the ProxyUsage table.
+func AddProxyUsage(db *sql.DB, node, ip string, bytes, time int64) error {
+	query := `INSERT INTO ProxyUsage (node, ip, bytes, time) VALUES (?, ?, ?, ?)`
+	_, err := db.Exec(query, node, ip, bytes, time)
+	if err != nil {
+		return fmt.Errorf("error adding record to ProxyUsage: %v", err)
+	}
+
+	return nil
+}
+
+// GetProxyUsage retrieves the ProxyUsage records, newest first.
+func GetProxyUsage(db *sql.DB) ([]models.ProxyUsage, error) {
+	query := `SELECT id, node, ip, bytes, time FROM ProxyUsage ORDER BY time DESC, id DESC`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying ProxyUsage table: %v", err)
+	}
+	defer rows.Close()
+
+	usage := []models.ProxyUsage{}
+	for rows.Next() {
+		var record models.ProxyUsage
+		err := rows.Scan(&record.Id, &record.Node, &record.IP, &record.Bytes, &record.Time)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning ProxyUsage record: %v", err)
+		}
+		usage = append(usage, record)
+	}
+
+	return usage, nil
+}
+
+// SumProxyUsage sums the bytes sent through a proxy between two times and counts the records.
+func SumProxyUsage(db *sql.DB, node string, from, to int64) (int64, int64, error) {
+	var bytes, count int64
+	query := `SELECT COALESCE(SUM(bytes), 0), COUNT(*) FROM ProxyUsage WHERE node = ? AND time >= ? AND time <= ?`
+	err := db.QueryRow(query, node, from, to).Scan(&bytes, &count)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error summing ProxyUsage bytes: %v", err)
+	}
+	return bytes, count, nil
+}
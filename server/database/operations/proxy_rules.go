@@ -0,0 +1,97 @@
+package operations
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"server/database/models"
+)
+
+// UpdateProxyRules updates the only record in the ProxyRules table.
+func UpdateProxyRules(db *sql.DB, rules models.ProxyRules) error {
+	lists := []any{}
+	for _, list := range [][]string{rules.AllowCIDRs, rules.DenyCIDRs, rules.AllowDomains, rules.DenyDomains, rules.AllowPorts} {
+		encoded, err := json.Marshal(list)
+		if err != nil {
+			return fmt.Errorf("error encoding proxy rules: %v", err)
+		}
+		lists = append(lists, string(encoded))
+	}
+
+	query := `UPDATE ProxyRules SET allowCidrs = ?, denyCidrs = ?, allowDomains = ?, denyDomains = ?, allowPorts = ?,
+	          maxConnections = ?, blockPrivate = ?`
+	_, err := db.Exec(query, append(lists, rules.MaxConnections, rules.BlockPrivate)...)
+	if err != nil {
+		return fmt.Errorf("error updating record from ProxyRules: %v", err)
+	}
+
+	fmt.Printf("Record updated successfully in ProxyRules.\n")
+	return nil
+}
+
+// GetProxyRules retrieves the only record from the ProxyRules table.
+func GetProxyRules(db *sql.DB) (*models.ProxyRules, error) {
+	var rules models.ProxyRules
+	var allowCIDRs, denyCIDRs, allowDomains, denyDomains, allowPorts string
+	query := `SELECT allowCidrs, denyCidrs, allowDomains, denyDomains, allowPorts, maxConnections, blockPrivate
+	          FROM ProxyRules`
+	err := db.QueryRow(query).Scan(&allowCIDRs, &denyCIDRs, &allowDomains, &denyDomains, &allowPorts,
+		&rules.MaxConnections, &rules.BlockPrivate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in ProxyRules: %v", err)
+	}
+
+	lists := []struct {
+		encoded string
+		list    *[]string
+	}{
+		{allowCIDRs, &rules.AllowCIDRs},
+		{denyCIDRs, &rules.DenyCIDRs},
+		{allowDomains, &rules.AllowDomains},
+		{denyDomains, &rules.DenyDomains},
+		{allowPorts, &rules.AllowPorts},
+	}
+	for _, l := range lists {
+		err = json.Unmarshal([]byte(l.encoded), l.list)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding proxy rules: %v", err)
+		}
+	}
+	return &rules, nil
+}
+
+// AddProxyDenial inserts a new record into the ProxyDenials table.
+func AddProxyDenial(db *sql.DB, denial models.ProxyDenials) error {
+	query := `INSERT INTO ProxyDenials (ip, destination, reason, time) VALUES (?, ?, ?, ?)`
+	_, err := db.Exec(query, denial.IP, denial.Destination, denial.Reason, denial.Time)
+	if err != nil {
+		return fmt.Errorf("error adding record to ProxyDenials: %v", err)
+	}
+
+	return nil
+}
+
+// GetProxyDenials retrieves the latest records of the ProxyDenials table, newest first.
+func GetProxyDenials(db *sql.DB, limit int) ([]models.ProxyDenials, error) {
+	query := `SELECT id, ip, destination, reason, time FROM ProxyDenials ORDER BY id DESC LIMIT ?`
+	rows, err := db.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying ProxyDenials table: %v", err)
+	}
+	defer rows.Close()
+
+	denials := []models.ProxyDenials{}
+	for rows.Next() {
+		var denial models.ProxyDenials
+		err := rows.Scan(&denial.Id, &denial.IP, &denial.Destination, &denial.Reason, &denial.Time)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning ProxyDenials record: %v", err)
+		}
+		denials = append(denials, denial)
+	}
+
+	return denials, nil
+}
@@ -0,0 +1,77 @@
+package operations
+
+import (
+	"database/sql"
+	"fmt"
+	"server/database/models"
+)
+
+// AddReputation adds the counters of delta to the record of its peer, creating the record if needed.
+func AddReputation(db *sql.DB, delta models.Reputation) error {
+	query := `INSERT INTO Reputation (peer, transfers, bytes, seconds, timeouts, failures, hashMismatches, disputes, bills, inaccurateBills, updated)
+	          VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	          ON CONFLICT(peer) DO UPDATE SET
+	              transfers = transfers + excluded.transfers,
+	              bytes = bytes + excluded.bytes,
+	              seconds = seconds + excluded.seconds,
+	              timeouts = timeouts + excluded.timeouts,
+	              failures = failures + excluded.failures,
+	              hashMismatches = hashMismatches + excluded.hashMismatches,
+	              disputes = disputes + excluded.disputes,
+	              bills = bills + excluded.bills,
+	              inaccurateBills = inaccurateBills + excluded.inaccurateBills,
+	              updated = excluded.updated`
+	_, err := db.Exec(query, delta.Peer, delta.Transfers, delta.Bytes, delta.Seconds, delta.Timeouts, delta.Failures,
+		delta.HashMismatches, delta.Disputes, delta.Bills, delta.InaccurateBills, delta.Updated)
+	if err != nil {
+		return fmt.Errorf("error updating record in Reputation: %v", err)
+	}
+	return nil
+}
+
+// FindReputation retrieves the record of a peer.
+func FindReputation(db *sql.DB, peer string) (*models.Reputation, error) {
+	query := `SELECT peer, transfers, bytes, seconds, timeouts, failures, hashMismatches, disputes, bills, inaccurateBills, updated
+	          FROM Reputation WHERE peer = ?`
+	reputation, err := scanReputation(db.QueryRow(query, peer))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in Reputation: %v", err)
+	}
+
+	return reputation, nil
+}
+
+// GetAllReputation retrieves all records from the Reputation table.
+func GetAllReputation(db *sql.DB) ([]models.Reputation, error) {
+	query := `SELECT peer, transfers, bytes, seconds, timeouts, failures, hashMismatches, disputes, bills, inaccurateBills, updated
+	          FROM Reputation`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying Reputation table: %v", err)
+	}
+	defer rows.Close()
+
+	reputationRecords := []models.Reputation{}
+	for rows.Next() {
+		reputation, err := scanReputation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning Reputation record: %v", err)
+		}
+		reputationRecords = append(reputationRecords, *reputation)
+	}
+
+	return reputationRecords, nil
+}
+
+func scanReputation(row interface{ Scan(...any) error }) (*models.Reputation, error) {
+	var r models.Reputation
+	err := row.Scan(&r.Peer, &r.Transfers, &r.Bytes, &r.Seconds, &r.Timeouts, &r.Failures,
+		&r.HashMismatches, &r.Disputes, &r.Bills, &r.InaccurateBills, &r.Updated)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
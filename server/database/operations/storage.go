@@ -0,0 +1,299 @@
+package operations
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"server/database/models"
+)
+
+const storageContractColumns = `id, hash, peer, wallet, size, price, interval, start, end, rounds, round, nextProof,
+	proofs, failures, paid, owed, status, lastError`
+
+// AddStorageContract inserts a contract and its challenges into the StorageContracts and
+// StorageChallenges tables and returns the contract's ID.
+func AddStorageContract(db *sql.DB, contract models.StorageContract, challenges []models.StorageChallenge) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO StorageContracts (hash, peer, wallet, size, price, interval, start, end, rounds, round,
+	          nextProof, proofs, failures, paid, owed, status, lastError) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(query, contract.Hash, contract.Peer, contract.Wallet, contract.Size, contract.Price,
+		contract.Interval, contract.Start, contract.End, contract.Rounds, contract.Round, contract.NextProof,
+		contract.Proofs, contract.Failures, contract.Paid, contract.Owed, contract.Status, contract.LastError)
+	if err != nil {
+		return 0, fmt.Errorf("error adding record to StorageContracts: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error reading StorageContracts ID: %v", err)
+	}
+
+	query = `INSERT INTO StorageChallenges (contract, round, nonce, chunks, expected) VALUES (?, ?, ?, ?, ?)`
+	for _, challenge := range challenges {
+		chunks, err := json.Marshal(challenge.Chunks)
+		if err != nil {
+			return 0, fmt.Errorf("error encoding challenge chunks: %v", err)
+		}
+		_, err = tx.Exec(query, id, challenge.Round, challenge.Nonce, string(chunks), challenge.Expected)
+		if err != nil {
+			return 0, fmt.Errorf("error adding record to StorageChallenges: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("error committing StorageContracts: %v", err)
+	}
+
+	fmt.Printf("Record added to StorageContracts with ID: %d\n", id)
+	return id, nil
+}
+
+// UpdateStorageContract saves the progress of a contract.
+func UpdateStorageContract(db *sql.DB, contract models.StorageContract) error {
+	query := `UPDATE StorageContracts SET round = ?, nextProof = ?, proofs = ?, failures = ?, paid = ?, owed = ?,
+	          status = ?, lastError = ? WHERE id = ?`
+	_, err := db.Exec(query, contract.Round, contract.NextProof, contract.Proofs, contract.Failures, contract.Paid,
+		contract.Owed, contract.Status, contract.LastError, contract.ID)
+	if err != nil {
+		return fmt.Errorf("error updating record from StorageContracts with ID %d: %v", contract.ID, err)
+	}
+	return nil
+}
+
+// FindStorageContract retrieves a record from the StorageContracts table by its ID.
+func FindStorageContract(db *sql.DB, id int64) (*models.StorageContract, error) {
+	query := `SELECT ` + storageContractColumns + ` FROM StorageContracts WHERE id = ?`
+	contract, err := scanStorageContract(db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in StorageContracts with ID %d: %v", id, err)
+	}
+	return contract, nil
+}
+
+// GetAllStorageContracts retrieves all records from the StorageContracts table, newest first.
+func GetAllStorageContracts(db *sql.DB) ([]models.StorageContract, error) {
+	return queryStorageContracts(db, `SELECT `+storageContractColumns+` FROM StorageContracts ORDER BY id DESC`)
+}
+
+// GetDueStorageContracts retrieves the active contracts whose next proof is due at now, and the
+// contracts that still owe payments.
+func GetDueStorageContracts(db *sql.DB, now int64) ([]models.StorageContract, error) {
+	query := `SELECT ` + storageContractColumns + ` FROM StorageContracts
+	          WHERE (status = 'active' AND nextProof <= ?) OR owed > 0 ORDER BY nextProof`
+	return queryStorageContracts(db, query, now)
+}
+
+func queryStorageContracts(db *sql.DB, query string, args ...any) ([]models.StorageContract, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying StorageContracts table: %v", err)
+	}
+	defer rows.Close()
+
+	contracts := []models.StorageContract{}
+	for rows.Next() {
+		contract, err := scanStorageContract(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning StorageContracts record: %v", err)
+		}
+		contracts = append(contracts, *contract)
+	}
+
+	return contracts, nil
+}
+
+func scanStorageContract(row interface{ Scan(...any) error }) (*models.StorageContract, error) {
+	var c models.StorageContract
+	err := row.Scan(&c.ID, &c.Hash, &c.Peer, &c.Wallet, &c.Size, &c.Price, &c.Interval, &c.Start, &c.End, &c.Rounds,
+		&c.Round, &c.NextProof, &c.Proofs, &c.Failures, &c.Paid, &c.Owed, &c.Status, &c.LastError)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// FindStorageChallenge retrieves the challenge of a contract for a round.
+func FindStorageChallenge(db *sql.DB, contract, round int64) (*models.StorageChallenge, error) {
+	challenge := models.StorageChallenge{Contract: contract, Round: round}
+	var chunks string
+	query := `SELECT nonce, chunks, expected FROM StorageChallenges WHERE contract = ? AND round = ?`
+	err := db.QueryRow(query, contract, round).Scan(&challenge.Nonce, &chunks, &challenge.Expected)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in StorageChallenges: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(chunks), &challenge.Chunks)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding challenge chunks: %v", err)
+	}
+	return &challenge, nil
+}
+
+// DeleteStorageChallenge removes the challenge of a round once it was sent to the peer.
+func DeleteStorageChallenge(db *sql.DB, contract, round int64) error {
+	query := `DELETE FROM StorageChallenges WHERE contract = ? AND round = ?`
+	_, err := db.Exec(query, contract, round)
+	if err != nil {
+		return fmt.Errorf("error deleting record from StorageChallenges: %v", err)
+	}
+	return nil
+}
+
+// DeleteStorageChallenges removes the challenges of a contract that is over.
+func DeleteStorageChallenges(db *sql.DB, contract int64) error {
+	query := `DELETE FROM StorageChallenges WHERE contract = ?`
+	_, err := db.Exec(query, contract)
+	if err != nil {
+		return fmt.Errorf("error deleting records from StorageChallenges: %v", err)
+	}
+	return nil
+}
+
+const pinColumns = `hash, peer, name, path, size, price, interval, expires, proofs, lastProof`
+
+// AddPin inserts a record into the Pins table, a peer pinning the same file again extends its pin.
+func AddPin(db *sql.DB, pin models.Pin) error {
+	query := `INSERT INTO Pins (` + pinColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	          ON CONFLICT(hash, peer) DO UPDATE SET price = excluded.price, interval = excluded.interval,
+	              expires = max(Pins.expires, excluded.expires)`
+	_, err := db.Exec(query, pin.Hash, pin.Peer, pin.Name, pin.Path, pin.Size, pin.Price, pin.Interval, pin.Expires,
+		pin.Proofs, pin.LastProof)
+	if err != nil {
+		return fmt.Errorf("error adding record to Pins: %v", err)
+	}
+
+	fmt.Printf("Record added to Pins with hash: %s\n", pin.Hash)
+	return nil
+}
+
+// AddPinProof counts a proof given for a pin.
+func AddPinProof(db *sql.DB, hash, peer string, now int64) error {
+	query := `UPDATE Pins SET proofs = proofs + 1, lastProof = ? WHERE hash = ? AND peer = ?`
+	_, err := db.Exec(query, now, hash, peer)
+	if err != nil {
+		return fmt.Errorf("error updating record from Pins with hash %s: %v", hash, err)
+	}
+	return nil
+}
+
+// DeletePin removes a record from the Pins table.
+func DeletePin(db *sql.DB, hash, peer string) error {
+	query := `DELETE FROM Pins WHERE hash = ? AND peer = ?`
+	_, err := db.Exec(query, hash, peer)
+	if err != nil {
+		return fmt.Errorf("error deleting record from Pins with hash %s: %v", hash, err)
+	}
+
+	fmt.Printf("Record with hash %s deleted successfully from Pins.\n", hash)
+	return nil
+}
+
+// FindPin retrieves the pin of a file for a peer.
+func FindPin(db *sql.DB, hash, peer string) (*models.Pin, error) {
+	query := `SELECT ` + pinColumns + ` FROM Pins WHERE hash = ? AND peer = ?`
+	pin, err := scanPin(db.QueryRow(query, hash, peer))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in Pins with hash %s: %v", hash, err)
+	}
+	return pin, nil
+}
+
+// GetAllPins retrieves all records from the Pins table, the first to expire first.
+func GetAllPins(db *sql.DB) ([]models.Pin, error) {
+	return queryPins(db, `SELECT `+pinColumns+` FROM Pins ORDER BY expires`)
+}
+
+// GetExpiredPins retrieves the pins that expired before.
+func GetExpiredPins(db *sql.DB, before int64) ([]models.Pin, error) {
+	return queryPins(db, `SELECT `+pinColumns+` FROM Pins WHERE expires < ?`, before)
+}
+
+// CountPins counts the peers pinning a file.
+func CountPins(db *sql.DB, hash string) (int64, error) {
+	var count int64
+	err := db.QueryRow(`SELECT COUNT(*) FROM Pins WHERE hash = ?`, hash).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting records in Pins: %v", err)
+	}
+	return count, nil
+}
+
+// GetPinnedBytes sums the size of the pinned files, counting a file pinned by several peers once.
+func GetPinnedBytes(db *sql.DB) (int64, error) {
+	var total int64
+	query := `SELECT COALESCE(SUM(size), 0) FROM (SELECT hash, MAX(size) AS size FROM Pins GROUP BY hash)`
+	err := db.QueryRow(query).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("error summing Pins sizes: %v", err)
+	}
+	return total, nil
+}
+
+func queryPins(db *sql.DB, query string, args ...any) ([]models.Pin, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying Pins table: %v", err)
+	}
+	defer rows.Close()
+
+	pins := []models.Pin{}
+	for rows.Next() {
+		pin, err := scanPin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning Pins record: %v", err)
+		}
+		pins = append(pins, *pin)
+	}
+
+	return pins, nil
+}
+
+func scanPin(row interface{ Scan(...any) error }) (*models.Pin, error) {
+	var pin models.Pin
+	err := row.Scan(&pin.Hash, &pin.Peer, &pin.Name, &pin.Path, &pin.Size, &pin.Price, &pin.Interval, &pin.Expires,
+		&pin.Proofs, &pin.LastProof)
+	if err != nil {
+		return nil, err
+	}
+	return &pin, nil
+}
+
+// UpdateStorageSettings updates the only record in the StorageSettings table.
+func UpdateStorageSettings(db *sql.DB, settings models.StorageSettings) error {
+	query := `UPDATE StorageSettings SET enabled = ?, maxBytes = ?, minPrice = ?`
+	_, err := db.Exec(query, settings.Enabled, settings.MaxBytes, settings.MinPrice)
+	if err != nil {
+		return fmt.Errorf("error updating record from StorageSettings: %v", err)
+	}
+
+	fmt.Printf("Record updated successfully in StorageSettings.\n")
+	return nil
+}
+
+// GetStorageSettings retrieves the only record from the StorageSettings table.
+func GetStorageSettings(db *sql.DB) (*models.StorageSettings, error) {
+	var settings models.StorageSettings
+	query := `SELECT enabled, maxBytes, minPrice FROM StorageSettings`
+	err := db.QueryRow(query).Scan(&settings.Enabled, &settings.MaxBytes, &settings.MinPrice)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in StorageSettings: %v", err)
+	}
+	return &settings, nil
+}
@@ -0,0 +1,45 @@
+package operations
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"server/database/models"
+)
+
+// UpdateUploadLimits updates the only record in the UploadLimits table.
+func UpdateUploadLimits(db *sql.DB, limits models.UploadLimits) error {
+	schedule, err := json.Marshal(limits.Schedule)
+	if err != nil {
+		return fmt.Errorf("error encoding upload schedule: %v", err)
+	}
+
+	query := `UPDATE UploadLimits SET globalRate = ?, peerRate = ?, maxUploads = ?, maxQueue = ?, schedule = ?`
+	_, err = db.Exec(query, limits.GlobalRate, limits.PeerRate, limits.MaxUploads, limits.MaxQueue, string(schedule))
+	if err != nil {
+		return fmt.Errorf("error updating record from UploadLimits: %v", err)
+	}
+
+	fmt.Printf("Record updated successfully in UploadLimits.\n")
+	return nil
+}
+
+// GetUploadLimits retrieves the only record from the UploadLimits table.
+func GetUploadLimits(db *sql.DB) (*models.UploadLimits, error) {
+	var limits models.UploadLimits
+	var schedule string
+	query := `SELECT globalRate, peerRate, maxUploads, maxQueue, schedule FROM UploadLimits`
+	err := db.QueryRow(query).Scan(&limits.GlobalRate, &limits.PeerRate, &limits.MaxUploads, &limits.MaxQueue, &schedule)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No record found
+		}
+		return nil, fmt.Errorf("error finding record in UploadLimits: %v", err)
+	}
+
+	err = json.Unmarshal([]byte(schedule), &limits.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding upload schedule: %v", err)
+	}
+	return &limits, nil
+}
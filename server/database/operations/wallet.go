@@ -4,7 +4,10 @@ import (
 	"database/sql"
 	"fmt"
 	"server/database/models"
+	"slices"
+	"time"
 
+	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/rpcclient"
 )
 
@@ -63,3 +66,100 @@ func GetWalletInfo(db *sql.DB) (*models.WalletInfo, error) {
 
 	return &walletInfo, nil
 }
+
+// GetWallet retrieves the wallet address and its current and pending balances.
+func GetWallet(btcwallet *rpcclient.Client, db *sql.DB) (models.Wallet, error) {
+	walletInfo, err := GetWalletInfo(db)
+	if err != nil {
+		return models.Wallet{}, err
+	}
+
+	currentBalance, err := btcwallet.GetBalance("*")
+	if err != nil {
+		return models.Wallet{}, err
+	}
+
+	pendingBalance, err := btcwallet.GetBalanceMinConf("*", 0)
+	if err != nil {
+		return models.Wallet{}, err
+	}
+
+	wallet := models.Wallet{
+		Address:        walletInfo.Address,
+		CurrentBalance: currentBalance.ToBTC(),
+		PendingBalance: pendingBalance.ToBTC(),
+	}
+
+	return wallet, nil
+}
+
+// GetTransactions retrieves the wallet transactions, newest first.
+func GetTransactions(btcwallet *rpcclient.Client) ([]models.Transactions, error) {
+	listSinceBlockResult, err := btcwallet.ListSinceBlock(nil)
+	if err != nil {
+		return nil, err
+	}
+	return TransactionRecords(listSinceBlockResult.Transactions), nil
+}
+
+// TransactionRecords maps the transactions btcwallet lists to records, newest first. Sends without
+// a fee are the change of a transaction, they are left out.
+func TransactionRecords(transactions []btcjson.ListTransactionsResult) []models.Transactions {
+	temp := []btcjson.ListTransactionsResult{}
+	for _, transaction := range transactions {
+		if !(transaction.Category == "send" && transaction.Fee != nil && *transaction.Fee == 0) {
+			temp = append(temp, transaction)
+		}
+	}
+	transactions = temp
+
+	slices.SortStableFunc(transactions, func(a, b btcjson.ListTransactionsResult) int {
+		return int(b.Time - a.Time)
+	})
+
+	transactionsRecords := []models.Transactions{}
+	for _, transaction := range transactions {
+		var fee float64
+		if transaction.Fee == nil {
+			fee = 0
+		} else {
+			fee = *transaction.Fee
+		}
+		transactionsRecords = append(transactionsRecords, models.Transactions{
+			Id:            transaction.TxID,
+			Date:          time.Unix(transaction.Time, 0).Local().Format("01/02/2006"),
+			Wallet:        transaction.Address,
+			Amount:        transaction.Amount,
+			Fee:           fee,
+			Category:      transaction.Category,
+			Confirmations: transaction.Confirmations,
+		})
+	}
+
+	return transactionsRecords
+}
+
+// TransactionDetailRecords maps a transaction btcwallet returns by its ID to one record per send
+// or receive in it, the same records TransactionRecords makes.
+func TransactionDetailRecords(transaction *btcjson.GetTransactionResult) []models.Transactions {
+	transactionsRecords := []models.Transactions{}
+	for _, detail := range transaction.Details {
+		var fee float64
+		if detail.Fee != nil {
+			fee = *detail.Fee
+		}
+		if detail.Category == "send" && fee == 0 {
+			continue
+		}
+		transactionsRecords = append(transactionsRecords, models.Transactions{
+			Id:            transaction.TxID,
+			Date:          time.Unix(transaction.Time, 0).Local().Format("01/02/2006"),
+			Wallet:        detail.Address,
+			Amount:        detail.Amount,
+			Fee:           fee,
+			Category:      detail.Category,
+			Confirmations: transaction.Confirmations,
+		})
+	}
+	return transactionsRecords
+}
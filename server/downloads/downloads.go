@@ -0,0 +1,546 @@
+// Package downloads runs the download queue. Jobs are kept in the DownloadJobs table so that the queue
+// survives restarts, they are picked by priority, retried with backoff on the other providers of the
+// file and written to a destination directory once bought.
+package downloads
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"server/database/models"
+	"server/database/operations"
+	"server/events"
+	"server/p2p"
+	"server/reputation"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// Job states
+const (
+	Queued  = "queued"
+	Running = "running"
+	Paused  = "paused"
+	Failed  = "failed"
+	Done    = "done"
+
+	// Canceled jobs are deleted, this state only shows in the event announcing it
+	Canceled = "canceled"
+)
+
+// Retry backoff, doubled after every failed attempt
+const (
+	initialBackoff = 5 * time.Second
+	maxBackoff     = 5 * time.Minute
+)
+
+// How often the queue is checked for jobs whose backoff is over
+const pollInterval = time.Second
+
+var (
+	ErrNotFound     = errors.New("download job not found")
+	ErrInvalidState = errors.New("download job can't be changed in its current state")
+
+	// errPurchaseLost fails a job that was bought before a restart but not saved, resuming it buys it again
+	errPurchaseLost = errors.New("the file was bought but not saved before the node restarted, resume the job to buy it again")
+)
+
+// Config holds the download manager settings
+type Config struct {
+	Directory   string // Default destination of finished downloads
+	Concurrency int    // Jobs running at the same time, see Manager
+	MaxAttempts int    // Attempts before a job is marked as failed
+}
+
+// DefaultConfig returns the settings used when no flags are given.
+func DefaultConfig() Config {
+	return Config{
+		Directory:   "./downloaded",
+		Concurrency: 2,
+		MaxAttempts: 5,
+	}
+}
+
+// Request describes a download to enqueue
+type Request struct {
+	Hash        string  `json:"hash"`
+	Peer        string  `json:"peer,omitempty"`        // Provider to try first, the others are found through the DHT
+	MaxPrice    float64 `json:"maxPrice,omitempty"`    // Providers asking more are skipped, 0 for no limit
+	Priority    int64   `json:"priority,omitempty"`    // Higher runs first
+	Destination string  `json:"destination,omitempty"` // Directory to write the file to, defaults to the configured one
+	Store       bool    `json:"store,omitempty"`       // Also add the finished file to Storing
+}
+
+// Manager schedules and runs the download jobs. Transfers from peers go one at a time, p2p only
+// waits for the answer of one request at a time, so running jobs only overlap their provider
+// lookups and writes.
+type Manager struct {
+	node      host.Host
+	btcwallet *rpcclient.Client
+	netParams *chaincfg.Params
+	db        *sql.DB
+	config    Config
+
+	wake      chan struct{}
+	mutex     sync.Mutex
+	running   map[int64]*runningJob
+	purchased map[int64]purchase // Files bought by jobs that are not saved yet
+}
+
+// purchase is a bought file, kept until it is saved so that a failed save doesn't buy it again
+type purchase struct {
+	name string
+	ext  string
+	data []byte
+}
+
+// runningJob records what was asked of a job while one of its attempts was in progress
+type runningJob struct {
+	canceled bool
+	paused   bool
+}
+
+// NewManager creates a download manager, call Run to start processing the queue.
+func NewManager(node host.Host, btcwallet *rpcclient.Client, netParams *chaincfg.Params, db *sql.DB, config Config) *Manager {
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+
+	return &Manager{
+		node:      node,
+		btcwallet: btcwallet,
+		netParams: netParams,
+		db:        db,
+		config:    config,
+		wake:      make(chan struct{}, 1),
+		running:   make(map[int64]*runningJob),
+		purchased: make(map[int64]purchase),
+	}
+}
+
+// Run processes the queue until ctx is done. Attempts still in progress then are left running,
+// the jobs they leave in the running state start over on the next Run.
+func (m *Manager) Run(ctx context.Context) {
+	// Jobs interrupted by the last shutdown start over
+	err := operations.RequeueRunningDownloadJobs(m.db)
+	if err != nil {
+		log.Printf("Error requeueing download jobs: %v", err)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		m.schedule()
+
+		select {
+		case <-ticker.C:
+		case <-m.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Enqueue adds a download job to the queue.
+func (m *Manager) Enqueue(request Request) (*models.DownloadJob, error) {
+	if request.Hash == "" {
+		return nil, fmt.Errorf("hash is required")
+	}
+
+	destination := request.Destination
+	if destination == "" {
+		destination = m.config.Directory
+	}
+
+	now := time.Now().Unix()
+	job := models.DownloadJob{
+		Hash:        request.Hash,
+		MaxPrice:    request.MaxPrice,
+		Peer:        request.Peer,
+		Priority:    request.Priority,
+		State:       Queued,
+		Destination: destination,
+		Store:       request.Store,
+		Created:     now,
+		Updated:     now,
+	}
+
+	id, err := operations.AddDownloadJob(m.db, job)
+	if err != nil {
+		return nil, err
+	}
+	job.Id = id
+
+	events.Publish(events.DownloadJobUpdated, job)
+	m.signal()
+	return &job, nil
+}
+
+// Jobs lists every job in the order they would run.
+func (m *Manager) Jobs() ([]models.DownloadJob, error) {
+	return operations.GetAllDownloadJobs(m.db)
+}
+
+// Job returns a single job.
+func (m *Manager) Job(id int64) (*models.DownloadJob, error) {
+	job, err := operations.FindDownloadJob(m.db, id)
+	if err != nil {
+		return nil, err
+	} else if job == nil {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+// Cancel removes a job from the queue. A running attempt can't be interrupted once the file is
+// being bought, its result is then discarded but the purchase still shows in Downloads.
+func (m *Manager) Cancel(id int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, err := m.Job(id)
+	if err != nil {
+		return err
+	}
+
+	if running, ok := m.running[id]; ok {
+		running.canceled = true
+	}
+	delete(m.purchased, id)
+
+	err = operations.DeleteDownloadJob(m.db, id)
+	if err != nil {
+		return err
+	}
+
+	job.State = Canceled
+	events.Publish(events.DownloadJobUpdated, *job)
+	return nil
+}
+
+// Pause stops a queued job from being picked. A running job finishes its current attempt first
+// and is paused instead of retried if that attempt fails.
+func (m *Manager) Pause(id int64) (*models.DownloadJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, err := m.Job(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch job.State {
+	case Queued:
+		job.State = Paused
+		return job, m.save(job)
+	case Running:
+		if running, ok := m.running[id]; ok {
+			running.paused = true
+		}
+		return job, nil
+	case Paused:
+		return job, nil
+	default:
+		return nil, ErrInvalidState
+	}
+}
+
+// Resume puts a paused or failed job back in the queue, failed jobs get a fresh set of attempts. A
+// job that was paid for but whose file was lost is bought again.
+func (m *Manager) Resume(id int64) (*models.DownloadJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, err := m.Job(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch job.State {
+	case Paused, Failed:
+		if job.State == Failed {
+			job.Attempts = 0
+		}
+		if _, ok := m.purchased[id]; !ok {
+			job.Paid = false
+		}
+		job.State = Queued
+		job.NextAttempt = 0
+		err = m.save(job)
+		if err != nil {
+			return nil, err
+		}
+		m.signal()
+		return job, nil
+	case Running:
+		if running, ok := m.running[id]; ok {
+			running.paused = false
+		}
+		return job, nil
+	case Queued:
+		return job, nil
+	default:
+		return nil, ErrInvalidState
+	}
+}
+
+// signal wakes the scheduler up without waiting for the next poll.
+func (m *Manager) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save stores the job and publishes its new state.
+func (m *Manager) save(job *models.DownloadJob) error {
+	job.Updated = time.Now().Unix()
+	err := operations.UpdateDownloadJob(m.db, *job)
+	if err != nil {
+		return err
+	}
+
+	events.Publish(events.DownloadJobUpdated, *job)
+	return nil
+}
+
+// schedule starts queued jobs until the concurrency limit is reached.
+func (m *Manager) schedule() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for len(m.running) < m.config.Concurrency {
+		job, err := operations.NextDownloadJob(m.db, time.Now().Unix())
+		if err != nil {
+			log.Printf("Error picking the next download job: %v", err)
+			return
+		}
+		if job == nil {
+			return
+		}
+
+		job.State = Running
+		job.Attempts++
+		job.Error = ""
+		err = m.save(job)
+		if err != nil {
+			log.Printf("Error starting download job %d: %v", job.Id, err)
+			return
+		}
+
+		m.running[job.Id] = &runningJob{}
+		go m.work(*job)
+	}
+}
+
+// work runs one attempt of a job and records its outcome.
+func (m *Manager) work(job models.DownloadJob) {
+	log.Printf("Download job %d: attempt %d for hash %s", job.Id, job.Attempts, job.Hash)
+	attemptErr := m.attempt(&job)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	defer m.signal()
+
+	running := m.running[job.Id]
+	delete(m.running, job.Id)
+	if running.canceled {
+		log.Printf("Download job %d was canceled", job.Id)
+		delete(m.purchased, job.Id)
+		return
+	}
+
+	switch {
+	case attemptErr == nil:
+		job.State = Done
+		delete(m.purchased, job.Id)
+	case errors.Is(attemptErr, errPurchaseLost):
+		job.State = Failed
+		job.Error = attemptErr.Error()
+	case running.paused:
+		job.State = Paused
+		job.Error = attemptErr.Error()
+	case job.Attempts >= int64(m.config.MaxAttempts):
+		job.State = Failed
+		job.Error = attemptErr.Error()
+	default:
+		job.State = Queued
+		job.Error = attemptErr.Error()
+		job.NextAttempt = time.Now().Add(backoff(job.Attempts)).Unix()
+	}
+	if attemptErr != nil {
+		log.Printf("Download job %d: attempt %d failed: %v", job.Id, job.Attempts, attemptErr)
+	}
+
+	err := m.save(&job)
+	if err != nil {
+		log.Printf("Error saving download job %d: %v", job.Id, err)
+	}
+}
+
+// attempt buys the file from the next provider in line and writes it to the destination. A file
+// bought by an earlier attempt is only written again.
+func (m *Manager) attempt(job *models.DownloadJob) error {
+	m.mutex.Lock()
+	bought, ok := m.purchased[job.Id]
+	m.mutex.Unlock()
+
+	if !ok {
+		if job.Paid {
+			return errPurchaseLost
+		}
+
+		var err error
+		bought, err = m.buy(job)
+		if err != nil {
+			return err
+		}
+
+		m.mutex.Lock()
+		m.purchased[job.Id] = bought
+		job.Paid = true
+		err = m.save(job)
+		m.mutex.Unlock()
+		if err != nil {
+			log.Printf("Error saving download job %d: %v", job.Id, err)
+		}
+	}
+
+	path, err := writeFile(job.Destination, bought.name, bought.data)
+	if err != nil {
+		return err
+	}
+	job.Path = path
+
+	if job.Store {
+		record, err := operations.FindStoring(m.db, job.Hash)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			date := time.Now().Local().Format("2006-01-02")
+			err = operations.AddStoring(m.db, job.Hash, bought.name, bought.ext, path, date, job.Size)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("Download job %d: saved %s (%d bytes) from peer %s", job.Id, path, job.Size, job.Peer)
+	return nil
+}
+
+// buy buys the file from the next provider in line.
+func (m *Manager) buy(job *models.DownloadJob) (purchase, error) {
+	providers, err := m.providers(job)
+	if err != nil {
+		return purchase{}, err
+	}
+	if len(providers) == 0 {
+		return purchase{}, fmt.Errorf("no providers found for hash %s", job.Hash)
+	}
+
+	// Each attempt moves on to the next provider
+	provider := providers[(job.Attempts-1)%int64(len(providers))]
+
+	info, err := p2p.RequestFileInfo(m.node, provider, job.Hash)
+	if err != nil {
+		if errors.Is(err, p2p.ErrTransferTimeout) {
+			reputation.RecordTimeout(m.db, provider)
+		} else {
+			reputation.RecordFailure(m.db, provider)
+		}
+		return purchase{}, err
+	}
+	if job.MaxPrice > 0 && info.Price > job.MaxPrice {
+		return purchase{}, fmt.Errorf("peer %s asks %v which is above the limit of %v", provider, info.Price, job.MaxPrice)
+	}
+
+	name, data, ext, err := p2p.PurchaseFile(m.node, m.btcwallet, m.netParams, m.db, provider, job.Hash, info.Price, info.Size)
+	if err != nil {
+		return purchase{}, err
+	}
+
+	job.Peer = provider
+	job.Name = name
+	job.Extension = ext
+	job.Size = int64(len(data))
+	job.Price = info.Price
+	return purchase{name: name, ext: ext, data: data}, nil
+}
+
+// providers lists the peers to try, the preferred peer of the job first and then the best scored.
+func (m *Manager) providers(job *models.DownloadJob) ([]string, error) {
+	found, err := p2p.GetProviderIDs(m.node, job.Hash)
+	if err != nil && job.Peer == "" {
+		return nil, err
+	}
+	found = reputation.Rank(m.db, found)
+
+	providers := []string{}
+	if job.Peer != "" {
+		providers = append(providers, job.Peer)
+	}
+	for _, provider := range found {
+		if provider != job.Peer {
+			providers = append(providers, provider)
+		}
+	}
+	return providers, nil
+}
+
+// backoff returns how long to wait after the given number of attempts.
+func backoff(attempts int64) time.Duration {
+	delay := initialBackoff
+	for i := int64(1); i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// writeFile writes data into directory without overwriting an existing file and returns its path.
+func writeFile(directory, name string, data []byte) (string, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return "", fmt.Errorf("error creating download directory: %v", err)
+	}
+
+	name = filepath.Base(name)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	path := filepath.Join(directory, name)
+	for i := 1; ; i++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			path = filepath.Join(directory, fmt.Sprintf("%s (%d)%s", base, i, ext))
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("error creating downloaded file: %v", err)
+		}
+
+		_, err = file.Write(data)
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", fmt.Errorf("error writing downloaded file: %v", err)
+		}
+		return path, nil
+	}
+}
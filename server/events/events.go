@@ -0,0 +1,102 @@
+// Package events is an in-process publish/subscribe bus. Subsystems publish what happens
+// (transfer progress, peers coming and going, bills, wallet activity) and the REST API
+// streams it to the Electron client so that it doesn't have to poll.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published on the bus
+const (
+	DownloadProgress   = "download.progress"
+	DownloadCompleted  = "download.completed"
+	DownloadJobUpdated = "download.job"
+	UploadProgress     = "upload.progress"
+	UploadCompleted    = "upload.completed"
+	PeerConnected      = "peer.connected"
+	PeerDisconnected   = "peer.disconnected"
+	PeerBlocked        = "peer.blocked"
+	PeerAbuse          = "peer.abuse"
+	ProxyBillReceived  = "proxy.bill"
+	IssuedBill         = "proxy.issued"
+	ProxyConnection    = "proxy.connection"
+	ProxyProbe         = "proxy.probe"
+	StorageContract    = "storage.contract"
+	WalletTransaction  = "wallet.transaction"
+	BtcStatus          = "btc.status"
+)
+
+// Event is a single message on the bus
+type Event struct {
+	ID   uint64 `json:"id"`
+	Type string `json:"type"`
+	Time int64  `json:"time"`
+	Data any    `json:"data"`
+}
+
+// Number of events a slow subscriber can fall behind before events are dropped for it
+const subscriberBuffer = 64
+
+// Bus fans published events out to every subscriber
+type Bus struct {
+	mutex       sync.Mutex
+	nextID      uint64
+	subscribers map[chan Event]struct{}
+}
+
+// NewBus creates an empty bus.
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Publish sends an event to every subscriber without blocking the publisher.
+func (b *Bus) Publish(eventType string, data any) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.nextID++
+	event := Event{ID: b.nextID, Type: eventType, Time: time.Now().Unix(), Data: data}
+
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// The subscriber is not keeping up, drop the event rather than stall the publisher
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on and a function to unsubscribe.
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	subscriber := make(chan Event, subscriberBuffer)
+
+	b.mutex.Lock()
+	b.subscribers[subscriber] = struct{}{}
+	b.mutex.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subscribers, subscriber)
+			b.mutex.Unlock()
+			close(subscriber)
+		})
+	}
+	return subscriber, unsubscribe
+}
+
+// Default is the bus shared by the whole server
+var Default = NewBus()
+
+// Publish publishes an event on the Default bus.
+func Publish(eventType string, data any) {
+	Default.Publish(eventType, data)
+}
+
+// Subscribe subscribes to the Default bus.
+func Subscribe() (<-chan Event, func()) {
+	return Default.Subscribe()
+}
@@ -0,0 +1,224 @@
+package gateway
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"server/database/models"
+	"server/p2p"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// HTML directory index for a collection
+var collectionTemplate = template.Must(template.New("collection").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Index of {{.Name}}</title>
+</head>
+<body>
+	<h1>Index of {{.Name}}</h1>
+	<p>Shared by {{.Owner}} on {{.Date}}</p>
+	<table>
+		<tr><th>Name</th><th>Size</th><th>Hash</th></tr>
+		{{range .Entries}}
+		<tr><td><a href="{{.Link}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.Hash}}</td></tr>
+		{{end}}
+	</table>
+	<p>Download all: <a href="{{.TarLink}}">tar</a> | <a href="{{.ZipLink}}">zip</a></p>
+</body>
+</html>
+`))
+
+type collectionIndexEntry struct {
+	models.CollectionEntry
+	Link string
+}
+
+type collectionIndex struct {
+	Name    string
+	Owner   string
+	Date    string
+	Entries []collectionIndexEntry
+	TarLink string
+	ZipLink string
+}
+
+// serves a collection manifest as an HTML index, JSON or a streamed archive:
+func collectionHandler(w http.ResponseWriter, r *http.Request, node host.Host, address, password string, data []byte) {
+	manifest, err := p2p.ParseCollection(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	switch archive := r.URL.Query().Get("archive"); archive {
+	case "tar", "zip":
+		streamCollection(w, node, address, password, manifest, archive)
+		return
+	case "":
+	default:
+		http.Error(w, "Unsupported archive format", http.StatusBadRequest)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(manifest)
+		return
+	}
+
+	index := collectionIndex{
+		Name:    manifest.Name,
+		Owner:   manifest.Owner,
+		Date:    manifest.Date,
+		TarLink: r.URL.Path + "?" + withQuery(r.URL.Query(), "archive", "tar"),
+		ZipLink: r.URL.Path + "?" + withQuery(r.URL.Query(), "archive", "zip"),
+	}
+	for _, entry := range manifest.Entries {
+		index.Entries = append(index.Entries, collectionIndexEntry{
+			CollectionEntry: entry,
+			Link:            r.URL.Path + "?" + withQuery(r.URL.Query(), "hash", entry.Hash),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = collectionTemplate.Execute(w, index)
+	if err != nil {
+		log.Printf("Error rendering collection index: %v", err)
+	}
+}
+
+// fetches every file of a collection in turn and streams it into a tar or zip archive:
+func streamCollection(w http.ResponseWriter, node host.Host, address, password string, manifest *models.CollectionManifest, archive string) {
+	w.Header().Set("Content-Type", "application/"+archive)
+	// The name comes from the sharer, FormatMediaType quotes and encodes it
+	name := archiveName(manifest.Name)
+	if name == "" {
+		name = "collection"
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + archive})
+	if disposition == "" {
+		disposition = "attachment; filename=collection." + archive
+	}
+	w.Header().Set("Content-Disposition", disposition)
+
+	var tarWriter *tar.Writer
+	var zipWriter *zip.Writer
+	if archive == "tar" {
+		tarWriter = tar.NewWriter(w)
+		defer tarWriter.Close()
+	} else {
+		zipWriter = zip.NewWriter(w)
+		defer zipWriter.Close()
+	}
+
+	names := archiveNames(manifest.Entries)
+	for i, entry := range manifest.Entries {
+		if names[i] == "" {
+			log.Printf("Skipping %s of collection %s, its name %q is not a file name", entry.Hash, manifest.Name, entry.Name)
+			continue
+		}
+
+		_, data, _, err := p2p.SendRequest(node, address, entry.Hash, password)
+		if err != nil {
+			// the headers are already sent, so all we can do is stop the archive early
+			log.Printf("Error fetching %s for collection %s: %v", entry.Hash, manifest.Name, err)
+			return
+		}
+
+		if tarWriter != nil {
+			err = tarWriter.WriteHeader(&tar.Header{
+				Name: names[i],
+				Mode: 0644,
+				Size: int64(len(data)),
+			})
+			if err == nil {
+				_, err = tarWriter.Write(data)
+			}
+		} else {
+			var fileWriter io.Writer
+			fileWriter, err = zipWriter.Create(names[i])
+			if err == nil {
+				_, err = fileWriter.Write(data)
+			}
+		}
+		if err != nil {
+			log.Printf("Error writing %s to %s archive: %v", names[i], archive, err)
+			return
+		}
+
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+// returns the name of every entry in an archive of the collection, or an empty name for entries that
+// can't be extracted safely. The names come from the sharer's manifest, so names with a ".."
+// element, absolute paths and empty names are refused, and the others are reduced to their last
+// element so that every file lands in the folder the archive is extracted to. Names taken by an
+// earlier entry get a number, like "name (1).txt".
+func archiveNames(entries []models.CollectionEntry) []string {
+	names := make([]string, len(entries))
+	taken := map[string]bool{}
+	for i, entry := range entries {
+		name := archiveName(entry.Name)
+		if name == "" {
+			continue
+		}
+
+		ext := path.Ext(name)
+		base := strings.TrimSuffix(name, ext)
+		unique := name
+		for n := 1; taken[strings.ToLower(unique)]; n++ {
+			unique = fmt.Sprintf("%s (%d)%s", base, n, ext)
+		}
+		taken[strings.ToLower(unique)] = true
+		names[i] = unique
+	}
+	return names
+}
+
+// returns the last element of a file name from a manifest, or an empty string when it is not safe:
+func archiveName(name string) string {
+	// Windows tools treat backslashes as separators too
+	name = strings.ReplaceAll(name, "\\", "/")
+	// "C:/..." is absolute on Windows
+	absolute := path.IsAbs(name) || (len(name) > 1 && name[1] == ':')
+	if name == "" || absolute || strings.ContainsRune(name, 0) {
+		return ""
+	}
+	for _, element := range strings.Split(name, "/") {
+		if element == ".." {
+			return ""
+		}
+	}
+
+	base := path.Base(path.Clean(name))
+	if base == "." || base == "/" {
+		return ""
+	}
+	// Colons are not allowed in Windows file names
+	return strings.ReplaceAll(base, ":", "_")
+}
+
+// returns the encoded query with a single key replaced:
+func withQuery(query url.Values, key, value string) string {
+	copied := url.Values{}
+	for k, v := range query {
+		copied[k] = v
+	}
+	copied.Set(key, value)
+	return copied.Encode()
+}
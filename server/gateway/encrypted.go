@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"server/p2p"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// Browsers never send the fragment of a link, where the key of an encrypted share is, so this
+// page hands it to the gateway with a form post. The key stays between the browser and the local
+// gateway, which fetches the encrypted file and decrypts it.
+const encryptedPage = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Encrypted file</title>
+</head>
+<body>
+	<form id="open" method="post">
+		<input type="hidden" name="key" id="key">
+	</form>
+	<p id="status">Decrypting...</p>
+	<script>
+		var key = window.location.hash.substring(1);
+		if (key === "") {
+			document.getElementById("status").textContent = "The link has no key, ask the sharer for the full link.";
+		} else {
+			document.getElementById("key").value = key;
+			document.getElementById("open").submit();
+		}
+	</script>
+</body>
+</html>
+`
+
+// /viewencrypted route:
+func viewEncryptedHandler(w http.ResponseWriter, r *http.Request, node host.Host) {
+	address := r.URL.Query().Get("address")
+	hash := r.URL.Query().Get("hash")
+	password := r.URL.Query().Get("password")
+
+	if address == "" || hash == "" || password == "" {
+		http.Error(w, "Missing required parameters", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, encryptedPage)
+		return
+	}
+
+	meta, data, err := p2p.FetchEncrypted(node, address, hash, password, r.FormValue("key"))
+	if errors.Is(err, p2p.ErrInvalidKey) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	contentType := meta.Extension
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// serve the decrypted content:
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", meta.Name))
+	w.Write(data)
+}
@@ -1,21 +1,59 @@
 package gateway
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 
 	"github.com/libp2p/go-libp2p/core/host"
 )
 
-// HTTP server
-func Gateway(node host.Host, db *sql.DB) {
-	http.HandleFunc("/viewfile", func(w http.ResponseWriter, r *http.Request) {
+// Gateway serves the files of the network over plain HTTP
+type Gateway struct {
+	addr   string
+	server *http.Server
+}
+
+// New sets up the gateway on addr, Start serves it.
+func New(node host.Host, db *sql.DB, addr string) *Gateway {
+	// The gateway is public, so it gets its own mux and never exposes the API routes
+	mux := http.NewServeMux()
+	mux.HandleFunc("/viewfile", func(w http.ResponseWriter, r *http.Request) {
 		viewFileHandler(w, r, node)
 	})
+	mux.HandleFunc("/viewencrypted", func(w http.ResponseWriter, r *http.Request) {
+		viewEncryptedHandler(w, r, node)
+	})
+
+	return &Gateway{addr: addr, server: &http.Server{Handler: mux}}
+}
+
+// Start listens on the address of the gateway and serves it in the background.
+func (g *Gateway) Start(_ context.Context) error {
+	listener, err := net.Listen("tcp", g.addr)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Starting server on http://localhost%s\n", g.addr)
+	go func() {
+		err := g.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Gateway failed: %v", err)
+		}
+	}()
+	return nil
+}
 
-	fmt.Println("Starting server on http://localhost:3002")
-	if err := http.ListenAndServe(":3002", nil); err != nil {
-		panic(fmt.Sprintf("Server failed: %s", err))
+// Stop stops accepting requests and waits for the ones in progress until ctx is done.
+func (g *Gateway) Stop(ctx context.Context) error {
+	err := g.server.Shutdown(ctx)
+	if err != nil {
+		g.server.Close()
 	}
+	return err
 }
@@ -24,6 +24,9 @@ func viewFileHandler(w http.ResponseWriter, r *http.Request, node host.Host) {
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
+	} else if ext == p2p.CollectionExtension {
+		// collections get a directory index or an archive instead of the raw manifest:
+		collectionHandler(w, r, node, address, password, data)
 	} else {
 		// detect file type:
 		var contentType string
@@ -1,34 +1,112 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"os"
 	"os/signal"
+	"server/bandwidth"
+	"server/billing"
 	"server/btc"
 	"server/database"
+	"server/database/operations"
+	"server/downloads"
 	"server/gateway"
 	"server/p2p"
+	"server/probe"
 	"server/proxy"
+	"server/proxyclient"
 	"server/server"
+	"server/supervisor"
+	"strings"
 	"syscall"
-
-	"github.com/btcsuite/btcd/chaincfg"
+	"time"
 )
 
 func main() {
-	// Creates a channel to receive signals
-	sigs := make(chan os.Signal, 1)
+	// Local REST API settings
+	apiConfig := server.DefaultConfig()
+	flag.StringVar(&apiConfig.Addr, "api-addr", apiConfig.Addr, "address of the local REST API")
+	flag.StringVar(&apiConfig.ClientOrigin, "client-origin", apiConfig.ClientOrigin, "origin allowed to call the REST API from a browser")
+	flag.StringVar(&apiConfig.TokenPath, "api-token", apiConfig.TokenPath, "file holding the REST API token")
+	flag.StringVar(&apiConfig.CertFile, "api-cert", "", "TLS certificate for the REST API")
+	flag.StringVar(&apiConfig.KeyFile, "api-key", "", "TLS key for the REST API")
+	flag.StringVar(&apiConfig.SocketPath, "api-socket", "", "Unix socket to also serve the REST API on")
+
+	// Download queue settings
+	downloadConfig := downloads.DefaultConfig()
+	flag.StringVar(&downloadConfig.Directory, "download-dir", downloadConfig.Directory, "directory finished downloads are written to")
+	flag.IntVar(&downloadConfig.Concurrency, "download-concurrency", downloadConfig.Concurrency, "downloads running at the same time, transfers still go one at a time")
+	flag.IntVar(&downloadConfig.MaxAttempts, "download-attempts", downloadConfig.MaxAttempts, "attempts before a download is marked as failed")
+
+	// Network settings
+	p2pConfig := p2p.DefaultConfig()
+	flag.StringVar(&p2pConfig.DHTMode, "dht-mode", p2pConfig.DHTMode, "DHT mode: auto (server once publicly reachable), server or client")
+	flag.StringVar(&p2pConfig.ProtocolPrefix, "dht-prefix", p2pConfig.ProtocolPrefix, "protocol prefix of the DHT, all peers of the swarm must use the same")
+	flag.StringVar(&p2pConfig.SwarmKeyPath, "swarm-key", os.Getenv("BLUBBER_SWARM_KEY"), "pre-shared key file of a private network (or BLUBBER_SWARM_KEY)")
+
+	// Proxy settings
+	proxyConfig := proxy.DefaultConfig()
+	flag.StringVar(&proxyConfig.SocksAddr, "socks-proxy-addr", proxyConfig.SocksAddr, "address of the SOCKS5 proxy")
+	flag.StringVar(&proxyConfig.HTTPAddr, "http-proxy-addr", proxyConfig.HTTPAddr, "address of the HTTP/HTTPS proxy, empty to disable it")
+
+	proxyClientConfig := proxyclient.DefaultConfig()
+	flag.StringVar(&proxyClientConfig.Addr, "proxy-client-addr", proxyClientConfig.Addr, "local address relaying to the proxy we connect to")
+
+	probeConfig := probe.DefaultConfig()
+	flag.StringVar(&probeConfig.SampleURL, "proxy-probe-url", probeConfig.SampleURL, "URL downloaded through each proxy to measure its throughput, only latency is measured without one")
+	flag.DurationVar(&probeConfig.TTL, "proxy-probe-ttl", probeConfig.TTL, "how long the probe results of a proxy are reused")
+
+	billingConfig := billing.DefaultConfig()
+	flag.DurationVar(&billingConfig.Cycle, "proxy-billing-cycle", billingConfig.Cycle, "how often the clients of our proxy are billed")
+	flag.DurationVar(&billingConfig.Grace, "proxy-billing-grace", billingConfig.Grace, "time a proxy client has to pay a bill before it is suspended")
 
-	// Notifies the channel on signals
-	signal.Notify(sigs, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	// Bitcoin settings
+	btcConfig := btc.DefaultConfig()
+	flag.StringVar(&btcConfig.Net, "btc-net", btcConfig.Net, "Bitcoin network: mainnet, testnet, simnet or regtest")
+	flag.StringVar(&btcConfig.Backend, "btc-backend", btcConfig.Backend, "chain backend: btcd (btcd and btcwallet processes) or neutrino (wallet in process on a light client)")
+	neutrinoPeers := flag.String("neutrino-peers", "", "comma separated peers neutrino connects to instead of the DNS seeds, such as 127.0.0.1:18555 for a simnet btcd")
+	flag.StringVar(&btcConfig.LogDir, "btc-log-dir", btcConfig.LogDir, "directory the output of btcd and btcwallet is logged to")
+	flag.DurationVar(&btcConfig.HealthInterval, "btc-health-interval", btcConfig.HealthInterval, "how often btcd and btcwallet are checked, they are restarted after 3 failed checks")
+	flag.BoolVar(&btcConfig.Debug, "btc-debug", false, "also print the output of btcd and btcwallet")
 
-	// Resets the database
-	err := os.Remove("./database/data.db")
-	if err != nil && !os.IsNotExist(err) {
-		log.Println("Error deleting existing database file:", err)
+	resetDB := flag.Bool("reset-db", false, "delete the database and start from the test data")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "time each service has to start or to stop")
+
+	// Identity, the daemon never prompts so that it can run in the background
+	studentID := flag.String("student-id", os.Getenv("BLUBBER_STUDENT_ID"), "student ID seeding the node key (or BLUBBER_STUDENT_ID)")
+	passphraseFile := flag.String("passphrase-file", os.Getenv("BLUBBER_PASSPHRASE_FILE"), "file holding the wallet's private passphrase (or BLUBBER_PASSPHRASE)")
+	flag.Parse()
+	if *neutrinoPeers != "" {
+		btcConfig.Peers = strings.Split(*neutrinoPeers, ",")
+	}
+
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		log.Println("Error reading passphrase:", err)
+		return
+	}
+	if *studentID == "" || passphrase == "" {
+		log.Println("A student ID and a private passphrase are required, see -help")
 		return
 	}
 
+	// Canceled on the first signal, a second one ends the process right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Resets the database if asked to, otherwise the queue and the files are kept between runs
+	if *resetDB {
+		err := os.Remove("./database/data.db")
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("Error deleting existing database file:", err)
+			return
+		}
+	}
+	_, err = os.Stat("./database/data.db")
+	newDB := os.IsNotExist(err)
+
 	// Initializes the database
 	db, err := database.SetupDatabase("./database/data.db")
 	if err != nil {
@@ -37,6 +115,15 @@ func main() {
 	}
 	defer db.Close()
 
+	// Stops the services in reverse order before the database is closed
+	sup := supervisor.New(*shutdownTimeout)
+	defer func() {
+		err := sup.Stop()
+		if err != nil {
+			log.Println("Shutdown incomplete:", err)
+		}
+	}()
+
 	// Creates the tables in the database
 	err = database.CreateNewTables(db)
 	if err != nil {
@@ -44,47 +131,110 @@ func main() {
 		return
 	}
 
-	// // Populates the database
-	err = database.PopulateDatabase(db)
+	// Applies the saved upload limits
+	uploadLimits, err := operations.GetUploadLimits(db)
+	if err != nil {
+		log.Println("Error reading upload limits:", err)
+		return
+	}
+	err = bandwidth.Default.SetLimits(*uploadLimits)
+	if err != nil {
+		log.Println("Error applying upload limits:", err)
+		return
+	}
+
+	// Applies the saved proxy rules
+	proxyRules, err := operations.GetProxyRules(db)
+	if err != nil {
+		log.Println("Error reading proxy rules:", err)
+		return
+	}
+	err = proxy.SetRules(*proxyRules)
 	if err != nil {
-		log.Println("Error populating database:", err)
+		log.Println("Error applying proxy rules:", err)
 		return
 	}
 
-	net := "testnet"
-	netParams := &chaincfg.MainNetParams
-	if net == "simnet" {
-		netParams = &chaincfg.SimNetParams
-	} else if net == "testnet" {
-		netParams = &chaincfg.TestNet3Params
+	// Populates a new database with the test data
+	if newDB {
+		err = database.PopulateDatabase(db)
+		if err != nil {
+			log.Println("Error populating database:", err)
+			return
+		}
 	}
 
+	netParams := btc.NetParams(btcConfig.Net)
+
 	// Starts btc-related processes and saves wallet address
-	btcdCmd, btcwalletCmd, btcd, btcwallet, err := btc.Start(net, db, false)
+	btcSupervisor, err := btc.Start(db, passphrase, btcConfig)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	btcwallet := btcSupervisor.Btcwallet()
+	err = sup.Start("btc", btcSupervisor)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	defer func() {
-		btc.ShutdownClient(btcd)
-		btc.ShutdownClient(btcwallet)
-
-		btc.InterruptCmd(btcwalletCmd)
-		btc.InterruptCmd(btcdCmd)
-	}()
+	// Blocked peers are refused from the node's first connection on
+	err = p2p.LoadBlocklist(db)
+	if err != nil {
+		log.Println(err)
+		return
+	}
 
-	node, dht, err := p2p.P2PSync()
+	node, dht, err := p2p.P2PSync(*studentID, p2pConfig)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	go p2p.P2PAsync(node, dht, db, btcwallet, netParams)
-	go gateway.Gateway(node, db)
-	go server.Server(node, btcwallet, netParams, db)
-	go proxy.Proxy(node, db)
+	downloadManager := downloads.NewManager(node, btcwallet, netParams, db, downloadConfig)
+	prober := probe.NewProber(node, db, probeConfig)
+	proxyClient := proxyclient.NewManager(node, db, prober, proxyClientConfig)
+	billingEngine := billing.NewEngine(node, db, billingConfig)
+
+	// Started in order, the services depending on others come after them and are stopped first
+	services := []struct {
+		name    string
+		service supervisor.Service
+	}{
+		{"p2p", supervisor.Loop(func(ctx context.Context) { p2p.P2PAsync(ctx, node, dht, db, btcwallet, netParams) })},
+		{"downloads", supervisor.Loop(downloadManager.Run)},
+		{"proxy client", supervisor.Loop(proxyClient.Run)},
+		{"billing", supervisor.Loop(billingEngine.Run)},
+		{"proxy", proxy.NewService(db, proxyConfig)},
+		{"transaction watcher", supervisor.Loop(func(ctx context.Context) { btcSupervisor.WatchTransactions(ctx, 5*time.Second) })},
+		{"api", server.New(node, btcwallet, netParams, db, downloadManager, proxyClient, billingEngine, prober, btcSupervisor, apiConfig)},
+		{"gateway", gateway.New(node, db, ":3002")},
+	}
+	for _, s := range services {
+		err = sup.Start(s.name, s.service)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
 
 	// Blocks until a signal is received
-	<-sigs
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down...")
+}
+
+// readPassphrase returns the first line of the passphrase file, or BLUBBER_PASSPHRASE when there is no file.
+func readPassphrase(path string) (string, error) {
+	if path == "" {
+		return os.Getenv("BLUBBER_PASSPHRASE"), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	passphrase, _, _ := strings.Cut(string(data), "\n")
+	return strings.TrimSpace(passphrase), nil
 }
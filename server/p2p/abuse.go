@@ -0,0 +1,331 @@
+package p2p
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"server/database/models"
+	"server/database/operations"
+	"server/events"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Abuse protection works in layers: the resource manager caps the connections, streams and memory
+// a peer can take up, quotas cap how often a peer may make the requests that are expensive to
+// serve, wrong share passwords lock a peer out for a while and blocked peers can't connect at all.
+// Refused requests are written to the AbuseLog table for later review.
+const (
+	maxPasswordFailures   = 5 // Wrong passwords within passwordFailureWindow before a lockout
+	passwordFailureWindow = 10 * time.Minute
+	passwordLockout       = 15 * time.Minute
+	abuseLogAge           = 30 * 24 * time.Hour // How long the abuse log is kept
+)
+
+var ErrInvalidPeer = errors.New("invalid peer ID")
+
+// quota allows a peer count requests per window
+type quota struct {
+	count  int
+	window time.Duration
+}
+
+// Per-peer quotas, by /senddata/p2p header or by protocol. Requests without a quota are only
+// limited by the resource manager.
+var quotas = map[string]quota{
+	"request_all":      {6, time.Minute}, // Answered with our whole Hosting table
+	"download_request": {30, time.Minute},
+	"request":          {30, time.Minute},
+	"request_info":     {60, time.Minute},
+	"proxy_request":    {10, time.Minute},
+	pexProtocol:        {1, pexMinInterval},
+	quoteProtocol:      {60, time.Minute},
+	storageProtocol:    {30, time.Minute},
+}
+
+type quotaKey struct {
+	peer    peer.ID
+	request string
+}
+
+type quotaUsage struct {
+	start   time.Time
+	count   int
+	refused bool // Whether a refusal was logged in this window already
+}
+
+var (
+	quotaMutex   sync.Mutex
+	quotaUsages  = map[quotaKey]*quotaUsage{}
+	quotaCleaned time.Time
+)
+
+// passwordFailures counts the wrong share passwords of a peer
+type passwordFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+var (
+	passwordMutex   sync.Mutex
+	passwordHistory = map[peer.ID]*passwordFailures{}
+)
+
+// blocklist holds the blocked peers and when their block expires, the zero time never does.
+// It is the connection gater of the node, so blocked peers are refused before any stream is opened.
+type blocklist struct {
+	mutex sync.RWMutex
+	peers map[peer.ID]time.Time
+}
+
+var blocked = &blocklist{peers: map[peer.ID]time.Time{}}
+
+func (b *blocklist) has(id peer.ID) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	expires, ok := b.peers[id]
+	return ok && (expires.IsZero() || time.Now().Before(expires))
+}
+
+func (b *blocklist) add(id peer.ID, expires time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.peers[id] = expires
+}
+
+func (b *blocklist) remove(id peer.ID) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.peers, id)
+}
+
+func (b *blocklist) InterceptPeerDial(id peer.ID) bool {
+	return !b.has(id)
+}
+
+func (b *blocklist) InterceptAddrDial(id peer.ID, _ multiaddr.Multiaddr) bool {
+	return !b.has(id)
+}
+
+// InterceptAccept allows every connection, the peer is only known once the connection is secured.
+func (b *blocklist) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (b *blocklist) InterceptSecured(_ network.Direction, id peer.ID, _ network.ConnMultiaddrs) bool {
+	return !b.has(id)
+}
+
+func (b *blocklist) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// resourceManager scales the default libp2p limits to the machine and adds per-peer limits to our
+// own protocols, so that a single peer can't hold all the streams of a protocol.
+func resourceManager() (network.ResourceManager, error) {
+	limits := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&limits)
+
+	limits.AddProtocolPeerLimit("/senddata/p2p", rcmgr.BaseLimit{
+		Streams: 32, StreamsInbound: 16, StreamsOutbound: 16, Memory: 64 << 20,
+	}, rcmgr.BaseLimitIncrease{})
+	limits.AddProtocolPeerLimit(pexProtocol, rcmgr.BaseLimit{
+		Streams: 2, StreamsInbound: 1, StreamsOutbound: 1, Memory: 1 << 20,
+	}, rcmgr.BaseLimitIncrease{})
+	limits.AddProtocolPeerLimit(quoteProtocol, rcmgr.BaseLimit{
+		Streams: 8, StreamsInbound: 4, StreamsOutbound: 4, Memory: 1 << 20,
+	}, rcmgr.BaseLimitIncrease{})
+	limits.AddProtocolPeerLimit(storageProtocol, rcmgr.BaseLimit{
+		Streams: 8, StreamsInbound: 4, StreamsOutbound: 4, Memory: 16 << 20,
+	}, rcmgr.BaseLimitIncrease{})
+
+	return rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits.AutoScale()))
+}
+
+// allowRequest counts a request of a peer against its quota. Blocked peers are refused everything
+// and the first refusal of every quota window is written to the abuse log.
+func allowRequest(db *sql.DB, id peer.ID, request string) bool {
+	if blocked.has(id) {
+		return false
+	}
+	limit, ok := quotas[request]
+	if !ok {
+		return true
+	}
+
+	quotaMutex.Lock()
+	now := time.Now()
+	// Forget the finished windows from time to time, the map would otherwise keep every peer ever seen
+	if now.Sub(quotaCleaned) >= time.Minute {
+		for key, usage := range quotaUsages {
+			if now.Sub(usage.start) >= quotas[key.request].window {
+				delete(quotaUsages, key)
+			}
+		}
+		quotaCleaned = now
+	}
+
+	key := quotaKey{id, request}
+	usage := quotaUsages[key]
+	if usage == nil || now.Sub(usage.start) >= limit.window {
+		usage = &quotaUsage{start: now}
+		quotaUsages[key] = usage
+	}
+	usage.count++
+	allowed := usage.count <= limit.count
+	firstRefusal := !allowed && !usage.refused
+	if !allowed {
+		usage.refused = true
+	}
+	quotaMutex.Unlock()
+
+	if firstRefusal {
+		logAbuse(db, id, fmt.Sprintf("exceeded the quota of %d %s requests per %s", limit.count, request, limit.window))
+	}
+	return allowed
+}
+
+// passwordLocked reports whether a peer is locked out after too many wrong share passwords.
+func passwordLocked(id peer.ID) bool {
+	passwordMutex.Lock()
+	defer passwordMutex.Unlock()
+
+	failures, ok := passwordHistory[id]
+	return ok && time.Now().Before(failures.lockedUntil)
+}
+
+// recordPasswordFailure counts a wrong share password and locks the peer out once it gave
+// maxPasswordFailures of them within passwordFailureWindow.
+func recordPasswordFailure(db *sql.DB, id peer.ID) {
+	passwordMutex.Lock()
+	now := time.Now()
+	for other, failures := range passwordHistory {
+		if now.Sub(failures.first) >= passwordFailureWindow && now.After(failures.lockedUntil) {
+			delete(passwordHistory, other)
+		}
+	}
+
+	failures, ok := passwordHistory[id]
+	if !ok {
+		failures = &passwordFailures{first: now}
+		passwordHistory[id] = failures
+	}
+	failures.count++
+	locked := failures.count >= maxPasswordFailures
+	if locked {
+		// The next failure after the lockout starts a new window
+		failures.lockedUntil = now.Add(passwordLockout)
+		failures.count = 0
+		failures.first = failures.lockedUntil
+	}
+	passwordMutex.Unlock()
+
+	if locked {
+		logAbuse(db, id, fmt.Sprintf("locked out for %s after %d wrong share passwords", passwordLockout, maxPasswordFailures))
+	}
+}
+
+// clearPasswordFailures forgets the wrong passwords of a peer once it gave a right one.
+func clearPasswordFailures(id peer.ID) {
+	passwordMutex.Lock()
+	defer passwordMutex.Unlock()
+	delete(passwordHistory, id)
+}
+
+func logAbuse(db *sql.DB, id peer.ID, reason string) {
+	log.Printf("Abuse from peer %s: %s", id, reason)
+	events.Publish(events.PeerAbuse, models.AbuseLog{Peer: id.String(), Reason: reason, Time: time.Now().Unix()})
+
+	err := operations.AddAbuseLog(db, id.String(), reason, time.Now().Unix())
+	if err != nil {
+		log.Printf("Failed to write abuse log: %v", err)
+	}
+}
+
+// LoadBlocklist loads the blocked peers from the database and drops the old abuse log entries.
+// It runs before the node is created so that blocked peers can't connect during startup.
+func LoadBlocklist(db *sql.DB) error {
+	now := time.Now()
+	records, err := operations.GetBlockedPeers(db, now.Unix())
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		id, err := peer.Decode(record.Peer)
+		if err != nil {
+			log.Printf("Skipping invalid blocked peer %s: %v", record.Peer, err)
+			continue
+		}
+		var expires time.Time
+		if record.Expires != 0 {
+			expires = time.Unix(record.Expires, 0)
+		}
+		blocked.add(id, expires)
+	}
+	fmt.Printf("Loaded %d blocked peers\n", len(records))
+
+	return operations.DeleteAbuseLogs(db, now.Add(-abuseLogAge).Unix())
+}
+
+// BlockPeer blocks a peer for duration, or until it is unblocked when duration is 0, and closes
+// the connections to it.
+func BlockPeer(node host.Host, db *sql.DB, peerID, reason string, duration time.Duration) (*models.BlockedPeer, error) {
+	id, err := peer.Decode(peerID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPeer, err)
+	}
+	if id == node.ID() {
+		return nil, fmt.Errorf("%w: can't block our own peer ID", ErrInvalidPeer)
+	}
+
+	now := time.Now()
+	record := models.BlockedPeer{Peer: id.String(), Reason: reason, Created: now.Unix()}
+	var expires time.Time
+	if duration > 0 {
+		expires = now.Add(duration)
+		record.Expires = expires.Unix()
+	}
+
+	err = operations.AddBlockedPeer(db, record)
+	if err != nil {
+		return nil, err
+	}
+	blocked.add(id, expires)
+
+	err = node.Network().ClosePeer(id)
+	if err != nil {
+		log.Printf("Failed to close the connections to blocked peer %s: %v", id, err)
+	}
+	log.Printf("Blocked peer %s: %s", id, reason)
+	events.Publish(events.PeerBlocked, record)
+	return &record, nil
+}
+
+// UnblockPeer lifts the block of a peer, it returns false when the peer wasn't blocked.
+func UnblockPeer(db *sql.DB, peerID string) (bool, error) {
+	record, err := operations.FindBlockedPeer(db, peerID)
+	if err != nil || record == nil {
+		return false, err
+	}
+
+	err = operations.DeleteBlockedPeer(db, peerID)
+	if err != nil {
+		return false, err
+	}
+	if id, err := peer.Decode(peerID); err == nil {
+		blocked.remove(id)
+	}
+	log.Printf("Unblocked peer %s", peerID)
+	return true, nil
+}
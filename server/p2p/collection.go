@@ -0,0 +1,209 @@
+package p2p
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"server/database/models"
+	"server/database/operations"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// CollectionExtension marks a Storing record whose file is a collection manifest.
+const CollectionExtension = ".collection"
+
+// Folder where the manifests of locally created collections are written
+const collectionFolder = "./collections"
+
+// CreateCollection builds a signed manifest for the given stored files, writes it to disk and
+// stores it like any other file so that it can be hosted and shared.
+func CreateCollection(db *sql.DB, node host.Host, name string, hashes []string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("collection name is required")
+	}
+	if len(hashes) == 0 {
+		return "", fmt.Errorf("collection must contain at least one file")
+	}
+
+	// Step 1: Look up every child in the Storing table
+	entries := []models.CollectionEntry{}
+	for _, hash := range hashes {
+		storing, err := operations.FindStoring(db, hash)
+		if err != nil {
+			return "", err
+		}
+		if storing == nil {
+			return "", fmt.Errorf("file with hash %s is not being stored", hash)
+		}
+		entries = append(entries, models.CollectionEntry{
+			Hash: storing.Hash,
+			Name: storing.Name,
+			Size: storing.Size,
+		})
+	}
+
+	// Step 2: Sign the manifest with the node's identity key
+	manifest := models.CollectionManifest{
+		Name:    name,
+		Owner:   node.ID().String(),
+		Date:    time.Now().Local().Format("2006-01-02"),
+		Entries: entries,
+	}
+	err := signManifest(node, &manifest)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal collection manifest: %v", err)
+	}
+
+	// Step 3: Write the manifest to disk, addressed by the sha256 of its contents
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	err = os.MkdirAll(collectionFolder, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create collection folder: %v", err)
+	}
+	path, err := filepath.Abs(filepath.Join(collectionFolder, hash+CollectionExtension))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve collection path: %v", err)
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write collection manifest: %v", err)
+	}
+
+	// Step 4: Store the manifest and remember that it is a collection
+	record, err := operations.FindStoring(db, hash)
+	if err != nil {
+		return "", err
+	} else if record != nil {
+		return hash, nil
+	}
+
+	err = operations.AddStoring(db, hash, name, CollectionExtension, path, manifest.Date, int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	err = operations.AddUploads(db, manifest.Date, hash, name, CollectionExtension, int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	err = operations.AddCollection(db, hash, name, manifest.Owner, string(data))
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Created collection %s with %d files: %s", name, len(entries), hash)
+	return hash, nil
+}
+
+// ParseCollection decodes a collection manifest and verifies the owner's signature.
+func ParseCollection(data []byte) (*models.CollectionManifest, error) {
+	var manifest models.CollectionManifest
+	err := json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal collection manifest: %v", err)
+	}
+
+	owner, err := peer.Decode(manifest.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection owner: %v", err)
+	}
+	pubKey, err := owner.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract owner public key: %v", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(manifest.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collection signature: %v", err)
+	}
+	payload, err := manifestPayload(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := pubKey.Verify(payload, signature)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("collection signature does not match owner %s", manifest.Owner)
+	}
+
+	return &manifest, nil
+}
+
+// signManifest fills in the signature of the manifest using the node's private key.
+func signManifest(node host.Host, manifest *models.CollectionManifest) error {
+	privKey := node.Peerstore().PrivKey(node.ID())
+	if privKey == nil {
+		return fmt.Errorf("no private key available for node %s", node.ID())
+	}
+
+	payload, err := manifestPayload(*manifest)
+	if err != nil {
+		return err
+	}
+
+	signature, err := privKey.Sign(payload)
+	if err != nil {
+		return fmt.Errorf("failed to sign collection manifest: %v", err)
+	}
+	manifest.Signature = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
+
+// manifestPayload returns the bytes covered by the manifest signature.
+func manifestPayload(manifest models.CollectionManifest) ([]byte, error) {
+	manifest.Signature = ""
+	payload, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal collection manifest: %v", err)
+	}
+	return payload, nil
+}
+
+// collectionGrantsAccess reports whether the hash belongs to a shared collection that uses the password.
+func collectionGrantsAccess(db *sql.DB, hash, password string) bool {
+	collections, err := operations.GetAllCollections(db)
+	if err != nil {
+		log.Printf("Error retrieving collections: %v", err)
+		return false
+	}
+
+	for _, collection := range collections {
+		if !strings.Contains(collection.Manifest, hash) {
+			continue
+		}
+		sharing, err := operations.FindSharing(db, collection.Hash)
+		if err != nil || sharing == nil || sharing.Password != password {
+			continue
+		}
+
+		manifest, err := ParseCollection([]byte(collection.Manifest))
+		if err != nil {
+			continue
+		}
+		for _, entry := range manifest.Entries {
+			if entry.Hash == hash {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package p2p
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// Config holds the network settings of the node
+type Config struct {
+	DHTMode        string // auto, server or client
+	ProtocolPrefix string // Prefix of the DHT protocols, keeps our DHT apart from the public IPFS one
+	SwarmKeyPath   string // Pre-shared key of a private network, the node joins the public network without one
+}
+
+// DefaultConfig returns the settings used when no flags are given.
+func DefaultConfig() Config {
+	return Config{
+		DHTMode:        "auto",
+		ProtocolPrefix: "/blubberbytes",
+	}
+}
+
+func (c Config) dhtMode() (dht.ModeOpt, error) {
+	switch c.DHTMode {
+	case "auto", "":
+		return dht.ModeAuto, nil
+	case "server":
+		return dht.ModeServer, nil
+	case "client":
+		return dht.ModeClient, nil
+	default:
+		return 0, fmt.Errorf("unknown DHT mode %q, expected auto, server or client", c.DHTMode)
+	}
+}
+
+// readSwarmKey reads a pre-shared key in the swarm.key format used by IPFS private networks.
+func readSwarmKey(path string) (pnet.PSK, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open swarm key: %v", err)
+	}
+	defer file.Close()
+
+	psk, err := pnet.DecodeV1PSK(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode swarm key %s: %v", path, err)
+	}
+	return psk, nil
+}
+
+// logReachability logs what AutoNAT finds out about the node, which also decides the DHT mode in auto mode.
+func logReachability(node host.Host) {
+	sub, err := node.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		log.Printf("Failed to subscribe to reachability changes: %v", err)
+		return
+	}
+	defer sub.Close()
+
+	for e := range sub.Out() {
+		switch e.(event.EvtLocalReachabilityChanged).Reachability {
+		case network.ReachabilityPublic:
+			log.Printf("Node is publicly reachable")
+		case network.ReachabilityPrivate:
+			log.Printf("Node is behind a NAT")
+		default:
+			log.Printf("Node reachability is unknown")
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"server/database/models"
+	"server/database/operations"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// EncryptedExtension marks a Storing record whose file is an encrypted blob.
+const EncryptedExtension = ".encrypted"
+
+// Folder where the encrypted copies of shared files are written
+const encryptedFolder = "./encrypted"
+
+// An encrypted blob is the magic, the length of the sealed metadata, the sealed metadata and then
+// the file in sealed chunks. Every seal uses AES-256-GCM with its position as nonce, which is safe
+// because every file gets its own random key. The last chunk has a flag in its nonce, so a
+// truncated blob doesn't decrypt.
+const (
+	encryptedMagic     = "BBENC1"
+	encryptedChunkSize = 64 * 1024
+	contentKeySize     = 32
+)
+
+var ErrInvalidKey = errors.New("the key does not decrypt this file")
+
+// CreateEncryptedShare encrypts a stored file with a new content key and stores, hosts and shares
+// the encrypted blob under the hash of its ciphertext. The key is only part of the returned link,
+// so neither the peers hosting the blob nor the gateway of the sharer can read the file.
+func CreateEncryptedShare(db *sql.DB, node host.Host, sourceHash string, price float64) (*models.EncryptedShare, string, error) {
+	// Step 1: Look up the file to encrypt
+	source, err := operations.FindStoring(db, sourceHash)
+	if err != nil {
+		return nil, "", err
+	} else if source == nil {
+		return nil, "", fmt.Errorf("file with hash %s is not being stored", sourceHash)
+	}
+
+	key := make([]byte, contentKeySize)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate content key: %v", err)
+	}
+
+	// Step 2: Encrypt it into the encrypted folder, named after the hash of the ciphertext
+	meta := models.EncryptedMetadata{Name: source.Name, Extension: source.Extension}
+	hash, path, size, err := encryptFile(source.Path, key, meta)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Step 3: Store, share and host the blob like any other file, under a name that gives nothing away
+	date := time.Now().Local().Format("2006-01-02")
+	err = operations.AddStoring(db, hash, "encrypted-"+hash[:12], EncryptedExtension, path, date, size)
+	if err != nil {
+		return nil, "", err
+	}
+
+	password, err := generateSecurePassword(16)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate password: %v", err)
+	}
+	err = operations.AddSharing(db, hash, password)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = operations.AddHosting(db, hash, price)
+	if err != nil {
+		return nil, "", err
+	}
+	// The reprovider retries a failed announce, the share works meanwhile through its link
+	err = Announce(hash)
+	if err != nil {
+		log.Printf("Failed to announce encrypted share %s: %v", hash, err)
+	}
+
+	share := models.EncryptedShare{
+		Hash:   hash,
+		Source: sourceHash,
+		Key:    base64.RawURLEncoding.EncodeToString(key),
+		Date:   date,
+	}
+	err = operations.AddEncryptedShare(db, share)
+	if err != nil {
+		return nil, "", err
+	}
+
+	log.Printf("Created encrypted share of %s: %s", sourceHash, hash)
+	return &share, EncryptedSharingLink(node.ID().String(), hash, password, share.Key), nil
+}
+
+// DeleteEncryptedShare stops sharing and hosting an encrypted blob and removes it from disk. The
+// source file is left alone.
+func DeleteEncryptedShare(db *sql.DB, hash string) error {
+	storing, err := operations.FindStoring(db, hash)
+	if err != nil {
+		return err
+	}
+	if storing != nil {
+		err = os.Remove(storing.Path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove encrypted file %s: %v", storing.Path, err)
+		}
+	}
+
+	err = operations.DeleteStoring(db, hash)
+	if err != nil {
+		return err
+	}
+	err = operations.DeleteHosting(db, hash)
+	if err != nil {
+		return err
+	}
+	StopAnnouncing(hash)
+
+	err = operations.DeleteSharing(db, hash)
+	if err != nil {
+		return err
+	}
+	return operations.DeleteEncryptedShare(db, hash)
+}
+
+// EncryptedSharingLink returns the gateway link of an encrypted share. The key is in the fragment,
+// which browsers never send to a server.
+func EncryptedSharingLink(address, hash, password, key string) string {
+	return fmt.Sprintf("http://localhost:3002/viewencrypted?address=%s&hash=%s&password=%s#%s", address, hash, password, key)
+}
+
+// OpenEncryptedLink downloads and decrypts the file of an encrypted share link.
+func OpenEncryptedLink(node host.Host, link string) (*models.EncryptedMetadata, []byte, error) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid link: %v", err)
+	}
+	query := parsed.Query()
+	address, hash, password := query.Get("address"), query.Get("hash"), query.Get("password")
+	if address == "" || hash == "" || password == "" || parsed.Fragment == "" {
+		return nil, nil, fmt.Errorf("the link is not an encrypted share link")
+	}
+
+	return FetchEncrypted(node, address, hash, password, parsed.Fragment)
+}
+
+// FetchEncrypted requests an encrypted blob from the peer sharing it, checks it against the hash
+// it is addressed by and decrypts it with the key of the link.
+func FetchEncrypted(node host.Host, address, hash, password, key string) (*models.EncryptedMetadata, []byte, error) {
+	contentKey, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil || len(contentKey) != contentKeySize {
+		return nil, nil, ErrInvalidKey
+	}
+
+	_, data, ext, err := SendRequest(node, address, hash, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ext != EncryptedExtension {
+		return nil, nil, fmt.Errorf("file %s is not encrypted", hash)
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, nil, fmt.Errorf("%w %s", ErrHashMismatch, hash)
+	}
+
+	return DecryptBlob(data, contentKey)
+}
+
+// DecryptBlob decrypts an encrypted blob and returns its metadata and content.
+func DecryptBlob(data, key []byte) (*models.EncryptedMetadata, []byte, error) {
+	aead, err := contentCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !bytes.HasPrefix(data, []byte(encryptedMagic)) || len(data) < len(encryptedMagic)+4 {
+		return nil, nil, fmt.Errorf("not an encrypted file")
+	}
+	data = data[len(encryptedMagic):]
+	headerSize := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint64(len(data)) < uint64(headerSize) {
+		return nil, nil, fmt.Errorf("encrypted file is truncated")
+	}
+
+	header, err := aead.Open(nil, chunkNonce(0, false), data[:headerSize], nil)
+	if err != nil {
+		return nil, nil, ErrInvalidKey
+	}
+	var meta models.EncryptedMetadata
+	err = json.Unmarshal(header, &meta)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal encrypted file metadata: %v", err)
+	}
+	data = data[headerSize:]
+
+	plaintext := make([]byte, 0, len(data))
+	sealedSize := encryptedChunkSize + aead.Overhead()
+	for counter := uint64(1); ; counter++ {
+		size := min(sealedSize, len(data))
+		last := size == len(data)
+		plaintext, err = aead.Open(plaintext, chunkNonce(counter, last), data[:size], nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("encrypted file is corrupt or truncated")
+		}
+		data = data[size:]
+		if last {
+			break
+		}
+	}
+
+	if int64(len(plaintext)) != meta.Size {
+		return nil, nil, fmt.Errorf("decrypted %d bytes, expected %d", len(plaintext), meta.Size)
+	}
+	return &meta, plaintext, nil
+}
+
+// encryptFile encrypts the file at sourcePath into the encrypted folder and returns the hash,
+// path and size of the blob.
+func encryptFile(sourcePath string, key []byte, meta models.EncryptedMetadata) (string, string, int64, error) {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to open file to encrypt: %v", err)
+	}
+	defer source.Close()
+
+	// The size of the Storing record is what the user entered, the metadata gets the real one
+	info, err := source.Stat()
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to read file to encrypt: %v", err)
+	}
+	meta.Size = info.Size()
+
+	err = os.MkdirAll(encryptedFolder, 0755)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to create encrypted folder: %v", err)
+	}
+	temp, err := os.CreateTemp(encryptedFolder, "encrypting-*")
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to create encrypted file: %v", err)
+	}
+	defer os.Remove(temp.Name()) // Fails harmlessly once the file is renamed
+
+	hasher := sha256.New()
+	writer := bufio.NewWriter(io.MultiWriter(temp, hasher))
+	err = encryptStream(writer, source, key, meta)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to encrypt file: %v", err)
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	path, err := filepath.Abs(filepath.Join(encryptedFolder, hash+EncryptedExtension))
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to resolve encrypted file path: %v", err)
+	}
+	err = os.Rename(temp.Name(), path)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to move encrypted file: %v", err)
+	}
+
+	info, err = os.Stat(path)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return hash, path, info.Size(), nil
+}
+
+// encryptStream writes the blob of the content read from r to w.
+func encryptStream(w io.Writer, r io.Reader, key []byte, meta models.EncryptedMetadata) error {
+	aead, err := contentCipher(key)
+	if err != nil {
+		return err
+	}
+
+	header, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	sealedHeader := aead.Seal(nil, chunkNonce(0, false), header, nil)
+
+	_, err = io.WriteString(w, encryptedMagic)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(w, binary.BigEndian, uint32(len(sealedHeader)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(sealedHeader)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(r, encryptedChunkSize)
+	chunk := make([]byte, encryptedChunkSize)
+	for counter := uint64(1); ; counter++ {
+		n, err := io.ReadFull(reader, chunk)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		// A full chunk is the last one when nothing follows it
+		if !last {
+			_, err = reader.Peek(1)
+			if err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		_, err = w.Write(aead.Seal(nil, chunkNonce(counter, last), chunk[:n], nil))
+		if err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func contentCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the position of a chunk, the metadata being chunk 0, with a flag on the last chunk.
+func chunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
@@ -30,12 +30,17 @@ func GenerateLink(db *sql.DB, node host.Host, fileHash string) (string, error) {
 	}
 
 	// Step 4: Generate the shareable link
-	link := fmt.Sprintf("http://localhost:3002/viewfile?address=%s&hash=%s&password=%s", nodeAddress, fileHash, password)
+	link := SharingLink(nodeAddress.String(), fileHash, password)
 
 	log.Printf("Generated link: %s", link)
 	return link, nil
 }
 
+// SharingLink returns the gateway link that serves a shared file.
+func SharingLink(address, fileHash, password string) string {
+	return fmt.Sprintf("http://localhost:3002/viewfile?address=%s&hash=%s&password=%s", address, fileHash, password)
+}
+
 // generateSecurePassword generates a secure random password of the specified length.
 func generateSecurePassword(length int) (string, error) {
 	bytes := make([]byte, length)
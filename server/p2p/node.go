@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"log"
+	"server/events"
 
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
@@ -14,6 +15,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	"github.com/multiformats/go-multiaddr"
 )
@@ -46,7 +48,7 @@ func generatePrivateKeyFromSeed(seed []byte) (crypto.PrivKey, error) {
 	return privKey, nil
 }
 
-func createNode() (host.Host, *dht.IpfsDHT, error) {
+func createNode(config Config) (host.Host, *dht.IpfsDHT, error) {
 	ctx := context.Background()
 	globalCtx = ctx
 
@@ -70,15 +72,39 @@ func createNode() (host.Host, *dht.IpfsDHT, error) {
 		panic(fmt.Sprintf("Failed to create AddrInfo from relay multiaddr: %v", err))
 	}
 
-	node, err := libp2p.New(
+	dhtMode, err := config.dhtMode()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resources, err := resourceManager()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create resource manager: %w", err)
+	}
+
+	options := []libp2p.Option{
 		libp2p.ListenAddrs(customAddr),
+		libp2p.ResourceManager(resources),
+		libp2p.ConnectionGater(blocked), // Refuses the peers of the blocklist
 		libp2p.Identity(privKey),
 		libp2p.NATPortMap(),
-		libp2p.EnableNATService(),
+		libp2p.EnableNATService(), // Lets our peers find out whether they are publicly reachable
 		libp2p.EnableAutoRelayWithStaticRelays([]peer.AddrInfo{*relayInfo}),
 		libp2p.EnableRelayService(),
 		libp2p.EnableHolePunching(),
-	)
+	}
+
+	// Only peers holding the same key can connect to a private network
+	if config.SwarmKeyPath != "" {
+		psk, err := readSwarmKey(config.SwarmKeyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		options = append(options, libp2p.PrivateNetwork(psk))
+		fmt.Println("Joining the private network of", config.SwarmKeyPath)
+	}
+
+	node, err := libp2p.New(options...)
 
 	if err != nil {
 		return nil, nil, err
@@ -88,10 +114,12 @@ func createNode() (host.Host, *dht.IpfsDHT, error) {
 		log.Printf("Failed to instantiate the relay: %v", err)
 	}
 
-	dhtRouting, err := dht.New(ctx, node, dht.Mode(dht.ModeClient))
+	// In auto mode AutoNAT decides, the node serves the DHT as soon as it is publicly reachable
+	dhtRouting, err := dht.New(ctx, node, dht.Mode(dhtMode), dht.ProtocolPrefix(protocol.ID(config.ProtocolPrefix)))
 	if err != nil {
 		return nil, nil, err
 	}
+	go logReachability(node)
 	namespacedValidator := record.NamespacedValidator{
 		"orcanet": &CustomValidator{}, // Add a custom validator for the "orcanet" namespace
 	}
@@ -107,6 +135,7 @@ func createNode() (host.Host, *dht.IpfsDHT, error) {
 	node.Network().Notify(&network.NotifyBundle{
 		ConnectedF: func(n network.Network, conn network.Conn) {
 			peerID := conn.RemotePeer().String()
+			events.Publish(events.PeerConnected, peerEvent(conn))
 
 			// Show a specific message based on the peer type after a successful connection
 			switch peerID {
@@ -133,14 +162,29 @@ func createNode() (host.Host, *dht.IpfsDHT, error) {
 				fmt.Printf("Total connected peers: %d\n", len(connectedPeers))
 
 			default:
-				addPeerID(peerID)
 				fmt.Println("Connected to peer:", peerID)
 			}
 		},
 		DisconnectedF: func(n network.Network, conn network.Conn) {
 			log.Printf("Disconnected from peer: %s", conn.RemotePeer().String())
+			events.Publish(events.PeerDisconnected, peerEvent(conn))
 		},
 	})
 
 	return node, dhtRouting, nil
 }
+
+// PeerEvent is the payload of the peer connected and disconnected events
+type PeerEvent struct {
+	Peer      string `json:"peer"`
+	Address   string `json:"address"`
+	Direction string `json:"direction"`
+}
+
+func peerEvent(conn network.Conn) PeerEvent {
+	return PeerEvent{
+		Peer:      conn.RemotePeer().String(),
+		Address:   conn.RemoteMultiaddr().String(),
+		Direction: conn.Stat().Direction.String(),
+	}
+}
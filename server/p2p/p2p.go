@@ -17,14 +17,14 @@ var (
 	bootstrap_node_addr = "/ip4/130.245.173.222/tcp/61020/p2p/12D3KooWM8uovScE5NPihSCKhXe8sbgdJAi88i2aXT2MmwjGWoSX"
 )
 
-func P2PSync() (host.Host, *dht.IpfsDHT, error) {
-	fmt.Print("Enter your student ID: ")
-	_, err := fmt.Scanln(&node_id)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read student ID: %s", err)
+// P2PSync creates the node, the student ID seeds its key so that the peer ID stays the same across runs.
+func P2PSync(studentID string, config Config) (host.Host, *dht.IpfsDHT, error) {
+	if studentID == "" {
+		return nil, nil, fmt.Errorf("a student ID is required")
 	}
+	node_id = studentID
 
-	node, dht, err := createNode()
+	node, dht, err := createNode(config)
 	dhtRouting = dht
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create node: %s", err)
@@ -33,27 +33,34 @@ func P2PSync() (host.Host, *dht.IpfsDHT, error) {
 	return node, dht, nil
 }
 
-func P2PAsync(node host.Host, dht *dht.IpfsDHT, db *sql.DB, btcwallet *rpcclient.Client, netParams *chaincfg.Params) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+func P2PAsync(ctx context.Context, node host.Host, dht *dht.IpfsDHT, db *sql.DB, btcwallet *rpcclient.Client, netParams *chaincfg.Params) {
 	globalCtx = ctx
 
 	fmt.Println("Node Peer ID:", node.ID())
 
+	startQuoteService(node, db)
+	startStorageService(node, db)
+
+	// Known peers are dialed alongside the relay and bootstrap nodes, either may be down
+	go startPeerExchange(ctx, node, db)
+
 	connectToPeer(node, relay_node_addr) // connect to relay node
 	makeReservation(node)                // make reservation on relay node
 	go refreshReservation(node, 10*time.Minute)
 	connectToPeer(node, bootstrap_node_addr) // connect to bootstrap node
-	// go handlePeerExchange(node)
+
 	go receiveDataFromPeer(node, db, "D:/blubberbytes/cse416-dht-go-main/", btcwallet, netParams) // Ensures a folder path is used
-	go handleInput(ctx, dht, node, db)                                                            // Pass db connection to handleInput
 
-	// Call the helper function to periodically provide keys
-	go periodicTaskHelper(12*time.Hour, db)
+	// Keeps the hosted files and the offered proxy announced in the DHT
+	go runReprovider(ctx, db)
+
+	// Checks the proofs of the peers storing our files and drops expired pins of other peers
+	go runStorageContracts(ctx, node, db, btcwallet, netParams)
 
-	// Keep the program running
+	// Keep the node running until the daemon shuts down
 	<-ctx.Done()
 
-	defer node.Close()
+	dht.Close()
+	node.Close()
 	fmt.Println("Node closed.")
 }
@@ -0,0 +1,318 @@
+package p2p
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"server/database/operations"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/record"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Peer exchange (PEX): peers answer with a list of signed peer records, their own first and then
+// the best peers from their peerstore. Records are verified before any address is trusted, so a
+// peer can't advertise addresses on behalf of someone else.
+const (
+	pexProtocol    = "/blubberbytes/pex/1.0.0"
+	pexMaxRecords  = 32               // Records sent and accepted per exchange
+	pexMaxResponse = 256 * 1024       // Bytes read from a single response
+	pexMinInterval = 30 * time.Second // Minimum time between two requests from the same peer
+	pexInterval    = 10 * time.Minute
+	pexFanout      = 3 // Peers asked every round
+	pexTimeout     = 15 * time.Second
+
+	reconnectLimit   = 20 // Known peers dialed on startup
+	reconnectTimeout = 10 * time.Second
+	minPeers         = 4 // Below this many connections the known peers are dialed again
+	peerRecordTTL    = 24 * time.Hour
+	stalePeerAge     = 30 * 24 * time.Hour
+)
+
+// startPeerExchange serves PEX requests, records identified peers in the database, reconnects
+// to the known peers and then exchanges peers periodically until the context is done.
+func startPeerExchange(ctx context.Context, node host.Host, db *sql.DB) {
+	node.SetStreamHandler(pexProtocol, func(s network.Stream) {
+		handlePexRequest(node, db, s)
+	})
+
+	sub, err := node.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		log.Printf("Failed to subscribe to peer identification: %v", err)
+	} else {
+		go recordIdentifiedPeers(ctx, db, sub)
+	}
+
+	reconnectKnownPeers(ctx, node, db)
+
+	ticker := time.NewTicker(pexInterval)
+	defer ticker.Stop()
+	for {
+		exchangePeers(ctx, node, db)
+
+		err := operations.DeleteStalePeers(db, time.Now().Add(-stalePeerAge).Unix())
+		if err != nil {
+			log.Printf("Failed to prune the peerstore: %v", err)
+		}
+		if len(node.Network().Peers()) < minPeers {
+			reconnectKnownPeers(ctx, node, db)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordIdentifiedPeers saves the addresses and signed record of every peer identify completes with.
+func recordIdentifiedPeers(ctx context.Context, db *sql.DB, sub event.Subscription) {
+	defer sub.Close()
+
+	for {
+		select {
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			evt := e.(event.EvtPeerIdentificationCompleted)
+
+			var signed []byte
+			if evt.SignedPeerRecord != nil {
+				signed, _ = evt.SignedPeerRecord.Marshal()
+			}
+			now := time.Now().Unix()
+			err := operations.SavePeer(db, evt.Peer.String(), addrStrings(evt.ListenAddrs), signed, now)
+			if err == nil {
+				err = operations.AddPeerAttempt(db, evt.Peer.String(), true, now)
+			}
+			if err != nil {
+				log.Printf("Failed to save peer %s: %v", evt.Peer, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reconnectKnownPeers dials the best peers of the database that we aren't connected to, so that
+// the node finds the network again even when the bootstrap node is down.
+func reconnectKnownPeers(ctx context.Context, node host.Host, db *sql.DB) {
+	known, err := operations.GetKnownPeers(db, reconnectLimit)
+	if err != nil {
+		log.Printf("Failed to read known peers: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, known := range known {
+		id, err := peer.Decode(known.ID)
+		if err != nil || id == node.ID() || node.Network().Connectedness(id) == network.Connected {
+			continue
+		}
+
+		// Prefer the signed record, fall back to the addresses identify reported
+		if !consumeRecord(node, known.Record) {
+			addrs := parseAddrs(known.Addresses)
+			if len(addrs) == 0 {
+				continue
+			}
+			node.Peerstore().AddAddrs(id, addrs, peerstore.TempAddrTTL)
+		}
+
+		wg.Add(1)
+		go func(id peer.ID) {
+			defer wg.Done()
+			dialCtx, cancel := context.WithTimeout(ctx, reconnectTimeout)
+			defer cancel()
+
+			err := node.Connect(dialCtx, peer.AddrInfo{ID: id})
+			if err != nil {
+				log.Printf("Failed to reconnect to known peer %s: %v", id, err)
+				err = operations.AddPeerAttempt(db, id.String(), false, time.Now().Unix())
+				if err != nil {
+					log.Printf("Failed to update peer %s: %v", id, err)
+				}
+				return
+			}
+			log.Printf("Reconnected to known peer %s", id)
+		}(id)
+	}
+	wg.Wait()
+}
+
+// exchangePeers asks a few random connected peers for their peers and saves the verified records.
+func exchangePeers(ctx context.Context, node host.Host, db *sql.DB) {
+	candidates := []peer.ID{}
+	for _, id := range node.Network().Peers() {
+		protocols, err := node.Peerstore().SupportsProtocols(id, pexProtocol)
+		if err == nil && len(protocols) > 0 {
+			candidates = append(candidates, id)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > pexFanout {
+		candidates = candidates[:pexFanout]
+	}
+
+	for _, id := range candidates {
+		records, err := requestPeers(ctx, node, id)
+		if err != nil {
+			log.Printf("Peer exchange with %s failed: %v", id, err)
+			continue
+		}
+
+		accepted := 0
+		for _, data := range records {
+			if savePeerRecord(node, db, data) {
+				accepted++
+			}
+		}
+		log.Printf("Peer exchange with %s: %d of %d records accepted", id, accepted, len(records))
+	}
+}
+
+func requestPeers(ctx context.Context, node host.Host, id peer.ID) ([][]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, pexTimeout)
+	defer cancel()
+
+	s, err := node.NewStream(ctx, id, pexProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(pexTimeout))
+
+	var records [][]byte
+	err = json.NewDecoder(io.LimitReader(s, pexMaxResponse)).Decode(&records)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	if len(records) > pexMaxRecords {
+		records = records[:pexMaxRecords]
+	}
+	return records, nil
+}
+
+// savePeerRecord verifies a signed peer record and stores it in the peerstore and the database.
+func savePeerRecord(node host.Host, db *sql.DB, data []byte) bool {
+	env, rec, err := record.ConsumeEnvelope(data, peer.PeerRecordEnvelopeDomain)
+	if err != nil {
+		return false
+	}
+	peerRec, ok := rec.(*peer.PeerRecord)
+	if !ok || peerRec.PeerID == node.ID() || len(peerRec.Addrs) == 0 {
+		return false
+	}
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil || signer != peerRec.PeerID {
+		return false
+	}
+
+	if !consumeRecord(node, data) {
+		return false
+	}
+	err = operations.SavePeer(db, peerRec.PeerID.String(), addrStrings(peerRec.Addrs), data, time.Now().Unix())
+	if err != nil {
+		log.Printf("Failed to save peer %s: %v", peerRec.PeerID, err)
+	}
+	return true
+}
+
+// consumeRecord adds the addresses of a signed peer record to the certified address book.
+func consumeRecord(node host.Host, data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	env, _, err := record.ConsumeEnvelope(data, peer.PeerRecordEnvelopeDomain)
+	if err != nil {
+		return false
+	}
+	book, ok := peerstore.GetCertifiedAddrBook(node.Peerstore())
+	if !ok {
+		return false
+	}
+	// A record that isn't newer than the stored one is not accepted, but its addresses are known already
+	_, err = book.ConsumePeerRecord(env, peerRecordTTL)
+	return err == nil
+}
+
+// handlePexRequest answers with our own signed record followed by the best known peers.
+func handlePexRequest(node host.Host, db *sql.DB, s network.Stream) {
+	defer s.Close()
+	remote := s.Conn().RemotePeer()
+
+	if !allowRequest(db, remote, pexProtocol) {
+		log.Printf("Peer exchange request from %s rate limited", remote)
+		s.Reset()
+		return
+	}
+	s.SetDeadline(time.Now().Add(pexTimeout))
+
+	records := [][]byte{}
+	own, err := ownPeerRecord(node)
+	if err != nil {
+		log.Printf("Failed to sign our peer record: %v", err)
+	} else {
+		records = append(records, own)
+	}
+
+	known, err := operations.GetKnownPeers(db, pexMaxRecords)
+	if err != nil {
+		log.Printf("Failed to read known peers: %v", err)
+	}
+	for _, known := range known {
+		if len(records) >= pexMaxRecords {
+			break
+		}
+		if len(known.Record) == 0 || known.ID == remote.String() || known.ID == node.ID().String() {
+			continue
+		}
+		records = append(records, known.Record)
+	}
+
+	err = json.NewEncoder(s).Encode(records)
+	if err != nil {
+		log.Printf("Failed to answer peer exchange request from %s: %v", remote, err)
+	}
+}
+
+func ownPeerRecord(node host.Host) ([]byte, error) {
+	rec := peer.PeerRecordFromAddrInfo(peer.AddrInfo{ID: node.ID(), Addrs: node.Addrs()})
+	env, err := record.Seal(rec, node.Peerstore().PrivKey(node.ID()))
+	if err != nil {
+		return nil, err
+	}
+	return env.Marshal()
+}
+
+func addrStrings(addrs []multiaddr.Multiaddr) []string {
+	strs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		strs = append(strs, addr.String())
+	}
+	return strs
+}
+
+func parseAddrs(strs []string) []multiaddr.Multiaddr {
+	addrs := make([]multiaddr.Multiaddr, 0, len(strs))
+	for _, str := range strs {
+		addr, err := multiaddr.NewMultiaddr(str)
+		if err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,46 @@
+package p2p
+
+import (
+	"io"
+	"time"
+
+	"server/events"
+)
+
+// How often transfer progress is published at most
+const progressInterval = 250 * time.Millisecond
+
+// TransferProgress is the payload of the transfer progress events
+type TransferProgress struct {
+	Peer  string `json:"peer"`
+	Name  string `json:"name,omitempty"`
+	Bytes int64  `json:"bytes"`
+	Total int64  `json:"total,omitempty"` // 0 when the size is not known in advance
+}
+
+// progressReader publishes how many bytes went through the wrapped reader
+type progressReader struct {
+	reader    io.Reader
+	eventType string
+	progress  TransferProgress
+	last      time.Time
+}
+
+func newProgressReader(reader io.Reader, eventType, peerID, name string, total int64) *progressReader {
+	return &progressReader{
+		reader:    reader,
+		eventType: eventType,
+		progress:  TransferProgress{Peer: peerID, Name: name, Total: total},
+	}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	p.progress.Bytes += int64(n)
+
+	if err == io.EOF || time.Since(p.last) >= progressInterval {
+		p.last = time.Now()
+		events.Publish(p.eventType, p.progress)
+	}
+	return n, err
+}
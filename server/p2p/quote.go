@@ -0,0 +1,248 @@
+package p2p
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"server/bandwidth"
+	"server/database/operations"
+	"server/reputation"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Quote protocol: a provider answers with what it would charge for a file, signed with its peer
+// key, so that all providers of a file can be compared in a single round trip.
+const (
+	quoteProtocol        = "/blubberbytes/quote/1.0.0"
+	QuoteProtocolVersion = "1.0.0"
+	DefaultQuoteTimeout  = 5 * time.Second
+	quoteMaxSize         = 16 * 1024
+)
+
+// Quote is what a provider charges for a file
+type Quote struct {
+	Peer      string  `json:"peer"`
+	Hash      string  `json:"hash"`
+	Available bool    `json:"available"` // False when the peer doesn't host the file (anymore)
+	Name      string  `json:"name,omitempty"`
+	Extension string  `json:"extension,omitempty"`
+	Size      int64   `json:"size"`
+	Price     float64 `json:"price"`
+	FreeSlots int     `json:"freeSlots"` // -1 when the peer doesn't limit its uploads
+	Version   string  `json:"version"`
+	Time      int64   `json:"time"`
+}
+
+// signedQuote is a quote as sent on the wire, the signature covers the quote bytes
+type signedQuote struct {
+	Quote     []byte `json:"quote"`
+	Signature []byte `json:"signature"`
+}
+
+// ProviderQuote is a provider's verified quote with the round trip it took and its reputation
+type ProviderQuote struct {
+	Quote
+	LatencyMs int64   `json:"latencyMs"`
+	Score     float64 `json:"score"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Orders of RequestQuotes
+const (
+	SortByPrice   = "price"
+	SortByLatency = "latency"
+	SortByScore   = "score"
+)
+
+func startQuoteService(node host.Host, db *sql.DB) {
+	node.SetStreamHandler(quoteProtocol, func(s network.Stream) {
+		handleQuoteRequest(node, db, s)
+	})
+}
+
+// handleQuoteRequest reads a hash and answers with our signed quote for it.
+func handleQuoteRequest(node host.Host, db *sql.DB, s network.Stream) {
+	defer s.Close()
+	if !allowRequest(db, s.Conn().RemotePeer(), quoteProtocol) {
+		s.Reset()
+		return
+	}
+	s.SetDeadline(time.Now().Add(DefaultQuoteTimeout))
+
+	var request struct {
+		Hash string `json:"hash"`
+	}
+	err := json.NewDecoder(io.LimitReader(s, quoteMaxSize)).Decode(&request)
+	if err != nil {
+		log.Printf("Invalid quote request from peer %s: %v", s.Conn().RemotePeer(), err)
+		s.Reset()
+		return
+	}
+
+	quote := Quote{
+		Peer:      node.ID().String(),
+		Hash:      request.Hash,
+		FreeSlots: bandwidth.Default.FreeSlots(),
+		Version:   QuoteProtocolVersion,
+		Time:      time.Now().Unix(),
+	}
+	hosting, err := operations.FindHosting(db, request.Hash)
+	if err != nil {
+		log.Printf("Failed to look up hosting for quote: %v", err)
+	} else if hosting != nil {
+		quote.Available = true
+		quote.Name = hosting.Name
+		quote.Extension = hosting.Extension
+		quote.Size = hosting.Size
+		quote.Price = hosting.Price
+	}
+
+	data, err := json.Marshal(quote)
+	if err != nil {
+		s.Reset()
+		return
+	}
+	signature, err := node.Peerstore().PrivKey(node.ID()).Sign(data)
+	if err != nil {
+		log.Printf("Failed to sign quote: %v", err)
+		s.Reset()
+		return
+	}
+
+	err = json.NewEncoder(s).Encode(signedQuote{Quote: data, Signature: signature})
+	if err != nil {
+		log.Printf("Failed to send quote to peer %s: %v", s.Conn().RemotePeer(), err)
+	}
+}
+
+// RequestQuotes asks every provider of a file for a quote at the same time and returns the answers
+// received before the timeout, sorted by price, latency or score. Providers that didn't answer
+// come last with their error.
+func RequestQuotes(node host.Host, db *sql.DB, hash string, timeout time.Duration, order string) ([]ProviderQuote, error) {
+	providers, err := GetProviderIDs(node, hash)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := reputation.Scores(db, providers)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(globalCtx, timeout)
+	defer cancel()
+
+	quotes := make([]ProviderQuote, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func(i int, provider string) {
+			defer wg.Done()
+
+			start := time.Now()
+			quote, err := requestQuote(ctx, node, provider, hash)
+			quotes[i] = ProviderQuote{Quote: quote, LatencyMs: time.Since(start).Milliseconds(), Score: scores[provider]}
+			if err != nil {
+				quotes[i].Quote = Quote{Peer: provider, Hash: hash}
+				quotes[i].LatencyMs = 0
+				quotes[i].Error = err.Error()
+			}
+		}(i, provider)
+	}
+	wg.Wait()
+
+	sortQuotes(quotes, order)
+	return quotes, nil
+}
+
+func requestQuote(ctx context.Context, node host.Host, provider, hash string) (Quote, error) {
+	id, err := peer.Decode(provider)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	s, err := node.NewStream(network.WithAllowLimitedConn(ctx, quoteProtocol), id, quoteProtocol)
+	if err != nil {
+		return Quote{}, err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	err = json.NewEncoder(s).Encode(map[string]string{"hash": hash})
+	if err != nil {
+		return Quote{}, err
+	}
+	s.CloseWrite()
+
+	var signed signedQuote
+	err = json.NewDecoder(io.LimitReader(s, quoteMaxSize)).Decode(&signed)
+	if err != nil {
+		return Quote{}, fmt.Errorf("invalid quote: %v", err)
+	}
+
+	// The quote must be signed by the peer we asked, and be about the file we asked for
+	key, err := id.ExtractPublicKey()
+	if err != nil {
+		return Quote{}, err
+	}
+	valid, err := key.Verify(signed.Quote, signed.Signature)
+	if err != nil || !valid {
+		return Quote{}, fmt.Errorf("quote signature from peer %s is invalid", provider)
+	}
+
+	var quote Quote
+	err = json.Unmarshal(signed.Quote, &quote)
+	if err != nil {
+		return Quote{}, fmt.Errorf("invalid quote: %v", err)
+	}
+	if quote.Peer != provider || quote.Hash != hash {
+		return Quote{}, fmt.Errorf("peer %s answered with a quote for something else", provider)
+	}
+	return quote, nil
+}
+
+// sortQuotes puts the available quotes first, in the given order, then the others.
+func sortQuotes(quotes []ProviderQuote, order string) {
+	rank := func(q ProviderQuote) int {
+		switch {
+		case q.Error == "" && q.Available:
+			return 0
+		case q.Error == "":
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	sort.SliceStable(quotes, func(i, j int) bool {
+		a, b := quotes[i], quotes[j]
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+		switch order {
+		case SortByLatency:
+			if a.LatencyMs != b.LatencyMs {
+				return a.LatencyMs < b.LatencyMs
+			}
+		case SortByScore:
+			if a.Score != b.Score {
+				return a.Score > b.Score
+			}
+		default:
+			if a.Price != b.Price {
+				return a.Price < b.Price
+			}
+		}
+		return a.Score > b.Score
+	})
+}
@@ -0,0 +1,277 @@
+package p2p
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"server/database/models"
+	"server/database/operations"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The reprovider keeps every hosted file, and the proxies we offer, announced in the DHT. Provider
+// records expire after 48 hours, so each key is provided again well before that, and keys whose
+// rows are deleted are no longer announced.
+const (
+	reprovideInterval = 22 * time.Hour
+	reprovideBatch    = 16          // Keys announced at the same time
+	reprovideSync     = time.Minute // How often the keys are compared with the database
+	announceRetryMin  = time.Minute
+	announceRetryMax  = time.Hour
+	proxyKey          = "PROXY"
+)
+
+// KeyStatus is the announce state of a key, times are Unix seconds and 0 when unset
+type KeyStatus struct {
+	Key          string `json:"key"`
+	LastProvided int64  `json:"lastProvided"`
+	NextProvide  int64  `json:"nextProvide"`
+	Failures     int    `json:"failures"`
+	LastError    string `json:"lastError,omitempty"`
+	Announcing   bool   `json:"announcing"`
+}
+
+type announcer struct {
+	mutex sync.Mutex
+	keys  map[string]*KeyStatus
+	wake  chan struct{}
+}
+
+var reprovider = &announcer{
+	keys: map[string]*KeyStatus{},
+	wake: make(chan struct{}, 1),
+}
+
+// Announce provides a key now and keeps it announced until StopAnnouncing is called or its
+// row is deleted.
+func Announce(key string) error {
+	reprovider.mutex.Lock()
+	status, ok := reprovider.keys[key]
+	if !ok {
+		status = &KeyStatus{Key: key}
+		reprovider.keys[key] = status
+	}
+	status.Announcing = true
+	reprovider.mutex.Unlock()
+
+	err := ProvideKey(key)
+	reprovider.finish(key, err)
+	return err
+}
+
+// ProxyKeys returns the keys an offering is announced under: PROXY for every proxy, then
+// PROXY/<protocol> and PROXY/<protocol>/<region> so that peers can look for a kind of proxy. The
+// offerings of a node share those, so each one also has its own PROXY/offering/<node>/<ID>.
+func ProxyKeys(offering models.Proxy) []string {
+	protocol := strings.ToLower(offering.Protocol)
+	if protocol == "" {
+		protocol = "socks5"
+	}
+	keys := []string{proxyKey, proxyKey + "/" + protocol}
+	if region := strings.ToLower(strings.TrimSpace(offering.Region)); region != "" {
+		keys = append(keys, proxyKey+"/"+protocol+"/"+region)
+	}
+	if offering.ID > 0 {
+		keys = append(keys, offeringKey(offering.Node, offering.ID))
+	}
+	return keys
+}
+
+// offeringKey returns the key only one offering of a node is announced under.
+func offeringKey(node string, id int64) string {
+	return fmt.Sprintf("%s/offering/%s/%d", proxyKey, node, id)
+}
+
+// AnnounceProxy announces the keys of an offering now.
+func AnnounceProxy(offering models.Proxy) error {
+	for _, key := range ProxyKeys(offering) {
+		err := Announce(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StopAnnouncing stops republishing a key, its provider records expire on their own.
+func StopAnnouncing(key string) {
+	reprovider.mutex.Lock()
+	defer reprovider.mutex.Unlock()
+
+	if _, ok := reprovider.keys[key]; ok {
+		delete(reprovider.keys, key)
+		log.Printf("Stopped announcing key: %s", key)
+	}
+}
+
+// AnnounceStatus returns the announce state of every key, sorted by key.
+func AnnounceStatus() []KeyStatus {
+	reprovider.mutex.Lock()
+	defer reprovider.mutex.Unlock()
+
+	statuses := make([]KeyStatus, 0, len(reprovider.keys))
+	for _, status := range reprovider.keys {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	return statuses
+}
+
+// runReprovider announces the due keys in batches until the context is done.
+func runReprovider(ctx context.Context, db *sql.DB) {
+	var lastSync time.Time
+	for {
+		if time.Since(lastSync) >= reprovideSync {
+			err := reprovider.sync(db)
+			if err != nil {
+				log.Printf("Error reading the keys to announce: %v", err)
+			}
+			lastSync = time.Now()
+		}
+
+		due := reprovider.due(time.Now())
+		var wg sync.WaitGroup
+		for _, key := range due {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				reprovider.finish(key, ProvideKey(key))
+			}(key)
+		}
+		wg.Wait()
+
+		// A full batch means more keys may be due already
+		if len(due) == reprovideBatch {
+			continue
+		}
+
+		wait := time.Until(lastSync.Add(reprovideSync))
+		if next := reprovider.nextProvide(); !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(max(wait, time.Second))
+		select {
+		case <-timer.C:
+		case <-reprovider.wake:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// sync announces the hosted files and the offered proxies and drops every other key.
+func (a *announcer) sync(db *sql.DB) error {
+	hostings, err := operations.GetAllHosting(db)
+	if err != nil {
+		return err
+	}
+	offerings, err := operations.GetProxyOfferings(db)
+	if err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, hosting := range hostings {
+		wanted[hosting.Hash] = true
+	}
+	for _, offering := range offerings {
+		for _, key := range ProxyKeys(offering) {
+			wanted[key] = true
+		}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	added := false
+	for key := range wanted {
+		if _, ok := a.keys[key]; !ok {
+			a.keys[key] = &KeyStatus{Key: key}
+			added = true
+		}
+	}
+	for key, status := range a.keys {
+		if !wanted[key] && !status.Announcing {
+			delete(a.keys, key)
+			log.Printf("Stopped announcing key: %s", key)
+		}
+	}
+
+	if added {
+		select {
+		case a.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// due marks up to a batch of keys whose announce is due, the most overdue first.
+func (a *announcer) due(now time.Time) []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	due := []*KeyStatus{}
+	for _, status := range a.keys {
+		if !status.Announcing && status.NextProvide <= now.Unix() {
+			due = append(due, status)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextProvide < due[j].NextProvide })
+	if len(due) > reprovideBatch {
+		due = due[:reprovideBatch]
+	}
+
+	keys := make([]string, 0, len(due))
+	for _, status := range due {
+		status.Announcing = true
+		keys = append(keys, status.Key)
+	}
+	return keys
+}
+
+// finish records the result of an announce and schedules the next one.
+func (a *announcer) finish(key string, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	status, ok := a.keys[key]
+	if !ok {
+		return // Removed while it was being announced
+	}
+	status.Announcing = false
+
+	now := time.Now()
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+		backoff := announceRetryMin << min(status.Failures-1, 6)
+		status.NextProvide = now.Add(min(backoff, announceRetryMax)).Unix()
+		return
+	}
+	status.LastProvided = now.Unix()
+	status.NextProvide = now.Add(reprovideInterval).Unix()
+	status.Failures = 0
+	status.LastError = ""
+}
+
+// nextProvide returns when the next key is due, the zero time when there is none.
+func (a *announcer) nextProvide() time.Time {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var next time.Time
+	for _, status := range a.keys {
+		due := time.Unix(status.NextProvide, 0)
+		if !status.Announcing && (next.IsZero() || due.Before(next)) {
+			next = due
+		}
+	}
+	return next
+}
@@ -0,0 +1,733 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"server/bandwidth"
+	"server/database/models"
+	"server/database/operations"
+	"server/events"
+	"server/reputation"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Storage protocol: a peer pays another one to keep a copy of a file for a while. The storing peer
+// proves every interval that it still has the file by hashing a nonce with random chunks of it,
+// and every passed proof is paid. The challenges are computed when the contract is made, so the
+// uploader can check the proofs even after it deleted its own copy.
+const (
+	storageProtocol       = "/blubberbytes/storage/1.0.0"
+	storageMessageSize    = 16 * 1024
+	storageChunkSize      = 16 * 1024
+	storageChunksPerProof = 4
+	maxStorageChunks      = 16   // Chunks a peer may ask a proof of
+	MaxStorageRounds      = 1000 // Proof intervals a contract can last
+	MinStorageInterval    = time.Minute
+	maxStorageFailures    = 3 // Failed proofs in a row before a contract is given up
+	storageCheckInterval  = time.Minute
+	storageProofTimeout   = 30 * time.Second
+	pinGrace              = time.Hour // Pins are kept this long after they expire for the last proof
+	pinnedFolder          = "./pinned"
+)
+
+// Statuses of a storage contract
+const (
+	StorageActive    = "active"
+	StorageCompleted = "completed"
+	StorageFailed    = "failed"
+	StorageCancelled = "cancelled"
+)
+
+var (
+	ErrStorageRefused      = errors.New("the peer refused to store the file")
+	ErrInvalidStorageTerms = errors.New("invalid storage terms")
+)
+
+// storageRequest is sent by the peer paying for storage
+type storageRequest struct {
+	Type      string  `json:"type"` // store, challenge or cancel
+	Hash      string  `json:"hash"`
+	Name      string  `json:"name,omitempty"`
+	Extension string  `json:"extension,omitempty"`
+	Size      int64   `json:"size,omitempty"`
+	Price     float64 `json:"price,omitempty"`
+	Interval  int64   `json:"interval,omitempty"`
+	Expires   int64   `json:"expires,omitempty"`
+	Nonce     string  `json:"nonce,omitempty"`
+	Chunks    []int64 `json:"chunks,omitempty"`
+}
+
+// storageResponse is the answer of the storing peer
+type storageResponse struct {
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+	Wallet   string `json:"wallet,omitempty"`
+	Proof    string `json:"proof,omitempty"`
+}
+
+func startStorageService(node host.Host, db *sql.DB) {
+	node.SetStreamHandler(storageProtocol, func(s network.Stream) {
+		handleStorageRequest(db, s)
+	})
+}
+
+// handleStorageRequest serves the requests of the peers paying us to store their files.
+func handleStorageRequest(db *sql.DB, s network.Stream) {
+	defer s.Close()
+	remote := s.Conn().RemotePeer()
+	if !allowRequest(db, remote, storageProtocol) {
+		s.Reset()
+		return
+	}
+	s.SetDeadline(time.Now().Add(storageProofTimeout))
+
+	decoder := json.NewDecoder(io.LimitReader(s, storageMessageSize))
+	var request storageRequest
+	err := decoder.Decode(&request)
+	if err != nil {
+		log.Printf("Invalid storage request from peer %s: %v", remote, err)
+		s.Reset()
+		return
+	}
+
+	var response storageResponse
+	switch request.Type {
+	case "store":
+		// The file follows the newline ending the request, part of it may be buffered in the decoder already
+		data := bufio.NewReader(io.MultiReader(decoder.Buffered(), s))
+		if next, err := data.Peek(1); err == nil && next[0] == '\n' {
+			data.Discard(1)
+		}
+		acceptPin(db, s, data, remote, request)
+		return
+	case "challenge":
+		response = answerChallenge(db, remote, request)
+	case "cancel":
+		response = cancelPin(db, remote, request)
+	default:
+		response.Error = fmt.Sprintf("unknown storage request %q", request.Type)
+	}
+
+	err = json.NewEncoder(s).Encode(response)
+	if err != nil {
+		log.Printf("Failed to answer storage request from peer %s: %v", remote, err)
+	}
+}
+
+// acceptPin checks a store request against our storage settings, receives the file and keeps it
+// until the pin expires. The file is hosted for free, the uploader pays for it to be available.
+func acceptPin(db *sql.DB, s network.Stream, data io.Reader, remote peer.ID, request storageRequest) {
+	encoder := json.NewEncoder(s)
+	refuse := func(err error) {
+		log.Printf("Refusing to store %s for peer %s: %v", request.Hash, remote, err)
+		encoder.Encode(storageResponse{Error: err.Error()})
+	}
+
+	err := checkPinRequest(db, request)
+	if err != nil {
+		refuse(err)
+		return
+	}
+	walletInfo, err := operations.GetWalletInfo(db)
+	if err != nil || walletInfo == nil || walletInfo.Address == "" {
+		refuse(fmt.Errorf("no wallet address to be paid at"))
+		return
+	}
+
+	err = encoder.Encode(storageResponse{Accepted: true, Wallet: walletInfo.Address})
+	if err != nil {
+		return
+	}
+	s.SetDeadline(time.Now().Add(storageUploadTimeout(request.Size)))
+
+	path, err := receivePinnedFile(data, request.Hash, request.Size)
+	if err != nil {
+		refuse(err)
+		return
+	}
+
+	// List the file unless we store it already, so that it can be downloaded from us
+	storing, err := operations.FindStoring(db, request.Hash)
+	if err != nil {
+		refuse(err)
+		return
+	}
+	if storing == nil {
+		date := time.Now().Local().Format("2006-01-02")
+		err = operations.AddStoring(db, request.Hash, request.Name, request.Extension, path, date, request.Size)
+		if err == nil {
+			err = operations.AddHosting(db, request.Hash, 0)
+		}
+		if err != nil {
+			refuse(err)
+			return
+		}
+		if err := Announce(request.Hash); err != nil {
+			log.Printf("Failed to announce pinned file %s: %v", request.Hash, err)
+		}
+	}
+
+	err = operations.AddPin(db, models.Pin{
+		Hash:     request.Hash,
+		Peer:     remote.String(),
+		Name:     request.Name,
+		Path:     path,
+		Size:     request.Size,
+		Price:    request.Price,
+		Interval: request.Interval,
+		Expires:  request.Expires,
+	})
+	if err != nil {
+		refuse(err)
+		return
+	}
+
+	log.Printf("Pinned %s for peer %s until %s", request.Hash, remote, time.Unix(request.Expires, 0).Format(time.RFC3339))
+	encoder.Encode(storageResponse{Accepted: true})
+}
+
+func checkPinRequest(db *sql.DB, request storageRequest) error {
+	settings, err := operations.GetStorageSettings(db)
+	if err != nil {
+		return err
+	}
+	if settings == nil || !settings.Enabled {
+		return fmt.Errorf("this node doesn't store files for other peers")
+	}
+
+	now := time.Now().Unix()
+	switch {
+	case len(request.Hash) != sha256.Size*2:
+		return fmt.Errorf("invalid hash")
+	case request.Size <= 0:
+		return fmt.Errorf("invalid size")
+	case request.Price < settings.MinPrice:
+		return fmt.Errorf("the price is below our minimum of %v per interval", settings.MinPrice)
+	case request.Interval < int64(MinStorageInterval/time.Second):
+		return fmt.Errorf("the interval is shorter than %s", MinStorageInterval)
+	case request.Expires <= now || request.Expires > now+request.Interval*MaxStorageRounds:
+		return fmt.Errorf("invalid expiry")
+	}
+
+	pinned, err := operations.GetPinnedBytes(db)
+	if err != nil {
+		return err
+	}
+	if pinned+request.Size > settings.MaxBytes {
+		return fmt.Errorf("not enough storage left")
+	}
+	return nil
+}
+
+// receivePinnedFile writes size bytes of data to the pinned folder and checks them against hash.
+func receivePinnedFile(data io.Reader, hash string, size int64) (string, error) {
+	err := os.MkdirAll(pinnedFolder, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create pinned folder: %v", err)
+	}
+	temp, err := os.CreateTemp(pinnedFolder, "receiving-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create pinned file: %v", err)
+	}
+	defer os.Remove(temp.Name()) // Fails harmlessly once the file is renamed
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(temp, hasher), io.LimitReader(data, size))
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to receive file: %v", err)
+	}
+	if n != size {
+		return "", fmt.Errorf("received %d bytes, expected %d", n, size)
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != hash {
+		return "", fmt.Errorf("%w %s", ErrHashMismatch, hash)
+	}
+
+	path, err := filepath.Abs(filepath.Join(pinnedFolder, hash))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve pinned file path: %v", err)
+	}
+	err = os.Rename(temp.Name(), path)
+	if err != nil {
+		return "", fmt.Errorf("failed to move pinned file: %v", err)
+	}
+	return path, nil
+}
+
+func answerChallenge(db *sql.DB, remote peer.ID, request storageRequest) storageResponse {
+	pin, err := operations.FindPin(db, request.Hash, remote.String())
+	if err != nil {
+		return storageResponse{Error: err.Error()}
+	} else if pin == nil {
+		return storageResponse{Error: "the file is not pinned for you"}
+	}
+	if len(request.Chunks) == 0 || len(request.Chunks) > maxStorageChunks {
+		return storageResponse{Error: fmt.Sprintf("a proof covers 1 to %d chunks", maxStorageChunks)}
+	}
+
+	proof, err := provePossession(pin.Path, request.Nonce, request.Chunks)
+	if err != nil {
+		log.Printf("Failed to prove possession of %s: %v", request.Hash, err)
+		return storageResponse{Error: err.Error()}
+	}
+
+	err = operations.AddPinProof(db, request.Hash, remote.String(), time.Now().Unix())
+	if err != nil {
+		log.Printf("Failed to count proof of %s: %v", request.Hash, err)
+	}
+	return storageResponse{Accepted: true, Proof: proof}
+}
+
+func cancelPin(db *sql.DB, remote peer.ID, request storageRequest) storageResponse {
+	pin, err := operations.FindPin(db, request.Hash, remote.String())
+	if err != nil {
+		return storageResponse{Error: err.Error()}
+	} else if pin == nil {
+		return storageResponse{Error: "the file is not pinned for you"}
+	}
+
+	err = removePin(db, *pin)
+	if err != nil {
+		return storageResponse{Error: err.Error()}
+	}
+	log.Printf("Peer %s cancelled its pin of %s", remote, request.Hash)
+	return storageResponse{Accepted: true}
+}
+
+// removePin deletes a pin, and the file once no peer pins it anymore.
+func removePin(db *sql.DB, pin models.Pin) error {
+	err := operations.DeletePin(db, pin.Hash, pin.Peer)
+	if err != nil {
+		return err
+	}
+	count, err := operations.CountPins(db, pin.Hash)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	// The file is only unlisted if it was listed for the pin, not when we stored it ourselves
+	storing, err := operations.FindStoring(db, pin.Hash)
+	if err != nil {
+		return err
+	}
+	if storing != nil && storing.Path == pin.Path {
+		err = operations.DeleteStoring(db, pin.Hash)
+		if err != nil {
+			return err
+		}
+		err = operations.DeleteHosting(db, pin.Hash)
+		if err != nil {
+			return err
+		}
+		StopAnnouncing(pin.Hash)
+	}
+
+	err = os.Remove(pin.Path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove pinned file %s: %v", pin.Path, err)
+	}
+	return nil
+}
+
+// provePossession hashes the nonce followed by the given chunks of a file.
+func provePossession(path, nonce string, chunks []int64) (string, error) {
+	nonceBytes, err := hex.DecodeString(nonce)
+	if err != nil || len(nonceBytes) == 0 {
+		return "", fmt.Errorf("invalid nonce")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	hasher.Write(nonceBytes)
+	chunk := make([]byte, storageChunkSize)
+	for _, index := range chunks {
+		if index < 0 {
+			return "", fmt.Errorf("chunk %d is out of range", index)
+		}
+		n, err := file.ReadAt(chunk, index*storageChunkSize)
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to read chunk %d: %v", index, err)
+		}
+		if n == 0 {
+			return "", fmt.Errorf("chunk %d is out of range", index)
+		}
+		hasher.Write(chunk[:n])
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// storageUploadTimeout leaves a file at least 64 KB/s to be transferred.
+func storageUploadTimeout(size int64) time.Duration {
+	return storageProofTimeout + time.Duration(size/(64*1024))*time.Second
+}
+
+// CreateStorageContract uploads a stored file to a peer that keeps it for duration and proves
+// every interval that it still has it. Every passed proof is paid price.
+func CreateStorageContract(node host.Host, db *sql.DB, hash, peerID string, duration, interval time.Duration, price float64) (*models.StorageContract, error) {
+	if interval < MinStorageInterval {
+		return nil, fmt.Errorf("%w: the interval must be at least %s", ErrInvalidStorageTerms, MinStorageInterval)
+	}
+	rounds := int64(duration / interval)
+	if rounds < 1 || rounds > MaxStorageRounds {
+		return nil, fmt.Errorf("%w: the duration must be 1 to %d intervals", ErrInvalidStorageTerms, MaxStorageRounds)
+	}
+	if price < 0 {
+		return nil, fmt.Errorf("%w: the price can't be negative", ErrInvalidStorageTerms)
+	}
+
+	storing, err := operations.FindStoring(db, hash)
+	if err != nil {
+		return nil, err
+	} else if storing == nil {
+		return nil, fmt.Errorf("file with hash %s is not being stored", hash)
+	}
+	id, err := peer.Decode(peerID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPeer, err)
+	}
+
+	file, err := os.Open(storing.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, fmt.Errorf("can't store an empty file")
+	}
+
+	// Step 1: Compute a challenge for every round while we have the file
+	challenges := []models.StorageChallenge{}
+	for round := int64(1); round <= rounds; round++ {
+		challenge, err := newStorageChallenge(storing.Path, size, round)
+		if err != nil {
+			return nil, err
+		}
+		challenges = append(challenges, challenge)
+	}
+
+	// Step 2: Ask the peer to store the file and upload it
+	now := time.Now()
+	contract := models.StorageContract{
+		Hash:      hash,
+		Peer:      peerID,
+		Size:      size,
+		Price:     price,
+		Interval:  int64(interval / time.Second),
+		Start:     now.Unix(),
+		End:       now.Add(time.Duration(rounds) * interval).Unix(),
+		Rounds:    rounds,
+		NextProof: now.Add(interval).Unix(),
+		Status:    StorageActive,
+	}
+
+	ctx, cancel := context.WithTimeout(globalCtx, storageUploadTimeout(size))
+	defer cancel()
+	s, err := node.NewStream(network.WithAllowLimitedConn(ctx, storageProtocol), id, storageProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	deadline, _ := ctx.Deadline()
+	s.SetDeadline(deadline)
+
+	err = json.NewEncoder(s).Encode(storageRequest{
+		Type:      "store",
+		Hash:      hash,
+		Name:      storing.Name,
+		Extension: storing.Extension,
+		Size:      size,
+		Price:     price,
+		Interval:  contract.Interval,
+		Expires:   contract.End,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(io.LimitReader(s, storageMessageSize))
+	var response storageResponse
+	err = decoder.Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage response: %v", err)
+	}
+	if !response.Accepted || response.Wallet == "" {
+		return nil, fmt.Errorf("%w: %s", ErrStorageRefused, response.Error)
+	}
+	contract.Wallet = response.Wallet
+
+	_, err = io.Copy(bandwidth.Default.Writer(s, peerID), file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %v", err)
+	}
+	s.CloseWrite()
+
+	err = decoder.Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage response: %v", err)
+	}
+	if !response.Accepted {
+		return nil, fmt.Errorf("%w: %s", ErrStorageRefused, response.Error)
+	}
+
+	// Step 3: Remember the contract, its proofs are checked by runStorageContracts
+	contract.ID, err = operations.AddStorageContract(db, contract, challenges)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Peer %s stores %s for %d rounds of %s", peerID, hash, rounds, interval)
+	events.Publish(events.StorageContract, contract)
+	return &contract, nil
+}
+
+func newStorageChallenge(path string, size, round int64) (models.StorageChallenge, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return models.StorageChallenge{}, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	// Chunks are picked with crypto/rand too, the storing peer must not be able to predict them
+	count := (size + storageChunkSize - 1) / storageChunkSize
+	chunks := make([]int64, storageChunksPerProof)
+	random := make([]byte, 8)
+	for i := range chunks {
+		_, err = rand.Read(random)
+		if err != nil {
+			return models.StorageChallenge{}, fmt.Errorf("failed to pick chunks: %v", err)
+		}
+		chunks[i] = int64(binary.BigEndian.Uint64(random) % uint64(count))
+	}
+
+	challenge := models.StorageChallenge{Round: round, Nonce: hex.EncodeToString(nonce), Chunks: chunks}
+	challenge.Expected, err = provePossession(path, challenge.Nonce, chunks)
+	return challenge, err
+}
+
+// CancelStorageContract stops checking and paying a contract and tells the peer it can drop the file.
+func CancelStorageContract(node host.Host, db *sql.DB, contract *models.StorageContract) error {
+	if contract.Status != StorageActive {
+		return nil
+	}
+
+	contract.Status = StorageCancelled
+	err := operations.UpdateStorageContract(db, *contract)
+	if err != nil {
+		return err
+	}
+	err = operations.DeleteStorageChallenges(db, contract.ID)
+	if err != nil {
+		return err
+	}
+	events.Publish(events.StorageContract, contract)
+
+	// The pin expires on its own if the peer can't be told
+	_, err = sendStorageRequest(node, contract.Peer, storageRequest{Type: "cancel", Hash: contract.Hash})
+	if err != nil {
+		log.Printf("Failed to tell peer %s that storage of %s is cancelled: %v", contract.Peer, contract.Hash, err)
+	}
+	return nil
+}
+
+// sendStorageRequest sends a challenge or cancel request and returns the peer's answer.
+func sendStorageRequest(node host.Host, peerID string, request storageRequest) (*storageResponse, error) {
+	id, err := peer.Decode(peerID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(globalCtx, storageProofTimeout)
+	defer cancel()
+	s, err := node.NewStream(network.WithAllowLimitedConn(ctx, storageProtocol), id, storageProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(storageProofTimeout))
+
+	err = json.NewEncoder(s).Encode(request)
+	if err != nil {
+		return nil, err
+	}
+	s.CloseWrite()
+
+	var response storageResponse
+	err = json.NewDecoder(io.LimitReader(s, storageMessageSize)).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage response: %v", err)
+	}
+	if !response.Accepted {
+		return nil, fmt.Errorf("peer %s: %s", peerID, response.Error)
+	}
+	return &response, nil
+}
+
+// runStorageContracts checks the proofs of our contracts as they come due and drops the pins of
+// other peers once they expired, until the context is done.
+func runStorageContracts(ctx context.Context, node host.Host, db *sql.DB, btcwallet *rpcclient.Client, netParams *chaincfg.Params) {
+	ticker := time.NewTicker(storageCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().Unix()
+		contracts, err := operations.GetDueStorageContracts(db, now)
+		if err != nil {
+			log.Printf("Failed to read storage contracts: %v", err)
+		}
+		for _, contract := range contracts {
+			if contract.Status == StorageActive && contract.NextProof <= now {
+				checkStorageProof(node, db, &contract)
+			}
+			if contract.Owed > 0 {
+				payStorageContract(db, btcwallet, netParams, &contract)
+			}
+			err = operations.UpdateStorageContract(db, contract)
+			if err != nil {
+				log.Printf("Failed to save storage contract %d: %v", contract.ID, err)
+			}
+			events.Publish(events.StorageContract, contract)
+		}
+
+		pins, err := operations.GetExpiredPins(db, time.Now().Add(-pinGrace).Unix())
+		if err != nil {
+			log.Printf("Failed to read expired pins: %v", err)
+		}
+		for _, pin := range pins {
+			err = removePin(db, pin)
+			if err != nil {
+				log.Printf("Failed to remove expired pin of %s: %v", pin.Hash, err)
+			}
+		}
+	}
+}
+
+// checkStorageProof challenges the storing peer for the next round of a contract, a matching proof
+// adds the price of the round to what the contract owes. A wrong or missing proof leaves the round
+// unpaid. Either way the round is over: its challenge is deleted once sent, since a peer that saw
+// the chunks and nonce could answer it again without the file.
+func checkStorageProof(node host.Host, db *sql.DB, contract *models.StorageContract) {
+	round := contract.Round + 1
+	challenge, err := operations.FindStorageChallenge(db, contract.ID, round)
+	if err != nil {
+		contract.LastError = err.Error()
+		return
+	} else if challenge == nil {
+		contract.Status = StorageFailed
+		contract.LastError = fmt.Sprintf("no challenge for round %d", round)
+		return
+	}
+
+	response, err := sendStorageRequest(node, contract.Peer, storageRequest{
+		Type:   "challenge",
+		Hash:   contract.Hash,
+		Nonce:  challenge.Nonce,
+		Chunks: challenge.Chunks,
+	})
+	if err == nil && response.Proof != challenge.Expected {
+		err = fmt.Errorf("wrong proof for round %d", round)
+	}
+	deleteErr := operations.DeleteStorageChallenge(db, contract.ID, round)
+	if deleteErr != nil {
+		log.Printf("Failed to delete challenge %d of storage contract %d: %v", round, contract.ID, deleteErr)
+	}
+
+	if err != nil {
+		log.Printf("Storage proof of %s by peer %s failed: %v", contract.Hash, contract.Peer, err)
+		reputation.RecordFailure(db, contract.Peer)
+		contract.Failures++
+		contract.LastError = err.Error()
+	} else {
+		contract.Proofs++
+		contract.Failures = 0
+		contract.Owed += contract.Price
+		contract.LastError = ""
+	}
+
+	contract.Round = round
+	contract.NextProof += contract.Interval
+	switch {
+	case contract.Failures >= maxStorageFailures:
+		contract.Status = StorageFailed
+	case contract.Round >= contract.Rounds:
+		contract.Status = StorageCompleted
+	}
+	if contract.Status != StorageActive {
+		err = operations.DeleteStorageChallenges(db, contract.ID)
+		if err != nil {
+			log.Printf("Failed to delete challenges of storage contract %d: %v", contract.ID, err)
+		}
+	}
+}
+
+// payStorageContract pays what a contract owes for its passed proofs. A failed payment is tried
+// again at the next tick, the peer isn't to blame and isn't challenged again for it.
+func payStorageContract(db *sql.DB, btcwallet *rpcclient.Client, netParams *chaincfg.Params, contract *models.StorageContract) {
+	err := payPeer(db, btcwallet, netParams, contract.Wallet, contract.Owed)
+	if err != nil {
+		log.Printf("Failed to pay peer %s for storing %s: %v", contract.Peer, contract.Hash, err)
+		contract.LastError = fmt.Sprintf("payment failed: %v", err)
+		return
+	}
+	contract.Paid += contract.Owed
+	contract.Owed = 0
+}
+
+// payPeer sends amount to a wallet address, nothing is sent for free contracts.
+func payPeer(db *sql.DB, btcwallet *rpcclient.Client, netParams *chaincfg.Params, address string, amount float64) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	walletInfo, err := operations.GetWalletInfo(db)
+	if err != nil {
+		return err
+	}
+	err = btcwallet.WalletPassphrase(walletInfo.PrivPassphrase, 300)
+	if err != nil {
+		return err
+	}
+
+	btcutilAddress, err := btcutil.DecodeAddress(address, netParams)
+	if err != nil {
+		return err
+	}
+	_, err = btcwallet.SendFrom("default", btcutilAddress, btcutil.Amount(amount*1e8))
+	return err
+}
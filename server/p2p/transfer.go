@@ -10,10 +10,13 @@ import (
 	"log"           // for logging
 	"os"            // for file operations
 	"path/filepath" // for file path manipulations
+	"server/bandwidth"
 	"server/database/models"
 	"server/database/operations"
+	"server/events"
 	"strings"
 	"sync"
+	"time"
 
 	// Add the necessary packages from libp2p, for example:
 	"github.com/btcsuite/btcd/chaincfg"
@@ -29,18 +32,18 @@ var (
 	receivedFileData      []byte
 	receivedFileExt       string
 	receivedFileName      string
+	receivedFileSize      int64 // Size of the file being downloaded for its progress events, 0 when unknown
 	receivedInfo          models.JoinedHosting
 	receivedWalletAddress string
 	infoSignal            = make(chan struct{})
 	passwordSignalChan    = make(chan struct{})
 	hashSignalChan        = make(chan struct{})
+	busySignalChan        = make(chan struct{})
 	hostingUpdateSignal   = make(chan struct{})
-	successSignal         = make(chan struct{})
-	failureSignal         = make(chan struct{})
-	proxyList             []models.Proxy        // Global list to store received proxies
-	proxySignal           = make(chan struct{}) // Channel to signal when a response is received
+	proxyWaiters          = map[string]chan []models.Proxy{} // FindProxies calls waiting for the offerings of a peer
 	hostingList           []models.JoinedHosting
 	dataMutex             sync.Mutex
+	advertisedRates       = map[string]map[int64]float64{} // Rates the proxies offered us, by peer ID and offering
 )
 
 // Channel for signaling when data is ready
@@ -66,6 +69,13 @@ func receiveDataFromPeer(node host.Host, db *sql.DB, folderPath string, btcwalle
 		// Log the header to help track the received type of data
 		log.Printf("Received header: %s", header)
 
+		// Requests of blocked peers and requests over the peer's quota are dropped
+		if !allowRequest(db, s.Conn().RemotePeer(), header) {
+			log.Printf("Dropping '%s' from peer %s", header, s.Conn().RemotePeer())
+			s.Reset()
+			return
+		}
+
 		if header == "file" {
 			// Handle file transfer
 			fileName := "node_file.pdf"
@@ -108,16 +118,9 @@ func receiveDataFromPeer(node host.Host, db *sql.DB, folderPath string, btcwalle
 			// Log the confirmation message
 			log.Printf("Confirmation received: %s", message)
 
-			// Send a signal based on the confirmation message
-			if message == "Processing successful" {
-				log.Println("Processing was successful. Sending success signal.")
-				successSignal <- struct{}{}
-			} else if message == "Processing failed" {
-				log.Println("Processing failed. Sending failure signal.")
-				failureSignal <- struct{}{}
-			} else {
-				log.Printf("Unknown confirmation message received: %s", message)
-			}
+			// The bill and its transaction follow on the next line
+			details, _ := reader.ReadString('\n')
+			confirmBill(s.Conn().RemotePeer().String(), message, details)
 		} else if header == "ProxyBill" {
 			log.Printf("Processing 'ProxyBill' from peer: %s", s.Conn().RemotePeer())
 
@@ -138,7 +141,7 @@ func receiveDataFromPeer(node host.Host, db *sql.DB, folderPath string, btcwalle
 			}
 
 			// Call helper function to handle the ProxyBill and send confirmation back
-			err = handleProxyBill(node, proxyBill, s.Conn().RemotePeer().String(), btcwallet, netParams, db)
+			err = handleProxyBill(node, proxyBill, s.Conn().RemotePeer().String(), s.Conn().RemoteMultiaddr(), btcwallet, netParams, db)
 			if err != nil {
 				log.Printf("Error processing ProxyBill: %v", err)
 			}
@@ -148,56 +151,52 @@ func receiveDataFromPeer(node host.Host, db *sql.DB, folderPath string, btcwalle
 			// Log the start of reading the response
 			log.Println("Attempting to read the 'requested_proxy' response.")
 
-			// Read the next line to check for the response type
-			response, err := reader.ReadString('\n')
-			if err != nil {
-				log.Printf("Error reading 'requested_proxy' response from peer %s: %v", s.Conn().RemotePeer(), err)
-				log.Println("Possible reasons: Peer closed the stream prematurely or did not send data.")
-				log.Println("Ensure the sending peer is properly sending the 'requested_proxy' response.")
-				// Send a signal even if there's an error
-				proxySignal <- struct{}{}
-				return
+			// The peer sends one line per offering, or "no proxy anymore" when it has none
+			received := []models.Proxy{}
+			for {
+				response, err := reader.ReadString('\n')
+				response = strings.TrimSpace(response)
+				if response == "no proxy anymore" {
+					log.Println("No proxy available. Peer has no proxies to provide.")
+					break
+				}
+				if response != "" {
+					var proxy models.Proxy
+					if jsonErr := json.Unmarshal([]byte(response), &proxy); jsonErr != nil {
+						log.Printf("Error unmarshaling proxy data from peer %s: %v", s.Conn().RemotePeer(), jsonErr)
+						log.Printf("Received data was: %s", response)
+					} else {
+						log.Printf("Received proxy from peer: %+v", proxy)
+						received = append(received, proxy)
+					}
+				}
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					log.Printf("Error reading 'requested_proxy' response from peer %s: %v", s.Conn().RemotePeer(), err)
+					break
+				}
 			}
-			response = strings.TrimSpace(response)
-
-			// Log the raw response received
-			log.Printf("Raw response received: %s", response)
 
-			// Check the response
-			if response == "no proxy anymore" {
-				log.Println("No proxy available. Peer has no proxies to provide.")
-				// Send a signal and exit
-				proxySignal <- struct{}{}
-				return
+			// Hand the received proxies to the caller waiting for them, and remember their rates to check their bills
+			peerID := s.Conn().RemotePeer().String()
+			dataMutex.Lock()
+			for _, proxy := range received {
+				if advertisedRates[peerID] == nil {
+					advertisedRates[peerID] = map[int64]float64{}
+				}
+				advertisedRates[peerID][proxy.ID] = proxy.Rate
 			}
+			waiter, ok := proxyWaiters[peerID]
+			delete(proxyWaiters, peerID)
+			dataMutex.Unlock()
 
-			// Log that a proxy response was received
-			log.Println("Received proxy data, attempting to unmarshal JSON.")
-
-			// If a proxy is available, unmarshal the JSON data
-			var proxy models.Proxy
-			err = json.Unmarshal([]byte(response), &proxy)
-			if err != nil {
-				log.Printf("Error unmarshaling proxy data from peer %s: %v", s.Conn().RemotePeer(), err)
-				log.Printf("Received data was: %s", response)
-				log.Println("Ensure the response is valid JSON in the format: {\"IP\":\"<ip>\",\"Rate\":<rate>},\"Wallet\":<wallet>}")
-				// Send a signal even if there's an unmarshaling error
-				proxySignal <- struct{}{}
+			if !ok {
+				log.Printf("Nobody is waiting for the proxies of peer %s anymore", peerID)
 				return
 			}
-
-			// Log the received proxy data
-			log.Printf("Received proxy from peer: %+v", proxy)
-
-			// Add the received proxy to the global list
-			dataMutex.Lock()
-			proxyList = append(proxyList, proxy)
-			dataMutex.Unlock()
-
-			log.Printf("Proxy added to global list. Current list size: %d", len(proxyList))
-
-			// Send a signal after adding the proxy
-			proxySignal <- struct{}{}
+			log.Printf("Received %d proxies from peer %s", len(received), peerID)
+			waiter <- received
 		} else if header == "proxy_request" {
 			log.Printf("Processing 'proxy_request' request from peer: %s", s.Conn().RemotePeer())
 
@@ -259,6 +258,12 @@ func receiveDataFromPeer(node host.Host, db *sql.DB, folderPath string, btcwalle
 				hashSignalChan <- struct{}{} // Notify the file signal channel
 				return
 
+			case "Upload queue full":
+				log.Println("Received 'Upload queue full' message from peer.")
+				signalChan <- struct{}{}
+				busySignalChan <- struct{}{}
+				return
+
 			default:
 				log.Printf("Received unknown message from peer: %s", message)
 				return
@@ -402,16 +407,16 @@ func sendProxyResponseToPeer(node host.Host, targetPeerID string, db *sql.DB) er
 		return err
 	}
 
-	// Retrieve the proxy from the database
-	proxy, err := operations.GetProxy(db)
+	// Retrieve the proxy offerings from the database
+	offerings, err := operations.GetProxyOfferings(db)
 	if err != nil {
 		// Send "no proxy anymore" if there's a database error
 		_, _ = s.Write([]byte("no proxy anymore\n"))
-		log.Printf("Error retrieving proxy from database: %v", err)
+		log.Printf("Error retrieving proxy offerings from database: %v", err)
 		return err
 	}
 
-	if proxy == nil {
+	if len(offerings) == 0 {
 		// No proxy found, send "no proxy anymore"
 		_, err = s.Write([]byte("no proxy anymore\n"))
 		if err != nil {
@@ -422,28 +427,37 @@ func sendProxyResponseToPeer(node host.Host, targetPeerID string, db *sql.DB) er
 		return nil
 	}
 
-	// Proxy found, send it back as JSON
-	proxyData, err := json.Marshal(proxy)
-	if err != nil {
-		// Send "no proxy anymore" if JSON marshaling fails
-		_, _ = s.Write([]byte("no proxy anymore\n"))
-		log.Printf("Error marshaling proxy data: %v", err)
-		return err
-	}
+	// Offerings found, send each back as a JSON line
+	for _, offering := range offerings {
+		proxyData, err := json.Marshal(offering)
+		if err != nil {
+			log.Printf("Error marshaling proxy offering %d: %v", offering.ID, err)
+			return err
+		}
 
-	_, err = s.Write(append(proxyData, '\n'))
-	if err != nil {
-		log.Printf("Error sending proxy data to peer %s: %v", targetPeerIDParsed, err)
-		return err
+		_, err = s.Write(append(proxyData, '\n'))
+		if err != nil {
+			log.Printf("Error sending proxy data to peer %s: %v", targetPeerIDParsed, err)
+			return err
+		}
 	}
 
-	log.Printf("Successfully sent proxy data to peer %s: %+v", targetPeerIDParsed, proxy)
+	log.Printf("Successfully sent %d proxy offerings to peer %s", len(offerings), targetPeerIDParsed)
 	return nil
 }
 
 func handleDownloadRequest(s network.Stream, db *sql.DB, node host.Host, targetPeerID string) {
 	log.Printf("Handling download request from peer %s", targetPeerID)
 
+	// Wait for a free upload slot, the requester gives up after transferTimeout
+	release, err := acquireUploadSlot()
+	if err != nil {
+		log.Printf("Refusing request from peer %s: %v", targetPeerID, err)
+		sendDataToPeer(node, targetPeerID, "", "Upload queue full", "message", "", "")
+		return
+	}
+	defer release()
+
 	reader := bufio.NewReader(s)
 
 	// Read the file hash
@@ -615,6 +629,23 @@ func handleSendAllRequest(s network.Stream, db *sql.DB, node host.Host, targetPe
 func handleFileRequest(s network.Stream, db *sql.DB, node host.Host, targetPeerID string) {
 	log.Printf("Handling file request from peer %s", targetPeerID)
 
+	// Peers that guessed wrong passwords too often are refused without checking
+	remote := s.Conn().RemotePeer()
+	if passwordLocked(remote) {
+		log.Printf("Refusing request from peer %s, locked out after wrong passwords", targetPeerID)
+		sendDataToPeer(node, targetPeerID, "", "Invalid password", "", "", "")
+		return
+	}
+
+	// Wait for a free upload slot, the requester gives up after transferTimeout
+	release, err := acquireUploadSlot()
+	if err != nil {
+		log.Printf("Refusing request from peer %s: %v", targetPeerID, err)
+		sendDataToPeer(node, targetPeerID, "", "Upload queue full", "message", "", "")
+		return
+	}
+	defer release()
+
 	reader := bufio.NewReader(s)
 
 	// Read the file hash
@@ -650,19 +681,26 @@ func handleFileRequest(s network.Stream, db *sql.DB, node host.Host, targetPeerI
 
 	log.Printf("Checking password in the Sharing table for file hash: %s", fileHash)
 	sharing, err := operations.FindSharing(db, fileHash)
-	if err != nil || sharing == nil {
+	if (err != nil || sharing == nil) && !collectionGrantsAccess(db, fileHash, password) {
 		log.Printf("No password found in the Sharing table for file hash %s: %v", fileHash, err)
+		// A wrong collection password for a file of the collection counts like any wrong password,
+		// only a failed lookup is not the peer's fault
+		if err == nil {
+			recordPasswordFailure(db, remote)
+		}
 		sendDataToPeer(node, targetPeerID, "", "Password not found", "", "", "")
 		return
 	}
-	// Validate the password
-	if sharing.Password != password {
+	// Validate the password, files inside a shared collection use the collection's password
+	if sharing != nil && sharing.Password != password && !collectionGrantsAccess(db, fileHash, password) {
 		log.Printf("Invalid password provided for file hash: %s", fileHash)
+		recordPasswordFailure(db, remote)
 		sendDataToPeer(node, targetPeerID, "", "Invalid password", "", "", "")
 		return
 	}
 
 	log.Printf("Password validated successfully for file hash: %s", fileHash)
+	clearPasswordFailures(remote)
 
 	// Send the file name
 	fileName := storing.Name
@@ -930,8 +968,14 @@ func sendDataToPeer(node host.Host, targetPeerID, filePath, message, dataType st
 func receiveRequestedFile(s network.Stream) ([]byte, error) {
 	reader := bufio.NewReader(s)
 
+	// The name arrives before the content, use it to label the progress events
+	dataMutex.Lock()
+	name := receivedFileName
+	total := receivedFileSize
+	dataMutex.Unlock()
+
 	// Directly read the file content
-	data, err := io.ReadAll(reader)
+	data, err := io.ReadAll(newProgressReader(reader, events.DownloadProgress, s.Conn().RemotePeer().String(), name, total))
 	if err != nil {
 		log.Printf("Error reading requested file data from stream: %v", err)
 		return nil, err
@@ -947,6 +991,15 @@ func receiveRequestedFile(s network.Stream) ([]byte, error) {
 	return data, nil
 }
 
+// How long a request may wait in the upload queue, well within the requester's transferTimeout
+const uploadQueueTimeout = 2 * time.Minute
+
+func acquireUploadSlot() (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadQueueTimeout)
+	defer cancel()
+	return bandwidth.Default.Acquire(ctx)
+}
+
 func sendRequestedFileToPeer(node host.Host, targetPeerID, filePath string) error {
 	log.Printf("Preparing to send requested file to peer %s, file: %s", targetPeerID, filePath)
 
@@ -985,19 +1038,20 @@ func sendRequestedFileToPeer(node host.Host, targetPeerID, filePath string) erro
 	}
 	log.Printf("Sent 'requested_file' header to peer %s", targetPeerID)
 
-	// Write the file content
-	fileContent, err := io.ReadAll(file)
-	if err != nil {
-		log.Printf("Error reading file content: %v", err)
-		return err
+	// Stream the file content, publishing upload progress on the way
+	var size int64
+	if fileInfo, err := file.Stat(); err == nil {
+		size = fileInfo.Size()
 	}
+	progress := newProgressReader(file, events.UploadProgress, targetPeerID, filepath.Base(filePath), size)
 
-	n, err := s.Write(fileContent)
+	n, err := io.Copy(bandwidth.Default.Writer(s, targetPeerID), progress)
 	if err != nil {
 		log.Printf("Failed to send file content to peer %s: %v", targetPeerIDParsed, err)
 		return err
 	}
 	log.Printf("Sent %d bytes of requested file content to peer %s", n, targetPeerID)
+	events.Publish(events.UploadCompleted, progress.progress)
 
 	log.Printf("Requested file sent successfully to peer %s: %s", targetPeerID, filePath)
 	return nil
@@ -3,11 +3,19 @@ package p2p
 import (
 	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"server/database/models"
 	"server/database/operations"
+	"server/events"
+	"server/reputation"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"math/rand"
@@ -17,9 +25,25 @@ import (
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/multiformats/go-multihash"
 )
 
+// DownloadResult is the payload of the download completed event
+type DownloadResult struct {
+	Peer  string `json:"peer"`
+	Hash  string `json:"hash"`
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// ReceivedProxyBill is the payload of the proxy bill event
+type ReceivedProxyBill struct {
+	Peer string           `json:"peer"`
+	Bill models.ProxyBill `json:"bill"`
+}
+
 func RequestFileInfo(node host.Host, targetPeerID, hash string) (models.JoinedHosting, error) {
 	log.Printf("Preparing to request file info from peer %s for hash: %s", targetPeerID, hash)
 
@@ -46,7 +70,7 @@ func RequestFileInfo(node host.Host, targetPeerID, hash string) (models.JoinedHo
 		return info, nil
 	case <-time.After(10 * time.Second): // Timeout
 		log.Println("Timeout: No response received.")
-		return models.JoinedHosting{}, fmt.Errorf("timed out waiting for response from peer %s", targetPeerID)
+		return models.JoinedHosting{}, fmt.Errorf("%w %s", ErrTransferTimeout, targetPeerID)
 	}
 }
 
@@ -118,8 +142,8 @@ func GetProviderIDs(node host.Host, key string) ([]string, error) {
 	var ids []string
 	for p := range providers {
 
-		// Skip the node's own PeerID
-		if p.ID == node.ID() {
+		// Skip the node's own PeerID and the peers we blocked
+		if p.ID == node.ID() || blocked.has(p.ID) {
 			continue
 		}
 
@@ -129,10 +153,67 @@ func GetProviderIDs(node host.Host, key string) ([]string, error) {
 	return ids, nil
 }
 
-func SimplyDownload(node host.Host, targetPeerID, hash string) (string, []byte, string, string, error) {
+// How long to wait for a peer to answer a download request
+const transferTimeout = 5 * time.Minute
+
+// Proxy rates are per megabyte
+const ProxyRateUnit = 1e6
+
+// A bill is disputed when it claims more than the bytes we counted by this share plus
+// billSlackBytes. The counts of both sides are written every few seconds, so the period of a bill
+// is widened by billSlack when looking up our usage.
+const (
+	billTolerance  = 0.05
+	billSlackBytes = 64 << 10
+	billSlack      = time.Minute
+)
+
+var (
+	ErrTransferTimeout = errors.New("timed out waiting for peer")
+	ErrHashMismatch    = errors.New("data received does not match the hash")
+	ErrProviderBusy    = errors.New("provider's upload queue is full")
+	ErrBillRefused     = errors.New("the client failed to pay the bill")
+	ErrBillDisputed    = errors.New("the client disputed the bill")
+)
+
+// Answers to download requests come back through the shared received* variables, so only one
+// request can be waiting for its answer at a time
+var exchangeMutex sync.Mutex
+
+// resetExchange discards whatever an earlier request that timed out left behind.
+func resetExchange() {
+	for {
+		select {
+		case <-signalChan:
+		case <-hashSignalChan:
+		case <-busySignalChan:
+		case <-passwordSignalChan:
+		default:
+			dataMutex.Lock()
+			receivedFileData = nil
+			receivedFileExt = ""
+			receivedFileName = ""
+			receivedFileSize = 0
+			receivedWalletAddress = ""
+			dataMutex.Unlock()
+			return
+		}
+	}
+}
+
+// SimplyDownload asks a peer for a file and waits for it. Size is the size the peer announced for
+// the file, the total of the progress events, 0 when it isn't known.
+func SimplyDownload(node host.Host, targetPeerID, hash string, size int64) (string, []byte, string, string, error) {
 	// Log the start of the function
 	log.Printf("Starting SendDownloadRequest to peer %s for hash %s", targetPeerID, hash)
 
+	exchangeMutex.Lock()
+	defer exchangeMutex.Unlock()
+	resetExchange()
+	dataMutex.Lock()
+	receivedFileSize = size
+	dataMutex.Unlock()
+
 	// Call sendDataToPeer to send the download request
 	log.Println("Calling sendDataToPeer to send the download request...")
 	err := sendDataToPeer(node, targetPeerID, "", "", "download_request", hash, "")
@@ -143,7 +224,12 @@ func SimplyDownload(node host.Host, targetPeerID, hash string) (string, []byte,
 	log.Println("Download request sent successfully. Waiting for signal...")
 
 	// Wait for the first signal
-	<-signalChan
+	select {
+	case <-signalChan:
+	case <-time.After(transferTimeout):
+		log.Printf("Timed out waiting for peer %s to send the file", targetPeerID)
+		return "", nil, "", "", fmt.Errorf("%w %s", ErrTransferTimeout, targetPeerID)
+	}
 	log.Println("First signal received. Proceeding...")
 
 	time.Sleep(500 * time.Millisecond)
@@ -153,6 +239,9 @@ func SimplyDownload(node host.Host, targetPeerID, hash string) (string, []byte,
 	case <-hashSignalChan:
 		log.Println("Received hash signal indicating the hash is invalid.")
 		return "", nil, "", "", fmt.Errorf("hash is invalid")
+	case <-busySignalChan:
+		log.Printf("Peer %s has no free upload slot.", targetPeerID)
+		return "", nil, "", "", ErrProviderBusy
 	case <-time.After(100 * time.Millisecond):
 		log.Println("No hash signal received within 100ms. Continuing...")
 	}
@@ -183,19 +272,81 @@ func SimplyDownload(node host.Host, targetPeerID, hash string) (string, []byte,
 	receivedFileData = nil
 	receivedFileExt = ""
 	receivedFileName = ""
+	receivedFileSize = 0
 	receivedWalletAddress = "" // Clear wallet address
 
+	events.Publish(events.DownloadCompleted, DownloadResult{Peer: targetPeerID, Hash: hash, Name: name, Bytes: int64(len(data))})
 	return name, data, ext, walletAddress, nil
 }
 
+// PurchaseFile downloads a hosted file from a peer, pays the peer's price and records the download.
+// Size is the size the peer announced for the file, 0 when it isn't known.
+func PurchaseFile(node host.Host, btcwallet *rpcclient.Client, netParams *chaincfg.Params, db *sql.DB, targetPeerID, hash string, price float64, size int64) (string, []byte, string, error) {
+	walletInfo, err := operations.GetWalletInfo(db)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	err = btcwallet.WalletPassphrase(walletInfo.PrivPassphrase, 300)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	start := time.Now()
+	name, data, ext, address, err := SimplyDownload(node, targetPeerID, hash, size)
+	if err != nil {
+		if errors.Is(err, ErrTransferTimeout) {
+			reputation.RecordTimeout(db, targetPeerID)
+		} else if !errors.Is(err, ErrProviderBusy) {
+			reputation.RecordFailure(db, targetPeerID)
+		}
+		return "", nil, "", err
+	}
+
+	// Don't pay for something else than what was asked for
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		reputation.RecordHashMismatch(db, targetPeerID)
+		return "", nil, "", fmt.Errorf("%w %s from peer %s", ErrHashMismatch, hash, targetPeerID)
+	}
+	reputation.RecordTransfer(db, targetPeerID, int64(len(data)), time.Since(start))
+
+	btcutilAddress, err := btcutil.DecodeAddress(address, netParams)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	_, err = btcwallet.SendFrom("default", btcutilAddress, btcutil.Amount(price*1e8))
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	date := time.Now().Local().Format("01/02/2006")
+	err = operations.AddDownloads(db, date, hash, name, ext, int64(len(data)), price)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	return name, data, ext, nil
+}
+
 func SendRequest(node host.Host, targetPeerID, hash, password string) (string, []byte, string, error) {
+	exchangeMutex.Lock()
+	defer exchangeMutex.Unlock()
+	resetExchange()
+
 	// Call sendDataToPeer to send the request
 	err := sendDataToPeer(node, targetPeerID, "", "", "request", hash, password)
 	if err != nil {
 		return "", nil, "", err
 	}
 
-	<-signalChan // Wait for the first signal
+	// Wait for the first signal
+	select {
+	case <-signalChan:
+	case <-time.After(transferTimeout):
+		return "", nil, "", fmt.Errorf("%w %s", ErrTransferTimeout, targetPeerID)
+	}
 
 	time.Sleep(500 * time.Millisecond)
 
@@ -203,6 +354,8 @@ func SendRequest(node host.Host, targetPeerID, hash, password string) (string, [
 	select {
 	case <-hashSignalChan: // Replace with your actual hash signal channel
 		return "", nil, "", fmt.Errorf("hash is invalid")
+	case <-busySignalChan:
+		return "", nil, "", ErrProviderBusy
 	case <-time.After(100 * time.Millisecond):
 		// No hash signal received, continue
 	}
@@ -232,70 +385,126 @@ func SendRequest(node host.Host, targetPeerID, hash, password string) (string, [
 	receivedFileExt = ""
 	receivedFileName = ""
 
+	events.Publish(events.DownloadCompleted, DownloadResult{Peer: targetPeerID, Hash: hash, Name: name, Bytes: int64(len(data))})
 	return name, data, ext, nil
 }
 
-func RandomProxiesInfo(node host.Host) ([]models.Proxy, error) {
-	// Get a list of provider IDs for the "PROXY" key from the DHT
-	providerIDs, err := GetProviderIDs(node, "PROXY")
+// Proxy providers asked for their offerings at once, and how long they have to answer
+const (
+	maxProxyProviders = 10
+	proxyWaitTimeout  = 5 * time.Second
+)
+
+// RandomProxiesInfo asks up to 10 proxies for their offerings, the best scored ones first and
+// random ones among equal scores.
+func RandomProxiesInfo(node host.Host, db *sql.DB) ([]models.Proxy, error) {
+	return FindProxies(node, db, "", "")
+}
+
+// FindProxies is RandomProxiesInfo for the offerings of one protocol, and of one region when it
+// isn't empty. An empty protocol finds every proxy. The providers are asked at the same time and
+// the ones that don't answer within 5 seconds are left out.
+func FindProxies(node host.Host, db *sql.DB, protocol, region string) ([]models.Proxy, error) {
+	key := proxyKey
+	if protocol != "" {
+		keys := ProxyKeys(models.Proxy{Protocol: protocol, Region: region})
+		key = keys[len(keys)-1]
+	}
+
+	// Get a list of provider IDs for the key from the DHT
+	providerIDs, err := GetProviderIDs(node, key)
 	if err != nil {
-		log.Printf("Failed to get provider IDs for PROXY key: %v", err)
+		log.Printf("Failed to get provider IDs for %s key: %v", key, err)
 		return []models.Proxy{}, err
 	}
 
-	// Log the original list of provider IDs
-	log.Println("Original provider IDs:", providerIDs)
-
 	// Shuffle the list of provider IDs using a random generator
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	r.Shuffle(len(providerIDs), func(i, j int) {
 		providerIDs[i], providerIDs[j] = providerIDs[j], providerIDs[i]
 	})
 
-	// Log the shuffled list
-	log.Println("Shuffled provider IDs:", providerIDs)
+	// Prefer the proxies that served us well
+	providerIDs = reputation.Rank(db, providerIDs)
 
-	// Select up to 5 random providers
+	// Select up to maxProxyProviders providers
 	selectedProviders := providerIDs
-	if len(providerIDs) > 5 {
-		selectedProviders = providerIDs[:5]
+	if len(providerIDs) > maxProxyProviders {
+		selectedProviders = providerIDs[:maxProxyProviders]
 	}
-
-	// Log the selected providers
 	log.Println("Selected providers:", selectedProviders)
 
-	// Send proxy requests and wait for signals
+	// Every provider answers on its own stream, the answers are collected as they come
+	answers := make(chan []models.Proxy, len(selectedProviders))
+	dataMutex.Lock()
 	for _, targetPeerID := range selectedProviders {
-		// Send the proxy request
-		err := sendDataToPeer(node, targetPeerID, "", "", "proxy_request", "", "")
-		if err != nil {
-			log.Printf("Failed to send proxy request to peer %s: %v", targetPeerID, err)
-			// Continue to the next peer even if one fails
-			continue
+		proxyWaiters[targetPeerID] = answers
+	}
+	dataMutex.Unlock()
+	defer func() {
+		dataMutex.Lock()
+		for _, targetPeerID := range selectedProviders {
+			if proxyWaiters[targetPeerID] == answers {
+				delete(proxyWaiters, targetPeerID)
+			}
 		}
+		dataMutex.Unlock()
+	}()
+
+	pending := len(selectedProviders)
+	for _, targetPeerID := range selectedProviders {
+		go func(targetPeerID string) {
+			err := sendDataToPeer(node, targetPeerID, "", "", "proxy_request", "", "")
+			if err != nil {
+				log.Printf("Failed to send proxy request to peer %s: %v", targetPeerID, err)
+				dataMutex.Lock()
+				if proxyWaiters[targetPeerID] == answers {
+					delete(proxyWaiters, targetPeerID)
+					answers <- nil
+				}
+				dataMutex.Unlock()
+			}
+		}(targetPeerID)
+	}
 
-		// Wait for a signal indicating the proxy response was processed
+	result := []models.Proxy{}
+	timeout := time.After(proxyWaitTimeout)
+	for pending > 0 {
 		select {
-		case <-proxySignal:
-			log.Printf("Signal received after sending proxy request to peer %s. Proceeding to next peer.", targetPeerID)
-		case <-time.After(5 * time.Second): // Timeout after 5 seconds
-			log.Printf("Timeout waiting for response signal from peer %s. Skipping to next peer.", targetPeerID)
+		case received := <-answers:
+			pending--
+			for _, proxy := range received {
+				// Providers answer with all their offerings, only the ones asked for are kept
+				if proxyMatches(proxy, protocol, region) {
+					result = append(result, proxy)
+				}
+			}
+		case <-timeout:
+			log.Printf("%d proxy providers didn't answer in time", pending)
+			pending = 0
 		}
 	}
 
-	// Read, log, and clear the global list of proxies
-	dataMutex.Lock()
-	defer dataMutex.Unlock()
-
-	log.Printf("Returning global proxy list: %+v", proxyList)
-	result := make([]models.Proxy, len(proxyList))
-	copy(result, proxyList) // Create a copy of the proxy list to return
-	proxyList = nil         // Clear the global list
-	log.Println("Global proxy list cleared.")
+	scores, err := reputation.Scores(db, selectedProviders)
+	if err == nil {
+		sort.SliceStable(result, func(i, j int) bool { return scores[result[i].Node] > scores[result[j].Node] })
+	}
 
 	return result, nil
 }
 
+// proxyMatches tells whether an offering is of a protocol and region, empty ones match any.
+func proxyMatches(proxy models.Proxy, protocol, region string) bool {
+	offered := proxy.Protocol
+	if offered == "" {
+		offered = "socks5"
+	}
+	if protocol != "" && !strings.EqualFold(offered, protocol) {
+		return false
+	}
+	return region == "" || strings.EqualFold(strings.TrimSpace(proxy.Region), strings.TrimSpace(region))
+}
+
 func Explore(node host.Host, peerIDs []string) ([]models.JoinedHosting, error) {
 	// Iterate through the list of peer IDs
 	for _, peerID := range peerIDs {
@@ -327,39 +536,145 @@ func Explore(node host.Host, peerIDs []string) ([]models.JoinedHosting, error) {
 	return collectedHostings, nil
 }
 
-func SendProxyBillWithConfirmation(node host.Host, peerID string, proxyBill models.ProxyBill) error {
+// Answers of a client to a bill, the first line of a confirmation
+const (
+	billPaid        = "Processing successful"
+	billFailed      = "Processing failed"
+	billDisputed    = "Processing disputed"
+	billAlreadyPaid = "Already paid"
+)
+
+// billConfirmationTimeout is how long a bill waits for its confirmation. The client pays before it
+// answers, a bill that times out anyway is answered from its PaidBills when it is sent again.
+const billConfirmationTimeout = 30 * time.Second
+
+// errBillInProgress is returned for a bill that arrives again while it is being paid, it gets no
+// answer so that the proxy asks again later
+var errBillInProgress = errors.New("the bill is being paid")
+
+// billKey identifies a bill waiting for its confirmation, bill IDs are only unique per proxy
+type billKey struct {
+	peer string
+	bill int64
+}
+
+// billConfirmation is the answer of a client to one of our bills
+type billConfirmation struct {
+	status string
+	txid   string
+}
+
+// Bills waiting for their confirmation, guarded by dataMutex
+var billWaiters = map[billKey]chan billConfirmation{}
+
+// SendProxyBillWithConfirmation sends a bill and waits for the peer to pay it, then returns the
+// transaction it was paid with. A refused or disputed bill counts against the peer's reputation.
+func SendProxyBillWithConfirmation(node host.Host, db *sql.DB, peerID string, proxyBill models.ProxyBill) (string, error) {
 	// Serialize ProxyBill to JSON
 	proxyBillJSON, err := json.Marshal(proxyBill)
 	if err != nil {
 		log.Printf("Failed to serialize ProxyBill: %v", err)
-		return fmt.Errorf("failed to serialize ProxyBill: %w", err)
+		return "", fmt.Errorf("failed to serialize ProxyBill: %w", err)
 	}
 
+	// Only the confirmation of this peer for this bill settles it
+	key := billKey{peer: peerID, bill: proxyBill.ID}
+	confirmed := make(chan billConfirmation, 1)
+	dataMutex.Lock()
+	if _, waiting := billWaiters[key]; waiting {
+		dataMutex.Unlock()
+		return "", fmt.Errorf("bill %d is already waiting for the confirmation of peer %s", proxyBill.ID, peerID)
+	}
+	billWaiters[key] = confirmed
+	dataMutex.Unlock()
+	defer func() {
+		dataMutex.Lock()
+		delete(billWaiters, key)
+		dataMutex.Unlock()
+	}()
+
 	// Send the ProxyBill to the specified peer
 	log.Printf("Sending ProxyBill to peer %s", peerID)
 	err = sendDataToPeer(node, peerID, "", string(proxyBillJSON), "ProxyBill", "", "")
 	if err != nil {
 		log.Printf("Failed to send ProxyBill to peer: %v", err)
-		return fmt.Errorf("failed to send ProxyBill to peer: %w", err)
+		return "", fmt.Errorf("failed to send ProxyBill to peer: %w", err)
 	}
 
-	// Wait for confirmation signal
+	// Wait for confirmation
 	select {
-	case <-successSignal:
-		log.Println("ProxyBill processing confirmed as successful")
-		return nil // No error, successful transaction
+	case confirmation := <-confirmed:
+		switch confirmation.status {
+		case billPaid, billAlreadyPaid:
+			log.Printf("ProxyBill %d was paid with transaction %s", proxyBill.ID, confirmation.txid)
+			return confirmation.txid, nil
+
+		case billFailed:
+			log.Printf("ProxyBill %d processing confirmed as unsuccessful", proxyBill.ID)
+			if proxyBill.Amount > 0 {
+				reputation.RecordDispute(db, peerID)
+			}
+			return "", ErrBillRefused
+
+		default:
+			log.Printf("ProxyBill %d was disputed", proxyBill.ID)
+			if proxyBill.Amount > 0 {
+				reputation.RecordDispute(db, peerID)
+			}
+			return "", ErrBillDisputed
+		}
+
+	case <-time.After(billConfirmationTimeout):
+		log.Printf("Timed out waiting for the confirmation of ProxyBill %d", proxyBill.ID)
+		return "", fmt.Errorf("confirmation signal timeout")
+	}
+}
+
+// confirmBill hands the confirmation of a peer to the bill waiting for it. Details is the second
+// line of the confirmation, the bill ID and the transaction. Peers from before it only send the
+// status, which can only settle registrations. Confirmations no bill waits for are dropped.
+func confirmBill(peerID string, status string, details string) {
+	if status != billPaid && status != billFailed && status != billDisputed && status != billAlreadyPaid {
+		log.Printf("Unknown confirmation message received: %s", status)
+		return
+	}
 
-	case <-failureSignal:
-		log.Println("ProxyBill processing confirmed as unsuccessful")
-		return fmt.Errorf("proxyBill processing failed")
+	confirmation := billConfirmation{status: status}
+	var billID int64
+	fields := strings.Fields(details)
+	if len(fields) > 0 {
+		var err error
+		billID, err = strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			log.Printf("Invalid bill ID in confirmation from peer %s: %s", peerID, fields[0])
+			return
+		}
+	}
+	if len(fields) > 1 {
+		confirmation.txid = fields[1]
+	}
 
-	case <-time.After(10 * time.Second): // Timeout for waiting confirmation
-		log.Println("Timed out waiting for confirmation signal")
-		return fmt.Errorf("confirmation signal timeout")
+	dataMutex.Lock()
+	confirmed, ok := billWaiters[billKey{peer: peerID, bill: billID}]
+	dataMutex.Unlock()
+	if !ok {
+		log.Printf("Dropping confirmation of bill %d from peer %s, no bill is waiting for it", billID, peerID)
+		return
 	}
+
+	select {
+	case confirmed <- confirmation:
+	default: // The bill is already confirmed
+	}
+}
+
+// sendBillConfirmation answers a bill of a peer, naming the bill and the transaction it was paid with.
+func sendBillConfirmation(node host.Host, peerID string, status string, billID int64, txid string) error {
+	message := strings.TrimSpace(fmt.Sprintf("%s\n%d %s", status, billID, txid))
+	return sendDataToPeer(node, peerID, "", message, "confirmation", "", "")
 }
 
-func handleProxyBill(node host.Host, proxyBill models.ProxyBill, peerID string, btcwallet *rpcclient.Client, netParams *chaincfg.Params, db *sql.DB) error {
+func handleProxyBill(node host.Host, proxyBill models.ProxyBill, peerID string, remoteAddr multiaddr.Multiaddr, btcwallet *rpcclient.Client, netParams *chaincfg.Params, db *sql.DB) error {
 	log.Println("Received ProxyBill:")
 	log.Printf("IP: %s", proxyBill.IP)
 	log.Printf("Rate: %.2f", proxyBill.Rate)
@@ -367,13 +682,63 @@ func handleProxyBill(node host.Host, proxyBill models.ProxyBill, peerID string,
 	log.Printf("Amount: %.2f", proxyBill.Amount)
 	log.Printf("Wallet: %s", proxyBill.Wallet)
 
-	// Placeholder function for additional processing
-	err := processProxyBill(proxyBill, btcwallet, netParams, db)
-	if err != nil {
+	// A bill we paid already is answered with its transaction, it is never paid twice
+	if proxyBill.Amount > 0 && proxyBill.ID > 0 {
+		paid, err := operations.FindPaidBill(db, peerID, proxyBill.ID)
+		if err != nil {
+			return err
+		} else if paid != nil && paid.TxID == "" {
+			return errBillInProgress
+		} else if paid != nil {
+			log.Printf("ProxyBill %d from peer %s was paid with transaction %s", proxyBill.ID, peerID, paid.TxID)
+			return sendBillConfirmation(node, peerID, billAlreadyPaid, proxyBill.ID, paid.TxID)
+		}
+	}
+	events.Publish(events.ProxyBillReceived, ReceivedProxyBill{Peer: peerID, Bill: proxyBill})
+
+	// Registrations carry no charge, everything else is judged as a bill
+	if proxyBill.Rate != -1 {
+		accurate := billAccurate(peerID, proxyBill)
+		if !accurate {
+			log.Printf("ProxyBill from peer %s does not match its advertised rate", peerID)
+		} else if proxyBill.Amount > 0 && proxyBill.ID <= 0 {
+			// Without an ID a bill can't be told from one we paid already
+			log.Printf("ProxyBill from peer %s charges %v without a bill ID", peerID, proxyBill.Amount)
+			accurate = false
+		} else {
+			matches, err := billMatchesUsage(db, peerID, proxyBill)
+			if err != nil {
+				log.Printf("Failed to check ProxyBill against our usage: %v", err)
+				confirmErr := sendBillConfirmation(node, peerID, billFailed, proxyBill.ID, "")
+				if confirmErr != nil {
+					log.Printf("Failed to send failure confirmation to peer: %v", confirmErr)
+				}
+				return err
+			} else if !matches {
+				log.Printf("ProxyBill from peer %s claims more bytes than we sent through it", peerID)
+				accurate = false
+			}
+		}
+		reputation.RecordBill(db, peerID, accurate)
+
+		// Inaccurate bills are not paid
+		if !accurate {
+			err := sendBillConfirmation(node, peerID, billDisputed, proxyBill.ID, "")
+			if err != nil {
+				log.Printf("Failed to send dispute confirmation to peer: %v", err)
+			}
+			return fmt.Errorf("disputed ProxyBill from peer %s", peerID)
+		}
+	}
+
+	txid, err := processProxyBill(proxyBill, peerID, remoteAddr, btcwallet, netParams, db)
+	if errors.Is(err, errBillInProgress) {
+		return err
+	} else if err != nil {
 		log.Printf("Failed to process ProxyBill: %v", err)
 
 		// Send failure confirmation back to peer
-		err = sendDataToPeer(node, peerID, "", "Processing failed", "confirmation", "", "")
+		err = sendBillConfirmation(node, peerID, billFailed, proxyBill.ID, "")
 		if err != nil {
 			log.Printf("Failed to send failure confirmation to peer: %v", err)
 		}
@@ -381,7 +746,7 @@ func handleProxyBill(node host.Host, proxyBill models.ProxyBill, peerID string,
 	}
 
 	// Send success confirmation back to peer
-	err = sendDataToPeer(node, peerID, "", "Processing successful", "confirmation", "", "")
+	err = sendBillConfirmation(node, peerID, billPaid, proxyBill.ID, txid)
 	if err != nil {
 		log.Printf("Failed to send success confirmation to peer: %v", err)
 		return err
@@ -391,38 +756,147 @@ func handleProxyBill(node host.Host, proxyBill models.ProxyBill, peerID string,
 	return nil
 }
 
-func processProxyBill(proxyBill models.ProxyBill, btcwallet *rpcclient.Client, netParams *chaincfg.Params, db *sql.DB) error {
-	log.Println("Processing ProxyBill...")
+// billAccurate checks a bill against the rate the proxy advertised and the bytes it claims. Only
+// proxies we asked for their offerings can bill us, and a charge needs a rate.
+func billAccurate(peerID string, proxyBill models.ProxyBill) bool {
+	dataMutex.Lock()
+	rates, known := advertisedRates[peerID]
+	dataMutex.Unlock()
 
-	if proxyBill.Rate == -1 {
-		err := operations.AddIPtoNode(db, proxyBill.IP, proxyBill.Wallet)
-		if err != nil {
-			return err
+	if !known || (proxyBill.Amount > 0 && proxyBill.Rate <= 0) {
+		return false
+	}
+	// Bills of proxies from before offerings name none, any rate the peer advertised will do
+	if rate, ok := rates[proxyBill.Offering]; ok && proxyBill.Rate != rate {
+		return false
+	} else if !ok {
+		advertised := false
+		for _, rate := range rates {
+			advertised = advertised || proxyBill.Rate == rate
 		}
-	} else {
-		walletInfo, err := operations.GetWalletInfo(db)
-		if err != nil {
-			return err
+		if !advertised {
+			return false
 		}
+	}
+	if proxyBill.Bytes < 0 || proxyBill.Amount < 0 {
+		return false
+	}
+	// Allow for rounding, but never more than the bytes at the bill's rate
+	expected := proxyBill.Rate * float64(proxyBill.Bytes) / ProxyRateUnit
+	return proxyBill.Amount <= expected*1.01+1e-8
+}
 
-		err = btcwallet.WalletPassphrase(walletInfo.PrivPassphrase, 300)
-		if err != nil {
-			return err
-		}
+// billMatchesUsage checks the bytes of a bill against the usage we counted for the proxy over the
+// bill's period. A bill without a period can't be checked, and a proxy we never counted usage for
+// was never used through the proxy client, neither is paid.
+func billMatchesUsage(db *sql.DB, peerID string, proxyBill models.ProxyBill) (bool, error) {
+	if proxyBill.From == 0 || proxyBill.To == 0 {
+		return false, nil
+	}
+	_, counted, err := operations.SumProxyUsage(db, peerID, 0, time.Now().Unix())
+	if err != nil {
+		return false, err
+	} else if counted == 0 {
+		return false, nil
+	}
+
+	slack := int64(billSlack / time.Second)
+	used, _, err := operations.SumProxyUsage(db, peerID, proxyBill.From-slack, proxyBill.To+slack)
+	if err != nil {
+		return false, err
+	}
+	return float64(proxyBill.Bytes) <= float64(used)*(1+billTolerance)+billSlackBytes, nil
+}
+
+// processProxyBill saves the IP of a registration, or pays a bill and returns its transaction.
+// A registration maps the IP the peer's connection comes from to the peer that sent it, whatever
+// IP and node it names, so that a peer can neither register for another node nor from a LAN address.
+func processProxyBill(proxyBill models.ProxyBill, peerID string, remoteAddr multiaddr.Multiaddr, btcwallet *rpcclient.Client, netParams *chaincfg.Params, db *sql.DB) (string, error) {
+	log.Println("Processing ProxyBill...")
 
-		btcutilAddress, err := btcutil.DecodeAddress(proxyBill.Wallet, netParams)
+	if proxyBill.Rate == -1 {
+		ip, err := remoteIP(remoteAddr)
 		if err != nil {
-			return err
+			return "", fmt.Errorf("can't register peer %s: %w", peerID, err)
 		}
+		log.Printf("Registering proxy client %s from %s", peerID, ip)
+		return "", operations.AddIPtoNode(db, ip, peerID)
+	} else if proxyBill.Amount > 0 && proxyBill.Rate <= 0 {
+		return "", fmt.Errorf("ProxyBill from peer %s charges %v without a rate", peerID, proxyBill.Amount)
+	} else if proxyBill.Amount > 0 {
+		return payProxyBill(proxyBill, peerID, btcwallet, netParams, db)
+	}
+	return "", nil
+}
 
-		_, err = btcwallet.SendToAddress(btcutilAddress, btcutil.Amount(proxyBill.Amount*1e8))
-		if err != nil {
-			return err
+// remoteIP returns the IP of a peer's connection, the IP our proxy sees the peer connect from.
+// Relayed connections don't tell it.
+func remoteIP(remoteAddr multiaddr.Multiaddr) (string, error) {
+	if _, err := remoteAddr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+		return "", errors.New("the peer is connected through a relay")
+	}
+	ip, err := manet.ToIP(remoteAddr)
+	if err != nil {
+		return "", fmt.Errorf("no IP in %s: %v", remoteAddr, err)
+	}
+	return ip.String(), nil
+}
+
+// payProxyBill pays a bill and records its transaction in PaidBills. The bill is reserved there
+// before it is paid, so that the same bill arriving again in the meantime isn't paid twice.
+func payProxyBill(proxyBill models.ProxyBill, peerID string, btcwallet *rpcclient.Client, netParams *chaincfg.Params, db *sql.DB) (string, error) {
+	if proxyBill.ID <= 0 {
+		return "", fmt.Errorf("ProxyBill from peer %s has no ID", peerID)
+	}
+	reserved, err := operations.ReservePaidBill(db, models.PaidBill{
+		Peer:   peerID,
+		Bill:   proxyBill.ID,
+		Amount: proxyBill.Amount,
+		Wallet: proxyBill.Wallet,
+		Time:   time.Now().Unix(),
+	})
+	if err != nil {
+		return "", err
+	} else if !reserved {
+		return "", errBillInProgress
+	}
+
+	txid, err := sendBillPayment(proxyBill, btcwallet, netParams, db)
+	if err != nil {
+		deleteErr := operations.DeletePaidBill(db, peerID, proxyBill.ID)
+		if deleteErr != nil {
+			log.Printf("Failed to release ProxyBill %d: %v", proxyBill.ID, deleteErr)
 		}
+		return "", err
 	}
 
-	// Simulate a processing error
-	err := fmt.Errorf("simulated processing error for ProxyBill with IP: %s", proxyBill.IP)
-	log.Printf("Error: %v", err)
-	return err
+	err = operations.SetPaidBillTx(db, peerID, proxyBill.ID, txid)
+	if err != nil {
+		log.Printf("Paid ProxyBill %d with transaction %s but could not record it: %v", proxyBill.ID, txid, err)
+		return "", err
+	}
+	return txid, nil
+}
+
+func sendBillPayment(proxyBill models.ProxyBill, btcwallet *rpcclient.Client, netParams *chaincfg.Params, db *sql.DB) (string, error) {
+	walletInfo, err := operations.GetWalletInfo(db)
+	if err != nil {
+		return "", err
+	}
+
+	err = btcwallet.WalletPassphrase(walletInfo.PrivPassphrase, 300)
+	if err != nil {
+		return "", err
+	}
+
+	btcutilAddress, err := btcutil.DecodeAddress(proxyBill.Wallet, netParams)
+	if err != nil {
+		return "", err
+	}
+
+	txid, err := btcwallet.SendToAddress(btcutilAddress, btcutil.Amount(proxyBill.Amount*1e8))
+	if err != nil {
+		return "", err
+	}
+	return txid.String(), nil
 }
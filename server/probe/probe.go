@@ -0,0 +1,333 @@
+// Package probe measures the proxies offered on the network before one is chosen. Every candidate
+// is probed at the same time through its own protocol: the round trip of a request the proxy
+// answers itself, then a short download through it when a sample URL is configured. Nothing is
+// downloaded by default, so that probing doesn't send traffic to a third party through every proxy.
+// The results are cached for a while and the proxies are ranked by a score that weighs their price
+// against how well they perform.
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"server/database/models"
+	"server/events"
+	"server/p2p"
+	"server/reputation"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+const (
+	defaultProxyPort = "8000" // SOCKS5 port of the proxies, used when their IP has no port
+	maxConcurrent    = 8      // Proxies probed at the same time
+	sampleLimit      = 1 << 20
+
+	// Weights of the score, they add up to 1
+	priceWeight      = 0.35
+	throughputWeight = 0.3
+	latencyWeight    = 0.2
+	reputationWeight = 0.15
+
+	referenceLatency    = 200 * time.Millisecond // Latency scoring 0.5
+	referenceThroughput = 1 << 20                // Bytes per second scoring 0.5
+)
+
+// Config holds the probe settings
+type Config struct {
+	SampleURL string        // Downloaded through each proxy to measure its throughput, no sample when empty (the default)
+	TTL       time.Duration // How long a result is reused
+	Timeout   time.Duration // Time a proxy has for the whole probe
+}
+
+// DefaultConfig returns the settings used when no flags are given.
+func DefaultConfig() Config {
+	return Config{
+		TTL:     10 * time.Minute,
+		Timeout: 10 * time.Second,
+	}
+}
+
+// Result is what a probe measured of a proxy offering
+type Result struct {
+	Proxy      models.Proxy `json:"proxy"`
+	Latency    int64        `json:"latency"`    // Milliseconds of the round trip, 0 when it failed
+	Throughput int64        `json:"throughput"` // Bytes per second of the sample, 0 when none was taken
+	Score      float64      `json:"score"`      // Between 0 and 1, 0 for proxies that failed
+	Error      string       `json:"error,omitempty"`
+	Time       int64        `json:"time"` // When the proxy was probed
+}
+
+// Prober probes proxies and keeps the results
+type Prober struct {
+	node   host.Host
+	db     *sql.DB
+	config Config
+
+	mutex sync.Mutex
+	cache map[string]Result // By node and offering
+}
+
+func NewProber(node host.Host, db *sql.DB, config Config) *Prober {
+	return &Prober{node: node, db: db, config: config, cache: map[string]Result{}}
+}
+
+// Find finds the proxies of a protocol and region on the network, probes the ones without a
+// recent result and returns them best scored first. Refresh probes every proxy again.
+func (p *Prober) Find(protocol, region string, refresh bool) ([]Result, error) {
+	proxies, err := p2p.FindProxies(p.node, p.db, protocol, region)
+	if err != nil {
+		return nil, err
+	}
+	return p.Probe(proxies, refresh), nil
+}
+
+// Proxies is Find without the measurements, for callers that only need the order.
+func (p *Prober) Proxies(protocol, region string) ([]models.Proxy, error) {
+	results, err := p.Find(protocol, region, false)
+	if err != nil {
+		return nil, err
+	}
+	proxies := make([]models.Proxy, 0, len(results))
+	for _, result := range results {
+		proxies = append(proxies, result.Proxy)
+	}
+	return proxies, nil
+}
+
+// Cached returns the results probed within the TTL, best scored first.
+func (p *Prober) Cached() []Result {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	results := []Result{}
+	for _, result := range p.cache {
+		if time.Since(time.Unix(result.Time, 0)) < p.config.TTL {
+			results = append(results, result)
+		}
+	}
+	sortResults(results)
+	return results
+}
+
+// Probe measures the proxies, reusing the results within the TTL unless refresh is set, and
+// returns them best scored first. The scores are computed among the given proxies.
+func (p *Prober) Probe(proxies []models.Proxy, refresh bool) []Result {
+	results := make([]Result, len(proxies))
+	semaphore := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	for i, proxy := range proxies {
+		if result, ok := p.cached(proxy); ok && !refresh {
+			results[i] = result
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, proxy models.Proxy) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[i] = p.measure(proxy)
+		}(i, proxy)
+	}
+	wg.Wait()
+
+	peers := []string{}
+	for _, result := range results {
+		peers = append(peers, result.Proxy.Node)
+	}
+	standings, err := reputation.Scores(p.db, peers)
+	if err != nil {
+		log.Printf("Failed to read the reputation of the proxies: %v", err)
+	}
+	score(results, standings, p.config.SampleURL != "")
+
+	p.mutex.Lock()
+	for _, result := range results {
+		p.cache[cacheKey(result.Proxy)] = result
+	}
+	p.mutex.Unlock()
+
+	sortResults(results)
+	return results
+}
+
+func (p *Prober) cached(proxy models.Proxy) (Result, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	result, ok := p.cache[cacheKey(proxy)]
+	if !ok || result.Proxy.IP != proxy.IP || time.Since(time.Unix(result.Time, 0)) >= p.config.TTL {
+		return Result{}, false
+	}
+	result.Proxy = proxy // The rate may have changed, the measurements still hold
+	return result, true
+}
+
+func cacheKey(proxy models.Proxy) string {
+	return fmt.Sprintf("%s/%d", proxy.Node, proxy.ID)
+}
+
+// measure probes one proxy and publishes the result.
+func (p *Prober) measure(proxy models.Proxy) Result {
+	result := Result{Proxy: proxy, Time: time.Now().Unix()}
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+
+	latency, err := roundTrip(ctx, proxy)
+	if err == nil {
+		result.Latency = max(latency.Milliseconds(), 1)
+		if p.config.SampleURL != "" {
+			result.Throughput, err = sample(ctx, proxy, p.config.SampleURL)
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+		log.Printf("Probe of proxy %s at %s failed: %v", proxy.Node, proxy.IP, err)
+	}
+
+	events.Publish(events.ProxyProbe, result)
+	return result
+}
+
+// score rates the results against each other. Price counts relative to the cheapest proxy that
+// works, so that the scores don't depend on the unit of the rates. Standings are the reputation
+// scores by node, sampled tells whether the throughput was measured.
+func score(results []Result, standings map[string]float64, sampled bool) {
+	cheapest := -1.0
+	for _, result := range results {
+		if result.Error == "" && (cheapest < 0 || result.Proxy.Rate < cheapest) {
+			cheapest = result.Proxy.Rate
+		}
+	}
+
+	for i := range results {
+		result := &results[i]
+		if result.Error != "" {
+			result.Score = 0
+			continue
+		}
+
+		price := 1.0
+		if result.Proxy.Rate > 0 {
+			price = (cheapest + 1e-9) / (result.Proxy.Rate + 1e-9)
+		}
+		latency := 1 / (1 + float64(result.Latency)/float64(referenceLatency.Milliseconds()))
+		throughput := 0.5 // Unknown without a sample
+		if sampled {
+			throughput = float64(result.Throughput) / float64(result.Throughput+referenceThroughput)
+		}
+		standing, ok := standings[result.Proxy.Node]
+		if !ok {
+			standing = 0.5
+		}
+
+		result.Score = priceWeight*price + throughputWeight*throughput + latencyWeight*latency + reputationWeight*standing
+	}
+}
+
+func sortResults(results []Result) {
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+}
+
+// proxyAddress adds the default SOCKS5 port to a proxy IP without one.
+func proxyAddress(ip string) string {
+	if _, _, err := net.SplitHostPort(ip); err == nil {
+		return ip
+	}
+	return net.JoinHostPort(ip, defaultProxyPort)
+}
+
+func isHTTP(proxy models.Proxy) bool {
+	return strings.EqualFold(proxy.Protocol, "http")
+}
+
+// roundTrip times a request the proxy answers without connecting anywhere: the SOCKS5 greeting,
+// or a request without an absolute URL for an HTTP proxy.
+func roundTrip(ctx context.Context, proxy models.Proxy) (time.Duration, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddress(proxy.IP))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	start := time.Now()
+	if isHTTP(proxy) {
+		_, err = io.WriteString(conn, "OPTIONS * HTTP/1.1\r\nHost: probe\r\nConnection: close\r\n\r\n")
+		if err != nil {
+			return 0, err
+		}
+		status, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		if !strings.HasPrefix(status, "HTTP/1.") {
+			return 0, fmt.Errorf("not an HTTP proxy")
+		}
+		return time.Since(start), nil
+	}
+
+	_, err = conn.Write([]byte{5, 1, 0}) // Version 5, one method: no authentication
+	if err != nil {
+		return 0, err
+	}
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(reply, []byte{5, 0}) {
+		return 0, fmt.Errorf("not a SOCKS5 proxy")
+	}
+	return time.Since(start), nil
+}
+
+// sample downloads up to a megabyte of the sample URL through the proxy and returns the bytes per
+// second it got, from the request to the last byte.
+func sample(ctx context.Context, proxy models.Proxy, sampleURL string) (int64, error) {
+	scheme := "socks5"
+	if isHTTP(proxy) {
+		scheme = "http"
+	}
+	transport := &http.Transport{
+		Proxy:             http.ProxyURL(&url.URL{Scheme: scheme, Host: proxyAddress(proxy.IP)}),
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, sampleURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	response, err := transport.RoundTrip(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("sample download failed: %s", response.Status)
+	}
+
+	n, err := io.Copy(io.Discard, io.LimitReader(response.Body, sampleLimit))
+	if err != nil {
+		return 0, err
+	}
+	elapsed := max(time.Since(start), time.Millisecond)
+	return int64(float64(n) / elapsed.Seconds()), nil
+}
@@ -0,0 +1,96 @@
+package probe
+
+import (
+	"math"
+	"testing"
+
+	"server/database/models"
+)
+
+func result(node string, rate float64, latency, throughput int64) Result {
+	return Result{Proxy: models.Proxy{Node: node, Rate: rate}, Latency: latency, Throughput: throughput}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   []Result
+		standings map[string]float64
+		sampled   bool
+		scores    []float64
+	}{
+		{
+			// Price 1, latency, throughput and reputation 0.5 each
+			name:    "reference proxy",
+			results: []Result{result("a", 2, 200, referenceThroughput)},
+			sampled: true,
+			scores:  []float64{priceWeight + 0.5*(throughputWeight+latencyWeight+reputationWeight)},
+		},
+		{
+			name:    "throughput is unknown without a sample",
+			results: []Result{result("a", 2, 200, 0)},
+			scores:  []float64{priceWeight + 0.5*(throughputWeight+latencyWeight+reputationWeight)},
+		},
+		{
+			name:    "price counts against the cheapest",
+			results: []Result{result("a", 1, 200, 0), result("b", 4, 200, 0)},
+			scores: []float64{
+				priceWeight + 0.5*(throughputWeight+latencyWeight+reputationWeight),
+				0.25*priceWeight + 0.5*(throughputWeight+latencyWeight+reputationWeight),
+			},
+		},
+		{
+			name:    "free proxy",
+			results: []Result{result("a", 0, 200, 0), result("b", 1, 200, 0)},
+			scores: []float64{
+				priceWeight + 0.5*(throughputWeight+latencyWeight+reputationWeight),
+				0.5*(throughputWeight+latencyWeight+reputationWeight) + priceWeight*1e-9/(1+1e-9),
+			},
+		},
+		{
+			name:      "reputation",
+			results:   []Result{result("a", 1, 200, 0), result("b", 1, 200, 0)},
+			standings: map[string]float64{"a": 1, "b": 0},
+			scores: []float64{
+				priceWeight + 0.5*(throughputWeight+latencyWeight) + reputationWeight,
+				priceWeight + 0.5*(throughputWeight+latencyWeight),
+			},
+		},
+		{
+			name:    "failed proxy scores 0 and isn't the cheapest",
+			results: []Result{{Proxy: models.Proxy{Node: "a", Rate: 1}, Error: "connection refused"}, result("b", 2, 200, 0)},
+			scores:  []float64{0, priceWeight + 0.5*(throughputWeight+latencyWeight+reputationWeight)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			score(test.results, test.standings, test.sampled)
+			for i, result := range test.results {
+				if math.Abs(result.Score-test.scores[i]) > 1e-9 {
+					t.Errorf("proxy %s scores %v, want %v", result.Proxy.Node, result.Score, test.scores[i])
+				}
+			}
+		})
+	}
+}
+
+func TestScoreOrder(t *testing.T) {
+	results := []Result{
+		result("slow", 1, 800, 1<<18),
+		result("fast", 1, 50, 1<<22),
+		result("expensive", 100, 50, 1<<22),
+	}
+	score(results, nil, true)
+	sortResults(results)
+
+	order := []string{}
+	for _, result := range results {
+		order = append(order, result.Proxy.Node)
+		if result.Score <= 0 || result.Score > 1 {
+			t.Errorf("proxy %s scores %v", result.Proxy.Node, result.Score)
+		}
+	}
+	if order[0] != "fast" || order[1] != "slow" || order[2] != "expensive" {
+		t.Errorf("proxies are ranked %v", order)
+	}
+}
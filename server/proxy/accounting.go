@@ -0,0 +1,260 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"server/bandwidth"
+	"server/database/models"
+	"server/database/operations"
+)
+
+// How often the bytes of the open sessions are written to ProxyLogs. A session is also written
+// when it closes, so a crash loses at most one interval of traffic.
+const checkpointInterval = 10 * time.Second
+
+// session counts the traffic of one client connection by direction. Every checkpoint writes the
+// bytes since the previous one, the rows of a session add up to its totals.
+type session struct {
+	id       string
+	clientIP string
+	peer     string             // Node the client IP registered as, empty when it didn't
+	offering int64              // ProxyOfferings ID the client connected to
+	limiter  *bandwidth.Limiter // Bandwidth cap of the offering, nil for none
+	db       *sql.DB
+	conn     net.Conn // Closed when the client gets suspended, set under sessionsMutex
+
+	mutex    sync.Mutex
+	in       int64 // Bytes the client sent
+	out      int64 // Bytes sent to the client
+	savedIn  int64 // Part of in written to ProxyLogs
+	savedOut int64
+}
+
+// Sessions that are still open, checkpointed by runCheckpoints
+var (
+	sessionsMutex sync.Mutex
+	sessions      = map[string]*session{}
+)
+
+// startSession opens the session of a new client connection to an offering.
+func startSession(db *sql.DB, remoteAddr string, offering int64) *session {
+	clientIP := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		clientIP = host
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	s := &session{id: hex.EncodeToString(id), clientIP: clientIP, offering: offering, db: db}
+
+	peer, err := operations.FindIPtoNode(db, clientIP)
+	if err != nil {
+		log.Printf("Failed to find the node of proxy client %s: %v", clientIP, err)
+	}
+	s.peer = peer
+
+	sessionsMutex.Lock()
+	sessions[s.id] = s
+	sessionsMutex.Unlock()
+	return s
+}
+
+func (s *session) addIn(n int) {
+	s.mutex.Lock()
+	s.in += int64(n)
+	s.mutex.Unlock()
+}
+
+func (s *session) addOut(n int) {
+	s.mutex.Lock()
+	s.out += int64(n)
+	s.mutex.Unlock()
+}
+
+// totals returns the bytes the client sent and received so far.
+func (s *session) totals() (int64, int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.in, s.out
+}
+
+// checkpoint writes the bytes since the previous checkpoint. Bytes that fail to be written are
+// kept for the next one.
+func (s *session) checkpoint() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	in, out := s.in-s.savedIn, s.out-s.savedOut
+	if in == 0 && out == 0 {
+		return nil
+	}
+	err := operations.AddProxyLogs(s.db, models.ProxyLogs{
+		Session:  s.id,
+		IP:       s.clientIP,
+		Peer:     s.peer,
+		Offering: s.offering,
+		BytesIn:  in,
+		BytesOut: out,
+		Time:     time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	s.savedIn, s.savedOut = s.in, s.out
+	return nil
+}
+
+// forget removes the session from the open ones.
+func (s *session) forget() {
+	sessionsMutex.Lock()
+	delete(sessions, s.id)
+	sessionsMutex.Unlock()
+}
+
+// end writes the last bytes of the session and forgets it.
+func (s *session) end() {
+	s.forget()
+
+	in, out := s.totals()
+	log.Printf("Proxy session %s of %s closed: %d bytes in, %d bytes out", s.id, s.clientIP, in, out)
+	err := s.checkpoint()
+	if err != nil {
+		log.Printf("Failed to write proxy session %s: %v", s.id, err)
+	}
+}
+
+// runCheckpoints writes the traffic of the open sessions every checkpointInterval until ctx is done.
+func runCheckpoints(ctx context.Context) {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			checkpointSessions()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func checkpointSessions() {
+	sessionsMutex.Lock()
+	open := make([]*session, 0, len(sessions))
+	for _, s := range sessions {
+		open = append(open, s)
+	}
+	sessionsMutex.Unlock()
+
+	for _, s := range open {
+		err := s.checkpoint()
+		if err != nil {
+			log.Printf("Failed to checkpoint proxy session %s: %v", s.id, err)
+		}
+	}
+}
+
+// suspended tells whether the node of a client has overdue or disputed bills.
+func suspended(db *sql.DB, peer string) bool {
+	if peer == "" {
+		return false
+	}
+	count, err := operations.CountOverdueBills(db, peer)
+	if err != nil {
+		log.Printf("Failed to check the bills of proxy client %s: %v", peer, err)
+		return false
+	}
+	return count > 0
+}
+
+// openSessions counts the open connections of a client IP.
+func openSessions(clientIP string) int {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	count := 0
+	for _, s := range sessions {
+		if s.clientIP == clientIP {
+			count++
+		}
+	}
+	return count
+}
+
+// openCount returns the number of open sessions.
+func openCount() int {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	return len(sessions)
+}
+
+// CloseSessions closes the open connections of a client node, it returns how many there were.
+func CloseSessions(peer string) int {
+	return closeSessions(func(s *session) bool { return s.peer == peer })
+}
+
+// closeAllSessions closes every open connection, it returns how many there were.
+func closeAllSessions() int {
+	return closeSessions(func(s *session) bool { return true })
+}
+
+func closeSessions(match func(s *session) bool) int {
+	sessionsMutex.Lock()
+	conns := []net.Conn{}
+	for _, s := range sessions {
+		if match(s) && s.conn != nil {
+			conns = append(conns, s.conn)
+		}
+	}
+	sessionsMutex.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return len(conns)
+}
+
+// accountingListener wraps every client connection in a trafficInterceptor with its own session,
+// clients with unpaid bills or too many connections are refused
+type accountingListener struct {
+	net.Listener
+	db       *sql.DB
+	offering int64
+	limiter  *bandwidth.Limiter
+}
+
+func (l *accountingListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		s := startSession(l.db, conn.RemoteAddr().String(), l.offering)
+		s.limiter = l.limiter
+		if suspended(l.db, s.peer) {
+			log.Printf("Refusing proxy client %s, %s has unpaid bills", s.clientIP, s.peer)
+			s.forget()
+			conn.Close()
+			continue
+		}
+		if limit := currentRules().rules.MaxConnections; limit > 0 && openSessions(s.clientIP) > limit {
+			deny(l.db, s.clientIP, "", fmt.Sprintf("more than %d connections", limit))
+			s.forget()
+			conn.Close()
+			continue
+		}
+
+		intercepted := newTrafficInterceptor(conn, s)
+		sessionsMutex.Lock()
+		s.conn = intercepted
+		sessionsMutex.Unlock()
+		return intercepted, nil
+	}
+}
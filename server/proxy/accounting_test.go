@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"database/sql"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"server/database"
+	"server/database/models"
+	"server/database/operations"
+)
+
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := database.SetupDatabase(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = database.CreateNewTables(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// pipe returns the client end of a net.Pipe and the proxy end wrapped in a trafficInterceptor.
+func pipe(t *testing.T, db *sql.DB) (net.Conn, *trafficInterceptor) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	return client, newTrafficInterceptor(server, startSession(db, server.RemoteAddr().String(), 0))
+}
+
+func proxyLogs(t *testing.T, db *sql.DB) []models.ProxyLogs {
+	t.Helper()
+	logs, err := operations.GetProxyLogs(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logs
+}
+
+func TestTrafficInterceptorCountsByDirection(t *testing.T) {
+	db := testDB(t)
+	err := operations.AddIPtoNode(db, "pipe", "client-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, intercepted := pipe(t, db)
+
+	go client.Write(make([]byte, 100))
+	_, err = io.ReadFull(intercepted, make([]byte, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go io.ReadFull(client, make([]byte, 30))
+	_, err = intercepted.Write(make([]byte, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if in, out := intercepted.GetBytesReceived(), intercepted.GetBytesSent(); in != 100 || out != 30 {
+		t.Fatalf("counted %d in and %d out, want 100 and 30", in, out)
+	}
+
+	intercepted.Close()
+	intercepted.Close() // The session is only written once
+	logs := proxyLogs(t, db)
+	if len(logs) != 1 {
+		t.Fatalf("got %d ProxyLogs rows, want 1", len(logs))
+	}
+	got := logs[0]
+	if got.BytesIn != 100 || got.BytesOut != 30 || got.Bytes != 130 || got.Peer != "client-node" || got.Session == "" {
+		t.Fatalf("unexpected ProxyLogs row %+v", got)
+	}
+}
+
+// eofConn returns its last bytes together with io.EOF, as net.Conn implementations may
+type eofConn struct {
+	net.Conn
+	data []byte
+}
+
+func (c *eofConn) Read(b []byte) (int, error) {
+	n := copy(b, c.data)
+	c.data = c.data[n:]
+	if len(c.data) == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestTrafficInterceptorCountsShortFinalRead(t *testing.T) {
+	db := testDB(t)
+	_, server := net.Pipe()
+	conn := &eofConn{Conn: server, data: make([]byte, 10)}
+	intercepted := newTrafficInterceptor(conn, startSession(db, "127.0.0.1:4000", 0))
+
+	n, err := intercepted.Read(make([]byte, 64))
+	if n != 10 || err != io.EOF {
+		t.Fatalf("read %d, %v", n, err)
+	}
+	if in := intercepted.GetBytesReceived(); in != 10 {
+		t.Fatalf("counted %d bytes in, want 10", in)
+	}
+	intercepted.Close()
+
+	logs := proxyLogs(t, db)
+	if len(logs) != 1 || logs[0].IP != "127.0.0.1" || logs[0].BytesIn != 10 {
+		t.Fatalf("unexpected ProxyLogs %+v", logs)
+	}
+}
+
+func TestCheckpointsWriteLongSessions(t *testing.T) {
+	db := testDB(t)
+	client, intercepted := pipe(t, db)
+	_, idle := pipe(t, db)
+
+	transfer := func(n int) {
+		go client.Write(make([]byte, n))
+		_, err := io.ReadFull(intercepted, make([]byte, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	transfer(40)
+	checkpointSessions()
+	transfer(2)
+	checkpointSessions()
+	checkpointSessions() // Nothing new, nothing written
+	transfer(5)
+	intercepted.Close()
+	idle.Close()
+
+	logs := proxyLogs(t, db)
+	want := []int64{40, 2, 5}
+	if len(logs) != len(want) {
+		t.Fatalf("got %d ProxyLogs rows, want %d: %+v", len(logs), len(want), logs)
+	}
+	for i, record := range logs {
+		if record.BytesIn != want[i] || record.Session != logs[0].Session {
+			t.Fatalf("row %d is %+v, want %d bytes in session %s", i, record, want[i], logs[0].Session)
+		}
+	}
+
+	sessionsMutex.Lock()
+	open := len(sessions)
+	sessionsMutex.Unlock()
+	if open != 0 {
+		t.Fatalf("%d sessions still open", open)
+	}
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+)
+
+// Config holds the addresses offerings listen on when they don't name one
+type Config struct {
+	SocksAddr string `json:"socksAddr"` // SOCKS5 proxy
+	HTTPAddr  string `json:"httpAddr"`  // HTTP forward proxy with CONNECT for HTTPS, none when empty
+}
+
+// DefaultConfig returns the settings used when no flags are given.
+func DefaultConfig() Config {
+	return Config{
+		SocksAddr: "0.0.0.0:8000",
+		HTTPAddr:  "0.0.0.0:8001",
+	}
+}
+
+// Settings of the running proxy, set by Proxy
+var (
+	settingsMutex sync.RWMutex
+	settings      Config
+)
+
+// Settings returns the default addresses of the offerings.
+func Settings() Config {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return settings
+}
+
+// httpProxy forwards plain HTTP requests and tunnels CONNECT requests, so that browsers and tools
+// that only speak HTTP proxies can use the node. The client connections come from an
+// accountingListener, the traffic is counted and limited per client like the SOCKS5 traffic, and
+// the destinations are checked against the same rules.
+type httpProxy struct {
+	forward *httputil.ReverseProxy
+	dial    func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func newHTTPProxy(db *sql.DB) *httpProxy {
+	dial := allowedDial(db)
+	transport := &http.Transport{
+		DialContext:           dial,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+	}
+
+	return &httpProxy{forward: &httputil.ReverseProxy{
+		// The request already holds the absolute URL, nothing is added about the client
+		Rewrite:   func(r *httputil.ProxyRequest) {},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var denied *deniedError
+			if errors.As(err, &denied) {
+				http.Error(w, denied.Error(), http.StatusForbidden)
+				return
+			}
+			log.Printf("HTTP proxy request to %s failed: %v", r.URL.Host, err)
+			http.Error(w, "proxy request failed", http.StatusBadGateway)
+		},
+	}, dial: dial}
+}
+
+func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("HTTP proxy client IP: %s", r.RemoteAddr)
+	if clientIP, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		r = r.WithContext(context.WithValue(r.Context(), clientKey{}, clientIP))
+	}
+
+	if r.Method == http.MethodConnect {
+		p.tunnel(r.Context(), w, r)
+		return
+	}
+	if !r.URL.IsAbs() || (r.URL.Scheme != "http" && r.URL.Scheme != "https") {
+		http.Error(w, "this is a proxy, requests need an absolute http URL", http.StatusBadRequest)
+		return
+	}
+	r.Header.Del("Proxy-Authorization")
+	r.Header.Del("Proxy-Connection")
+	p.forward.ServeHTTP(w, r)
+}
+
+// tunnel connects the client to the host of a CONNECT request and copies the bytes both ways.
+func (p *httpProxy) tunnel(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, _, err := net.SplitHostPort(r.Host); err != nil {
+		http.Error(w, "CONNECT needs a host and port", http.StatusBadRequest)
+		return
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	upstream, err := p.dial(dialCtx, "tcp", r.Host)
+	var denied *deniedError
+	if errors.As(err, &denied) {
+		http.Error(w, denied.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Printf("HTTP proxy failed to connect to %s: %v", r.Host, err)
+		http.Error(w, "failed to connect to "+r.Host, http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "tunneling is not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("HTTP proxy failed to take over the connection: %v", err)
+		return
+	}
+	defer client.Close()
+
+	_, err = io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+	if err != nil {
+		return
+	}
+
+	// Whatever the client sent after the CONNECT may be buffered already
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, buffered)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// closeWrite tells the other side that nothing more is sent, the connection is closed if it can't.
+func closeWrite(conn net.Conn) {
+	if interceptor, ok := conn.(*trafficInterceptor); ok {
+		conn = interceptor.conn
+	}
+	if tcp, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcp.CloseWrite()
+		return
+	}
+	conn.Close()
+}
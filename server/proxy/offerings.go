@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"server/bandwidth"
+	"server/database/models"
+	"server/database/operations"
+
+	"github.com/armon/go-socks5"
+)
+
+// Protocols a proxy can be offered with
+const (
+	ProtocolSOCKS5 = "socks5"
+	ProtocolHTTP   = "http"
+)
+
+// offeringListener serves one offering on its listen address
+type offeringListener struct {
+	offering models.Proxy
+	listen   string
+	listener net.Listener
+	limiter  *bandwidth.Limiter // Bandwidth cap shared by the clients of the offering
+}
+
+// Listeners of the offerings, by offering ID, kept in line with ProxyOfferings by ApplyOfferings
+var (
+	listenersMutex sync.Mutex
+	listeners      = map[int64]*offeringListener{}
+)
+
+// ListenAddress returns the local address an offering is served on.
+func ListenAddress(offering models.Proxy) string {
+	if offering.Listen != "" {
+		return offering.Listen
+	}
+	if offering.Protocol == ProtocolHTTP {
+		return Settings().HTTPAddr
+	}
+	return Settings().SocksAddr
+}
+
+// ValidateOffering checks an offering before it is saved and fills in what can be derived: the
+// protocol defaults to SOCKS5 and an IP without a port gets the port of the listen address.
+// Offerings are the other offerings of the node, two of them can't listen on the same address.
+func ValidateOffering(offering *models.Proxy, offerings []models.Proxy) error {
+	offering.Protocol = strings.ToLower(strings.TrimSpace(offering.Protocol))
+	if offering.Protocol == "" {
+		offering.Protocol = ProtocolSOCKS5
+	}
+	offering.Region = strings.TrimSpace(offering.Region)
+
+	switch {
+	case offering.Protocol != ProtocolSOCKS5 && offering.Protocol != ProtocolHTTP:
+		return fmt.Errorf("protocol must be %s or %s", ProtocolSOCKS5, ProtocolHTTP)
+	case offering.IP == "":
+		return fmt.Errorf("ip is required")
+	case offering.Rate < 0:
+		return fmt.Errorf("rate can't be negative")
+	case offering.Bandwidth < 0:
+		return fmt.Errorf("bandwidth can't be negative")
+	case strings.ContainsAny(offering.Region, "/ "):
+		return fmt.Errorf("region can't contain spaces or slashes")
+	}
+
+	listen := ListenAddress(*offering)
+	if listen == "" {
+		return fmt.Errorf("listen is required, the %s proxy has no default address", offering.Protocol)
+	}
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q", listen)
+	}
+	if _, _, err := net.SplitHostPort(offering.IP); err != nil {
+		offering.IP = net.JoinHostPort(strings.Trim(offering.IP, "[]"), port)
+	}
+
+	for _, other := range offerings {
+		if other.ID != offering.ID && ListenAddress(other) == listen {
+			return fmt.Errorf("offering %d already listens on %s", other.ID, listen)
+		}
+	}
+	return nil
+}
+
+// ApplyOfferings starts a listener for every offering in ProxyOfferings and closes the listeners
+// of the offerings that were deleted. Listeners whose address and protocol didn't change keep
+// their clients, only their bandwidth cap is updated.
+func ApplyOfferings(db *sql.DB) error {
+	offerings, err := operations.GetProxyOfferings(db)
+	if err != nil {
+		return err
+	}
+
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+
+	// Deleted offerings free their addresses first, another offering may take them over
+	wanted := map[int64]bool{}
+	for _, offering := range offerings {
+		wanted[offering.ID] = true
+	}
+	for id, l := range listeners {
+		if !wanted[id] {
+			l.listener.Close()
+			delete(listeners, id)
+		}
+	}
+
+	errs := []error{}
+	for _, offering := range offerings {
+		listen := ListenAddress(offering)
+		limits := models.UploadLimits{GlobalRate: offering.Bandwidth}
+
+		current, ok := listeners[offering.ID]
+		if ok && current.listen == listen && current.offering.Protocol == offering.Protocol {
+			current.offering = offering
+			current.limiter.SetLimits(limits)
+			continue
+		}
+		if ok {
+			current.listener.Close()
+			delete(listeners, offering.ID)
+		}
+
+		listener, err := net.Listen("tcp", listen)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to listen on %s for proxy offering %d: %v", listen, offering.ID, err))
+			continue
+		}
+		l := &offeringListener{offering: offering, listen: listen, listener: listener, limiter: bandwidth.New(limits)}
+		listeners[offering.ID] = l
+		go l.serve(db)
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeListeners closes the listeners of every offering, the open sessions go on.
+func closeListeners() {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+	for id, l := range listeners {
+		l.listener.Close()
+		delete(listeners, id)
+	}
+}
+
+// serve runs the proxy of the offering until its listener is closed, every client connection is
+// counted in its own session.
+func (l *offeringListener) serve(db *sql.DB) {
+	accounting := &accountingListener{Listener: l.listener, db: db, offering: l.offering.ID, limiter: l.limiter}
+
+	var err error
+	switch l.offering.Protocol {
+	case ProtocolHTTP:
+		fmt.Printf("HTTP proxy offering %d is running on %s.\n", l.offering.ID, l.listen)
+		server := &http.Server{
+			Handler:           newHTTPProxy(db),
+			ReadHeaderTimeout: 30 * time.Second,
+		}
+		err = server.Serve(accounting)
+	default:
+		fmt.Printf("SOCKS5 proxy offering %d is running on %s.\n", l.offering.ID, l.listen)
+		var server *socks5.Server
+		server, err = socks5.New(&socks5.Config{Dial: customDial, Rules: &clientAddressRuleset{db: db}})
+		if err == nil {
+			err = server.Serve(accounting)
+		}
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Proxy offering %d stopped: %v", l.offering.ID, err)
+	}
+}
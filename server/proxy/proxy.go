@@ -1,7 +1,7 @@
 /*
-This is a SOCKS proxy using go. It logs the total number of ingoing and outgoing bytes
-for each user (1 user = 1 IP address) and every 5 minutes this information is logged to
-a txt file in the format [IP, bytes]\n[IP, bytes]\n[IP, bytes]
+This is a SOCKS proxy using go. It counts the bytes every client connection sends and receives,
+and writes them to the ProxyLogs table every few seconds and when the connection closes, with
+the session of the connection and the node of the client IP.
 */
 
 package proxy
@@ -10,61 +10,68 @@ package proxy
 import (
 	"context" // For managing context-based data
 	"database/sql" // For SQL database interaction
-	"fmt" // For formatted output
 	"log" // For logging
 	"net" // For network-related functionality
-	"strings" // For string manipulation
+	"strconv" // For formatting the destination port
 	"sync" // For managing concurrent access to shared resources
 	"time" // For time-related operations
 
-	"server/database/operations" // Custom package for database operations
+	"server/bandwidth" // Upload limits shared with file transfers
 
 	"github.com/armon/go-socks5" // Go package to implement a SOCKS5 proxy server
-	"github.com/libp2p/go-libp2p/core/host" // Libp2p package for network host operations
 )
 
-var paymentInformation = make(map[string]int64) // Map to store payment data by IP address (in bytes)
-var mutex sync.Mutex // Mutex to ensure thread-safe access to paymentInformation map
-
-// Define the trafficInterceptor struct to intercept network traffic for each connection
+// Define the trafficInterceptor struct to count the traffic of a client connection
 type trafficInterceptor struct {
-	conn     net.Conn // Underlying network connection
-	clientIP string // Client IP address
-	read     int64 // Total bytes received by the client
-	written  int64 // Total bytes sent by the client
+	conn    net.Conn // Underlying client connection
+	session *session // Session the bytes are counted in
+	once    sync.Once // Ends the session only once
+}
+
+func newTrafficInterceptor(conn net.Conn, session *session) *trafficInterceptor {
+	return &trafficInterceptor{conn: conn, session: session}
 }
 
-// Read method to intercept incoming traffic and log bytes received
+// Read method to intercept the bytes sent by the client
 func (t *trafficInterceptor) Read(b []byte) (n int, err error) {
 	n, err = t.conn.Read(b) // Read data from the underlying connection
-	if err == nil {
-		t.read += int64(n) // Track the number of bytes read
+	if n > 0 {
+		t.session.addIn(n) // A read can return bytes with an error, they count too
+		t.wait(n) // What was read is sent on upstream, within the upload limits
 	}
 	return
 }
 
-// Write method to intercept outgoing traffic and log bytes sent
+// Write method to intercept the bytes sent to the client
 func (t *trafficInterceptor) Write(b []byte) (n int, err error) {
+	t.wait(len(b)) // Wait for the upload limits before sending
 	n, err = t.conn.Write(b) // Write data to the underlying connection
-	if err == nil {
-		t.written += int64(n) // Track the number of bytes written
+	if n > 0 {
+		t.session.addOut(n) // A short write counts what was written
 	}
 	return
 }
 
-// Close method to log final data transfer statistics and update payment info
-func (t *trafficInterceptor) Close() error {
-	log.Printf("Final bytes received: %d", t.read) // Log bytes received
-	log.Printf("Final bytes sent: %d", t.written) // Log bytes sent
-	log.Printf("IP Of the bytes above: %s", t.clientIP) // Log the client IP address
+// limiterKey returns the key of the client in the upload limiter, proxy clients are limited per IP
+func (t *trafficInterceptor) limiterKey() string {
+	return "proxy " + t.session.clientIP
+}
 
-	// Update the payment information for the client (client IP and total bytes)
-	updatePaymentInfo(strings.Split(t.clientIP, ":")[0], t.read+t.written)
+// wait blocks until n bytes fit in the upload limits and in the bandwidth cap of the offering
+func (t *trafficInterceptor) wait(n int) {
+	bandwidth.Default.Wait(t.limiterKey(), n)
+	if t.session.limiter != nil {
+		t.session.limiter.Wait(t.limiterKey(), n)
+	}
+}
 
+// Close method to write the last bytes of the session and close the connection
+func (t *trafficInterceptor) Close() error {
+	t.once.Do(t.session.end)
 	return t.conn.Close() // Close the underlying network connection
 }
 
-// LocalAddr returns the local address of the connection (unused in this example)
+// LocalAddr returns the local address of the connection
 func (t *trafficInterceptor) LocalAddr() net.Addr {
 	return t.conn.LocalAddr()
 }
@@ -74,7 +81,7 @@ func (t *trafficInterceptor) RemoteAddr() net.Addr {
 	return t.conn.RemoteAddr()
 }
 
-// SetDeadline sets the read/write deadline for the connection (unused in this example)
+// SetDeadline sets the read/write deadline for the connection
 func (t *trafficInterceptor) SetDeadline(deadline time.Time) error {
 	return t.conn.SetDeadline(deadline)
 }
@@ -89,94 +96,108 @@ func (t *trafficInterceptor) SetWriteDeadline(deadline time.Time) error {
 	return t.conn.SetWriteDeadline(deadline)
 }
 
-// GetBytesSent returns the total bytes sent by the client
+// GetBytesSent returns the total bytes sent to the client
 func (t *trafficInterceptor) GetBytesSent() int64 {
-	return t.written
+	_, out := t.session.totals()
+	return out
 }
 
-// GetBytesReceived returns the total bytes received by the client
+// GetBytesReceived returns the total bytes received from the client
 func (t *trafficInterceptor) GetBytesReceived() int64 {
-	return t.read
+	in, _ := t.session.totals()
+	return in
 }
 
-// Define clientAddressRuleset to handle client address-specific rules for SOCKS5 proxy
+// Define clientAddressRuleset to check the destinations of the SOCKS5 clients against the proxy rules
 type clientAddressRuleset struct {
-	socks5.RuleSet
-}
-
-// Function to update payment information for the client
-func updatePaymentInfo(key string, value int64) {
-	mutex.Lock() // Lock the mutex to ensure safe access to the shared resource
-	defer mutex.Unlock()
-
-	log.Printf("Key: %s value: %d\n", key, value)
-
-	// If the client IP already exists in the map, add the new bytes to the existing total
-	if currentBytes, exists := paymentInformation[key]; exists {
-		paymentInformation[key] = currentBytes + value
-	} else {
-		paymentInformation[key] = value // If not, create a new entry with the given bytes
-	}
+	db *sql.DB // Denied requests are written to ProxyDenials
 }
 
-// Allow function for handling connection requests for the SOCKS5 proxy
+// Allow function for handling connection requests for the SOCKS5 proxy, names are resolved before
 func (r *clientAddressRuleset) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	clientIP := ""
 	if req.RemoteAddr != nil {
-		clientIP := req.RemoteAddr.String() // Get the client's IP address
-		log.Printf("Client IP: %s", clientIP) // Log the client IP address
-		return context.WithValue(ctx, "clientIP", clientIP), true // Add the client IP to context and allow the connection
+		clientIP = req.RemoteAddr.IP.String() // Get the client's IP address
+		log.Printf("Client IP: %s", req.RemoteAddr.String()) // Log the client address
+		ctx = context.WithValue(ctx, "clientIP", req.RemoteAddr.String()) // Add the client address to context
 	}
 
-	return ctx, true // Allow the connection if no IP address is found
+	dest := req.DestAddr
+	host := dest.FQDN // The name the client asked for, or the address when it asked for one
+	if host == "" {
+		host = dest.IP.String()
+	}
+	destination := net.JoinHostPort(host, strconv.Itoa(dest.Port))
+
+	reason := "destination has no address" // The address that is dialed is the one checked
+	if dest.IP != nil {
+		reason = currentRules().check(dest.FQDN, dest.IP, dest.Port)
+	}
+	if reason != "" {
+		deny(r.db, clientIP, destination, reason) // Log and record the denied request
+		return ctx, false
+	}
+	return ctx, true // Allow the connection
 }
 
-// Custom dial function to intercept traffic and wrap the connection
+// Custom dial function to connect to the destinations, the traffic is counted on the client side
 func customDial(ctx context.Context, network, addr string) (net.Conn, error) {
-	conn, err := net.Dial(network, addr) // Dial the network connection
-	if err != nil {
-		return nil, err // Return an error if unable to dial
-	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, addr) // Dial the network connection
+}
 
-	clientIP, _ := ctx.Value("clientIP").(string) // Retrieve the client IP from context
+// Service serves the proxy offerings of the node, each on its own listener, and writes the traffic of their sessions
+type Service struct {
+	db     *sql.DB
+	config Config
+	cancel context.CancelFunc // Stops the checkpoints
+	done   chan struct{}      // Closed once the checkpoints stopped
+}
 
-	// Return a wrapped connection with the trafficInterceptor to monitor traffic
-	return &trafficInterceptor{conn: conn, clientIP: clientIP}, nil
+func NewService(db *sql.DB, config Config) *Service {
+	return &Service{db: db, config: config}
 }
 
-// Main Proxy function that sets up and runs the SOCKS5 proxy server
-func Proxy(node host.Host, db *sql.DB) {
-	dial := customDial // Define the custom dial function to intercept traffic
-	conf := &socks5.Config{Dial: dial, Rules: &clientAddressRuleset{}} // Set up SOCKS5 config with custom dial and rules
-	server, err := socks5.New(conf) // Create a new SOCKS5 server
-	if err != nil {
-		panic(err) // Panic if server creation fails
+// Start listens for the offerings and starts the checkpoints
+func (s *Service) Start(_ context.Context) error {
+	settingsMutex.Lock()
+	settings = s.config // Remember the default addresses of the offerings
+	settingsMutex.Unlock()
+
+	// An address that can't be listened on doesn't stop the others, the offering can be changed over the API
+	if err := ApplyOfferings(s.db); err != nil {
+		log.Printf("Failed to start proxy offerings: %v", err)
 	}
 
-	// Start a goroutine that logs traffic information to the database every 30 seconds
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
 	go func() {
-		ticker := time.NewTicker(30 * time.Second) // Set up ticker to run every 30 seconds
-		for range ticker.C {
-			mutex.Lock() // Lock the mutex to safely access the shared resource
-
-			// Loop through the payment information map and log to the database
-			for key, value := range paymentInformation {
-				log.Printf("%s : %d", key, value) // Log the IP and its transferred data
-				operations.AddProxyLogs(db, key, value, time.Now().Unix()) // Insert data into the database
-			}
-
-			// Clear the map after logging
-			for key := range paymentInformation {
-				delete(paymentInformation, key)
-			}
-
-			mutex.Unlock() // Unlock the mutex
-		}
+		defer close(s.done)
+		runCheckpoints(ctx) // Write the traffic of the open sessions to the database
 	}()
-
-	fmt.Println("Proxy is running on http://localhost:8000.") // Log message indicating the proxy is running
-
-	// Start the SOCKS5 proxy server on localhost port 8000
-	if err := server.ListenAndServe("tcp", "0.0.0.0:8000"); err != nil {
-		panic(err) // Panic if the server fails to start
+	return nil
+}
+
+// Stop closes the listeners and lets the open sessions finish until ctx is done, the sessions left are
+// closed then. Every session writes its last bytes to ProxyLogs when it closes.
+func (s *Service) Stop(ctx context.Context) error {
+	closeListeners()
+
+	ticker := time.NewTicker(100 * time.Millisecond) // How often the open sessions are counted
+	defer ticker.Stop()
+drain:
+	for openCount() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Printf("Closing %d proxy sessions that are still open", closeAllSessions())
+			break drain
+		}
 	}
+
+	s.cancel()
+	<-s.done
+	checkpointSessions() // Sessions still closing write what they counted so far
+	return nil
 }
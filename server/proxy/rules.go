@@ -0,0 +1,306 @@
+package proxy
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"server/database/models"
+	"server/database/operations"
+)
+
+// DefaultRules returns the rules used until the saved ones are applied: web traffic only, 64
+// connections per client and nothing on the local network.
+func DefaultRules() models.ProxyRules {
+	return models.ProxyRules{
+		AllowCIDRs:     []string{},
+		DenyCIDRs:      []string{},
+		AllowDomains:   []string{},
+		DenyDomains:    []string{},
+		AllowPorts:     []string{"80", "443"},
+		MaxConnections: 64,
+		BlockPrivate:   true,
+	}
+}
+
+// Addresses refused with BlockPrivate that the net.IP methods don't cover
+var extraPrivateNets = mustParseCIDRs("100.64.0.0/10", "198.18.0.0/15")
+
+// ruleset holds the rules in the form they are checked in
+type ruleset struct {
+	rules        models.ProxyRules
+	allowNets    []*net.IPNet
+	denyNets     []*net.IPNet
+	allowDomains []string
+	denyDomains  []string
+	ports        [][2]int // Ranges of allowed ports, empty for every port
+}
+
+// Rules the proxy checks, replaced by SetRules
+var (
+	rulesMutex  sync.RWMutex
+	activeRules = mustCompileRules(DefaultRules())
+)
+
+// ValidateRules checks rules before they are saved.
+func ValidateRules(rules models.ProxyRules) error {
+	_, err := compileRules(rules)
+	return err
+}
+
+// SetRules replaces the rules, they apply to the next connection.
+func SetRules(rules models.ProxyRules) error {
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return err
+	}
+
+	rulesMutex.Lock()
+	activeRules = compiled
+	rulesMutex.Unlock()
+	return nil
+}
+
+// Rules returns the rules in effect.
+func Rules() models.ProxyRules {
+	return currentRules().rules
+}
+
+func currentRules() *ruleset {
+	rulesMutex.RLock()
+	defer rulesMutex.RUnlock()
+	return activeRules
+}
+
+func compileRules(rules models.ProxyRules) (*ruleset, error) {
+	if rules.MaxConnections < 0 {
+		return nil, fmt.Errorf("maxConnections can't be negative")
+	}
+
+	r := &ruleset{rules: rules}
+	var err error
+	r.allowNets, err = parseCIDRs(rules.AllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	r.denyNets, err = parseCIDRs(rules.DenyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	r.allowDomains, err = parseDomains(rules.AllowDomains)
+	if err != nil {
+		return nil, err
+	}
+	r.denyDomains, err = parseDomains(rules.DenyDomains)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range rules.AllowPorts {
+		low, high, found := strings.Cut(entry, "-")
+		if !found {
+			high = low
+		}
+		from, err1 := strconv.Atoi(strings.TrimSpace(low))
+		to, err2 := strconv.Atoi(strings.TrimSpace(high))
+		if err1 != nil || err2 != nil || from < 1 || to > 65535 || from > to {
+			return nil, fmt.Errorf("invalid port or port range %q", entry)
+		}
+		r.ports = append(r.ports, [2]int{from, to})
+	}
+	return r, nil
+}
+
+func mustCompileRules(rules models.ProxyRules) *ruleset {
+	r, err := compileRules(rules)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// parseCIDRs parses networks, a single address is a network of its own.
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR %q", entry)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+func mustParseCIDRs(entries ...string) []*net.IPNet {
+	nets, err := parseCIDRs(entries)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+// parseDomains lowercases the domains, *.example.com and .example.com mean example.com.
+func parseDomains(entries []string) ([]string, error) {
+	domains := []string{}
+	for _, entry := range entries {
+		domain := strings.ToLower(strings.TrimSpace(entry))
+		domain = strings.TrimPrefix(strings.TrimPrefix(domain, "*"), ".")
+		domain = strings.TrimSuffix(domain, ".")
+		if domain == "" || strings.ContainsAny(domain, " /:*") {
+			return nil, fmt.Errorf("invalid domain %q", entry)
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+func matchDomain(domains []string, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchNets(nets []*net.IPNet, ip net.IP) bool {
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// private tells whether an address is on the loopback, a local network or otherwise not public.
+func private(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || matchNets(extraPrivateNets, ip)
+}
+
+// checkDomain checks what is known of a destination before its name is resolved. It returns why
+// the destination is denied, or an empty string.
+func (r *ruleset) checkDomain(domain string, port int) string {
+	if len(r.ports) > 0 {
+		allowed := false
+		for _, ports := range r.ports {
+			allowed = allowed || (port >= ports[0] && port <= ports[1])
+		}
+		if !allowed {
+			return fmt.Sprintf("port %d is not allowed", port)
+		}
+	}
+	if domain != "" && matchDomain(r.denyDomains, domain) {
+		return "domain is denied"
+	}
+	return ""
+}
+
+// check checks a destination with the address its name resolved to, domain is empty when the
+// client asked for the address. It returns why the destination is denied, or an empty string.
+func (r *ruleset) check(domain string, ip net.IP, port int) string {
+	if reason := r.checkDomain(domain, port); reason != "" {
+		return reason
+	}
+	if r.rules.BlockPrivate && private(ip) {
+		return "private or loopback address"
+	}
+	if matchNets(r.denyNets, ip) {
+		return "address is denied"
+	}
+	if len(r.allowNets) > 0 || len(r.allowDomains) > 0 {
+		if !matchNets(r.allowNets, ip) && !(domain != "" && matchDomain(r.allowDomains, domain)) {
+			return "destination is not allowed"
+		}
+	}
+	return ""
+}
+
+// deniedError is returned when the rules refuse a destination
+type deniedError struct {
+	reason string
+}
+
+func (e *deniedError) Error() string {
+	return "denied by the proxy rules: " + e.reason
+}
+
+// deny logs a connection the proxy refused and writes it to ProxyDenials.
+func deny(db *sql.DB, clientIP, destination, reason string) {
+	log.Printf("Proxy denied %s to %s: %s", clientIP, destination, reason)
+	err := operations.AddProxyDenial(db, models.ProxyDenials{
+		IP:          clientIP,
+		Destination: destination,
+		Reason:      reason,
+		Time:        time.Now().Unix(),
+	})
+	if err != nil {
+		log.Printf("Failed to write proxy denial: %v", err)
+	}
+}
+
+// clientKey is the context key of the client IP of an HTTP proxy request
+type clientKey struct{}
+
+// allowedDial connects to addr when the rules allow it. Names are resolved here and the address
+// that is dialed is the one that was checked, so a name can't lead to a denied address.
+func allowedDial(db *sql.DB) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		clientIP, _ := ctx.Value(clientKey{}).(string)
+		host, portText, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.Atoi(portText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in %s", addr)
+		}
+
+		rules := currentRules()
+		domain := ""
+		ips := []net.IP{}
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			domain = host
+			if reason := rules.checkDomain(domain, port); reason != "" {
+				deny(db, clientIP, addr, reason)
+				return nil, &deniedError{reason}
+			}
+			addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			for _, address := range addresses {
+				ips = append(ips, address.IP)
+			}
+		}
+
+		reason := "no address found"
+		for _, ip := range ips {
+			reason = rules.check(domain, ip, port)
+			if reason == "" {
+				return customDial(ctx, network, net.JoinHostPort(ip.String(), portText))
+			}
+		}
+		deny(db, clientIP, addr, reason)
+		return nil, &deniedError{reason}
+	}
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"server/database/models"
+	"server/database/operations"
+)
+
+func TestCompileRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules models.ProxyRules
+		valid bool
+	}{
+		{"defaults", DefaultRules(), true},
+		{"empty", models.ProxyRules{}, true},
+		{"addresses and networks", models.ProxyRules{AllowCIDRs: []string{"203.0.113.7", "2001:db8::/32"}, DenyCIDRs: []string{" 10.0.0.0/8 "}}, true},
+		{"wildcard domains", models.ProxyRules{AllowDomains: []string{"*.Example.com", ".example.org", "example.net."}}, true},
+		{"port ranges", models.ProxyRules{AllowPorts: []string{"1-65535", " 8000 - 8100 ", "443"}}, true},
+		{"negative connections", models.ProxyRules{MaxConnections: -1}, false},
+		{"invalid CIDR", models.ProxyRules{DenyCIDRs: []string{"10.0.0.0/33"}}, false},
+		{"not an address", models.ProxyRules{AllowCIDRs: []string{"example.com"}}, false},
+		{"empty domain", models.ProxyRules{DenyDomains: []string{"*."}}, false},
+		{"domain with a port", models.ProxyRules{AllowDomains: []string{"example.com:443"}}, false},
+		{"domain with a path", models.ProxyRules{DenyDomains: []string{"example.com/login"}}, false},
+		{"port zero", models.ProxyRules{AllowPorts: []string{"0"}}, false},
+		{"port too high", models.ProxyRules{AllowPorts: []string{"65536"}}, false},
+		{"reversed range", models.ProxyRules{AllowPorts: []string{"8100-8000"}}, false},
+		{"port is not a number", models.ProxyRules{AllowPorts: []string{"http"}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := compileRules(test.rules)
+			if test.valid && err != nil {
+				t.Errorf("rules are refused: %v", err)
+			} else if !test.valid && err == nil {
+				t.Error("rules are accepted")
+			}
+		})
+	}
+}
+
+func TestCompileRulesParsesEntries(t *testing.T) {
+	r, err := compileRules(models.ProxyRules{
+		AllowCIDRs:   []string{"203.0.113.7", "2001:db8::1"},
+		AllowDomains: []string{"*.Example.COM", "example.org."},
+		AllowPorts:   []string{"443", "8000-8100"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ones, bits := r.allowNets[0].Mask.Size(); ones != 32 || bits != 32 {
+		t.Errorf("IPv4 address is a /%d of %d bits", ones, bits)
+	}
+	if ones, bits := r.allowNets[1].Mask.Size(); ones != 128 || bits != 128 {
+		t.Errorf("IPv6 address is a /%d of %d bits", ones, bits)
+	}
+	if r.allowDomains[0] != "example.com" || r.allowDomains[1] != "example.org" {
+		t.Errorf("domains are %q", r.allowDomains)
+	}
+	if r.ports[0] != [2]int{443, 443} || r.ports[1] != [2]int{8000, 8100} {
+		t.Errorf("ports are %v", r.ports)
+	}
+}
+
+func TestCheckDomain(t *testing.T) {
+	r := mustCompileRules(models.ProxyRules{
+		DenyDomains: []string{"*.tracker.example"},
+		AllowPorts:  []string{"443", "8000-8100"},
+	})
+	tests := []struct {
+		name   string
+		domain string
+		port   int
+		denied bool
+	}{
+		{"allowed port", "example.com", 443, false},
+		{"port in a range", "example.com", 8050, false},
+		{"port outside the ranges", "example.com", 80, true},
+		{"denied domain", "tracker.example", 443, true},
+		{"denied subdomain", "Ads.Tracker.Example.", 443, true},
+		{"domain ending like a denied one", "nottracker.example", 443, false},
+		{"no domain", "", 443, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason := r.checkDomain(test.domain, test.port)
+			if (reason != "") != test.denied {
+				t.Errorf("reason is %q, denied should be %v", reason, test.denied)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  models.ProxyRules
+		domain string
+		ip     string
+		denied bool
+	}{
+		{"public address", DefaultRules(), "", "93.184.216.34", false},
+		{"loopback", DefaultRules(), "", "127.0.0.1", true},
+		{"private network", DefaultRules(), "", "192.168.1.10", true},
+		{"shared address space", DefaultRules(), "", "100.64.0.1", true},
+		{"benchmarking network", DefaultRules(), "", "198.18.0.1", true},
+		{"link-local", DefaultRules(), "", "169.254.169.254", true},
+		{"unspecified", DefaultRules(), "", "0.0.0.0", true},
+		{"IPv6 loopback", DefaultRules(), "", "::1", true},
+		{"IPv6 unique local", DefaultRules(), "", "fd00::1", true},
+		{"IPv4-mapped loopback", DefaultRules(), "", "::ffff:127.0.0.1", true},
+		{"IPv4-mapped private network", DefaultRules(), "", "::ffff:10.1.2.3", true},
+		{"IPv4-mapped shared address space", DefaultRules(), "", "::ffff:100.64.0.1", true},
+		{"IPv4-mapped public address", DefaultRules(), "", "::ffff:93.184.216.34", false},
+		{"domain resolved to a private address", DefaultRules(), "intranet.example", "10.0.0.5", true},
+		{"private address allowed", models.ProxyRules{}, "", "10.0.0.5", false},
+		{"denied network", models.ProxyRules{DenyCIDRs: []string{"203.0.113.0/24"}}, "", "203.0.113.9", true},
+		{"IPv4-mapped address in a denied network", models.ProxyRules{DenyCIDRs: []string{"203.0.113.0/24"}}, "", "::ffff:203.0.113.9", true},
+		{"in the allowed networks", models.ProxyRules{AllowCIDRs: []string{"203.0.113.0/24"}}, "", "203.0.113.9", false},
+		{"outside the allowed networks", models.ProxyRules{AllowCIDRs: []string{"203.0.113.0/24"}}, "", "198.51.100.1", true},
+		{"allowed domain", models.ProxyRules{AllowDomains: []string{"example.com"}}, "www.example.com", "198.51.100.1", false},
+		{"address of an allowed domain asked directly", models.ProxyRules{AllowDomains: []string{"example.com"}}, "", "198.51.100.1", true},
+		{"allowed domain on a denied network", models.ProxyRules{AllowDomains: []string{"example.com"}, DenyCIDRs: []string{"198.51.100.0/24"}}, "example.com", "198.51.100.1", true},
+		{"allowed domain on a private address", models.ProxyRules{AllowDomains: []string{"example.com"}, BlockPrivate: true}, "example.com", "127.0.0.1", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason := mustCompileRules(test.rules).check(test.domain, net.ParseIP(test.ip), 443)
+			if (reason != "") != test.denied {
+				t.Errorf("reason is %q, denied should be %v", reason, test.denied)
+			}
+		})
+	}
+}
+
+// useRules applies rules for the rest of a test.
+func useRules(t *testing.T, rules models.ProxyRules) {
+	t.Helper()
+	previous := Rules()
+	err := SetRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetRules(previous) })
+}
+
+func TestAllowedDial(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	tests := []struct {
+		name   string
+		rules  models.ProxyRules
+		addr   string
+		denied bool
+	}{
+		{"allowed address", models.ProxyRules{}, "127.0.0.1:" + port, false},
+		// Only the address listened on is allowed, whichever address of localhost comes first
+		{"allowed domain", models.ProxyRules{AllowDomains: []string{"localhost"}, DenyCIDRs: []string{"::1"}}, "localhost:" + port, false},
+		{"private address", DefaultRules(), "127.0.0.1:" + port, true},
+		{"IPv4-mapped private address", models.ProxyRules{BlockPrivate: true}, "[::ffff:127.0.0.1]:" + port, true},
+		{"domain resolving to a private address", models.ProxyRules{BlockPrivate: true}, "localhost:" + port, true},
+		{"domain resolving to a denied network", models.ProxyRules{DenyCIDRs: []string{"127.0.0.0/8", "::1"}}, "localhost:" + port, true},
+		{"denied port", models.ProxyRules{AllowPorts: []string{"80"}}, "127.0.0.1:" + port, true},
+		{"denied domain", models.ProxyRules{DenyDomains: []string{"localhost"}}, "localhost:" + port, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := testDB(t)
+			useRules(t, test.rules)
+			ctx := context.WithValue(context.Background(), clientKey{}, "198.51.100.20")
+
+			conn, err := allowedDial(db)(ctx, "tcp", test.addr)
+			if !test.denied {
+				if err != nil {
+					t.Fatalf("dial failed: %v", err)
+				}
+				conn.Close()
+				return
+			}
+
+			var denied *deniedError
+			if !errors.As(err, &denied) {
+				t.Fatalf("dial is not denied: %v", err)
+			}
+			denials, err := operations.GetProxyDenials(db, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(denials) != 1 || denials[0].IP != "198.51.100.20" || denials[0].Destination != test.addr || denials[0].Reason != denied.reason {
+				t.Errorf("denials are %+v", denials)
+			}
+		})
+	}
+}
@@ -0,0 +1,536 @@
+// Package proxyclient sends this node's traffic through the proxy of another peer. It listens on a
+// local address and relays every connection to the chosen proxy, checks that the proxy is still up
+// and fails over to the next best one, and counts the bytes sent through each proxy so that their
+// bills can be checked against our own numbers.
+package proxyclient
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"server/database/models"
+	"server/database/operations"
+	"server/events"
+	"server/p2p"
+	"server/probe"
+	"server/reputation"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+const (
+	defaultProxyPort = "8000" // SOCKS5 port of the proxies, used when their IP has no port
+	healthInterval   = 30 * time.Second
+	healthTimeout    = 5 * time.Second
+	dialTimeout      = 10 * time.Second
+	usageInterval    = 30 * time.Second // How often the counted bytes are written to ProxyUsage
+	maxDialAttempts  = 3                // Proxies a connection is tried on before it is dropped
+)
+
+var (
+	ErrNoProxy       = errors.New("no proxy is available")
+	ErrProxyNotFound = errors.New("the proxy was not found on the network")
+	ErrNotConnected  = errors.New("not connected to a proxy")
+)
+
+// Config holds the proxy client settings
+type Config struct {
+	Addr string // Local address applications use as their SOCKS5 proxy
+}
+
+// DefaultConfig returns the settings used when no flags are given.
+func DefaultConfig() Config {
+	return Config{Addr: "localhost:1080"}
+}
+
+// Status is the state of the connection to a proxy
+type Status struct {
+	Connected     bool          `json:"connected"`
+	Proxy         *models.Proxy `json:"proxy,omitempty"`
+	LocalAddr     string        `json:"localAddr"`
+	Since         int64         `json:"since,omitempty"`
+	BytesSent     int64         `json:"bytesSent"`     // Sent through the proxy since connecting
+	BytesReceived int64         `json:"bytesReceived"` // Received through the proxy since connecting
+	Failovers     int           `json:"failovers"`
+	LastCheck     int64         `json:"lastCheck,omitempty"`
+	Latency       int64         `json:"latency,omitempty"` // Milliseconds of the last health check
+	LastError     string        `json:"lastError,omitempty"`
+}
+
+// usageKey identifies the bytes sent through one proxy, the IP is kept as the proxy may change it
+type usageKey struct {
+	node string
+	ip   string
+}
+
+// Manager relays the local connections to the current proxy
+type Manager struct {
+	node   host.Host
+	db     *sql.DB
+	prober *probe.Prober
+	config Config
+
+	mutex      sync.Mutex
+	listener   net.Listener
+	conns      map[net.Conn]struct{} // Relayed connections, closed on disconnect
+	current    *models.Proxy
+	candidates []models.Proxy // Next best proxies to fail over to
+	since      time.Time
+	sent       int64
+	received   int64
+	failovers  int
+	lastCheck  time.Time
+	latency    time.Duration
+	lastError  string
+	usage      map[usageKey]int64 // Bytes not written to ProxyUsage yet
+
+	failoverMutex sync.Mutex // Only one failover runs at a time
+}
+
+func NewManager(node host.Host, db *sql.DB, prober *probe.Prober, config Config) *Manager {
+	return &Manager{
+		node:   node,
+		db:     db,
+		prober: prober,
+		config: config,
+		conns:  map[net.Conn]struct{}{},
+		usage:  map[usageKey]int64{},
+	}
+}
+
+// Run checks the health of the current proxy and writes the counted bytes until ctx is done. It then
+// disconnects from the proxy and writes the bytes counted since the last write.
+func (m *Manager) Run(ctx context.Context) {
+	health := time.NewTicker(healthInterval)
+	defer health.Stop()
+	usage := time.NewTicker(usageInterval)
+	defer usage.Stop()
+
+	for {
+		select {
+		case <-health.C:
+			m.checkCurrent()
+		case <-usage.C:
+			m.saveUsage()
+		case <-ctx.Done():
+			m.mutex.Lock()
+			m.disconnect()
+			m.mutex.Unlock()
+			m.saveUsage()
+			return
+		}
+	}
+}
+
+// Connect finds the proxies on the network and connects to the best one that is up, or to the
+// proxy of nodeID when it is given. The others are kept to fail over to.
+func (m *Manager) Connect(nodeID string) (Status, error) {
+	proxies, err := m.findProxies("")
+	if err != nil {
+		return m.Status(), err
+	}
+	if nodeID != "" {
+		proxies, err = preferProxy(proxies, nodeID)
+		if err != nil {
+			return m.Status(), err
+		}
+	}
+
+	proxy, rest, latency, err := firstHealthy(proxies)
+	if nodeID != "" && (err != nil || proxy.Node != nodeID) {
+		return m.Status(), fmt.Errorf("proxy %s is not reachable", nodeID)
+	} else if err != nil {
+		return m.Status(), err
+	}
+
+	m.mutex.Lock()
+	if m.listener == nil {
+		listener, err := net.Listen("tcp", m.config.Addr)
+		if err != nil {
+			m.mutex.Unlock()
+			return m.Status(), fmt.Errorf("failed to listen on %s: %v", m.config.Addr, err)
+		}
+		m.listener = listener
+		go m.serve(listener)
+	}
+	m.use(proxy, rest, latency)
+	m.since = time.Now()
+	m.sent, m.received, m.failovers = 0, 0, 0
+	m.lastError = ""
+	m.mutex.Unlock()
+
+	log.Printf("Connected to proxy %s at %s, listening on %s", proxy.Node, proxy.IP, m.config.Addr)
+	go m.register(proxy)
+	status := m.Status()
+	events.Publish(events.ProxyConnection, status)
+	return status, nil
+}
+
+// Disconnect stops relaying and closes the relayed connections.
+func (m *Manager) Disconnect() error {
+	m.mutex.Lock()
+	if m.listener == nil {
+		m.mutex.Unlock()
+		return ErrNotConnected
+	}
+	m.disconnect()
+	m.mutex.Unlock()
+
+	log.Println("Disconnected from proxy")
+	events.Publish(events.ProxyConnection, m.Status())
+	return nil
+}
+
+// Status returns the state of the connection.
+func (m *Manager) Status() Status {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	status := Status{
+		Connected:     m.current != nil,
+		LocalAddr:     m.config.Addr,
+		BytesSent:     m.sent,
+		BytesReceived: m.received,
+		Failovers:     m.failovers,
+		Latency:       m.latency.Milliseconds(),
+		LastError:     m.lastError,
+	}
+	if m.current != nil {
+		proxy := *m.current
+		status.Proxy = &proxy
+		status.Since = m.since.Unix()
+	}
+	if !m.lastCheck.IsZero() {
+		status.LastCheck = m.lastCheck.Unix()
+	}
+	return status
+}
+
+// use makes proxy the current one, the mutex must be held.
+func (m *Manager) use(proxy models.Proxy, candidates []models.Proxy, latency time.Duration) {
+	m.current = &proxy
+	m.candidates = candidates
+	m.latency = latency
+	m.lastCheck = time.Now()
+}
+
+// disconnect closes the listener and the relayed connections, the mutex must be held.
+func (m *Manager) disconnect() {
+	if m.listener != nil {
+		m.listener.Close()
+		m.listener = nil
+	}
+	for conn := range m.conns {
+		conn.Close()
+	}
+	m.conns = map[net.Conn]struct{}{}
+	m.current = nil
+	m.candidates = nil
+}
+
+func (m *Manager) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return // Closed by Disconnect
+		}
+		go m.relay(conn)
+	}
+}
+
+// relay copies a local connection to the current proxy and back, counting the bytes both ways.
+func (m *Manager) relay(client net.Conn) {
+	defer client.Close()
+
+	upstream, proxy, err := m.dialUpstream()
+	if err != nil {
+		log.Printf("Failed to relay connection to a proxy: %v", err)
+		return
+	}
+	defer upstream.Close()
+
+	if !m.track(client, upstream) {
+		return
+	}
+	defer m.untrack(client, upstream)
+
+	key := usageKey{node: proxy.Node, ip: proxy.IP}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(&countingWriter{m: m, key: key, w: upstream, sent: true}, client)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(&countingWriter{m: m, key: key, w: client}, upstream)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// dialUpstream connects to the current proxy, failing over to the next one when it is down.
+func (m *Manager) dialUpstream() (net.Conn, models.Proxy, error) {
+	for attempt := 0; attempt < maxDialAttempts; attempt++ {
+		m.mutex.Lock()
+		if m.current == nil {
+			m.mutex.Unlock()
+			return nil, models.Proxy{}, ErrNotConnected
+		}
+		proxy := *m.current
+		m.mutex.Unlock()
+
+		conn, err := net.DialTimeout("tcp", proxyAddress(proxy.IP), dialTimeout)
+		if err == nil {
+			return conn, proxy, nil
+		}
+		m.failover(proxy, err)
+	}
+	return nil, models.Proxy{}, ErrNoProxy
+}
+
+// track remembers the connections of a relay so that Disconnect can close them.
+func (m *Manager) track(conns ...net.Conn) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.listener == nil {
+		return false
+	}
+	for _, conn := range conns {
+		m.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (m *Manager) untrack(conns ...net.Conn) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, conn := range conns {
+		delete(m.conns, conn)
+	}
+}
+
+func (m *Manager) count(key usageKey, sent bool, n int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.usage[key] += int64(n)
+	if m.current == nil || m.current.Node != key.node {
+		return
+	}
+	if sent {
+		m.sent += int64(n)
+	} else {
+		m.received += int64(n)
+	}
+}
+
+// checkCurrent fails over when the current proxy doesn't answer its health check.
+func (m *Manager) checkCurrent() {
+	m.mutex.Lock()
+	if m.current == nil {
+		m.mutex.Unlock()
+		return
+	}
+	proxy := *m.current
+	m.mutex.Unlock()
+
+	latency, err := healthCheck(proxy.IP)
+	if err != nil {
+		m.failover(proxy, err)
+		return
+	}
+
+	m.mutex.Lock()
+	if m.current != nil && m.current.Node == proxy.Node {
+		m.latency = latency
+		m.lastCheck = time.Now()
+	}
+	m.mutex.Unlock()
+}
+
+// failover replaces a failed proxy with the next best one that is up. The proxies are searched
+// again once the known ones are used up, and the client disconnects when none is left.
+func (m *Manager) failover(failed models.Proxy, cause error) {
+	m.failoverMutex.Lock()
+	defer m.failoverMutex.Unlock()
+
+	m.mutex.Lock()
+	if m.current == nil || m.current.Node != failed.Node {
+		m.mutex.Unlock()
+		return // Another connection failed over already
+	}
+	candidates := m.candidates
+	m.mutex.Unlock()
+
+	log.Printf("Proxy %s failed: %v", failed.Node, cause)
+	reputation.RecordFailure(m.db, failed.Node)
+
+	proxy, rest, latency, err := firstHealthy(candidates)
+	if err != nil {
+		proxies, findErr := m.findProxies(failed.Node)
+		if findErr == nil {
+			proxy, rest, latency, err = firstHealthy(proxies)
+		}
+	}
+
+	m.mutex.Lock()
+	if err != nil {
+		m.lastError = fmt.Sprintf("proxy %s failed (%v) and no other proxy is available", failed.Node, cause)
+		m.disconnect()
+		m.mutex.Unlock()
+		log.Printf("Proxy %s failed and no other proxy is available, disconnected", failed.Node)
+		events.Publish(events.ProxyConnection, m.Status())
+		return
+	}
+	m.use(proxy, rest, latency)
+	m.failovers++
+	m.lastError = fmt.Sprintf("proxy %s failed: %v", failed.Node, cause)
+	m.mutex.Unlock()
+
+	log.Printf("Failed over to proxy %s at %s", proxy.Node, proxy.IP)
+	go m.register(proxy)
+	events.Publish(events.ProxyConnection, m.Status())
+}
+
+// findProxies returns the SOCKS5 proxies offered on the network best probed first, without our own
+// and without the excluded one. The relay only speaks SOCKS5 to the proxy.
+func (m *Manager) findProxies(exclude string) ([]models.Proxy, error) {
+	proxies, err := p2p.RandomProxiesInfo(m.node, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	found := []models.Proxy{}
+	for _, proxy := range proxies {
+		socks := proxy.Protocol == "" || proxy.Protocol == "socks5"
+		if socks && proxy.Node != m.node.ID().String() && proxy.Node != exclude && proxy.IP != "" {
+			found = append(found, proxy)
+		}
+	}
+	if len(found) == 0 {
+		return nil, ErrNoProxy
+	}
+
+	ranked := []models.Proxy{}
+	for _, result := range m.prober.Probe(found, false) {
+		ranked = append(ranked, result.Proxy)
+	}
+	return ranked, nil
+}
+
+// register tells the proxy that our IP belongs to our node, so that it can bill us. The proxy takes
+// the IP our libp2p connection comes from, a local address would be wrong behind NAT.
+func (m *Manager) register(proxy models.Proxy) {
+	registration := models.ProxyBill{Rate: -1, Wallet: m.node.ID().String()}
+	_, err := p2p.SendProxyBillWithConfirmation(m.node, m.db, proxy.Node, registration)
+	if err != nil {
+		log.Printf("Failed to register with proxy %s: %v", proxy.Node, err)
+	}
+}
+
+// saveUsage writes the bytes counted since the last call to the ProxyUsage table.
+func (m *Manager) saveUsage() {
+	m.mutex.Lock()
+	usage := m.usage
+	m.usage = map[usageKey]int64{}
+	m.mutex.Unlock()
+
+	now := time.Now().Unix()
+	for key, bytes := range usage {
+		err := operations.AddProxyUsage(m.db, key.node, key.ip, bytes, now)
+		if err != nil {
+			log.Printf("Failed to save proxy usage: %v", err)
+		}
+	}
+}
+
+// preferProxy moves the proxy of nodeID to the front of proxies.
+func preferProxy(proxies []models.Proxy, nodeID string) ([]models.Proxy, error) {
+	for i, proxy := range proxies {
+		if proxy.Node == nodeID {
+			ordered := append([]models.Proxy{proxy}, proxies[:i]...)
+			return append(ordered, proxies[i+1:]...), nil
+		}
+	}
+	return nil, ErrProxyNotFound
+}
+
+// firstHealthy returns the first proxy that passes its health check and the proxies after it.
+func firstHealthy(proxies []models.Proxy) (models.Proxy, []models.Proxy, time.Duration, error) {
+	for i, proxy := range proxies {
+		latency, err := healthCheck(proxy.IP)
+		if err != nil {
+			log.Printf("Proxy %s at %s is not usable: %v", proxy.Node, proxy.IP, err)
+			continue
+		}
+		return proxy, proxies[i+1:], latency, nil
+	}
+	return models.Proxy{}, nil, 0, ErrNoProxy
+}
+
+// healthCheck opens a SOCKS5 session without authentication and returns how long the proxy took
+// to accept it.
+func healthCheck(ip string) (time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", proxyAddress(ip), healthTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(healthTimeout))
+
+	_, err = conn.Write([]byte{5, 1, 0}) // Version 5, one method: no authentication
+	if err != nil {
+		return 0, err
+	}
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(reply, []byte{5, 0}) {
+		return 0, fmt.Errorf("not a SOCKS5 proxy")
+	}
+
+	return time.Since(start), nil
+}
+
+// proxyAddress adds the default SOCKS5 port to a proxy IP without one.
+func proxyAddress(ip string) string {
+	if _, _, err := net.SplitHostPort(ip); err == nil {
+		return ip
+	}
+	return net.JoinHostPort(ip, defaultProxyPort)
+}
+
+// countingWriter counts the bytes relayed through a proxy
+type countingWriter struct {
+	m    *Manager
+	key  usageKey
+	w    io.Writer
+	sent bool
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.m.count(c.key, c.sent, n)
+	return n, err
+}
+
+// closeWrite tells the other side that nothing more is sent, the connection is closed if it can't.
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(interface{ CloseWrite() error }); ok {
+		tcp.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,156 @@
+// Package reputation records how our dealings with each peer went, transfers, timeouts, hash
+// mismatches, payment disputes and proxy bills, and turns them into a score between 0 and 1 that
+// provider and proxy selection prefer. A peer we know nothing about scores 0.5.
+package reputation
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"server/database/models"
+	"server/database/operations"
+	"sort"
+	"time"
+)
+
+const (
+	// Unknown peers start from one good and one bad outcome, so that a single result doesn't decide
+	priorGood = 1.0
+	priorBad  = 1.0
+
+	// Lying about a file or a bill weighs more than being slow or unreachable
+	mismatchWeight   = 3.0
+	disputeWeight    = 3.0
+	inaccurateWeight = 2.0
+
+	// Throughput at which the speed part of the score is full
+	referenceThroughput = 1 << 20 // bytes per second
+	speedShare          = 0.2
+)
+
+// RecordTransfer records a file received in full from a peer.
+func RecordTransfer(db *sql.DB, peer string, bytes int64, elapsed time.Duration) {
+	record(db, models.Reputation{Peer: peer, Transfers: 1, Bytes: bytes, Seconds: elapsed.Seconds()})
+}
+
+// RecordTimeout records a peer that didn't answer in time.
+func RecordTimeout(db *sql.DB, peer string) {
+	record(db, models.Reputation{Peer: peer, Timeouts: 1})
+}
+
+// RecordFailure records a request to a peer that failed for any other reason.
+func RecordFailure(db *sql.DB, peer string) {
+	record(db, models.Reputation{Peer: peer, Failures: 1})
+}
+
+// RecordHashMismatch records a peer that sent data not matching the requested hash.
+func RecordHashMismatch(db *sql.DB, peer string) {
+	record(db, models.Reputation{Peer: peer, HashMismatches: 1})
+}
+
+// RecordDispute records a peer that refused to pay a bill.
+func RecordDispute(db *sql.DB, peer string) {
+	record(db, models.Reputation{Peer: peer, Disputes: 1})
+}
+
+// RecordBill records a bill received from a proxy and whether it was accurate.
+func RecordBill(db *sql.DB, peer string, accurate bool) {
+	delta := models.Reputation{Peer: peer, Bills: 1}
+	if !accurate {
+		delta.InaccurateBills = 1
+	}
+	record(db, delta)
+}
+
+func record(db *sql.DB, delta models.Reputation) {
+	if db == nil || delta.Peer == "" {
+		return
+	}
+	delta.Updated = time.Now().Unix()
+
+	err := operations.AddReputation(db, delta)
+	if err != nil {
+		log.Printf("Failed to record reputation of peer %s: %v", delta.Peer, err)
+	}
+}
+
+// Score returns the score of a record, between 0 and 1.
+func Score(r models.Reputation) float64 {
+	good := float64(r.Transfers + r.Bills - r.InaccurateBills)
+	bad := float64(r.Timeouts+r.Failures) + mismatchWeight*float64(r.HashMismatches) +
+		disputeWeight*float64(r.Disputes) + inaccurateWeight*float64(r.InaccurateBills)
+	reliability := (good + priorGood) / (good + bad + priorGood + priorBad)
+
+	speed := 0.5
+	if r.Seconds > 0 {
+		speed = math.Min(float64(r.Bytes)/r.Seconds/referenceThroughput, 1)
+	}
+
+	score := (1-speedShare)*reliability + speedShare*speed
+	return math.Round(score*1000) / 1000
+}
+
+// Scores returns the score of each peer, peers without a record get the neutral score.
+func Scores(db *sql.DB, peers []string) (map[string]float64, error) {
+	scores := make(map[string]float64, len(peers))
+	for _, peer := range peers {
+		reputation, err := operations.FindReputation(db, peer)
+		if err != nil {
+			return nil, err
+		}
+		if reputation == nil {
+			reputation = &models.Reputation{Peer: peer}
+		}
+		scores[peer] = Score(*reputation)
+	}
+	return scores, nil
+}
+
+// Scored is a peer and its score
+type Scored struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// RankScored sorts peers by score, best first, keeping the given order between equal scores.
+func RankScored(db *sql.DB, peers []string) ([]Scored, error) {
+	scores, err := Scores(db, peers)
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := make([]Scored, 0, len(peers))
+	for _, peer := range peers {
+		ranked = append(ranked, Scored{ID: peer, Score: scores[peer]})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	return ranked, nil
+}
+
+// Rank is RankScored without the scores. The peers are returned unchanged if the scores can't be read.
+func Rank(db *sql.DB, peers []string) []string {
+	ranked, err := RankScored(db, peers)
+	if err != nil {
+		log.Printf("Failed to rank peers: %v", err)
+		return peers
+	}
+
+	ids := make([]string, 0, len(ranked))
+	for _, scored := range ranked {
+		ids = append(ids, scored.ID)
+	}
+	return ids
+}
+
+// All returns every record with its score, best first.
+func All(db *sql.DB) ([]models.Reputation, error) {
+	records, err := operations.GetAllReputation(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		records[i].Score = Score(records[i])
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Score > records[j].Score })
+	return records, nil
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"server/billing"
+	"server/btc"
+	"server/downloads"
+	"server/probe"
+	"server/proxyclient"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// Prefix is the path every versioned route is served under
+const Prefix = "/api/v1"
+
+// Error codes used in the error envelope
+const (
+	CodeBadRequest       = "bad_request"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeInternal         = "internal"
+)
+
+// Deps are the services the handlers work with
+type Deps struct {
+	Node        host.Host
+	Wallet      *rpcclient.Client
+	NetParams   *chaincfg.Params
+	DB          *sql.DB
+	Downloads   *downloads.Manager
+	ProxyClient *proxyclient.Manager
+	Billing     *billing.Engine
+	Prober      *probe.Prober
+	BTC         *btc.Supervisor
+}
+
+// handlerFunc writes a successful response or returns the error to put in the envelope
+type handlerFunc func(w http.ResponseWriter, r *http.Request, deps *Deps) error
+
+// route describes one method on one path, it is used both for routing and for the OpenAPI document
+type route struct {
+	Method   string
+	Path     string // Relative to Prefix, may contain {hash} style wildcards
+	Summary  string
+	Request  any // Zero value of the JSON request body, nil if there is none
+	Response any // Zero value of the JSON response body, nil if there is none
+	Status   int // Status on success
+	Errors   []int
+	Handler  handlerFunc
+}
+
+// apiError is an error with the status and code to report it with
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func badRequest(format string, args ...any) error {
+	return &apiError{http.StatusBadRequest, CodeBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...any) error {
+	return &apiError{http.StatusNotFound, CodeNotFound, fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...any) error {
+	return &apiError{http.StatusConflict, CodeConflict, fmt.Sprintf(format, args...)}
+}
+
+// Register adds every /api/v1 route to the mux.
+func Register(mux *http.ServeMux, deps Deps) {
+	// Group the routes by path so that each path dispatches on the method
+	byPath := map[string][]route{}
+	paths := []string{}
+	for _, rt := range routes() {
+		if _, ok := byPath[rt.Path]; !ok {
+			paths = append(paths, rt.Path)
+		}
+		byPath[rt.Path] = append(byPath[rt.Path], rt)
+	}
+
+	for _, path := range paths {
+		methods := byPath[path]
+		mux.HandleFunc(Prefix+path, func(w http.ResponseWriter, r *http.Request) {
+			dispatch(w, r, &deps, methods)
+		})
+	}
+
+	mux.HandleFunc(Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, &apiError{http.StatusNotFound, CodeNotFound, "no route for " + r.URL.Path})
+	})
+}
+
+// dispatch calls the handler registered for the request method.
+func dispatch(w http.ResponseWriter, r *http.Request, deps *Deps, methods []route) {
+	allowed := []string{}
+	for _, rt := range methods {
+		if rt.Method == r.Method {
+			err := rt.Handler(w, r, deps)
+			if err != nil {
+				writeError(w, err)
+			}
+			return
+		}
+		allowed = append(allowed, rt.Method)
+	}
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, &apiError{http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method + " is not allowed on " + r.URL.Path})
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("Error encoding API response: %v", err)
+	}
+	return nil
+}
+
+// writeError writes err inside the error envelope, errors that are not apiErrors are internal.
+func writeError(w http.ResponseWriter, err error) {
+	var e *apiError
+	if !errors.As(err, &e) {
+		e = &apiError{http.StatusInternalServerError, CodeInternal, err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorBody{Code: e.Code, Message: e.Message}})
+}
+
+// decodeJSON reads the JSON request body into v.
+func decodeJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// requireHash returns the {hash} path wildcard.
+func requireHash(r *http.Request) (string, error) {
+	hash := r.PathValue("hash")
+	if hash == "" {
+		return "", badRequest("hash is required")
+	}
+	return hash, nil
+}
+
+// requireID returns the {id} path wildcard.
+func requireID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return 0, badRequest("id must be a number")
+	}
+	return id, nil
+}
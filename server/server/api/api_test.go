@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"server/database"
+	"server/database/models"
+)
+
+// examplePath fills the wildcards of a route path with example values.
+func examplePath(path string) string {
+	replacer := strings.NewReplacer("{hash}", "QmHash", "{id}", "1", "{peer}", "12D3KooWPeer")
+	return replacer.Replace(path)
+}
+
+func TestRegisterServesEveryRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	// Conflicting patterns make ServeMux panic
+	Register(mux, Deps{})
+
+	for _, rt := range routes() {
+		request := httptest.NewRequest(rt.Method, Prefix+examplePath(rt.Path), nil)
+		_, pattern := mux.Handler(request)
+		if pattern != Prefix+rt.Path {
+			t.Errorf("%s %s is served by %q", rt.Method, rt.Path, pattern)
+		}
+	}
+}
+
+func testDeps(t *testing.T) Deps {
+	t.Helper()
+	db, err := database.SetupDatabase(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	err = database.CreateNewTables(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Deps{DB: db}
+}
+
+// serve sends a request through a mux with every route registered.
+func serve(t *testing.T, deps Deps, method string, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	Register(mux, deps)
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(method, Prefix+path, strings.NewReader(body)))
+	return recorder
+}
+
+// expectError checks the status and the error envelope of a response.
+func expectError(t *testing.T, recorder *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if recorder.Code != status {
+		t.Fatalf("status is %d, want %d: %s", recorder.Code, status, recorder.Body)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type is %q", contentType)
+	}
+	var response ErrorResponse
+	err := json.NewDecoder(recorder.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("error body is not an envelope: %v", err)
+	}
+	if response.Error.Code != code || response.Error.Message == "" {
+		t.Errorf("error is %+v, want code %q and a message", response.Error, code)
+	}
+}
+
+func TestStoringLifecycle(t *testing.T) {
+	deps := testDeps(t)
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	err := os.WriteFile(path, []byte("some notes"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := fmt.Sprintf(`{"path": %q, "name": "notes", "extension": "txt", "size": 10, "date": "2024-01-01"}`, path)
+
+	recorder := serve(t, deps, "POST", "/storing", body)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("status is %d: %s", recorder.Code, recorder.Body)
+	}
+	var stored models.Storing
+	err = json.NewDecoder(recorder.Body).Decode(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Name != "notes" || stored.Hash == "" {
+		t.Fatalf("stored %+v", stored)
+	}
+
+	expectError(t, serve(t, deps, "POST", "/storing", body), http.StatusConflict, CodeConflict)
+
+	recorder = serve(t, deps, "GET", "/storing", "")
+	var list []models.Storing
+	err = json.NewDecoder(recorder.Body).Decode(&list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusOK || len(list) != 1 {
+		t.Fatalf("status %d, listed %+v", recorder.Code, list)
+	}
+
+	recorder = serve(t, deps, "DELETE", "/storing/"+stored.Hash, "")
+	if recorder.Code != http.StatusNoContent || recorder.Body.Len() != 0 {
+		t.Fatalf("status is %d: %s", recorder.Code, recorder.Body)
+	}
+	expectError(t, serve(t, deps, "DELETE", "/storing/"+stored.Hash, ""), http.StatusNotFound, CodeNotFound)
+}
+
+func TestBadRequests(t *testing.T) {
+	deps := testDeps(t)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"invalid JSON", "POST", "/storing", `{"path":`},
+		{"unknown field", "POST", "/storing", `{"path": "a", "name": "b", "owner": "c"}`},
+		{"missing fields", "POST", "/storing", `{"path": "a"}`},
+		{"negative price", "POST", "/hosting", `{"hash": "QmHash", "price": -1}`},
+		{"id is not a number", "GET", "/downloads/jobs/abc", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectError(t, serve(t, deps, test.method, test.path, test.body), http.StatusBadRequest, CodeBadRequest)
+		})
+	}
+}
+
+func TestMissingRecords(t *testing.T) {
+	deps := testDeps(t)
+	expectError(t, serve(t, deps, "POST", "/hosting", `{"hash": "QmHash", "price": 1}`), http.StatusNotFound, CodeNotFound)
+	expectError(t, serve(t, deps, "DELETE", "/saved/QmHash", ""), http.StatusNotFound, CodeNotFound)
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	recorder := serve(t, Deps{}, "PATCH", "/storing", "")
+	expectError(t, recorder, http.StatusMethodNotAllowed, CodeMethodNotAllowed)
+	if allow := recorder.Header().Get("Allow"); allow != "GET, POST" {
+		t.Errorf("Allow is %q", allow)
+	}
+}
+
+func TestUnknownRoute(t *testing.T) {
+	expectError(t, serve(t, Deps{}, "GET", "/nothing/here", ""), http.StatusNotFound, CodeNotFound)
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	recorder := serve(t, Deps{}, "GET", "/openapi.json", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status is %d", recorder.Code)
+	}
+	var document struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	err := json.NewDecoder(recorder.Body).Decode(&document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rt := range routes() {
+		if _, ok := document.Paths[Prefix+rt.Path][strings.ToLower(rt.Method)]; !ok {
+			t.Errorf("%s %s is not documented", rt.Method, rt.Path)
+		}
+	}
+}
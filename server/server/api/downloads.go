@@ -0,0 +1,100 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"server/downloads"
+)
+
+func listDownloadJobs(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	jobs, err := deps.Downloads.Jobs()
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, jobs)
+}
+
+func enqueueDownload(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request EnqueueDownloadRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Hash == "" {
+		return badRequest("hash is required")
+	}
+	if request.MaxPrice < 0 {
+		return badRequest("maxPrice can't be negative")
+	}
+
+	job, err := deps.Downloads.Enqueue(downloads.Request(request))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, job)
+}
+
+func getDownloadJob(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	id, err := requireID(r)
+	if err != nil {
+		return err
+	}
+
+	job, err := deps.Downloads.Job(id)
+	if err != nil {
+		return downloadJobError(err, id)
+	}
+	return writeJSON(w, http.StatusOK, job)
+}
+
+func cancelDownloadJob(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	id, err := requireID(r)
+	if err != nil {
+		return err
+	}
+
+	err = deps.Downloads.Cancel(id)
+	if err != nil {
+		return downloadJobError(err, id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func pauseDownloadJob(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	id, err := requireID(r)
+	if err != nil {
+		return err
+	}
+
+	job, err := deps.Downloads.Pause(id)
+	if err != nil {
+		return downloadJobError(err, id)
+	}
+	return writeJSON(w, http.StatusOK, job)
+}
+
+func resumeDownloadJob(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	id, err := requireID(r)
+	if err != nil {
+		return err
+	}
+
+	job, err := deps.Downloads.Resume(id)
+	if err != nil {
+		return downloadJobError(err, id)
+	}
+	return writeJSON(w, http.StatusOK, job)
+}
+
+// downloadJobError maps the download manager errors to their status.
+func downloadJobError(err error, id int64) error {
+	switch {
+	case errors.Is(err, downloads.ErrNotFound):
+		return notFound("no download job with id %d", id)
+	case errors.Is(err, downloads.ErrInvalidState):
+		return conflict("%v", err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,12 @@
+package api
+
+import (
+	"net/http"
+	"server/server/handlers"
+)
+
+// streamEvents serves the same server-sent event stream as the legacy /events route.
+func streamEvents(w http.ResponseWriter, r *http.Request, _ *Deps) error {
+	handlers.EventsHandler(w, r)
+	return nil
+}
@@ -0,0 +1,443 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"server/database/models"
+	"server/database/operations"
+	"server/p2p"
+)
+
+func listStoring(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	storingRecords, err := operations.GetAllStoring(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, storingRecords)
+}
+
+func addStoring(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request AddStoringRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Path == "" || request.Name == "" {
+		return badRequest("path and name are required")
+	}
+
+	hash, err := operations.HashFile(request.Path)
+	if err != nil {
+		return badRequest("could not read file: %v", err)
+	}
+
+	record, err := operations.FindStoring(deps.DB, hash)
+	if err != nil {
+		return err
+	} else if record != nil {
+		return conflict("the file is already being stored")
+	}
+
+	err = operations.AddStoring(deps.DB, hash, request.Name, request.Extension, request.Path, request.Date, request.Size)
+	if err != nil {
+		return err
+	}
+
+	err = operations.AddUploads(deps.DB, request.Date, hash, request.Name, request.Extension, request.Size)
+	if err != nil {
+		return err
+	}
+
+	record, err = operations.FindStoring(deps.DB, hash)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, record)
+}
+
+func deleteStoring(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	hash, err := requireHash(r)
+	if err != nil {
+		return err
+	}
+
+	record, err := operations.FindStoring(deps.DB, hash)
+	if err != nil {
+		return err
+	} else if record == nil {
+		return notFound("no stored file with hash %s", hash)
+	}
+
+	// A file that is no longer stored can't be hosted, shared or be a collection either
+	err = operations.DeleteStoring(deps.DB, hash)
+	if err != nil {
+		return err
+	}
+
+	err = operations.DeleteHosting(deps.DB, hash)
+	if err != nil {
+		return err
+	}
+	p2p.StopAnnouncing(hash)
+
+	err = operations.DeleteSharing(deps.DB, hash)
+	if err != nil {
+		return err
+	}
+
+	err = operations.DeleteCollection(deps.DB, hash)
+	if err != nil {
+		return err
+	}
+
+	err = operations.DeleteEncryptedShare(deps.DB, hash)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func listHosting(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	hostingRecords, err := operations.GetAllHosting(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, hostingRecords)
+}
+
+func addHosting(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request AddHostingRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Hash == "" {
+		return badRequest("hash is required")
+	}
+	if request.Price < 0 {
+		return badRequest("price can't be negative")
+	}
+
+	storing, err := operations.FindStoring(deps.DB, request.Hash)
+	if err != nil {
+		return err
+	} else if storing == nil {
+		return notFound("no stored file with hash %s", request.Hash)
+	}
+
+	record, err := operations.FindHosting(deps.DB, request.Hash)
+	if err != nil {
+		return err
+	} else if record != nil {
+		return conflict("the file is already being hosted")
+	}
+
+	err = p2p.Announce(request.Hash)
+	if err != nil {
+		return err
+	}
+
+	err = operations.AddHosting(deps.DB, request.Hash, request.Price)
+	if err != nil {
+		return err
+	}
+
+	record, err = operations.FindHosting(deps.DB, request.Hash)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, record)
+}
+
+func deleteHosting(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	hash, err := requireHash(r)
+	if err != nil {
+		return err
+	}
+
+	record, err := operations.FindHosting(deps.DB, hash)
+	if err != nil {
+		return err
+	} else if record == nil {
+		return notFound("no hosted file with hash %s", hash)
+	}
+
+	err = operations.DeleteHosting(deps.DB, hash)
+	if err != nil {
+		return err
+	}
+	p2p.StopAnnouncing(hash)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func announceStatus(w http.ResponseWriter, _ *http.Request, _ *Deps) error {
+	return writeJSON(w, http.StatusOK, p2p.AnnounceStatus())
+}
+
+func listSharing(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	sharingRecords, err := operations.GetAllSharing(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, sharingRecords)
+}
+
+func addSharing(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request HashRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Hash == "" {
+		return badRequest("hash is required")
+	}
+
+	storing, err := operations.FindStoring(deps.DB, request.Hash)
+	if err != nil {
+		return err
+	} else if storing == nil {
+		return notFound("no stored file with hash %s", request.Hash)
+	}
+
+	record, err := operations.FindSharing(deps.DB, request.Hash)
+	if err != nil {
+		return err
+	} else if record != nil {
+		return conflict("the file is already being shared")
+	}
+
+	link, err := p2p.GenerateLink(deps.DB, deps.Node, request.Hash)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, SharingLinkResponse{Hash: request.Hash, Link: link})
+}
+
+func deleteSharing(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	hash, err := requireHash(r)
+	if err != nil {
+		return err
+	}
+
+	record, err := operations.FindSharing(deps.DB, hash)
+	if err != nil {
+		return err
+	} else if record == nil {
+		return notFound("no shared file with hash %s", hash)
+	}
+
+	err = operations.DeleteSharing(deps.DB, hash)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func sharingLink(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	hash, err := requireHash(r)
+	if err != nil {
+		return err
+	}
+
+	record, err := operations.FindSharing(deps.DB, hash)
+	if err != nil {
+		return err
+	} else if record == nil {
+		return notFound("no shared file with hash %s", hash)
+	}
+
+	link := p2p.SharingLink(deps.Node.ID().String(), record.Hash, record.Password)
+
+	// The link of an encrypted share also carries its key
+	share, err := operations.FindEncryptedShare(deps.DB, hash)
+	if err != nil {
+		return err
+	} else if share != nil {
+		link = p2p.EncryptedSharingLink(deps.Node.ID().String(), record.Hash, record.Password, share.Key)
+	}
+	return writeJSON(w, http.StatusOK, SharingLinkResponse{Hash: record.Hash, Link: link})
+}
+
+func listEncryptedShares(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	shareRecords, err := operations.GetAllEncryptedShares(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, shareRecords)
+}
+
+func addEncryptedShare(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request AddEncryptedShareRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Hash == "" {
+		return badRequest("hash is required")
+	}
+	if request.Price < 0 {
+		return badRequest("price can't be negative")
+	}
+
+	storing, err := operations.FindStoring(deps.DB, request.Hash)
+	if err != nil {
+		return err
+	} else if storing == nil {
+		return notFound("no stored file with hash %s", request.Hash)
+	} else if storing.Extension == p2p.EncryptedExtension {
+		return badRequest("the file is encrypted already")
+	}
+
+	share, link, err := p2p.CreateEncryptedShare(deps.DB, deps.Node, request.Hash, request.Price)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, EncryptedShareResponse{Hash: share.Hash, Source: share.Source, Link: link})
+}
+
+func deleteEncryptedShare(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	hash, err := requireHash(r)
+	if err != nil {
+		return err
+	}
+
+	record, err := operations.FindEncryptedShare(deps.DB, hash)
+	if err != nil {
+		return err
+	} else if record == nil {
+		return notFound("no encrypted share with hash %s", hash)
+	}
+
+	err = p2p.DeleteEncryptedShare(deps.DB, hash)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func openSharing(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request OpenSharingRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Link == "" {
+		return badRequest("link is required")
+	}
+
+	meta, data, err := p2p.OpenEncryptedLink(deps.Node, request.Link)
+	if errors.Is(err, p2p.ErrInvalidKey) {
+		return badRequest("%v", err)
+	} else if err != nil {
+		return &apiError{http.StatusBadGateway, CodeInternal, err.Error()}
+	}
+
+	contentType := meta.Extension
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", meta.Name))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+	return nil
+}
+
+func listSaved(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	savedRecords, err := operations.GetAllSaved(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, savedRecords)
+}
+
+func addSaved(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request AddSavedRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Hash == "" {
+		return badRequest("hash is required")
+	}
+
+	record, err := operations.FindSaved(deps.DB, request.Hash)
+	if err != nil {
+		return err
+	} else if record != nil {
+		return conflict("the file has already been saved")
+	}
+
+	err = operations.AddSaved(deps.DB, request.Hash, request.Name, request.Extension, request.Size)
+	if err != nil {
+		return err
+	}
+
+	saved := models.Saved{Hash: request.Hash, Name: request.Name, Extension: request.Extension, Size: request.Size}
+	return writeJSON(w, http.StatusCreated, saved)
+}
+
+func deleteSaved(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	hash, err := requireHash(r)
+	if err != nil {
+		return err
+	}
+
+	record, err := operations.FindSaved(deps.DB, hash)
+	if err != nil {
+		return err
+	} else if record == nil {
+		return notFound("no saved file with hash %s", hash)
+	}
+
+	err = operations.DeleteSaved(deps.DB, hash)
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func listCollections(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	collectionRecords, err := operations.GetAllCollections(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, collectionRecords)
+}
+
+func addCollection(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request AddCollectionRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Name == "" || len(request.Hashes) == 0 {
+		return badRequest("name and at least one hash are required")
+	}
+
+	for _, hash := range request.Hashes {
+		storing, err := operations.FindStoring(deps.DB, hash)
+		if err != nil {
+			return err
+		} else if storing == nil {
+			return notFound("no stored file with hash %s", hash)
+		}
+	}
+
+	hash, err := p2p.CreateCollection(deps.DB, deps.Node, request.Name, request.Hashes)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, HashResponse{Hash: hash})
+}
@@ -0,0 +1,560 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"server/billing"
+	"server/database/models"
+	"server/database/operations"
+	"server/p2p"
+	"server/proxy"
+	"server/proxyclient"
+	"server/reputation"
+	"strconv"
+	"time"
+)
+
+// The longest a quote request may wait for the providers
+const maxQuoteTimeout = 30 * time.Second
+
+func listPeers(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	peers := []PeerInfo{}
+	for _, id := range deps.Node.Network().Peers() {
+		addresses := []string{}
+		for _, conn := range deps.Node.Network().ConnsToPeer(id) {
+			addresses = append(addresses, conn.RemoteMultiaddr().String())
+		}
+		peers = append(peers, PeerInfo{ID: id.String(), Addresses: addresses})
+	}
+	return writeJSON(w, http.StatusOK, peers)
+}
+
+func listKnownPeers(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	peers, err := operations.GetKnownPeers(deps.DB, 1000)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, peers)
+}
+
+func listBlockedPeers(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	blocked, err := operations.GetBlockedPeers(deps.DB, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, blocked)
+}
+
+func blockPeer(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request BlockPeerRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Peer == "" {
+		return badRequest("peer is required")
+	}
+
+	var duration time.Duration
+	if request.Duration != "" {
+		duration, err = time.ParseDuration(request.Duration)
+		if err != nil || duration <= 0 {
+			return badRequest("duration must be a positive duration such as 24h")
+		}
+	}
+
+	record, err := p2p.BlockPeer(deps.Node, deps.DB, request.Peer, request.Reason, duration)
+	if errors.Is(err, p2p.ErrInvalidPeer) {
+		return badRequest("%v", err)
+	} else if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, record)
+}
+
+func unblockPeer(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	id := r.PathValue("peer")
+	found, err := p2p.UnblockPeer(deps.DB, id)
+	if err != nil {
+		return err
+	} else if !found {
+		return notFound("peer %s is not blocked", id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func listAbuseLog(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	logs, err := operations.GetAbuseLogs(deps.DB, 1000)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, logs)
+}
+
+func getProviders(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	hash, err := requireHash(r)
+	if err != nil {
+		return err
+	}
+
+	providers, err := scoredProviders(deps, hash)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, ProvidersResponse{Hash: hash, Providers: providers})
+}
+
+// scoredProviders lists the providers of a file with their reputation score, best first.
+func scoredProviders(deps *Deps, hash string) ([]ProviderInfo, error) {
+	ids, err := p2p.GetProviderIDs(deps.Node, hash)
+	if err != nil {
+		return nil, err
+	}
+	ranked, err := reputation.RankScored(deps.DB, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := []ProviderInfo{}
+	for _, scored := range ranked {
+		providers = append(providers, ProviderInfo{ID: scored.ID, Score: scored.Score})
+	}
+	return providers, nil
+}
+
+func getQuotes(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	hash, err := requireHash(r)
+	if err != nil {
+		return err
+	}
+
+	order := r.URL.Query().Get("sort")
+	if order != "" && order != p2p.SortByPrice && order != p2p.SortByLatency && order != p2p.SortByScore {
+		return badRequest("sort must be price, latency or score")
+	}
+	timeout := p2p.DefaultQuoteTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout <= 0 || timeout > maxQuoteTimeout {
+			return badRequest("timeout must be a duration up to %s", maxQuoteTimeout)
+		}
+	}
+
+	quotes, err := p2p.RequestQuotes(deps.Node, deps.DB, hash, timeout, order)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, quotes)
+}
+
+func listReputation(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	records, err := reputation.All(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, records)
+}
+
+func requestMetadata(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request MetadataRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Peer == "" || request.Hash == "" {
+		return badRequest("peer and hash are required")
+	}
+
+	metadata, err := p2p.RequestFileInfo(deps.Node, request.Peer, request.Hash)
+	if err != nil {
+		return &apiError{http.StatusBadGateway, CodeInternal, err.Error()}
+	}
+	return writeJSON(w, http.StatusOK, metadata)
+}
+
+func downloadFile(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request DownloadRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Peer == "" || request.Hash == "" {
+		return badRequest("peer and hash are required")
+	}
+	if request.Price < 0 {
+		return badRequest("price can't be negative")
+	}
+
+	name, data, ext, err := p2p.PurchaseFile(deps.Node, deps.Wallet, deps.NetParams, deps.DB, request.Peer, request.Hash, request.Price, 0)
+	if err != nil {
+		return err
+	}
+
+	contentType := ext
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+	return nil
+}
+
+func explore(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request ExploreRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+
+	hostings, err := p2p.Explore(deps.Node, request.Peers)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, hostings)
+}
+
+func refreshProxies(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	protocol := r.URL.Query().Get("protocol")
+	region := r.URL.Query().Get("region")
+	if protocol != "" && protocol != proxy.ProtocolSOCKS5 && protocol != proxy.ProtocolHTTP {
+		return badRequest("protocol must be %s or %s", proxy.ProtocolSOCKS5, proxy.ProtocolHTTP)
+	}
+
+	proxies, err := deps.Prober.Proxies(protocol, region)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, proxies)
+}
+
+func probeProxies(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	protocol := r.URL.Query().Get("protocol")
+	region := r.URL.Query().Get("region")
+	if protocol != "" && protocol != proxy.ProtocolSOCKS5 && protocol != proxy.ProtocolHTTP {
+		return badRequest("protocol must be %s or %s", proxy.ProtocolSOCKS5, proxy.ProtocolHTTP)
+	}
+	refresh := false
+	if value := r.URL.Query().Get("refresh"); value != "" {
+		var err error
+		refresh, err = strconv.ParseBool(value)
+		if err != nil {
+			return badRequest("refresh must be true or false")
+		}
+	}
+
+	results, err := deps.Prober.Find(protocol, region, refresh)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, results)
+}
+
+func cachedProbes(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	return writeJSON(w, http.StatusOK, deps.Prober.Cached())
+}
+
+func getProxy(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	offerings, err := operations.GetProxyOfferings(deps.DB)
+	if err != nil {
+		return err
+	} else if len(offerings) == 0 {
+		return notFound("this node is not offering a proxy")
+	}
+	return writeJSON(w, http.StatusOK, offerings[0])
+}
+
+func updateProxy(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request UpdateProxyRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+
+	offerings, err := operations.GetProxyOfferings(deps.DB)
+	if err != nil {
+		return err
+	}
+	offering := models.Proxy{Protocol: proxy.ProtocolSOCKS5}
+	if len(offerings) > 0 {
+		offering = offerings[0]
+	}
+	offering.IP = request.IP
+	offering.Rate = request.Rate
+
+	err = saveProxyOffering(deps, &offering, offerings)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, offering)
+}
+
+func listProxyOfferings(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	offerings, err := operations.GetProxyOfferings(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, offerings)
+}
+
+func getProxyOffering(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	offering, err := findProxyOffering(r, deps)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, offering)
+}
+
+func addProxyOffering(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request ProxyOfferingRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	offerings, err := operations.GetProxyOfferings(deps.DB)
+	if err != nil {
+		return err
+	}
+
+	offering := offeringFromRequest(request)
+	err = saveProxyOffering(deps, &offering, offerings)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, offering)
+}
+
+func updateProxyOffering(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	current, err := findProxyOffering(r, deps)
+	if err != nil {
+		return err
+	}
+	var request ProxyOfferingRequest
+	err = decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	offerings, err := operations.GetProxyOfferings(deps.DB)
+	if err != nil {
+		return err
+	}
+
+	offering := offeringFromRequest(request)
+	offering.ID = current.ID
+	err = saveProxyOffering(deps, &offering, offerings)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, offering)
+}
+
+func deleteProxyOffering(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	offering, err := findProxyOffering(r, deps)
+	if err != nil {
+		return err
+	}
+
+	err = operations.DeleteProxyOffering(deps.DB, offering.ID)
+	if err != nil {
+		return err
+	}
+	// The keys still announced for other offerings are kept, the others stop at the next sync
+	err = proxy.ApplyOfferings(deps.DB)
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func findProxyOffering(r *http.Request, deps *Deps) (*models.Proxy, error) {
+	id, err := requireID(r)
+	if err != nil {
+		return nil, err
+	}
+	offering, err := operations.FindProxyOffering(deps.DB, id)
+	if err != nil {
+		return nil, err
+	} else if offering == nil {
+		return nil, notFound("no proxy offering %d", id)
+	}
+	return offering, nil
+}
+
+func offeringFromRequest(request ProxyOfferingRequest) models.Proxy {
+	return models.Proxy{
+		IP:        request.IP,
+		Listen:    request.Listen,
+		Protocol:  request.Protocol,
+		Rate:      request.Rate,
+		Bandwidth: request.Bandwidth,
+		Region:    request.Region,
+	}
+}
+
+// saveProxyOffering validates an offering against the others, saves it with our node and wallet,
+// starts its listener and announces it. An offering without an ID is added.
+func saveProxyOffering(deps *Deps, offering *models.Proxy, offerings []models.Proxy) error {
+	err := proxy.ValidateOffering(offering, offerings)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+
+	walletInfo, err := operations.GetWalletInfo(deps.DB)
+	if err != nil {
+		return err
+	}
+	offering.Node = deps.Node.ID().String()
+	offering.Wallet = walletInfo.Address
+
+	if offering.ID == 0 {
+		offering.ID, err = operations.AddProxyOffering(deps.DB, *offering)
+	} else {
+		err = operations.UpdateProxyOffering(deps.DB, *offering)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = proxy.ApplyOfferings(deps.DB)
+	if err != nil {
+		return err
+	}
+	return p2p.AnnounceProxy(*offering)
+}
+
+func proxyLogs(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	proxyLogsRecords, err := operations.GetProxyLogs(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, proxyLogsRecords)
+}
+
+func connectProxy(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request ConnectProxyRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+
+	status, err := deps.ProxyClient.Connect(request.Node)
+	if errors.Is(err, proxyclient.ErrProxyNotFound) {
+		return notFound("%v", err)
+	} else if err != nil {
+		return &apiError{http.StatusBadGateway, CodeInternal, err.Error()}
+	}
+	return writeJSON(w, http.StatusOK, status)
+}
+
+func disconnectProxy(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	err := deps.ProxyClient.Disconnect()
+	if errors.Is(err, proxyclient.ErrNotConnected) {
+		return conflict("%v", err)
+	} else if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, deps.ProxyClient.Status())
+}
+
+func proxyStatus(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	return writeJSON(w, http.StatusOK, deps.ProxyClient.Status())
+}
+
+func proxyUsage(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	usage, err := operations.GetProxyUsage(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, usage)
+}
+
+func proxyBills(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	bills, err := deps.Billing.Bills()
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, bills)
+}
+
+func proxyBill(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	id, err := requireID(r)
+	if err != nil {
+		return err
+	}
+
+	bill, err := operations.FindIssuedBill(deps.DB, id)
+	if err != nil {
+		return err
+	} else if bill == nil {
+		return notFound("no proxy bill %d", id)
+	}
+	return writeJSON(w, http.StatusOK, bill)
+}
+
+func resendProxyBill(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	id, err := requireID(r)
+	if err != nil {
+		return err
+	}
+
+	bill, err := deps.Billing.Resend(id)
+	if errors.Is(err, billing.ErrBillNotFound) {
+		return notFound("no proxy bill %d", id)
+	} else if errors.Is(err, billing.ErrBillPaid) {
+		return conflict("proxy bill %d is already paid", id)
+	} else if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, bill)
+}
+
+func getProxyRules(w http.ResponseWriter, _ *http.Request, _ *Deps) error {
+	return writeJSON(w, http.StatusOK, proxy.Rules())
+}
+
+func updateProxyRules(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var rules models.ProxyRules
+	err := decodeJSON(r, &rules)
+	if err != nil {
+		return err
+	}
+	for _, list := range []*[]string{&rules.AllowCIDRs, &rules.DenyCIDRs, &rules.AllowDomains, &rules.DenyDomains, &rules.AllowPorts} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+
+	err = proxy.ValidateRules(rules)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+
+	err = operations.UpdateProxyRules(deps.DB, rules)
+	if err != nil {
+		return err
+	}
+	proxy.SetRules(rules)
+	return writeJSON(w, http.StatusOK, rules)
+}
+
+func proxyDenials(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return badRequest("limit must be a positive number")
+		}
+		limit = parsed
+	}
+
+	denials, err := operations.GetProxyDenials(deps.DB, limit)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, denials)
+}
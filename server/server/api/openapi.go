@@ -0,0 +1,200 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"server/events"
+	"strconv"
+	"strings"
+)
+
+// serveOpenAPI serves the OpenAPI document describing the /api/v1 routes.
+func serveOpenAPI(w http.ResponseWriter, _ *http.Request, _ *Deps) error {
+	return writeJSON(w, http.StatusOK, OpenAPI())
+}
+
+// OpenAPI builds an OpenAPI 3 document from the route table and the Go request/response types.
+func OpenAPI() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]any{}
+	errorSchema := schemaFor(reflect.TypeOf(ErrorResponse{}), schemas)
+
+	for _, rt := range routes() {
+		operation := map[string]any{
+			"summary":     rt.Summary,
+			"operationId": operationID(rt),
+		}
+
+		// Path wildcards such as {hash}
+		parameters := []any{}
+		for _, segment := range strings.Split(rt.Path, "/") {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				parameters = append(parameters, map[string]any{
+					"name":     strings.Trim(segment, "{}"),
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]any{"type": "string"},
+				})
+			}
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if rt.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(rt.Request), schemas)},
+				},
+			}
+		}
+
+		responses := map[string]any{}
+		success := map[string]any{"description": http.StatusText(rt.Status)}
+		switch rt.Response.(type) {
+		case nil:
+		case binaryResponse:
+			success["content"] = map[string]any{
+				"application/octet-stream": map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			}
+		case eventStream:
+			success["content"] = map[string]any{
+				"text/event-stream": map[string]any{"schema": schemaFor(reflect.TypeOf(events.Event{}), schemas)},
+			}
+		default:
+			success["content"] = map[string]any{
+				"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(rt.Response), schemas)},
+			}
+		}
+		responses[strconv.Itoa(rt.Status)] = success
+
+		for _, status := range append(rt.Errors, http.StatusInternalServerError) {
+			responses[strconv.Itoa(status)] = map[string]any{
+				"description": http.StatusText(status),
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": errorSchema},
+				},
+			}
+		}
+		operation["responses"] = responses
+
+		path := Prefix + rt.Path
+		item, ok := paths[path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(rt.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "BlubberBytes REST API",
+			"version": "1",
+		},
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"apiToken": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Token"},
+			},
+		},
+		"security": []any{map[string]any{"apiToken": []any{}}},
+		"paths":    paths,
+	}
+}
+
+// operationID derives an identifier such as "deleteStoringHash" from a route.
+func operationID(rt route) string {
+	id := strings.ToLower(rt.Method)
+	for _, segment := range strings.Split(rt.Path, "/") {
+		segment = strings.Trim(segment, "{}")
+		segment = strings.TrimSuffix(segment, ".json")
+		if segment != "" {
+			id += strings.ToUpper(segment[:1]) + segment[1:]
+		}
+	}
+	return id
+}
+
+// schemaFor returns the JSON schema of t, named structs are added to schemas and referenced.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			// Reserve the name first so that recursive types terminate
+			schemas[t.Name()] = map[string]any{}
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+// structSchema returns the object schema of a struct using its json tags.
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// Embedded structs are flattened, as encoding/json does
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := structSchema(field.Type, schemas)
+			for key, value := range embedded["properties"].(map[string]any) {
+				properties[key] = value
+			}
+			if fields, ok := embedded["required"].([]string); ok {
+				required = append(required, fields...)
+			}
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = schemaFor(field.Type, schemas)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"server/btc"
+	"server/database/models"
+	"server/p2p"
+	"server/probe"
+	"server/proxyclient"
+)
+
+// routes lists every /api/v1 route. Register serves them and openAPI documents them.
+func routes() []route {
+	bad, missing, taken := http.StatusBadRequest, http.StatusNotFound, http.StatusConflict
+
+	return []route{
+		// Files
+		{"GET", "/storing", "List stored files", nil, []models.Storing{}, http.StatusOK, nil, listStoring},
+		{"POST", "/storing", "Store a file from the local disk", AddStoringRequest{}, models.Storing{}, http.StatusCreated, []int{bad, taken}, addStoring},
+		{"DELETE", "/storing/{hash}", "Stop storing a file", nil, nil, http.StatusNoContent, []int{missing}, deleteStoring},
+		{"GET", "/hosting", "List hosted files", nil, []models.JoinedHosting{}, http.StatusOK, nil, listHosting},
+		{"POST", "/hosting", "Host a stored file", AddHostingRequest{}, models.JoinedHosting{}, http.StatusCreated, []int{bad, missing, taken}, addHosting},
+		{"GET", "/hosting/announce-status", "Show when each hosted file was announced in the DHT", nil, []p2p.KeyStatus{}, http.StatusOK, nil, announceStatus},
+		{"DELETE", "/hosting/{hash}", "Stop hosting a file", nil, nil, http.StatusNoContent, []int{missing}, deleteHosting},
+		{"GET", "/sharing", "List shared files", nil, []models.JoinedSharing{}, http.StatusOK, nil, listSharing},
+		{"POST", "/sharing", "Share a stored file behind a password", HashRequest{}, SharingLinkResponse{}, http.StatusCreated, []int{bad, missing, taken}, addSharing},
+		{"DELETE", "/sharing/{hash}", "Stop sharing a file", nil, nil, http.StatusNoContent, []int{missing}, deleteSharing},
+		{"GET", "/sharing/{hash}/link", "Get the gateway link of a shared file", nil, SharingLinkResponse{}, http.StatusOK, []int{missing}, sharingLink},
+		{"GET", "/encrypted-shares", "List encrypted shares", nil, []models.JoinedEncryptedShare{}, http.StatusOK, nil, listEncryptedShares},
+		{"POST", "/encrypted-shares", "Encrypt a stored file with a new key, then host and share the encrypted copy", AddEncryptedShareRequest{}, EncryptedShareResponse{}, http.StatusCreated, []int{bad, missing}, addEncryptedShare},
+		{"DELETE", "/encrypted-shares/{hash}", "Stop sharing an encrypted copy and delete it", nil, nil, http.StatusNoContent, []int{missing}, deleteEncryptedShare},
+		{"POST", "/sharing/open", "Download and decrypt the file of an encrypted share link", OpenSharingRequest{}, binaryResponse{}, http.StatusOK, []int{bad, http.StatusBadGateway}, openSharing},
+		{"GET", "/saved", "List saved files", nil, []models.Saved{}, http.StatusOK, nil, listSaved},
+		{"POST", "/saved", "Save a file found on the network", AddSavedRequest{}, models.Saved{}, http.StatusCreated, []int{bad, taken}, addSaved},
+		{"DELETE", "/saved/{hash}", "Remove a saved file", nil, nil, http.StatusNoContent, []int{missing}, deleteSaved},
+		{"GET", "/collections", "List collections", nil, []models.Collection{}, http.StatusOK, nil, listCollections},
+		{"POST", "/collections", "Create a collection from stored files", AddCollectionRequest{}, HashResponse{}, http.StatusCreated, []int{bad, missing}, addCollection},
+
+		// Network
+		{"GET", "/peers", "List the connected peers", nil, []PeerInfo{}, http.StatusOK, nil, listPeers},
+		{"GET", "/peers/reputation", "List the reputation of the peers we dealt with, best first", nil, []models.Reputation{}, http.StatusOK, nil, listReputation},
+		{"GET", "/peers/known", "List the peers of the persistent peerstore, most reliable first", nil, []models.Peer{}, http.StatusOK, nil, listKnownPeers},
+		{"GET", "/peers/block", "List the blocked peers", nil, []models.BlockedPeer{}, http.StatusOK, nil, listBlockedPeers},
+		{"POST", "/peers/block", "Block a peer and close the connections to it", BlockPeerRequest{}, models.BlockedPeer{}, http.StatusCreated, []int{bad}, blockPeer},
+		{"DELETE", "/peers/block/{peer}", "Unblock a peer", nil, nil, http.StatusNoContent, []int{missing}, unblockPeer},
+		{"GET", "/peers/abuse", "List the requests refused for abuse, newest first", nil, []models.AbuseLog{}, http.StatusOK, nil, listAbuseLog},
+		{"GET", "/providers/{hash}", "Find the peers providing a file", nil, ProvidersResponse{}, http.StatusOK, []int{bad}, getProviders},
+		{"GET", "/providers/{hash}/quotes", "Ask every provider of a file for a signed quote, sorted by ?sort=price, latency or score", nil, []p2p.ProviderQuote{}, http.StatusOK, []int{bad}, getQuotes},
+		{"POST", "/metadata", "Ask a peer for the metadata of a file", MetadataRequest{}, models.JoinedHosting{}, http.StatusOK, []int{bad, http.StatusBadGateway}, requestMetadata},
+		{"POST", "/download", "Buy and download a file from a peer", DownloadRequest{}, binaryResponse{}, http.StatusOK, []int{bad}, downloadFile},
+		{"POST", "/explore", "Collect the hosted files of peers", ExploreRequest{}, []models.JoinedHosting{}, http.StatusOK, []int{bad}, explore},
+		{"GET", "/proxies", "Find proxies offered by peers, of one ?protocol and ?region when given, best probed price and performance first", nil, []models.Proxy{}, http.StatusOK, []int{bad}, refreshProxies},
+		{"GET", "/proxies/probe", "Find proxies like /proxies and return their latency, throughput and score, ?refresh=true probes them all again", nil, []probe.Result{}, http.StatusOK, []int{bad}, probeProxies},
+		{"GET", "/proxies/probes", "List the probe results still cached, best scored first", nil, []probe.Result{}, http.StatusOK, nil, cachedProbes},
+		{"GET", "/proxy", "Get the first proxy offering of this node", nil, models.Proxy{}, http.StatusOK, []int{missing}, getProxy},
+		{"PUT", "/proxy", "Change the IP and rate of the first proxy offering, or offer a SOCKS5 proxy", UpdateProxyRequest{}, models.Proxy{}, http.StatusOK, []int{bad}, updateProxy},
+		{"GET", "/proxy/offerings", "List the proxies this node offers", nil, []models.Proxy{}, http.StatusOK, nil, listProxyOfferings},
+		{"POST", "/proxy/offerings", "Offer a proxy on its own listen address, announced by protocol and region", ProxyOfferingRequest{}, models.Proxy{}, http.StatusCreated, []int{bad}, addProxyOffering},
+		{"GET", "/proxy/offerings/{id}", "Get a proxy offering", nil, models.Proxy{}, http.StatusOK, []int{bad, missing}, getProxyOffering},
+		{"PUT", "/proxy/offerings/{id}", "Replace a proxy offering, its clients stay connected unless the address or protocol changed", ProxyOfferingRequest{}, models.Proxy{}, http.StatusOK, []int{bad, missing}, updateProxyOffering},
+		{"DELETE", "/proxy/offerings/{id}", "Stop offering a proxy and close its listener", nil, nil, http.StatusNoContent, []int{bad, missing}, deleteProxyOffering},
+		{"GET", "/proxy/logs", "List proxy traffic logs", nil, []models.ProxyLogs{}, http.StatusOK, nil, proxyLogs},
+		{"POST", "/proxy/connect", "Relay the local proxy address to a peer's proxy, failing over to the next best one", ConnectProxyRequest{}, proxyclient.Status{}, http.StatusOK, []int{bad, missing, http.StatusBadGateway}, connectProxy},
+		{"POST", "/proxy/disconnect", "Stop relaying to the proxy", nil, proxyclient.Status{}, http.StatusOK, []int{taken}, disconnectProxy},
+		{"GET", "/proxy/status", "Get the proxy we are connected to and the bytes sent through it", nil, proxyclient.Status{}, http.StatusOK, nil, proxyStatus},
+		{"GET", "/proxy/bills", "List the bills sent to the clients of our proxy, newest first", nil, []models.IssuedBill{}, http.StatusOK, nil, proxyBills},
+		{"GET", "/proxy/bills/{id}", "Get a bill sent to a client of our proxy", nil, models.IssuedBill{}, http.StatusOK, []int{bad, missing}, proxyBill},
+		{"POST", "/proxy/bills/{id}/resend", "Send an unpaid bill to the client again", nil, models.IssuedBill{}, http.StatusOK, []int{bad, missing, taken}, resendProxyBill},
+		{"GET", "/proxy/rules", "Get the rules on where the clients of our proxy may connect to", nil, models.ProxyRules{}, http.StatusOK, nil, getProxyRules},
+		{"PUT", "/proxy/rules", "Replace the proxy rules, they apply to the next connection", models.ProxyRules{}, models.ProxyRules{}, http.StatusOK, []int{bad}, updateProxyRules},
+		{"GET", "/proxy/denials", "List the connections the proxy refused, newest first", nil, []models.ProxyDenials{}, http.StatusOK, nil, proxyDenials},
+		{"GET", "/proxy/usage", "List the traffic sent through other peers' proxies as counted on our side, newest first", nil, []models.ProxyUsage{}, http.StatusOK, nil, proxyUsage},
+
+		// Replicated storage
+		{"GET", "/storage/contracts", "List the contracts of the peers we pay to store our files", nil, []models.StorageContract{}, http.StatusOK, nil, listStorageContracts},
+		{"POST", "/storage/contracts", "Pay a peer to store a file, checking and paying a proof every interval", StorageContractRequest{}, models.StorageContract{}, http.StatusCreated, []int{bad, missing, http.StatusBadGateway}, addStorageContract},
+		{"GET", "/storage/contracts/{id}", "Get a storage contract", nil, models.StorageContract{}, http.StatusOK, []int{bad, missing}, getStorageContract},
+		{"POST", "/storage/contracts/{id}/cancel", "Stop paying a storage contract and let the peer drop the file", nil, models.StorageContract{}, http.StatusOK, []int{bad, missing, taken}, cancelStorageContract},
+		{"GET", "/storage/pins", "List the files we store for other peers", nil, []models.Pin{}, http.StatusOK, nil, listPins},
+		{"GET", "/storage/settings", "Get what this node accepts to store for other peers", nil, models.StorageSettings{}, http.StatusOK, nil, getStorageSettings},
+		{"PUT", "/storage/settings", "Change what this node accepts to store for other peers", models.StorageSettings{}, models.StorageSettings{}, http.StatusOK, []int{bad}, updateStorageSettings},
+
+		// Wallet and histories
+		{"GET", "/wallet", "Get the wallet address and balances", nil, models.Wallet{}, http.StatusOK, nil, getWallet},
+		{"GET", "/btc/status", "Get the state of btcd and btcwallet, their restarts and the sync progress", nil, btc.Status{}, http.StatusOK, nil, getBtcStatus},
+		{"GET", "/transactions", "List wallet transactions", nil, []models.Transactions{}, http.StatusOK, nil, listTransactions},
+		{"POST", "/generate", "Mine a block", nil, []string{}, http.StatusCreated, nil, generateBlock},
+		{"GET", "/statistics", "Get file statistics", nil, models.Statistics{}, http.StatusOK, nil, getStatistics},
+		{"GET", "/uploads", "List the upload history", nil, []models.Uploads{}, http.StatusOK, nil, listUploads},
+		{"GET", "/uploads/limits", "Get the upload rate, slot and schedule limits", nil, models.UploadLimits{}, http.StatusOK, nil, getUploadLimits},
+		{"PUT", "/uploads/limits", "Change the upload limits, rates are bytes per second and 0 is unlimited", models.UploadLimits{}, models.UploadLimits{}, http.StatusOK, []int{bad}, updateUploadLimits},
+		{"GET", "/downloads", "List the download history", nil, []models.Downloads{}, http.StatusOK, nil, listDownloads},
+
+		// Download queue
+		{"GET", "/downloads/jobs", "List the download queue", nil, []models.DownloadJob{}, http.StatusOK, nil, listDownloadJobs},
+		{"POST", "/downloads/jobs", "Add a file to the download queue", EnqueueDownloadRequest{}, models.DownloadJob{}, http.StatusCreated, []int{bad}, enqueueDownload},
+		{"GET", "/downloads/jobs/{id}", "Get a download job", nil, models.DownloadJob{}, http.StatusOK, []int{bad, missing}, getDownloadJob},
+		{"DELETE", "/downloads/jobs/{id}", "Cancel a download job", nil, nil, http.StatusNoContent, []int{bad, missing}, cancelDownloadJob},
+		{"POST", "/downloads/jobs/{id}/pause", "Pause a download job", nil, models.DownloadJob{}, http.StatusOK, []int{bad, missing, taken}, pauseDownloadJob},
+		{"POST", "/downloads/jobs/{id}/resume", "Resume a paused or failed download job", nil, models.DownloadJob{}, http.StatusOK, []int{bad, missing, taken}, resumeDownloadJob},
+
+		// Events
+		{"GET", "/events", "Stream transfer, peer, bill and wallet events", nil, eventStream{}, http.StatusOK, nil, streamEvents},
+
+		// Documentation
+		{"GET", "/openapi.json", "Get this OpenAPI document", nil, nil, http.StatusOK, nil, serveOpenAPI},
+	}
+}
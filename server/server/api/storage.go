@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"server/database/models"
+	"server/database/operations"
+	"server/p2p"
+	"time"
+)
+
+func listStorageContracts(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	contracts, err := operations.GetAllStorageContracts(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, contracts)
+}
+
+func addStorageContract(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var request StorageContractRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		return err
+	}
+	if request.Hash == "" || request.Peer == "" {
+		return badRequest("hash and peer are required")
+	}
+	duration, err := time.ParseDuration(request.Duration)
+	if err != nil {
+		return badRequest("duration must be a duration such as 720h")
+	}
+	interval, err := time.ParseDuration(request.Interval)
+	if err != nil {
+		return badRequest("interval must be a duration such as 24h")
+	}
+
+	storing, err := operations.FindStoring(deps.DB, request.Hash)
+	if err != nil {
+		return err
+	} else if storing == nil {
+		return notFound("no stored file with hash %s", request.Hash)
+	}
+
+	contract, err := p2p.CreateStorageContract(deps.Node, deps.DB, request.Hash, request.Peer, duration, interval, request.Price)
+	if errors.Is(err, p2p.ErrInvalidStorageTerms) || errors.Is(err, p2p.ErrInvalidPeer) {
+		return badRequest("%v", err)
+	} else if err != nil {
+		return &apiError{http.StatusBadGateway, CodeInternal, err.Error()}
+	}
+	return writeJSON(w, http.StatusCreated, contract)
+}
+
+func getStorageContract(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	id, err := requireID(r)
+	if err != nil {
+		return err
+	}
+
+	contract, err := operations.FindStorageContract(deps.DB, id)
+	if err != nil {
+		return err
+	} else if contract == nil {
+		return notFound("no storage contract %d", id)
+	}
+	return writeJSON(w, http.StatusOK, contract)
+}
+
+func cancelStorageContract(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	id, err := requireID(r)
+	if err != nil {
+		return err
+	}
+
+	contract, err := operations.FindStorageContract(deps.DB, id)
+	if err != nil {
+		return err
+	} else if contract == nil {
+		return notFound("no storage contract %d", id)
+	} else if contract.Status != p2p.StorageActive {
+		return conflict("storage contract %d is %s", id, contract.Status)
+	}
+
+	err = p2p.CancelStorageContract(deps.Node, deps.DB, contract)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, contract)
+}
+
+func listPins(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	pins, err := operations.GetAllPins(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, pins)
+}
+
+func getStorageSettings(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	settings, err := operations.GetStorageSettings(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, settings)
+}
+
+func updateStorageSettings(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var settings models.StorageSettings
+	err := decodeJSON(r, &settings)
+	if err != nil {
+		return err
+	}
+	if settings.MaxBytes < 0 || settings.MinPrice < 0 {
+		return badRequest("maxBytes and minPrice can't be negative")
+	}
+
+	err = operations.UpdateStorageSettings(deps.DB, settings)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, settings)
+}
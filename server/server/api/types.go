@@ -0,0 +1,167 @@
+package api
+
+// Request and response bodies of the /api/v1 routes. The OpenAPI document is generated from these types,
+// so every field needs a json tag.
+
+// ErrorResponse is the envelope returned with every non-2xx status
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes what went wrong with a request
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// HashRequest identifies a file by its hash
+type HashRequest struct {
+	Hash string `json:"hash"`
+}
+
+// HashResponse returns the hash of a created object
+type HashResponse struct {
+	Hash string `json:"hash"`
+}
+
+// AddStoringRequest adds a file on the local disk to Storing
+type AddStoringRequest struct {
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	Extension string `json:"extension"`
+	Size      int64  `json:"size"`
+	Date      string `json:"date"`
+}
+
+// AddHostingRequest starts hosting a stored file for a price
+type AddHostingRequest struct {
+	Hash  string  `json:"hash"`
+	Price float64 `json:"price"`
+}
+
+// SharingLinkResponse returns the gateway link of a shared file
+type SharingLinkResponse struct {
+	Hash string `json:"hash"`
+	Link string `json:"link"`
+}
+
+// AddEncryptedShareRequest shares an encrypted copy of a stored file, hosted for Price
+type AddEncryptedShareRequest struct {
+	Hash  string  `json:"hash"`
+	Price float64 `json:"price"`
+}
+
+// EncryptedShareResponse returns the link of an encrypted share, its fragment holds the key
+type EncryptedShareResponse struct {
+	Hash   string `json:"hash"`
+	Source string `json:"source"`
+	Link   string `json:"link"`
+}
+
+// OpenSharingRequest downloads and decrypts the file of an encrypted share link
+type OpenSharingRequest struct {
+	Link string `json:"link"`
+}
+
+// AddSavedRequest bookmarks a file found on the network
+type AddSavedRequest struct {
+	Hash      string `json:"hash"`
+	Name      string `json:"name"`
+	Extension string `json:"extension"`
+	Size      int64  `json:"size"`
+}
+
+// AddCollectionRequest groups stored files into a collection
+type AddCollectionRequest struct {
+	Name   string   `json:"name"`
+	Hashes []string `json:"hashes"`
+}
+
+// ProvidersResponse lists the peers providing a file, the best scored first
+type ProvidersResponse struct {
+	Hash      string         `json:"hash"`
+	Providers []ProviderInfo `json:"providers"`
+}
+
+// ProviderInfo is a peer providing a file and its reputation score between 0 and 1
+type ProviderInfo struct {
+	ID    string  `json:"id"`
+	Score float64 `json:"score"`
+}
+
+// PeerInfo describes a peer this node is connected to
+type PeerInfo struct {
+	ID        string   `json:"id"`
+	Addresses []string `json:"addresses"`
+}
+
+// BlockPeerRequest blocks a peer, for Duration or until it is unblocked when Duration is empty
+type BlockPeerRequest struct {
+	Peer     string `json:"peer"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration,omitempty"` // Go duration such as 24h
+}
+
+// StorageContractRequest pays a peer Price per Interval to keep a stored file for Duration
+type StorageContractRequest struct {
+	Hash     string  `json:"hash"`
+	Peer     string  `json:"peer"`
+	Duration string  `json:"duration"` // Go duration such as 720h
+	Interval string  `json:"interval"` // Go duration such as 24h, one proof is checked and paid per interval
+	Price    float64 `json:"price"`
+}
+
+// ConnectProxyRequest connects to the proxy of Node, or to the best proxy found when it is empty
+type ConnectProxyRequest struct {
+	Node string `json:"node,omitempty"`
+}
+
+// MetadataRequest asks a peer for the metadata of a file it hosts
+type MetadataRequest struct {
+	Peer string `json:"peer"`
+	Hash string `json:"hash"`
+}
+
+// DownloadRequest buys a file from a peer
+type DownloadRequest struct {
+	Peer  string  `json:"peer"`
+	Hash  string  `json:"hash"`
+	Price float64 `json:"price"`
+}
+
+// ExploreRequest lists the peers whose hosted files should be collected
+type ExploreRequest struct {
+	Peers []string `json:"peers"`
+}
+
+// UpdateProxyRequest changes the IP and rate of the first offering, or offers a SOCKS5 proxy
+type UpdateProxyRequest struct {
+	IP   string  `json:"ip"`
+	Rate float64 `json:"rate"`
+}
+
+// ProxyOfferingRequest offers a proxy of this node, Listen defaults to the address of its protocol
+type ProxyOfferingRequest struct {
+	IP        string  `json:"ip"`
+	Listen    string  `json:"listen,omitempty"`
+	Protocol  string  `json:"protocol,omitempty"` // socks5 or http, socks5 when empty
+	Rate      float64 `json:"rate"`
+	Bandwidth int64   `json:"bandwidth,omitempty"` // Bytes per second, 0 for no cap
+	Region    string  `json:"region,omitempty"`
+}
+
+// EnqueueDownloadRequest adds a job to the download queue
+type EnqueueDownloadRequest struct {
+	Hash        string  `json:"hash"`
+	Peer        string  `json:"peer,omitempty"`
+	MaxPrice    float64 `json:"maxPrice,omitempty"`
+	Priority    int64   `json:"priority,omitempty"`
+	Destination string  `json:"destination,omitempty"`
+	Store       bool    `json:"store,omitempty"`
+}
+
+// binaryResponse marks routes that answer with raw file bytes instead of JSON
+type binaryResponse struct{}
+
+// eventStream marks routes that answer with a stream of server-sent events
+type eventStream struct{}
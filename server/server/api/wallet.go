@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"server/bandwidth"
+	"server/database/models"
+	"server/database/operations"
+)
+
+func getWallet(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	wallet, err := operations.GetWallet(deps.Wallet, deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, wallet)
+}
+
+func getBtcStatus(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	return writeJSON(w, http.StatusOK, deps.BTC.Status())
+}
+
+func listTransactions(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	transactionsRecords, err := operations.GetTransactions(deps.Wallet)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, transactionsRecords)
+}
+
+func generateBlock(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	block, err := deps.Wallet.Generate(1)
+	if err != nil {
+		return err
+	}
+
+	blocks := []string{}
+	for _, hash := range block {
+		blocks = append(blocks, hash.String())
+	}
+	return writeJSON(w, http.StatusCreated, blocks)
+}
+
+func getStatistics(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	statistics, err := operations.CalcStatistics(deps.DB)
+	if err != nil {
+		return err
+	}
+	statistics.Upload = bandwidth.Default.Usage()
+	return writeJSON(w, http.StatusOK, statistics)
+}
+
+func getUploadLimits(w http.ResponseWriter, _ *http.Request, _ *Deps) error {
+	return writeJSON(w, http.StatusOK, bandwidth.Default.Limits())
+}
+
+func updateUploadLimits(w http.ResponseWriter, r *http.Request, deps *Deps) error {
+	var limits models.UploadLimits
+	err := decodeJSON(r, &limits)
+	if err != nil {
+		return err
+	}
+	if limits.Schedule == nil {
+		limits.Schedule = []models.UploadWindow{}
+	}
+
+	err = bandwidth.Validate(limits)
+	if err != nil {
+		return badRequest("%v", err)
+	}
+
+	err = operations.UpdateUploadLimits(deps.DB, limits)
+	if err != nil {
+		return err
+	}
+	bandwidth.Default.SetLimits(limits)
+	return writeJSON(w, http.StatusOK, limits)
+}
+
+func listUploads(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	uploadsRecords, err := operations.GetAllUploads(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, uploadsRecords)
+}
+
+func listDownloads(w http.ResponseWriter, _ *http.Request, deps *Deps) error {
+	downloadsRecords, err := operations.GetAllDownloads(deps.DB)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, downloadsRecords)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Header the Electron client sends the API token in, besides "Authorization: Bearer <token>"
+const tokenHeader = "X-API-Token"
+
+// loadOrCreateToken reads the API token from tokenPath, generating and saving a new one if it does not exist.
+func loadOrCreateToken(tokenPath string) (string, error) {
+	data, err := os.ReadFile(tokenPath)
+	if err == nil {
+		token := strings.TrimSpace(string(data))
+		if token != "" {
+			return token, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("error reading API token: %v", err)
+	}
+
+	bytes := make([]byte, 32)
+	_, err = rand.Read(bytes)
+	if err != nil {
+		return "", fmt.Errorf("error generating API token: %v", err)
+	}
+	token := hex.EncodeToString(bytes)
+
+	err = os.MkdirAll(filepath.Dir(tokenPath), 0700)
+	if err != nil {
+		return "", fmt.Errorf("error creating API token folder: %v", err)
+	}
+
+	// Only the current user may read the token
+	err = os.WriteFile(tokenPath, []byte(token+"\n"), 0600)
+	if err != nil {
+		return "", fmt.Errorf("error writing API token: %v", err)
+	}
+
+	log.Printf("Generated new API token at %s", tokenPath)
+	return token, nil
+}
+
+// requestToken extracts the API token from the request headers.
+func requestToken(r *http.Request) string {
+	if token := r.Header.Get(tokenHeader); token != "" {
+		return token
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+	return ""
+}
+
+// requireToken rejects every request that does not carry the API token.
+// CORS preflight requests are let through since browsers never attach credentials to them.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	handler := requireToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		value  string
+		status int
+	}{
+		{"token header", "GET", tokenHeader, "secret", http.StatusNoContent},
+		{"bearer token", "GET", "Authorization", "Bearer secret", http.StatusNoContent},
+		{"no token", "GET", "", "", http.StatusUnauthorized},
+		{"wrong token", "GET", tokenHeader, "guess", http.StatusUnauthorized},
+		{"basic authorization", "GET", "Authorization", "Basic secret", http.StatusUnauthorized},
+		{"preflight", "OPTIONS", "", "", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, "/api/v1/storing", nil)
+			if test.header != "" {
+				request.Header.Set(test.header, test.value)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("status is %d, want %d", recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+func TestLoadOrCreateToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "api_token")
+	token, err := loadOrCreateToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != 64 {
+		t.Fatalf("token %q is not 32 hex encoded bytes", token)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("token file mode is %v", info.Mode().Perm())
+	}
+
+	again, err := loadOrCreateToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != token {
+		t.Errorf("token changed from %q to %q", token, again)
+	}
+}
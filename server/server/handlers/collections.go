@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"server/database/operations"
+	"server/p2p"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+func CollectionsHandler(w http.ResponseWriter, _ *http.Request, db *sql.DB) {
+	collectionRecords, err := operations.GetAllCollections(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(collectionRecords)
+}
+
+func AddCollectionHandler(w http.ResponseWriter, r *http.Request, node host.Host, db *sql.DB) {
+	decoder := json.NewDecoder(r.Body)
+	var request struct {
+		Name   string   `json:"name"`
+		Hashes []string `json:"hashes"`
+	}
+	err := decoder.Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hash, err := p2p.CreateCollection(db, node, request.Name, request.Hashes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, hash)
+}
@@ -6,11 +6,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"server/database/operations"
 	"server/p2p"
-	"time"
+	"server/reputation"
 
-	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/libp2p/go-libp2p/core/host"
@@ -29,8 +27,32 @@ func GetProvidersHandler(w http.ResponseWriter, r *http.Request, node host.Host,
 		return
 	}
 
+	// Best scored providers first
+	ranked, err := reputation.RankScored(db, providers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ranked)
+}
+
+func GetQuotesHandler(w http.ResponseWriter, r *http.Request, node host.Host, db *sql.DB) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	quotes, err := p2p.RequestQuotes(node, db, string(body), p2p.DefaultQuoteTimeout, r.URL.Query().Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(providers)
+	json.NewEncoder(w).Encode(quotes)
 }
 
 func RequestMetadataHandler(w http.ResponseWriter, r *http.Request, node host.Host, db *sql.DB) {
@@ -68,38 +90,7 @@ func DownloadFileHandler(w http.ResponseWriter, r *http.Request, node host.Host,
 		return
 	}
 
-	walletInfo, err := operations.GetWalletInfo(db)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	err = btcwallet.WalletPassphrase(walletInfo.PrivPassphrase, 300)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	name, data, ext, address, err := p2p.SimplyDownload(node, request.Peer, request.Hash)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	btcutilAddress, err := btcutil.DecodeAddress(address, netParams)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	_, err = btcwallet.SendFrom("default", btcutilAddress, btcutil.Amount(request.Price*1e8))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	date := time.Now().Local().Format("01/02/2006")
-	err = operations.AddDownloads(db, date, request.Hash, name, ext, int64(len(data)), request.Price)
+	name, data, ext, err := p2p.PurchaseFile(node, btcwallet, netParams, db, request.Peer, request.Hash, request.Price, 0)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"server/downloads"
+	"strconv"
+	"strings"
+)
+
+func DownloadJobsHandler(w http.ResponseWriter, _ *http.Request, manager *downloads.Manager) {
+	jobs, err := manager.Jobs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+func AddDownloadJobHandler(w http.ResponseWriter, r *http.Request, manager *downloads.Manager) {
+	decoder := json.NewDecoder(r.Body)
+	var request downloads.Request
+	err := decoder.Decode(&request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	job, err := manager.Enqueue(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+func CancelDownloadJobHandler(w http.ResponseWriter, r *http.Request, manager *downloads.Manager) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = manager.Cancel(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
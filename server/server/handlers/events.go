@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"server/events"
+	"strings"
+	"time"
+)
+
+// How often a comment is sent on an idle stream so that proxies and clients keep it open
+const heartbeatInterval = 15 * time.Second
+
+// Done once the server shuts down, the streams never become idle on their own
+var streams, endStreams = context.WithCancel(context.Background())
+
+// EndStreams ends the open event streams, so that shutting the server down doesn't wait for them.
+func EndStreams() {
+	endStreams()
+}
+
+// EventsHandler streams the event bus as server-sent events.
+// The optional "types" query parameter is a comma separated list of event types to receive.
+func EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var types map[string]bool
+	if query := r.URL.Query().Get("types"); query != "" {
+		types = map[string]bool{}
+		for _, eventType := range strings.Split(query, ",") {
+			types[strings.TrimSpace(eventType)] = true
+		}
+	}
+
+	subscription, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-streams.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case event := <-subscription:
+			if types != nil && !types[event.Type] {
+				continue
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -40,7 +40,7 @@ func AddHostingHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	err = p2p.ProvideKey(m.Hash)
+	err = p2p.Announce(m.Hash)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -65,4 +65,10 @@ func DeleteHostingHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	p2p.StopAnnouncing(string(body))
+}
+
+func AnnounceStatusHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p2p.AnnounceStatus())
 }
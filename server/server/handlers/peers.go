@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"server/database/operations"
+	"server/p2p"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+func BlockedPeersHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	blocked, err := operations.GetBlockedPeers(db, time.Now().Unix())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocked)
+}
+
+func BlockPeerHandler(w http.ResponseWriter, r *http.Request, node host.Host, db *sql.DB) {
+	var m struct {
+		Peer     string `json:"peer"`
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var duration time.Duration
+	if m.Duration != "" {
+		duration, err = time.ParseDuration(m.Duration)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	record, err := p2p.BlockPeer(node, db, m.Peer, m.Reason, duration)
+	if errors.Is(err, p2p.ErrInvalidPeer) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+func UnblockPeerHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = p2p.UnblockPeer(db, string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func AbuseLogHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	logs, err := operations.GetAbuseLogs(db, 1000)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs)
+}
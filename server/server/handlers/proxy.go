@@ -3,15 +3,23 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"server/billing"
 	"server/database/models"
 	"server/database/operations"
 	"server/p2p"
+	"server/probe"
+	"server/proxy"
+	"server/proxyclient"
+	"strconv"
+	"strings"
 
 	"github.com/libp2p/go-libp2p/core/host"
 )
 
+// UpdateProxyHandler changes the IP and rate of the first offering, or offers a SOCKS5 proxy.
 func UpdateProxyHandler(w http.ResponseWriter, r *http.Request, node host.Host, db *sql.DB) {
 	decoder := json.NewDecoder(r.Body)
 	var m models.Proxy
@@ -21,28 +29,129 @@ func UpdateProxyHandler(w http.ResponseWriter, r *http.Request, node host.Host,
 		return
 	}
 
+	offerings, err := operations.GetProxyOfferings(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	offering := models.Proxy{Protocol: proxy.ProtocolSOCKS5}
+	if len(offerings) > 0 {
+		offering = offerings[0]
+	}
+	offering.IP = m.IP
+	offering.Rate = m.Rate
+
+	saveProxyOffering(w, node, db, offering, offerings)
+}
+
+func ProxyOfferingsHandler(w http.ResponseWriter, _ *http.Request, db *sql.DB) {
+	offerings, err := operations.GetProxyOfferings(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(offerings)
+}
+
+// AddProxyOfferingHandler adds the offering in the body, or replaces it when it has an ID.
+func AddProxyOfferingHandler(w http.ResponseWriter, r *http.Request, node host.Host, db *sql.DB) {
+	decoder := json.NewDecoder(r.Body)
+	var m models.Proxy
+	err := decoder.Decode(&m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	offerings, err := operations.GetProxyOfferings(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if m.ID != 0 {
+		current, err := operations.FindProxyOffering(db, m.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if current == nil {
+			http.Error(w, "proxy offering not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	saveProxyOffering(w, node, db, m, offerings)
+}
+
+func DeleteProxyOfferingHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = operations.DeleteProxyOffering(db, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = proxy.ApplyOfferings(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// saveProxyOffering validates and saves an offering, starts its listener, announces it and writes
+// it back. An offering without an ID is added.
+func saveProxyOffering(w http.ResponseWriter, node host.Host, db *sql.DB, offering models.Proxy, offerings []models.Proxy) {
+	err := proxy.ValidateOffering(&offering, offerings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	walletInfo, err := operations.GetWalletInfo(db)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	address := walletInfo.Address
+	offering.Node = node.ID().String()
+	offering.Wallet = walletInfo.Address
 
-	err = p2p.ProvideKey("PROXY")
+	if offering.ID == 0 {
+		offering.ID, err = operations.AddProxyOffering(db, offering)
+	} else {
+		err = operations.UpdateProxyOffering(db, offering)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = operations.UpdateProxy(db, m.IP, m.Rate, node.ID().String(), address)
+	err = proxy.ApplyOfferings(db)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	err = p2p.AnnounceProxy(offering)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(offering)
 }
 
-func RefreshProxiesHandler(w http.ResponseWriter, _ *http.Request, node host.Host, db *sql.DB) {
-	proxies, err := p2p.RandomProxiesInfo(node)
+func RefreshProxiesHandler(w http.ResponseWriter, _ *http.Request, prober *probe.Prober) {
+	proxies, err := prober.Proxies("", "")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -52,26 +161,61 @@ func RefreshProxiesHandler(w http.ResponseWriter, _ *http.Request, node host.Hos
 	json.NewEncoder(w).Encode(proxies)
 }
 
-func ConnectToProxyHandler(w http.ResponseWriter, r *http.Request, node host.Host, db *sql.DB) {
+// ProbeProxiesHandler probes the proxies on the network again and returns the measurements.
+func ProbeProxiesHandler(w http.ResponseWriter, _ *http.Request, prober *probe.Prober) {
+	results, err := prober.Find("", "", true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func ConnectToProxyHandler(w http.ResponseWriter, r *http.Request, proxyClient *proxyclient.Manager) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	connectRequest := models.ProxyBill{
-		IP:     "connect",
-		Rate:   0,
-		Bytes:  0,
-		Amount: 0,
-		Wallet: "",
+	// An empty body connects to the best proxy found
+	status, err := proxyClient.Connect(strings.TrimSpace(string(body)))
+	if errors.Is(err, proxyclient.ErrProxyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func DisconnectProxyHandler(w http.ResponseWriter, _ *http.Request, proxyClient *proxyclient.Manager) {
+	err := proxyClient.Disconnect()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
 	}
+}
 
-	err = p2p.SendProxyBillWithConfirmation(node, string(body), connectRequest)
+func ProxyStatusHandler(w http.ResponseWriter, _ *http.Request, proxyClient *proxyclient.Manager) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proxyClient.Status())
+}
+
+func ProxyUsageHandler(w http.ResponseWriter, _ *http.Request, db *sql.DB) {
+	usage, err := operations.GetProxyUsage(db)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
 }
 
 func ProxyLogsHandler(w http.ResponseWriter, _ *http.Request, db *sql.DB) {
@@ -84,3 +228,86 @@ func ProxyLogsHandler(w http.ResponseWriter, _ *http.Request, db *sql.DB) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(proxyLogsRecords)
 }
+
+func ProxyBillsHandler(w http.ResponseWriter, _ *http.Request, billingEngine *billing.Engine) {
+	bills, err := billingEngine.Bills()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bills)
+}
+
+func ResendProxyBillHandler(w http.ResponseWriter, r *http.Request, billingEngine *billing.Engine) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bill, err := billingEngine.Resend(id)
+	if errors.Is(err, billing.ErrBillNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if errors.Is(err, billing.ErrBillPaid) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bill)
+}
+
+func ProxyRulesHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proxy.Rules())
+}
+
+func UpdateProxyRulesHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	decoder := json.NewDecoder(r.Body)
+	var m models.ProxyRules
+	err := decoder.Decode(&m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, list := range []*[]string{&m.AllowCIDRs, &m.DenyCIDRs, &m.AllowDomains, &m.DenyDomains, &m.AllowPorts} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+
+	err = proxy.ValidateRules(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = operations.UpdateProxyRules(db, m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	proxy.SetRules(m)
+}
+
+func ProxyDenialsHandler(w http.ResponseWriter, _ *http.Request, db *sql.DB) {
+	denials, err := operations.GetProxyDenials(db, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(denials)
+}
@@ -78,5 +78,57 @@ func SharingLinkHandler(w http.ResponseWriter, r *http.Request, node host.Host,
 		return
 	}
 
-	fmt.Fprintf(w, "http://localhost:3002/viewfile?address=%s&hash=%s&password=%s", node.ID().String(), record.Hash, record.Password)
+	share, err := operations.FindEncryptedShare(db, record.Hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if share != nil {
+		fmt.Fprint(w, p2p.EncryptedSharingLink(node.ID().String(), record.Hash, record.Password, share.Key))
+		return
+	}
+
+	fmt.Fprint(w, p2p.SharingLink(node.ID().String(), record.Hash, record.Password))
+}
+
+func EncryptedSharingHandler(w http.ResponseWriter, _ *http.Request, db *sql.DB) {
+	shareRecords, err := operations.GetAllEncryptedShares(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shareRecords)
+}
+
+func AddEncryptedSharingHandler(w http.ResponseWriter, r *http.Request, node host.Host, db *sql.DB) {
+	decoder := json.NewDecoder(r.Body)
+	var m models.Hosting
+	err := decoder.Decode(&m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, link, err := p2p.CreateEncryptedShare(db, node, m.Hash, m.Price)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, link)
+}
+
+func DeleteEncryptedSharingHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = p2p.DeleteEncryptedShare(db, string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
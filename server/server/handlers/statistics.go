@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"server/bandwidth"
+	"server/database/models"
 	"server/database/operations"
 )
 
@@ -13,7 +15,36 @@ func StatisticsHandler(w http.ResponseWriter, _ *http.Request, db *sql.DB) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	statistics.Upload = bandwidth.Default.Usage()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(statistics)
 }
+
+func UploadLimitsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bandwidth.Default.Limits())
+}
+
+func UpdateUploadLimitsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	decoder := json.NewDecoder(r.Body)
+	var m models.UploadLimits
+	err := decoder.Decode(&m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = bandwidth.Validate(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = operations.UpdateUploadLimits(db, m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bandwidth.Default.SetLimits(m)
+}
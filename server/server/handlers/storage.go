@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"server/database/models"
+	"server/database/operations"
+	"server/p2p"
+	"strconv"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+func StorageContractsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	contracts, err := operations.GetAllStorageContracts(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(contracts)
+}
+
+func AddStorageContractHandler(w http.ResponseWriter, r *http.Request, node host.Host, db *sql.DB) {
+	var m struct {
+		Hash     string  `json:"hash"`
+		Peer     string  `json:"peer"`
+		Duration string  `json:"duration"`
+		Interval string  `json:"interval"`
+		Price    float64 `json:"price"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	duration, err := time.ParseDuration(m.Duration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	interval, err := time.ParseDuration(m.Interval)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contract, err := p2p.CreateStorageContract(node, db, m.Hash, m.Peer, duration, interval, m.Price)
+	if errors.Is(err, p2p.ErrInvalidStorageTerms) || errors.Is(err, p2p.ErrInvalidPeer) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(contract)
+}
+
+func CancelStorageContractHandler(w http.ResponseWriter, r *http.Request, node host.Host, db *sql.DB) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(string(body), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contract, err := operations.FindStorageContract(db, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if contract == nil {
+		http.Error(w, "storage contract not found", http.StatusNotFound)
+		return
+	}
+
+	err = p2p.CancelStorageContract(node, db, contract)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func PinsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	pins, err := operations.GetAllPins(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pins)
+}
+
+func StorageSettingsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	settings, err := operations.GetStorageSettings(db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func UpdateStorageSettingsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	var m models.StorageSettings
+	err := json.NewDecoder(r.Body).Decode(&m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = operations.UpdateStorageSettings(db, m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
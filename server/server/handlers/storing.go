@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"server/database/models"
 	"server/database/operations"
+	"server/p2p"
 )
 
 func StoringHandler(w http.ResponseWriter, _ *http.Request, db *sql.DB) {
@@ -76,10 +77,23 @@ func DeleteStoringHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	p2p.StopAnnouncing(string(body))
 
 	err = operations.DeleteSharing(db, string(body))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	err = operations.DeleteCollection(db, string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = operations.DeleteEncryptedShare(db, string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
@@ -4,42 +4,29 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
-	"server/database/models"
+	"server/btc"
 	"server/database/operations"
 
 	"github.com/btcsuite/btcd/rpcclient"
 )
 
 func WalletHandler(w http.ResponseWriter, _ *http.Request, btcwallet *rpcclient.Client, db *sql.DB) {
-	walletInfo, err := operations.GetWalletInfo(db)
+	wallet, err := operations.GetWallet(btcwallet, db)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	address := walletInfo.Address
-
-	currentBalance, err := btcwallet.GetBalance("*")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	pendingBalance, err := btcwallet.GetBalanceMinConf("*", 0)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	wallet := models.Wallet{
-		Address:        address,
-		CurrentBalance: currentBalance.ToBTC(),
-		PendingBalance: pendingBalance.ToBTC(),
-	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(wallet)
 }
 
+// BtcStatusHandler returns the state of btcd and btcwallet and the sync progress.
+func BtcStatusHandler(w http.ResponseWriter, _ *http.Request, btcSupervisor *btc.Supervisor) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(btcSupervisor.Status())
+}
+
 func GenerateHandler(w http.ResponseWriter, _ *http.Request, btcwallet *rpcclient.Client, db *sql.DB) {
 	block, err := btcwallet.Generate(1)
 	if err != nil {
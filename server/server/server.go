@@ -1,9 +1,22 @@
 package server
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"server/billing"
+	"server/btc"
+	"server/downloads"
+	"server/probe"
+	"server/proxy"
+	"server/proxyclient"
+	"server/server/api"
 	"server/server/handlers"
 
 	"github.com/btcsuite/btcd/chaincfg"
@@ -11,10 +24,10 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 )
 
-// handler for HTTP proxy setup:
+// handler for HTTP proxy setup, returns the addresses the SOCKS5 and HTTP proxies listen on:
 func setupHTTPProxy(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Recieved request to setup HTTP proxy")
-	w.Write([]byte("HTTP proxy setup request received"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proxy.Settings())
 }
 
 // handler for viewing a random neighbor's files:
@@ -23,10 +36,35 @@ func viewRandomNeighborFiles(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Random neighbors files displayed"))
 }
 
+// Config holds the settings of the local REST API.
+type Config struct {
+	Addr         string // TCP address of the API, eg. ":3001"
+	ClientOrigin string // Only origin allowed to make cross-origin requests (the Electron client)
+	TokenPath    string // File holding the API token required on every TCP request
+	CertFile     string // Optional TLS certificate, TLS is enabled when both CertFile and KeyFile are set
+	KeyFile      string // Optional TLS key
+	SocketPath   string // Optional Unix socket, requests over it are trusted through file permissions
+}
+
+// DefaultConfig returns the configuration used by the Electron client.
+func DefaultConfig() Config {
+	return Config{
+		Addr:         "localhost:3001",
+		ClientOrigin: "http://localhost:8088",
+		TokenPath:    "./api_token",
+	}
+}
+
+// Origin allowed by cors, set from the Config passed to New
+var allowedOrigin string
+
 func cors(w http.ResponseWriter, r *http.Request, handler func()) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept")
+	if origin := r.Header.Get("Origin"); origin != "" && origin == allowedOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, "+tokenHeader)
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
 	} else {
@@ -34,123 +72,377 @@ func cors(w http.ResponseWriter, r *http.Request, handler func()) {
 	}
 }
 
-func Server(node host.Host, btcwallet *rpcclient.Client, netParams *chaincfg.Params, db *sql.DB) {
-	http.HandleFunc("/setupHTTPProxy", setupHTTPProxy)
-	http.HandleFunc("/viewRandomNeighborFiles", viewRandomNeighborFiles)
+// Server serves the local REST API over TCP, and over a Unix socket when one is configured
+type Server struct {
+	config Config
+	mux    *http.ServeMux
+	http   *http.Server
+	socket *http.Server
+}
+
+// New registers the routes of the API, Start serves them.
+func New(node host.Host, btcwallet *rpcclient.Client, netParams *chaincfg.Params, db *sql.DB, downloadManager *downloads.Manager, proxyClient *proxyclient.Manager, billingEngine *billing.Engine, prober *probe.Prober, btcSupervisor *btc.Supervisor, config Config) *Server {
+	allowedOrigin = config.ClientOrigin
+	mux := http.NewServeMux()
+
+	// Versioned JSON API
+	apiMux := http.NewServeMux()
+	api.Register(apiMux, api.Deps{Node: node, Wallet: btcwallet, NetParams: netParams, DB: db, Downloads: downloadManager, ProxyClient: proxyClient, Billing: billingEngine, Prober: prober, BTC: btcSupervisor})
+	mux.HandleFunc(api.Prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { apiMux.ServeHTTP(w, r) })
+	})
+
+	// Unversioned routes used by the Electron client
+	mux.HandleFunc("/setupHTTPProxy", setupHTTPProxy)
+	mux.HandleFunc("/viewRandomNeighborFiles", viewRandomNeighborFiles)
 
 	// GET routes
-	http.HandleFunc("/storing", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/storing", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.StoringHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/hosting", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/hosting", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.HostingHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/sharing", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/sharing", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.SharingHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/saved", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/encryptedsharing", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.EncryptedSharingHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/saved", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.SavedHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/statistics", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/statistics", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.StatisticsHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/uploads", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/uploads", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.UploadsHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/downloads", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/downloads", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.DownloadsHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/transactions", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/transactions", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.TransactionsHandler(w, r, btcwallet, db) })
 	})
 
-	http.HandleFunc("/proxies", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/collections", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.CollectionsHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/proxies", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.ProxiesHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/wallet", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/wallet", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.WalletHandler(w, r, btcwallet, db) })
 	})
 
-	http.HandleFunc("/generate", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/btcstatus", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.BtcStatusHandler(w, r, btcSupervisor) })
+	})
+
+	mux.HandleFunc("/generate", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.GenerateHandler(w, r, btcwallet, db) })
 	})
 
-	http.HandleFunc("/refreshproxies", func(w http.ResponseWriter, r *http.Request) {
-		cors(w, r, func() { handlers.RefreshProxiesHandler(w, r, node, db) })
+	mux.HandleFunc("/refreshproxies", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.RefreshProxiesHandler(w, r, prober) })
+	})
+
+	mux.HandleFunc("/probeproxies", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.ProbeProxiesHandler(w, r, prober) })
+	})
+
+	mux.HandleFunc("/proxystatus", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.ProxyStatusHandler(w, r, proxyClient) })
+	})
+
+	mux.HandleFunc("/proxyusage", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.ProxyUsageHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/proxylogs", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/proxylogs", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.ProxyLogsHandler(w, r, db) })
 	})
 
+	mux.HandleFunc("/proxybills", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.ProxyBillsHandler(w, r, billingEngine) })
+	})
+
+	mux.HandleFunc("/proxyrules", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.ProxyRulesHandler(w, r) })
+	})
+
+	mux.HandleFunc("/proxydenials", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.ProxyDenialsHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/proxyofferings", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.ProxyOfferingsHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/downloadjobs", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.DownloadJobsHandler(w, r, downloadManager) })
+	})
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.EventsHandler(w, r) })
+	})
+
+	mux.HandleFunc("/blockedpeers", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.BlockedPeersHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/abuselog", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.AbuseLogHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/storagecontracts", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.StorageContractsHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/pins", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.PinsHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/storagesettings", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.StorageSettingsHandler(w, r, db) })
+	})
+
 	// POST routes
-	http.HandleFunc("/getproviders", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/getproviders", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.GetProvidersHandler(w, r, node, db) })
 	})
 
-	http.HandleFunc("/requestmetadata", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/getquotes", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.GetQuotesHandler(w, r, node, db) })
+	})
+
+	mux.HandleFunc("/requestmetadata", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.RequestMetadataHandler(w, r, node, db) })
 	})
 
-	http.HandleFunc("/downloadfile", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/downloadfile", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.DownloadFileHandler(w, r, node, btcwallet, netParams, db) })
 	})
 
-	http.HandleFunc("/explore", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/explore", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.ExploreHandler(w, r, node, db) })
 	})
 
-	http.HandleFunc("/addstoring", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/addstoring", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.AddStoringHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/deletestoring", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/deletestoring", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.DeleteStoringHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/addhosting", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/hosting/announce-status", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.AnnounceStatusHandler(w, r) })
+	})
+
+	mux.HandleFunc("/addhosting", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.AddHostingHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/deletehosting", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/deletehosting", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.DeleteHostingHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/addsharing", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/addsharing", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.AddSharingHandler(w, r, node, db) })
 	})
 
-	http.HandleFunc("/deletesharing", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/deletesharing", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.DeleteSharingHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/sharinglink", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/sharinglink", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.SharingLinkHandler(w, r, node, db) })
 	})
 
-	http.HandleFunc("/addsaved", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/addencryptedsharing", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.AddEncryptedSharingHandler(w, r, node, db) })
+	})
+
+	mux.HandleFunc("/deleteencryptedsharing", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.DeleteEncryptedSharingHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/addcollection", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.AddCollectionHandler(w, r, node, db) })
+	})
+
+	mux.HandleFunc("/adddownloadjob", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.AddDownloadJobHandler(w, r, downloadManager) })
+	})
+
+	mux.HandleFunc("/canceldownloadjob", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.CancelDownloadJobHandler(w, r, downloadManager) })
+	})
+
+	mux.HandleFunc("/addsaved", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.AddSavedHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/deletesaved", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/deletesaved", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.DeleteSavedHandler(w, r, db) })
 	})
 
-	http.HandleFunc("/updateproxy", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/uploadlimits", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.UploadLimitsHandler(w, r) })
+	})
+
+	mux.HandleFunc("/updateuploadlimits", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.UpdateUploadLimitsHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/blockpeer", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.BlockPeerHandler(w, r, node, db) })
+	})
+
+	mux.HandleFunc("/unblockpeer", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.UnblockPeerHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/addstoragecontract", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.AddStorageContractHandler(w, r, node, db) })
+	})
+
+	mux.HandleFunc("/cancelstoragecontract", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.CancelStorageContractHandler(w, r, node, db) })
+	})
+
+	mux.HandleFunc("/updatestoragesettings", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.UpdateStorageSettingsHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/connecttoproxy", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.ConnectToProxyHandler(w, r, proxyClient) })
+	})
+
+	mux.HandleFunc("/disconnectproxy", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.DisconnectProxyHandler(w, r, proxyClient) })
+	})
+
+	mux.HandleFunc("/resendproxybill", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.ResendProxyBillHandler(w, r, billingEngine) })
+	})
+
+	mux.HandleFunc("/updateproxyrules", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.UpdateProxyRulesHandler(w, r, db) })
+	})
+
+	mux.HandleFunc("/updateproxy", func(w http.ResponseWriter, r *http.Request) {
 		cors(w, r, func() { handlers.UpdateProxyHandler(w, r, node, db) })
 	})
 
+	mux.HandleFunc("/addproxyoffering", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.AddProxyOfferingHandler(w, r, node, db) })
+	})
+
+	mux.HandleFunc("/deleteproxyoffering", func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r, func() { handlers.DeleteProxyOfferingHandler(w, r, db) })
+	})
+
+	return &Server{config: config, mux: mux}
+}
+
+// Start listens on the API addresses and serves the requests in the background.
+func (s *Server) Start(_ context.Context) error {
+	token, err := loadOrCreateToken(s.config.TokenPath)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return err
+	}
+
+	// Requests over the Unix socket skip the token, only the owner can connect to it
+	if s.config.SocketPath != "" {
+		socketListener, err := listenUnixSocket(s.config.SocketPath)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		s.socket = &http.Server{Handler: s.mux}
+		s.socket.RegisterOnShutdown(handlers.EndStreams)
+		fmt.Printf("Server is running on unix socket %s...\n", s.config.SocketPath)
+		go serve(s.socket, socketListener, "", "")
+	}
+
 	// Run the server
-	fmt.Println("Server is running on port 3001...")
-	if err := http.ListenAndServe(":3001", nil); err != nil {
-		panic(fmt.Sprintf("Server failed: %s", err))
+	s.http = &http.Server{Handler: requireToken(token, s.mux)}
+	s.http.RegisterOnShutdown(handlers.EndStreams)
+	if s.config.CertFile != "" && s.config.KeyFile != "" {
+		fmt.Printf("Server is running on https://%s...\n", s.config.Addr)
+	} else {
+		fmt.Printf("Server is running on http://%s...\n", s.config.Addr)
+	}
+	go serve(s.http, listener, s.config.CertFile, s.config.KeyFile)
+	return nil
+}
+
+// Stop stops accepting requests and waits for the ones in progress until ctx is done, the event
+// streams end right away.
+func (s *Server) Stop(ctx context.Context) error {
+	errs := []error{}
+	for _, server := range []*http.Server{s.http, s.socket} {
+		if server == nil {
+			continue
+		}
+		err := server.Shutdown(ctx)
+		if err != nil {
+			server.Close()
+			errs = append(errs, err)
+		}
+	}
+	if s.socket != nil {
+		os.Remove(s.config.SocketPath)
+	}
+	return errors.Join(errs...)
+}
+
+// serve serves requests until the server is shut down, with TLS when a certificate is given.
+func serve(server *http.Server, listener net.Listener, certFile, keyFile string) {
+	var err error
+	if certFile != "" && keyFile != "" {
+		err = server.ServeTLS(listener, certFile, keyFile)
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("API server failed: %v", err)
+	}
+}
+
+// listenUnixSocket listens on a Unix socket that only the current user can access.
+func listenUnixSocket(socketPath string) (net.Listener, error) {
+	err := os.Remove(socketPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error removing stale API socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on API socket: %v", err)
+	}
+
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error restricting API socket permissions: %v", err)
 	}
+	return listener, nil
 }
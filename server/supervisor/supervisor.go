@@ -0,0 +1,145 @@
+// Package supervisor starts the services of the node in order and stops them in reverse order, so
+// that a service is never left running without the ones it depends on. Starting and stopping each
+// service is bounded by a timeout, a service that doesn't stop in time is left behind and the next
+// one is stopped.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Service is a part of the node with a lifecycle. Start returns once the service runs, errors such
+// as an address that can't be listened on are returned instead of ending the process. Stop
+// returns once the service has stopped and saved what it has to, or when ctx is done.
+type Service interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// running is a started service
+type running struct {
+	name    string
+	service Service
+}
+
+// Supervisor keeps the started services
+type Supervisor struct {
+	timeout time.Duration
+
+	mutex   sync.Mutex
+	started []running
+	stopped bool
+}
+
+func New(timeout time.Duration) *Supervisor {
+	return &Supervisor{timeout: timeout}
+}
+
+// Start starts a service, it is stopped before the services started earlier.
+func (s *Supervisor) Start(name string, service Service) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopped {
+		return fmt.Errorf("cannot start %s, the node is shutting down", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	err := service.Start(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %v", name, err)
+	}
+	log.Printf("Started %s", name)
+	s.started = append(s.started, running{name, service})
+	return nil
+}
+
+// Stop stops the started services in reverse order, each within the timeout. It only stops them
+// once, later calls return nil.
+func (s *Supervisor) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+
+	errs := []error{}
+	for i := len(s.started) - 1; i >= 0; i-- {
+		r := s.started[i]
+		log.Printf("Stopping %s", r.name)
+		ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		err := r.service.Stop(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to stop %s: %v", r.name, err)
+			errs = append(errs, fmt.Errorf("%s: %v", r.name, err))
+		}
+	}
+	s.started = nil
+	return errors.Join(errs...)
+}
+
+// loop runs a function until the context it was given is canceled
+type loop struct {
+	run    func(ctx context.Context)
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Loop returns a service running run in its own goroutine. Stop cancels the context of run and
+// waits for it to return.
+func Loop(run func(ctx context.Context)) Service {
+	return &loop{run: run}
+}
+
+func (l *loop) Start(_ context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		l.run(ctx)
+	}()
+	return nil
+}
+
+func (l *loop) Stop(ctx context.Context) error {
+	l.cancel()
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("still running: %v", ctx.Err())
+	}
+}
+
+// funcs is a service made of two functions
+type funcs struct {
+	start func(ctx context.Context) error
+	stop  func(ctx context.Context) error
+}
+
+// Funcs returns a service that calls start and stop, either may be nil.
+func Funcs(start, stop func(ctx context.Context) error) Service {
+	return &funcs{start: start, stop: stop}
+}
+
+func (f *funcs) Start(ctx context.Context) error {
+	if f.start == nil {
+		return nil
+	}
+	return f.start(ctx)
+}
+
+func (f *funcs) Stop(ctx context.Context) error {
+	if f.stop == nil {
+		return nil
+	}
+	return f.stop(ctx)
+}